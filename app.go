@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// App is what the server is made of: its config, log, storage database,
+// GREEN-API client, send queues, scheduler and every store and subsystem
+// keeping state. main builds one from the flags, registers its routes and
+// serves them; tests build as many as they need. Handlers are methods of
+// App, and subsystems needing more of it than their own state hold it.
+type App struct {
+	config *Config
+	// live is the config as reloaded at run time, nil until the first
+	// change. A published Config is never modified; updates store a
+	// changed copy.
+	live   atomic.Pointer[Config]
+	liveMu sync.Mutex
+
+	// db is the database behind -storage, nil with memory storage.
+	db *sqlDB
+	// client is shared by all GREEN-API calls so connections are reused.
+	// Per-call deadlines come from the method's time budget.
+	client *http.Client
+	// sendQueue paces messages by -send-interval, checkQueue checkWhatsapp
+	// calls by -check-interval.
+	sendQueue     *SendQueue
+	checkQueue    *SendQueue
+	scheduler     *Scheduler
+	notifications *NotificationStore
+	audit         *AuditLog
+	events        *EventHub
+	// graphqlSchema is what /graphql can query: the chats, messages,
+	// contacts, broadcasts and API history kept locally. Like the REST
+	// lists, every part only shows what the caller's workspace may see.
+	graphqlSchema *gqlSchema
+	mux           *http.ServeMux
+
+	// The stores below keep their records in memory unless openStorage
+	// puts them in the -storage database.
+	history          HistoryStore
+	sessions         SessionStore
+	presets          PresetStore
+	cannedReplies    CannedReplyStore
+	trash            TrashStore
+	messageIndex     MessageIndex
+	instanceProfiles *InstanceProfiles
+	drift            *DriftDetector
+	inbox            *Inbox
+	labeler          *Labeler
+	chatNotes        *ChatNotebook
+	mediaDownloader  *MediaDownloader
+
+	// fileHost serves the files uploaded for sendFileByUrl, from load on.
+	fileHost *FileHost
+	// oauth is the provider set up from -oauth-provider, nil when disabled.
+	oauth       *oauthProvider
+	oauthLogins *oauthLogins
+	// sealedInstances are the instances read from -secrets-file at
+	// startup. The file isn't read again on reload, which would need the
+	// passphrase.
+	sealedInstances instanceList
+
+	upstream          *Breakers
+	featureFlags      *FeatureFlags
+	stats             *Stats
+	slowRequests      *SlowLog
+	anomalies         *AnomalyStore
+	inFlight          *InFlight
+	rateLimiter       *RateLimiter
+	chatLocks         *ChatLocks
+	seenNotifications *SeenSet
+	// seenSignatures remembers the signatures of signed webhooks, so a
+	// captured delivery can't be replayed within -webhook-replay-window.
+	seenSignatures   *SeenSet
+	deadLetters      *DeadLetterQueue
+	batches          *BatchTracker
+	polls            *PollStore
+	optOuts          *OptOutList
+	thumbnails       *ThumbnailCache
+	jobs             *JobRunner
+	watcher          *StateWatcher
+	webhookRegistrar *WebhookRegistrar
+	vault            *VaultSource
+	forwarder        *Forwarder
+	mailer           *Mailer
+	shortener        *Shortener
+}
+
+// newApp opens storage and loads what the server needs from c before it
+// starts: secrets, feature flags, managed instances, the audit log, the
+// opt-out list and the file directories.
+func newApp(c *Config) (*App, error) {
+	a := assembleApp(c)
+	if err := a.resolveSecrets(); err != nil {
+		return nil, err
+	}
+	if err := a.featureFlags.configure(c.Features); err != nil {
+		return nil, err
+	}
+	if a.vaultEnabled() {
+		a.vault.static = a.configuredInstances()
+		if err := a.vault.refresh(context.Background()); err != nil {
+			return nil, fmt.Errorf("failed to read instances from Vault: %w", err)
+		}
+	}
+	if err := a.openStorage(c.Storage); err != nil {
+		return nil, err
+	}
+	if err := a.load(); err != nil {
+		a.close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// assembleApp builds the App of c with memory stores, without touching the
+// disk or the network. -check starts from it.
+func assembleApp(c *Config) *App {
+	a := &App{
+		config:            c,
+		client:            &http.Client{Transport: newAPITransport(c)},
+		notifications:     newNotificationStore(c.WebhookHistorySize),
+		audit:             &AuditLog{nextSeq: 1},
+		events:            newEventHub(),
+		mux:               http.NewServeMux(),
+		history:           newMemoryHistory(c.HistorySize),
+		sessions:          newMemorySessions(),
+		presets:           newMemoryPresets(),
+		cannedReplies:     newMemoryCannedReplies(),
+		trash:             newMemoryTrash(),
+		instanceProfiles:  &InstanceProfiles{store: newMemoryInstances()},
+		inbox:             &Inbox{store: newMemoryConversations()},
+		chatNotes:         &ChatNotebook{store: newMemoryChatNotes()},
+		mediaDownloader:   newMediaDownloader(),
+		oauthLogins:       &oauthLogins{pending: make(map[string]oauthLogin)},
+		featureFlags:      newFeatureFlags(knownFeatures...),
+		stats:             newStats(),
+		slowRequests:      &SlowLog{nextID: 1},
+		anomalies:         &AnomalyStore{nextID: 1, counts: make(map[string]int)},
+		inFlight:          newInFlight(),
+		rateLimiter:       newRateLimiter(),
+		chatLocks:         newChatLocks(),
+		deadLetters:       &DeadLetterQueue{nextID: 1},
+		batches:           newBatchTracker(),
+		optOuts:           newOptOutList(),
+		thumbnails:        newThumbnailCache(),
+		jobs:              newJobRunner(),
+		seenNotifications: newSeenSet(),
+		seenSignatures:    newSeenSet(),
+		forwarder:         &Forwarder{client: &http.Client{Timeout: forwardTimeout}},
+		mailer:            &Mailer{},
+		shortener:         &Shortener{client: &http.Client{Timeout: shortenerTimeout}},
+		vault:             &VaultSource{client: &http.Client{Timeout: vaultTimeout}},
+	}
+	a.scheduler = newScheduler(a)
+	a.sendQueue = newSendQueue(func() time.Duration { return a.liveConfig().SendInterval })
+	a.checkQueue = newSendQueue(func() time.Duration { return a.liveConfig().CheckInterval })
+	a.upstream = newBreakers(a.scheduler.wakeUp)
+	a.messageIndex = memoryMessageIndex{notifications: a.notifications}
+	a.labeler = &Labeler{store: newMemoryLabels()}
+	a.drift = newDriftDetector()
+	a.polls = newPollStore(a.events)
+	a.watcher = newStateWatcher(a)
+	a.webhookRegistrar = newWebhookRegistrar(a)
+	a.graphqlSchema = a.newGraphQLSchema()
+	a.wire()
+	return a
+}
+
+// wire gives the subsystems what they use of a: the live config, the
+// GREEN-API client and workspace lookups.
+func (a *App) wire() {
+	a.audit.instanceWorkspace = a.instanceWorkspace
+	a.notifications.instanceWorkspace = a.instanceWorkspace
+	a.stats.instanceWorkspace = a.instanceWorkspace
+	a.anomalies.instanceWorkspace = a.instanceWorkspace
+
+	a.upstream.config = a.liveConfig
+	a.mailer.config = a.liveConfig
+	a.mailer.deadLetters = a.deadLetters
+	a.forwarder.config = a.liveConfig
+	a.forwarder.mailer = a.mailer
+	a.forwarder.deadLetters = a.deadLetters
+	a.inFlight.config = a.liveConfig
+	a.labeler.config = a.liveConfig
+	a.shortener.config = a.liveConfig
+	a.slowRequests.config = a.liveConfig
+	a.rateLimiter.config = a.liveConfig
+
+	a.drift.app = a
+	a.vault.app = a
+	a.mediaDownloader.blobs = a.mediaBlobs()
+	a.mediaDownloader.dir = a.config.MediaDir
+	a.mediaDownloader.client = a.client
+	a.mediaDownloader.downloadURL = a.freshDownloadURL
+	a.thumbnails.size = a.config.ThumbnailCacheSize
+}
+
+func (a *App) load() error {
+	if err := a.instanceProfiles.load(); err != nil {
+		return fmt.Errorf("failed to read managed instances: %w", err)
+	}
+	if a.config.AuditFile != "" {
+		if err := a.audit.open(a.config.AuditFile); err != nil {
+			return err
+		}
+	}
+	if a.config.OptOutFile != "" {
+		if err := a.optOuts.load(a.config.OptOutFile); err != nil {
+			return err
+		}
+	}
+	if err := a.setupOAuth(context.Background()); err != nil {
+		return err
+	}
+	var err error
+	a.fileHost, err = newFileHost(a.config.FilesDir, a.config.FileTTL)
+	if err != nil {
+		return err
+	}
+	if a.config.MediaDir != "" {
+		if err := os.MkdirAll(a.config.MediaDir, 0o700); err != nil {
+			return fmt.Errorf("failed to create media dir: %w", err)
+		}
+	}
+	return nil
+}
+
+// close closes the storage database, if there is one.
+func (a *App) close() error {
+	return a.closeStorage()
+}
+
+// start runs the background workers until ctx is done.
+func (a *App) start(ctx context.Context) error {
+	go a.fileHost.runJanitor(time.Minute)
+	go a.seenNotifications.runJanitor(time.Minute)
+	go a.seenSignatures.runJanitor(time.Minute)
+	go a.scheduler.run(ctx)
+	go a.mediaDownloader.run(ctx)
+	go a.runSessionJanitor(time.Minute)
+	go a.watchConfig(ctx)
+	if err := a.addJobs(); err != nil {
+		return err
+	}
+	go a.jobs.run(ctx)
+	return nil
+}
+
+// ServeHTTP serves the routes of the app.
+func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+// serve listens on -listen and serves the app until ctx is done, then
+// drains requests and restores the webhook settings it changed.
+func (a *App) serve(ctx context.Context) error {
+	// Expose the server publicly before handlers read config.PublicURL
+	if a.config.Tunnel {
+		publicUrl, closeTunnel, err := a.openTunnel(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to open tunnel: %w", err)
+		}
+		defer closeTunnel()
+		a.config.PublicURL = publicUrl
+		a.config.RegisterWebhook = len(a.configuredInstances()) > 0
+		infof("Tunnel open at %s", publicUrl)
+	}
+
+	ln, err := listen(a.config.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	server := &http.Server{Handler: a.withProxy(a.corsPreflight(a))}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(ln)
+	}()
+	fmt.Printf("Server running on %s\n", listenURL(ln))
+
+	if a.config.RegisterWebhook {
+		a.webhookRegistrar.register(ctx, a.config.PublicURL+"/webhook")
+	}
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	infof("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+	if a.config.RegisterWebhook {
+		a.webhookRegistrar.restore(shutdownCtx)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAppsDontShareState builds two Apps in one process: what one receives,
+// records or is told must not show up in the other.
+func TestAppsDontShareState(t *testing.T) {
+	m := newMockGreenAPI(t)
+	first, second := newTestApp(t, m), newTestApp(t, m)
+
+	incoming := incomingWebhook("79009876543@c.us", "only for the first")
+	if w := serve(first, webhookRequest(testWebhookToken, incoming)()); w.Code != http.StatusOK {
+		t.Fatalf("webhook: status %d: %s", w.Code, w.Body)
+	}
+	// The same delivery is new to the second App, not a duplicate
+	if w := serve(second, webhookRequest(testWebhookToken, incoming)()); w.Code != http.StatusOK {
+		t.Fatalf("webhook to the second App: status %d: %s", w.Code, w.Body)
+	}
+	if n := len(listed[Notification](t, second, "/api/webhooks")); n != 1 {
+		t.Errorf("second App lists %d webhooks, want its own 1", n)
+	}
+
+	if w := serve(first, apiRequest(http.MethodPost, "/api/optouts", `{"phoneNumber":"79001234567"}`)); w.Code != http.StatusOK {
+		t.Fatalf("opt-out: status %d: %s", w.Code, w.Body)
+	}
+	if n := len(listed[OptOut](t, second, "/api/optouts")); n != 0 {
+		t.Errorf("second App lists %d opt-outs of the first", n)
+	}
+
+	send := sendMessageRequest(`{"idInstance":"` + testInstance + `","apiTokenInstance":"` + testToken + `","phoneNumber":"79007654321","messageText":"hi"}`)
+	if w := serve(first, send()); w.Code != http.StatusOK {
+		t.Fatalf("send: status %d: %s", w.Code, w.Body)
+	}
+	if n := len(listed[HistoryEntry](t, second, "/api/history")); n != 0 {
+		t.Errorf("second App has %d history entries of the first", n)
+	}
+	if n := len(listed[HistoryEntry](t, first, "/api/history")); n != 1 {
+		t.Errorf("first App has %d history entries, want 1", n)
+	}
+}
+
+// listed decodes the JSON list a GET of target answers.
+func listed[T any](t *testing.T, a *App, target string) []T {
+	t.Helper()
+	return listedBy[T](t, a, testAdminKey, target)
+}
+
+// listedBy is listed as seen by the holder of key.
+func listedBy[T any](t *testing.T, a *App, key, target string) []T {
+	t.Helper()
+	w := serve(a, keyRequest(key, http.MethodGet, target, ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s: status %d: %s", target, w.Code, w.Body)
+	}
+	var list []T
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("%s: %v: %s", target, err, w.Body)
+	}
+	return list
+}
+
+func TestDedupSurvivesRestart(t *testing.T) {
+	args := []string{"-storage", "sqlite:" + filepath.Join(t.TempDir(), "grapi.db")}
+	body := incomingWebhook("79009876543@c.us", "hello")
+	first := newTestApp(t, newMockGreenAPI(t), args...)
+	serve(first, webhookRequest(testWebhookToken, body)())
+	first.close()
+
+	second := newTestApp(t, newMockGreenAPI(t), args...)
+	serve(second, webhookRequest(testWebhookToken, body)())
+	if n := len(listed[Notification](t, second, "/api/webhooks")); n != 0 {
+		t.Errorf("the webhook seen before the restart was received again: %d listed", n)
+	}
+	serve(second, webhookRequest(testWebhookToken, incomingWebhook("79009876543@c.us", "hello"))())
+	if n := len(listed[Notification](t, second, "/api/webhooks")); n != 1 {
+		t.Errorf("%d webhooks listed after a new one, want 1", n)
+	}
+
+	// Expired keys can be seen again
+	seen := &SeenSet{store: &sqlSeen{db: second.db, kind: "test"}}
+	if !seen.firstSeenFor("key", 100*time.Millisecond) || seen.firstSeenFor("key", time.Hour) {
+		t.Fatal("a new key isn't seen first, or a live one is")
+	}
+	time.Sleep(150 * time.Millisecond)
+	if !seen.firstSeenFor("key", time.Hour) {
+		t.Error("an expired key isn't seen first")
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	var buf bytes.Buffer
+	previous := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() {
+		log.SetOutput(previous)
+		setLogLevel("info")
+	})
+
+	if err := setLogLevel("warn"); err != nil {
+		t.Fatal(err)
+	}
+	debugf("debug line")
+	infof("info line")
+	warnf("warn line")
+	errorf("error line")
+	if got := buf.String(); strings.Contains(got, "debug line") || strings.Contains(got, "info line") ||
+		!strings.Contains(got, "warn line") || !strings.Contains(got, "error line") {
+		t.Errorf("-log-level warn logged:\n%s", got)
+	}
+
+	if err := setLogLevel("verbose"); err == nil {
+		t.Error("an unknown level was accepted")
+	}
+}
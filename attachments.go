@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// maxPendingDownloads is how many incoming files may wait for the media
+// downloader. Files arriving while it is full are not saved.
+const maxPendingDownloads = 100
+
+// mediaDownloadTimeout bounds fetching one file.
+const mediaDownloadTimeout = 5 * time.Minute
+
+// Attachment is the file of an incoming message saved to -media-dir or
+// -s3-bucket, so it can still be shown after GREEN-API's download link
+// expires. Path is its name in MediaBlobs.
+type Attachment struct {
+	IDMessage    string    `json:"idMessage"`
+	IDInstance   string    `json:"idInstance"`
+	ChatID       string    `json:"chatId"`
+	TypeMessage  string    `json:"typeMessage"`
+	FileName     string    `json:"fileName"`
+	MimeType     string    `json:"mimeType,omitempty"`
+	Size         int64     `json:"size"`
+	Path         string    `json:"-"`
+	DownloadedAt time.Time `json:"downloadedAt"`
+}
+
+// attachmentURL is where the saved file of a message is served.
+func (a *App) attachmentURL(idInstance, idMessage string) string {
+	return a.appPath("/api/attachments/" + idInstance + "/" + idMessage)
+}
+
+// AttachmentStore keeps the metadata of saved files by instance and message
+// ID, as message IDs are only unique within an instance.
+type AttachmentStore interface {
+	add(attachment Attachment) error
+	get(idInstance, idMessage string) (Attachment, bool, error)
+	list() ([]Attachment, error)
+}
+
+// MediaDownloader saves the files of incoming messages in the background,
+// in blobs. With no blobs it saves nothing.
+type MediaDownloader struct {
+	store AttachmentStore
+	jobs  chan Notification
+
+	blobs MediaBlobs
+	// dir is where files are downloaded to before they go to blobs, the
+	// temp dir when empty.
+	dir    string
+	client *http.Client
+	// downloadURL asks GREEN-API for a current link to the file.
+	downloadURL func(ctx context.Context, idInstance string, notification Notification) (string, error)
+}
+
+func newMediaDownloader() *MediaDownloader {
+	return &MediaDownloader{store: newMemoryAttachments(), jobs: make(chan Notification, maxPendingDownloads)}
+}
+
+// enqueue hands an incoming file message to the downloader. It never
+// blocks the webhook.
+func (d *MediaDownloader) enqueue(notification Notification) {
+	if d.blobs == nil || notification.File == nil || notification.TypeWebhook != "incomingMessageReceived" {
+		return
+	}
+	select {
+	case d.jobs <- notification:
+	default:
+		warnf("Media downloader busy, not saving the file of message %s", notification.IDMessage)
+	}
+}
+
+// run saves queued files until the context ends.
+func (d *MediaDownloader) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case notification := <-d.jobs:
+			if err := d.save(ctx, notification); err != nil {
+				errorf("Failed to save the file of message %s: %v", notification.IDMessage, err)
+			}
+		}
+	}
+}
+
+// save downloads the file of a message and records it. Files already saved,
+// e.g. from a redelivered webhook, are skipped.
+func (d *MediaDownloader) save(ctx context.Context, notification Notification) error {
+	idInstance := strconv.FormatInt(notification.IDInstance, 10)
+	if _, ok, err := d.store.get(idInstance, notification.IDMessage); err != nil || ok {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, mediaDownloadTimeout)
+	defer cancel()
+
+	downloadUrl, err := d.downloadURL(ctx, idInstance, notification)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadUrl, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download returned status %d", resp.StatusCode)
+	}
+
+	file, err := os.CreateTemp(d.dir, ".download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	size, err := io.Copy(file, io.LimitReader(resp.Body, maxMediaSize+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size > maxMediaSize {
+		err = fmt.Errorf("file is larger than %d MB", maxMediaSize>>20)
+	}
+	if err != nil {
+		return err
+	}
+
+	mimeType := notification.File.MimeType
+	if mimeType == "" {
+		mimeType = resp.Header.Get("Content-Type")
+	}
+	name := newMediaID()
+	if err := d.blobs.put(ctx, name, file.Name(), mimeType); err != nil {
+		return err
+	}
+	return d.store.add(Attachment{
+		IDMessage:    notification.IDMessage,
+		IDInstance:   idInstance,
+		ChatID:       notification.ChatID,
+		TypeMessage:  notification.TypeMessage,
+		FileName:     notification.File.FileName,
+		MimeType:     mimeType,
+		Size:         size,
+		Path:         name,
+		DownloadedAt: time.Now(),
+	})
+}
+
+// freshDownloadURL asks downloadFile for a current link to the file. Only
+// files of configured instances are saved: the link in the webhook body is
+// never fetched, as anyone who can reach /webhook could point it anywhere.
+func (a *App) freshDownloadURL(ctx context.Context, idInstance string, notification Notification) (string, error) {
+	for _, instance := range a.configuredInstances() {
+		if instance.IDInstance != idInstance {
+			continue
+		}
+		response, _, err := a.makeAPIRequestWithPayload(ctx, "downloadFile", a.instanceURL(instance, "downloadFile"), map[string]interface{}{
+			"chatId":    notification.ChatID,
+			"idMessage": notification.IDMessage,
+		})
+		if err != nil {
+			return "", fmt.Errorf("downloadFile: %w", err)
+		}
+		downloadUrl, _ := response["downloadUrl"].(string)
+		if downloadUrl == "" {
+			return "", errors.New("downloadFile returned no download URL")
+		}
+		return downloadUrl, nil
+	}
+	return "", fmt.Errorf("instance %s is not configured", idInstance)
+}
+
+// attachmentView is how a saved file is listed.
+type attachmentView struct {
+	Attachment
+	URL string `json:"url"`
+}
+
+// attachmentSpec sorts saved files by time (oldest first), chat or size and
+// searches their chats and names.
+var attachmentSpec = listSpec[attachmentView]{
+	sorts: map[string]func(a, b attachmentView) int{
+		"time": byNumber(func(a attachmentView) int64 { return a.DownloadedAt.UnixNano() }),
+		"chat": byString(func(a attachmentView) string { return a.ChatID }),
+		"size": byNumber(func(a attachmentView) int64 { return a.Size }),
+	},
+	text: func(a attachmentView) []string { return []string{a.ChatID, a.FileName, a.MimeType} },
+}
+
+func (a *App) attachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseListQuery(w, r)
+	if !ok || !attachmentSpec.check(w, r, &q, defaultPageSize) {
+		return
+	}
+	attachments, err := a.mediaDownloader.store.list()
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	workspace := workspaceOf(r)
+	list := make([]attachmentView, 0, len(attachments))
+	for _, attachment := range attachments {
+		if canSee(workspace, a.instanceWorkspace(attachment.IDInstance)) {
+			list = append(list, attachmentView{Attachment: attachment, URL: a.attachmentURL(attachment.IDInstance, attachment.IDMessage)})
+		}
+	}
+	page, total := attachmentSpec.apply(list, q)
+	writeList(w, page, total)
+}
+
+// attachmentHandler serves the saved file of a message as a download, or
+// redirects to a presigned link to it in -s3-bucket. The file and its type
+// come from whoever sent the message, so it is never rendered as a page of
+// the proxy.
+func (a *App) attachmentHandler(w http.ResponseWriter, r *http.Request) {
+	attachment, ok, err := a.mediaDownloader.store.get(r.PathValue("idInstance"), r.PathValue("idMessage"))
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	blobs := a.mediaDownloader.blobs
+	if !ok || blobs == nil || !canSee(workspaceOf(r), a.instanceWorkspace(attachment.IDInstance)) {
+		writeError(w, r, http.StatusNotFound, "not_found", "Attachment not found")
+		return
+	}
+	if link := blobs.link(attachment); link != "" {
+		http.Redirect(w, r, link, http.StatusFound)
+		return
+	}
+
+	file, err := blobs.open(r.Context(), attachment.Path)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "not_found", "Attachment not found")
+		return
+	}
+	defer file.Close()
+
+	if attachment.MimeType != "" {
+		w.Header().Set("Content-Type", attachment.MimeType)
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", attachment.FileName))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, attachment.FileName, attachment.DownloadedAt, seeker)
+		return
+	}
+	io.Copy(w, file)
+}
+
+// memoryAttachments keeps file metadata in a map keyed by chatKey of the
+// instance and message ID, so the saved files are forgotten on restart.
+type memoryAttachments struct {
+	attachments *SyncMap[string, Attachment]
+}
+
+func newMemoryAttachments() *memoryAttachments {
+	return &memoryAttachments{attachments: newSyncMap[string, Attachment]()}
+}
+
+func (s *memoryAttachments) add(attachment Attachment) error {
+	s.attachments.set(chatKey(attachment.IDInstance, attachment.IDMessage), attachment)
+	return nil
+}
+
+func (s *memoryAttachments) get(idInstance, idMessage string) (Attachment, bool, error) {
+	attachment, ok := s.attachments.get(chatKey(idInstance, idMessage))
+	return attachment, ok, nil
+}
+
+func (s *memoryAttachments) list() ([]Attachment, error) {
+	list := s.attachments.values()
+	sort.Slice(list, func(i, j int) bool { return list[i].DownloadedAt.Before(list[j].DownloadedAt) })
+	return list, nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxAuditRecords is how many audit records are kept in memory. With
+// -audit-file set the file keeps all of them.
+const maxAuditRecords = 10000
+
+// audited reports whether a GREEN-API method sends something to a chat.
+func audited(method string) bool {
+	return apiMethods[method].Audited
+}
+
+// AuditActor identifies who triggered a send. User is the API key name when
+// auth is enabled.
+type AuditActor struct {
+	User       string `json:"user,omitempty"`
+	RemoteAddr string `json:"remoteAddr"`
+	UserAgent  string `json:"userAgent,omitempty"`
+}
+
+// OutboundMessage describes a sent message for the audit log. Content is
+// hashed, never stored.
+type OutboundMessage struct {
+	Method  string
+	URL     string
+	ChatID  string
+	Content string
+}
+
+// AuditRecord is one outbound message or event. Records form a hash chain: each
+// Hash covers the record and the previous Hash, so edits and deletions
+// show up when the chain is verified.
+type AuditRecord struct {
+	Seq   int64      `json:"seq"`
+	Time  time.Time  `json:"time"`
+	Actor AuditActor `json:"actor"`
+	// Event names records that aren't a message, like optOut.
+	Event         string `json:"event,omitempty"`
+	IDInstance    string `json:"idInstance"`
+	APIKey        string `json:"apiKey"`
+	Method        string `json:"method"`
+	ChatID        string `json:"chatId"`
+	ContentHash   string `json:"contentHash"`
+	ContentLength int    `json:"contentLength"`
+	StatusCode    int    `json:"statusCode"`
+	IDMessage     string `json:"idMessage,omitempty"`
+	Error         string `json:"error,omitempty"`
+	PrevHash      string `json:"prevHash"`
+	Hash          string `json:"hash"`
+}
+
+// AuditLog is the append-only log of outbound messages.
+type AuditLog struct {
+	mu       sync.Mutex
+	records  []AuditRecord
+	nextSeq  int64
+	lastHash string
+	file     *os.File
+	// instanceWorkspace returns the workspace of an instance.
+	instanceWorkspace func(idInstance string) string
+}
+
+// payloadMessage describes a JSON call for the audit log, hashing the whole
+// payload as its content.
+func payloadMessage(method, apiUrl string, payload map[string]interface{}) OutboundMessage {
+	chatId, _ := payload["chatId"].(string)
+	content, _ := json.Marshal(payload)
+	return OutboundMessage{Method: method, URL: apiUrl, ChatID: chatId, Content: string(content)}
+}
+
+func actorOf(r *http.Request) AuditActor {
+	actor := AuditActor{RemoteAddr: r.RemoteAddr, UserAgent: r.UserAgent()}
+	if principal, ok := principalOf(r); ok {
+		actor.User = principal.Name
+	}
+	return actor
+}
+
+// shortHash is the first 16 hex digits of the SHA-256 of value.
+func shortHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:8])
+}
+
+// apiKeyOf identifies the apiTokenInstance of a GREEN-API URL without
+// revealing it.
+func apiKeyOf(apiUrl string) string {
+	parts := strings.Split(apiUrl, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, "waInstance") && i+2 < len(parts) {
+			token, _, _ := strings.Cut(parts[i+2], "?")
+			return shortHash(token)
+		}
+	}
+	return ""
+}
+
+// open continues the log stored in path, verifying its chain, and appends
+// new records there.
+func (a *AuditLog) open(path string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			file.Close()
+			return fmt.Errorf("invalid audit record after seq %d: %w", a.nextSeq-1, err)
+		}
+		if record.PrevHash != a.lastHash || record.Hash != record.chainHash() {
+			errorf("Audit log %s is broken at seq %d", path, record.Seq)
+		}
+		a.append(record)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return err
+	}
+
+	a.file = file
+	return nil
+}
+
+// chainHash is the hash a record must carry given its PrevHash.
+func (record AuditRecord) chainHash() string {
+	record.Hash = ""
+	data, _ := json.Marshal(record)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// append adds a record to memory. The caller holds mu.
+func (a *AuditLog) append(record AuditRecord) {
+	if len(a.records) >= maxAuditRecords {
+		a.records = a.records[1:]
+	}
+	a.records = append(a.records, record)
+	a.nextSeq = record.Seq + 1
+	a.lastHash = record.Hash
+}
+
+// record appends the outcome of an outbound message.
+func (a *AuditLog) record(actor AuditActor, message OutboundMessage, statusCode int, response map[string]interface{}, err error) {
+	record := AuditRecord{
+		Time:          time.Now(),
+		Actor:         actor,
+		IDInstance:    instanceID(message.URL),
+		APIKey:        apiKeyOf(message.URL),
+		Method:        message.Method,
+		ChatID:        message.ChatID,
+		ContentHash:   shortHash(message.Content),
+		ContentLength: len(message.Content),
+		StatusCode:    statusCode,
+	}
+	record.IDMessage, _ = response["idMessage"].(string)
+	if err != nil {
+		record.Error = err.Error()
+	}
+	a.write(record)
+}
+
+// event appends something done to a chat other than sending it a message,
+// like opting it out. Detail is hashed as the content.
+func (a *AuditLog) event(actor AuditActor, event, idInstance, chatId, detail string) {
+	a.write(AuditRecord{
+		Time:          time.Now(),
+		Actor:         actor,
+		Event:         event,
+		IDInstance:    idInstance,
+		ChatID:        chatId,
+		ContentHash:   shortHash(detail),
+		ContentLength: len(detail),
+	})
+}
+
+// write chains a record to the log and saves it.
+func (a *AuditLog) write(record AuditRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	record.Seq = a.nextSeq
+	record.PrevHash = a.lastHash
+	record.Hash = record.chainHash()
+	a.append(record)
+
+	if a.file != nil {
+		data, _ := json.Marshal(record)
+		if _, err := a.file.Write(append(data, '\n')); err != nil {
+			errorf("Failed to write audit record %d: %v", record.Seq, err)
+		}
+	}
+}
+
+// list returns the records of instances workspace may see, newest first.
+func (a *AuditLog) list(workspace string, limit int) []AuditRecord {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if limit <= 0 || limit > len(a.records) {
+		limit = len(a.records)
+	}
+	list := make([]AuditRecord, 0, limit)
+	for i := len(a.records) - 1; i >= 0 && len(list) < limit; i-- {
+		if canSee(workspace, a.instanceWorkspace(a.records[i].IDInstance)) {
+			list = append(list, a.records[i])
+		}
+	}
+	return list
+}
+
+func (a *App) auditHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			writeError(w, r, http.StatusBadRequest, "invalid_request", "limit must be a positive number")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.audit.list(workspaceOf(r), limit))
+}
+
+// auditExportHandler downloads the log as JSON lines, oldest first, from
+// the audit file when there is one so nothing trimmed from memory is lost.
+// Callers in a workspace get their records from memory.
+func (a *App) auditExportHandler(w http.ResponseWriter, r *http.Request) {
+	fileName := fmt.Sprintf("greenapi-audit-%s.jsonl", time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+
+	workspace := workspaceOf(r)
+	if a.config.AuditFile != "" && workspace == "" {
+		http.ServeFile(w, r, a.config.AuditFile)
+		return
+	}
+
+	records := a.audit.list(workspace, 0)
+	encoder := json.NewEncoder(w)
+	for i := len(records) - 1; i >= 0; i-- {
+		encoder.Encode(records[i])
+	}
+}
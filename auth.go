@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Role is what a caller may do. Each role includes the ones below it.
+type Role int
+
+const (
+	roleViewer Role = iota + 1
+	roleSender
+	roleAdmin
+)
+
+var roleNames = map[Role]string{
+	roleViewer: "viewer",
+	roleSender: "sender",
+	roleAdmin:  "admin",
+}
+
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+func parseRole(name string) (Role, error) {
+	for role, roleName := range roleNames {
+		if roleName == name {
+			return role, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role %q, expected viewer, sender or admin", name)
+}
+
+// APIKey is a named credential with a role, optionally limited to a
+// workspace.
+type APIKey struct {
+	Workspace string
+	Name      string
+	Role      Role
+	Key       string
+}
+
+// apiKeyList parses comma-separated [workspace/]name:role:key triples. Auth
+// is enabled as soon as one key is configured.
+type apiKeyList []APIKey
+
+func (l *apiKeyList) String() string {
+	names := make([]string, len(*l))
+	for i, key := range *l {
+		names[i] = key.Name + ":" + key.Role.String()
+		if key.Workspace != "" {
+			names[i] = key.Workspace + "/" + names[i]
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+func (l *apiKeyList) Set(value string) error {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return fmt.Errorf("invalid API key %q, expected name:role:key", parts[0])
+		}
+		role, err := parseRole(parts[1])
+		if err != nil {
+			return err
+		}
+		workspace, name := splitWorkspace(parts[0])
+		*l = append(*l, APIKey{Workspace: workspace, Name: name, Role: role, Key: parts[2]})
+	}
+	return nil
+}
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Name      string
+	Role      Role
+	Workspace string
+}
+
+type principalKey struct{}
+
+// principalOf returns the caller of an authenticated request.
+func principalOf(r *http.Request) (Principal, bool) {
+	principal, ok := r.Context().Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+func (a *App) authEnabled() bool {
+	return len(a.liveConfig().APIKeys) > 0 || a.config.OAuthProvider != ""
+}
+
+// authenticate finds the key presented as a bearer token or X-API-Key,
+// falling back to the session cookie of a signed-in browser.
+func (a *App) authenticate(r *http.Request) (Principal, bool) {
+	presented := r.Header.Get("X-API-Key")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		presented = bearer
+	}
+	if presented == "" {
+		return a.sessionPrincipal(r)
+	}
+	return a.keyPrincipal(presented)
+}
+
+// keyPrincipal returns the owner of an API key.
+func (a *App) keyPrincipal(presented string) (Principal, bool) {
+	for _, key := range a.liveConfig().APIKeys {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(key.Key)) == 1 {
+			return Principal{Name: key.Name, Role: key.Role, Workspace: key.Workspace}, true
+		}
+	}
+	return Principal{}, false
+}
+
+// authorize checks the caller holds at least role, writing 401 or 403 when
+// not. With auth disabled everything is allowed.
+func (a *App) authorize(w http.ResponseWriter, r *http.Request, role Role) (*http.Request, bool) {
+	if !a.authEnabled() {
+		return r, true
+	}
+
+	principal, ok := principalOf(r)
+	if !ok {
+		if principal, ok = a.authenticate(r); !ok {
+			if wantsPage(r) {
+				a.loginRedirect(w, r)
+				return r, false
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="grapi"`)
+			writeError(w, r, http.StatusUnauthorized, "unauthorized", "A valid API key is required")
+			return r, false
+		}
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+	}
+
+	if principal.Role < role {
+		writeErrorf(w, r, http.StatusForbidden, "forbidden", "The %s role is not allowed to do this, %s is required", principal.Role, role)
+		return r, false
+	}
+	return r, true
+}
+
+// holdsRole reports whether the caller of an authorized request holds at
+// least role, which everyone does with auth disabled.
+func (a *App) holdsRole(r *http.Request, role Role) bool {
+	if !a.authEnabled() {
+		return true
+	}
+	principal, ok := principalOf(r)
+	return ok && principal.Role >= role
+}
+
+// requireRole wraps a handler so only callers with at least role reach it.
+func (a *App) requireRole(role Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r, ok := a.authorize(w, r, role)
+		if !ok {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// readMethodPrefixes start the names of GREEN-API methods that only read.
+// Note logout and reboot are GET requests, so the verb says nothing.
+var readMethodPrefixes = []string{"get", "check", "show", "last", "download"}
+
+// rawMethodRole is the role a raw GREEN-API call needs. Catalogued methods
+// say; for others reads are for viewers and anything else, such as
+// clearing the message queue, for admins.
+func rawMethodRole(method string) Role {
+	if m, ok := apiMethods[method]; ok {
+		return m.Role
+	}
+	for _, prefix := range readMethodPrefixes {
+		if strings.HasPrefix(method, prefix) {
+			return roleViewer
+		}
+	}
+	return roleAdmin
+}
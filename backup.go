@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	errInvalidBackup = errors.New("not a backup of this server")
+	errNewerBackup   = errors.New("backup is from a newer version")
+)
+
+// sqliteHeader starts every SQLite database file.
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// copySQLite copies the src database over dest with SQLite's online backup
+// API, so src may keep changing while it is read.
+func copySQLite(dest, src *sql.Conn) error {
+	return dest.Raw(func(destConn interface{}) error {
+		return src.Raw(func(srcConn interface{}) error {
+			backup, err := destConn.(*sqlite3.SQLiteConn).Backup("main", srcConn.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Close()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// withSQLiteFile runs fn on a connection to the SQLite file at path.
+func withSQLiteFile(ctx context.Context, path string, fn func(*sql.Conn) error) error {
+	db, err := sql.Open(sqliteDialect.driver, "file:"+path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return fn(conn)
+}
+
+// withConn runs fn on a connection to the database.
+func (d *sqlDB) withConn(ctx context.Context, fn func(*sql.Conn) error) error {
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return fn(conn)
+}
+
+// checkBackupAccess allows backups only of SQLite storage, and only to
+// admins outside a workspace since a backup holds every workspace.
+func (a *App) checkBackupAccess(w http.ResponseWriter, r *http.Request) bool {
+	if a.db == nil || a.db.dialect != sqliteDialect {
+		writeError(w, r, http.StatusBadRequest, "backup_unsupported", "Backups need -storage sqlite:path")
+		return false
+	}
+	if workspaceOf(r) != "" {
+		writeError(w, r, http.StatusForbidden, "forbidden", "Backups cover every workspace")
+		return false
+	}
+	return true
+}
+
+// backupHandler streams a consistent snapshot of the storage database.
+func (a *App) backupHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.checkBackupAccess(w, r) {
+		return
+	}
+
+	file, err := os.CreateTemp("", "grapi-backup-*.db")
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	err = withSQLiteFile(r.Context(), file.Name(), func(dest *sql.Conn) error {
+		return a.db.withConn(r.Context(), func(src *sql.Conn) error {
+			return copySQLite(dest, src)
+		})
+	})
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	snapshot, err := os.Open(file.Name())
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	defer snapshot.Close()
+
+	name := fmt.Sprintf("grapi-%s.db", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, time.Now(), snapshot)
+}
+
+// restoreHandler replaces the storage database with an uploaded backup,
+// then migrates it if it comes from an older version. Backups from a newer
+// version are refused.
+func (a *App) restoreHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.checkBackupAccess(w, r) {
+		return
+	}
+
+	file, err := os.CreateTemp("", "grapi-restore-*.db")
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	defer os.Remove(file.Name())
+
+	_, err = io.Copy(file, http.MaxBytesReader(w, r.Body, a.config.MaxUploadSize))
+	file.Close()
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	if header, err := readHeader(file.Name(), len(sqliteHeader)); err != nil || !bytes.Equal(header, sqliteHeader) {
+		writeError(w, r, http.StatusBadRequest, "invalid_backup", "Not a backup of this server")
+		return
+	}
+
+	migrations, err := sqliteDialect.migrations()
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	latest := migrations[len(migrations)-1].Version
+	var version int
+	err = withSQLiteFile(r.Context(), file.Name(), func(src *sql.Conn) error {
+		var check string
+		if err := src.QueryRowContext(r.Context(), `PRAGMA quick_check`).Scan(&check); err != nil || check != "ok" {
+			return errInvalidBackup
+		}
+		current, err := schemaVersion(r.Context(), src)
+		if err != nil {
+			return errInvalidBackup
+		}
+		if version = current; version > latest {
+			return errNewerBackup
+		}
+		return a.db.withConn(r.Context(), func(dest *sql.Conn) error {
+			return copySQLite(dest, src)
+		})
+	})
+	switch {
+	case errors.Is(err, errInvalidBackup):
+		writeError(w, r, http.StatusBadRequest, "invalid_backup", "Not a backup of this server")
+		return
+	case errors.Is(err, errNewerBackup):
+		writeError(w, r, http.StatusBadRequest, "invalid_backup", "Backup is from a newer version of this server")
+		return
+	case err != nil:
+		writeStorageError(w, r, err)
+		return
+	}
+
+	if err := a.db.migrate(r.Context()); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	// Restored sends may be due already
+	a.scheduler.wakeUp()
+	if err := a.instanceProfiles.load(); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	infof("Storage restored from a backup at schema version %d by %s", version, actorName(r))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readHeader returns the first n bytes of a file.
+func readHeader(path string, n int) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, n)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxTrackedBatches is how many recent broadcasts keep their delivery
+// statistics.
+const maxTrackedBatches = 100
+
+// trackedMessage is when a broadcast message was sent, delivered and read,
+// as reported by outgoingMessageStatus webhooks.
+type trackedMessage struct {
+	SentAt      time.Time
+	DeliveredAt time.Time
+	ReadAt      time.Time
+	Failed      bool
+}
+
+type trackedBatch struct {
+	ID         int64
+	IDInstance string
+	Method     string
+	CreatedAt  time.Time
+	Recipients int
+	messages   map[string]*trackedMessage
+	links      []ShortLink
+}
+
+// BatchTracker follows the messages of recent broadcasts through their
+// status webhooks. Deferred recipients aren't tracked, since their
+// messages go out later through the scheduler.
+type BatchTracker struct {
+	mu        sync.Mutex
+	batches   map[int64]*trackedBatch
+	order     []int64
+	byMessage map[string]*trackedMessage
+	nextID    int64
+}
+
+func newBatchTracker() *BatchTracker {
+	return &BatchTracker{
+		batches:   make(map[int64]*trackedBatch),
+		byMessage: make(map[string]*trackedMessage),
+		nextID:    1,
+	}
+}
+
+// start begins tracking a broadcast and returns its ID.
+func (t *BatchTracker) start(idInstance, method string, recipients int) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.order) >= maxTrackedBatches {
+		oldest := t.batches[t.order[0]]
+		for idMessage := range oldest.messages {
+			delete(t.byMessage, idMessage)
+		}
+		delete(t.batches, oldest.ID)
+		t.order = t.order[1:]
+	}
+
+	batch := &trackedBatch{
+		ID:         t.nextID,
+		IDInstance: idInstance,
+		Method:     method,
+		CreatedAt:  time.Now(),
+		Recipients: recipients,
+		messages:   make(map[string]*trackedMessage),
+	}
+	t.nextID++
+	t.batches[batch.ID] = batch
+	t.order = append(t.order, batch.ID)
+	return batch.ID
+}
+
+// sent records a message of a batch accepted by GREEN-API.
+func (t *BatchTracker) sent(id int64, idMessage string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch, ok := t.batches[id]
+	if !ok || idMessage == "" {
+		return
+	}
+	message := &trackedMessage{SentAt: at}
+	batch.messages[idMessage] = message
+	t.byMessage[idMessage] = message
+}
+
+// linked records the short links -shortener put in the messages of a
+// batch.
+func (t *BatchTracker) linked(id int64, links []ShortLink) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if batch, ok := t.batches[id]; ok {
+		batch.links = links
+	}
+}
+
+// links returns the instance of a batch and a copy of its short links.
+func (t *BatchTracker) links(id int64) (string, []ShortLink, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch, ok := t.batches[id]
+	if !ok {
+		return "", nil, false
+	}
+	return batch.IDInstance, append([]ShortLink{}, batch.links...), true
+}
+
+// ids lists the tracked batches, newest first.
+func (t *BatchTracker) ids() []int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ids := slices.Clone(t.order)
+	slices.Reverse(ids)
+	return ids
+}
+
+// recordStatus applies an outgoingMessageStatus webhook to the message it
+// is about, if it belongs to a tracked batch. Statuses may arrive out of
+// order, so a read message counts as delivered too.
+func (t *BatchTracker) recordStatus(notification Notification) {
+	if notification.TypeWebhook != "outgoingMessageStatus" {
+		return
+	}
+	var status struct {
+		IDMessage string `json:"idMessage"`
+		Status    string `json:"status"`
+		Timestamp int64  `json:"timestamp"`
+	}
+	if err := json.Unmarshal(notification.Body, &status); err != nil {
+		return
+	}
+	at := notification.ReceivedAt
+	if status.Timestamp > 0 {
+		at = time.Unix(status.Timestamp, 0)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	message, ok := t.byMessage[status.IDMessage]
+	if !ok {
+		return
+	}
+	switch status.Status {
+	case "delivered":
+		if message.DeliveredAt.IsZero() || at.Before(message.DeliveredAt) {
+			message.DeliveredAt = at
+		}
+	case "read":
+		if message.ReadAt.IsZero() {
+			message.ReadAt = at
+		}
+		if message.DeliveredAt.IsZero() {
+			message.DeliveredAt = at
+		}
+	case "failed", "noAccount", "notInGroup", "yellowCard":
+		message.Failed = true
+	}
+}
+
+// BatchAnalytics is how far the messages of a broadcast got.
+type BatchAnalytics struct {
+	ID            int64         `json:"id"`
+	IDInstance    string        `json:"idInstance"`
+	Method        string        `json:"method"`
+	CreatedAt     time.Time     `json:"createdAt"`
+	Recipients    int           `json:"recipients"`
+	Sent          int           `json:"sent"`
+	Delivered     int           `json:"delivered"`
+	Read          int           `json:"read"`
+	Failed        int           `json:"failed"`
+	Pending       int           `json:"pending"`
+	DeliveryRate  float64       `json:"deliveryRate"`
+	ReadRate      float64       `json:"readRate"`
+	TimeToDeliver TimingSummary `json:"timeToDeliver"`
+	TimeToRead    TimingSummary `json:"timeToRead"`
+}
+
+// TimingSummary gives percentiles of the time from sending a message to a
+// later status, empty without any message reaching it.
+type TimingSummary struct {
+	P50 string `json:"p50,omitempty"`
+	P90 string `json:"p90,omitempty"`
+	P99 string `json:"p99,omitempty"`
+	Max string `json:"max,omitempty"`
+}
+
+func (t *BatchTracker) analytics(id int64) (BatchAnalytics, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	batch, ok := t.batches[id]
+	if !ok {
+		return BatchAnalytics{}, false
+	}
+	analytics := BatchAnalytics{
+		ID:         batch.ID,
+		IDInstance: batch.IDInstance,
+		Method:     batch.Method,
+		CreatedAt:  batch.CreatedAt,
+		Recipients: batch.Recipients,
+		Sent:       len(batch.messages),
+	}
+	var toDeliver, toRead []time.Duration
+	for _, message := range batch.messages {
+		switch {
+		case !message.DeliveredAt.IsZero():
+			analytics.Delivered++
+			toDeliver = append(toDeliver, max(message.DeliveredAt.Sub(message.SentAt), 0))
+			if !message.ReadAt.IsZero() {
+				analytics.Read++
+				toRead = append(toRead, max(message.ReadAt.Sub(message.SentAt), 0))
+			}
+		case message.Failed:
+			analytics.Failed++
+		default:
+			analytics.Pending++
+		}
+	}
+	if analytics.Sent > 0 {
+		analytics.DeliveryRate = float64(analytics.Delivered) / float64(analytics.Sent)
+		analytics.ReadRate = float64(analytics.Read) / float64(analytics.Sent)
+	}
+	analytics.TimeToDeliver = summarizeTimings(toDeliver)
+	analytics.TimeToRead = summarizeTimings(toRead)
+	return analytics, true
+}
+
+func summarizeTimings(timings []time.Duration) TimingSummary {
+	if len(timings) == 0 {
+		return TimingSummary{}
+	}
+	slices.Sort(timings)
+	percentile := func(p int) string {
+		// Nearest rank
+		rank := (p*len(timings) + 99) / 100
+		return timings[max(rank, 1)-1].Round(time.Millisecond).String()
+	}
+	return TimingSummary{
+		P50: percentile(50),
+		P90: percentile(90),
+		P99: percentile(99),
+		Max: timings[len(timings)-1].Round(time.Millisecond).String(),
+	}
+}
+
+func (a *App) batchAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid batch ID")
+		return
+	}
+	analytics, ok := a.batches.analytics(id)
+	if !ok || !canSee(workspaceOf(r), a.instanceWorkspace(analytics.IDInstance)) {
+		writeErrorf(w, r, http.StatusNotFound, "not_found", "Batch %d not found", id)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(analytics)
+}
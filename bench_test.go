@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The benchmarks run requests through the middleware, the handlers and the
+// GREEN-API client against the mock, as a client of the proxy would. To see
+// where the time goes:
+//
+//	go test -run XXX -bench . -benchmem -cpuprofile cpu.out -memprofile mem.out
+//	go tool pprof cpu.out
+
+// benchArgs lift the rate limit, which would otherwise throttle the
+// benchmark after -rate-burst requests.
+var benchArgs = []string{"-rate-limit", "0"}
+
+// webhookSeq numbers the webhooks the benchmarks send, as webhooks seen
+// before are dropped.
+var webhookSeq atomic.Int64
+
+// incomingWebhook is an incoming text message with a new idMessage.
+func incomingWebhook(chatId, text string) string {
+	idMessage := strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatInt(webhookSeq.Add(1), 10)
+	return `{"typeWebhook":"incomingMessageReceived","instanceData":{"idInstance":1101000001,"wid":"79001234567@c.us","typeInstance":"whatsapp"},` +
+		`"timestamp":1700000000,"idMessage":"` + idMessage + `","senderData":{"chatId":"` + chatId + `","sender":"` + chatId + `"},` +
+		`"messageData":{"typeMessage":"textMessage","textMessageData":{"textMessage":"` + text + `"}}}`
+}
+
+func BenchmarkSendMessage(b *testing.B) {
+	a := newTestApp(b, newMockGreenAPI(b), benchArgs...)
+	body := `{"idInstance":"` + testInstance + `","apiTokenInstance":"` + testToken + `","phoneNumber":"79001234567","messageText":"hi"}`
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if w := serve(a, apiRequest(http.MethodPost, "/api/send-message", body)); w.Code != http.StatusOK {
+			b.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+	}
+}
+
+func BenchmarkSendMessageParallel(b *testing.B) {
+	a := newTestApp(b, newMockGreenAPI(b), benchArgs...)
+	body := `{"idInstance":"` + testInstance + `","apiTokenInstance":"` + testToken + `","phoneNumber":"79001234567","messageText":"hi"}`
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if w := serve(a, apiRequest(http.MethodPost, "/api/send-message", body)); w.Code != http.StatusOK {
+				b.Errorf("status %d: %s", w.Code, w.Body)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkWebhook(b *testing.B) {
+	a := newTestApp(b, newMockGreenAPI(b), benchArgs...)
+	bodies := make([]string, b.N)
+	for i := range bodies {
+		bodies[i] = incomingWebhook("79009876543@c.us", "hello")
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if w := serve(a, webhookRequest(testWebhookToken, bodies[i])()); w.Code != http.StatusOK {
+			b.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+	}
+}
+
+// seedWebhooks receives n messages spread over 20 chats, every tenth
+// mentioning an invoice.
+func seedWebhooks(b *testing.B, a *App, n int) {
+	b.Helper()
+	for i := 0; i < n; i++ {
+		text := "hello there"
+		if i%10 == 0 {
+			text = "about the invoice " + strconv.Itoa(i)
+		}
+		chatId := fmt.Sprintf("790098765%02d@c.us", i%20)
+		if w := serve(a, webhookRequest(testWebhookToken, incomingWebhook(chatId, text))()); w.Code != http.StatusOK {
+			b.Fatalf("seeding: status %d: %s", w.Code, w.Body)
+		}
+	}
+}
+
+func BenchmarkListWebhooks(b *testing.B) {
+	a := newTestApp(b, newMockGreenAPI(b), benchArgs...)
+	seedWebhooks(b, a, 200)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if w := serve(a, apiRequest(http.MethodGet, "/api/webhooks?limit=50", "")); w.Code != http.StatusOK {
+			b.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	a := newTestApp(b, newMockGreenAPI(b), benchArgs...)
+	seedWebhooks(b, a, 200)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if w := serve(a, apiRequest(http.MethodGet, "/api/search?q=invoice", "")); w.Code != http.StatusOK {
+			b.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+	}
+}
+
+// allocBudgets are the most allocations a request may make, counting those
+// of the mock answering it. They leave some room over the 295 and 298 the
+// requests make now; raise one only for a change worth the allocations.
+var allocBudgets = map[string]float64{
+	"send-message": 340,
+	"webhook":      340,
+}
+
+func TestAllocBudget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipped with -short")
+	}
+	if raceEnabled {
+		t.Skip("the race detector allocates")
+	}
+	body := `{"idInstance":"` + testInstance + `","apiTokenInstance":"` + testToken + `","phoneNumber":"79001234567","messageText":"hi"}`
+	cases := []struct {
+		name string
+		run  func(a *App) int
+	}{
+		{
+			name: "send-message",
+			run: func(a *App) int {
+				return serve(a, apiRequest(http.MethodPost, "/api/send-message", body)).Code
+			},
+		},
+		{
+			name: "webhook",
+			run: func(a *App) int {
+				return serve(a, webhookRequest(testWebhookToken, incomingWebhook("79009876543@c.us", "hello"))()).Code
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newTestApp(t, newMockGreenAPI(t), benchArgs...)
+			// Warm up connections and lazily built state first
+			if status := tc.run(a); status != http.StatusOK {
+				t.Fatalf("status %d", status)
+			}
+			allocs := testing.AllocsPerRun(50, func() { tc.run(a) })
+			t.Logf("%s: %.0f allocations a request", tc.name, allocs)
+			if budget := allocBudgets[tc.name]; allocs > budget {
+				t.Errorf("%s makes %.0f allocations a request, over its budget of %.0f", tc.name, allocs, budget)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// errUpstreamDown is returned without calling GREEN-API while the circuit
+// breaker is open.
+var errUpstreamDown = errors.New("GREEN-API is unreachable, calls are paused")
+
+// Breaker stops calling GREEN-API for an instance after -breaker-threshold
+// calls in a row failed to reach it: network errors, timeouts and 5xx
+// answers. Once open, calls fail at once until -breaker-cooldown has
+// passed; then a single call is let through as a probe, which closes the
+// breaker when it gets an answer and opens it again when it doesn't.
+type Breaker struct {
+	idInstance string
+	mu         sync.Mutex
+	failures   int
+	openedAt   time.Time
+	probing    bool
+	// closed is called when the breaker closes again.
+	closed func()
+	// config returns the live config.
+	config func() *Config
+}
+
+// Breakers keeps a breaker per instance, so an instance GREEN-API stopped
+// answering for doesn't pause the calls of the others.
+type Breakers struct {
+	instances *SyncMap[string, *Breaker]
+	closed    func()
+	config    func() *Config
+}
+
+func newBreakers(closed func()) *Breakers {
+	return &Breakers{instances: newSyncMap[string, *Breaker](), closed: closed}
+}
+
+// of returns the breaker of an instance, closed until its calls fail.
+func (b *Breakers) of(idInstance string) *Breaker {
+	return b.instances.update(idInstance, func(breaker *Breaker, ok bool) (*Breaker, bool) {
+		if !ok {
+			breaker = &Breaker{idInstance: idInstance, closed: b.closed, config: b.config}
+		}
+		return breaker, true
+	})
+}
+
+// held returns the breakers of the instances calls can't go out for now.
+func (b *Breakers) held() []*Breaker {
+	var held []*Breaker
+	for _, breaker := range b.instances.values() {
+		if !breaker.ready() {
+			held = append(held, breaker)
+		}
+	}
+	return held
+}
+
+// allow reports whether a call may go out, and whether it is the probe,
+// taking the probe slot when the cooldown of an open breaker has passed.
+func (b *Breaker) allow() (ok, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.openedAt.IsZero():
+		return true, false
+	case b.probing || time.Since(b.openedAt) < b.config().BreakerCooldown:
+		return false, false
+	default:
+		b.probing = true
+		return true, true
+	}
+}
+
+// ready reports whether a call would be allowed, without taking the probe
+// slot.
+func (b *Breaker) ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.openedAt.IsZero() || !b.probing && time.Since(b.openedAt) >= b.config().BreakerCooldown
+}
+
+// isOpen reports whether calls are paused or waiting for a probe.
+func (b *Breaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.openedAt.IsZero()
+}
+
+// retryIn is how long until the breaker lets a probe through, or a second
+// while one is out.
+func (b *Breaker) retryIn() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.probing {
+		return time.Second
+	}
+	return max(b.config().BreakerCooldown-time.Since(b.openedAt), 0)
+}
+
+// record counts the outcome of a call that went out. Only the probe's own
+// outcome gives up the probe slot: a call let through before the breaker
+// opened may finish while the probe is still out.
+func (b *Breaker) record(probe bool, err error) {
+	b.mu.Lock()
+	if probe {
+		b.probing = false
+	}
+	if err != nil && !reached(err) && !unreachable(err) {
+		// Cancelled by the client or refused before going out: says
+		// nothing about GREEN-API
+		b.mu.Unlock()
+		return
+	}
+
+	wasOpen := !b.openedAt.IsZero()
+	if !unreachable(err) {
+		b.failures = 0
+		b.openedAt = time.Time{}
+		b.mu.Unlock()
+		if wasOpen {
+			infof("GREEN-API answers instance %s again, circuit breaker closed", b.idInstance)
+			b.closed()
+		}
+		return
+	}
+
+	b.failures++
+	threshold := b.config().BreakerThreshold
+	if threshold > 0 && (wasOpen || b.failures >= threshold) {
+		b.openedAt = time.Now()
+		if !wasOpen {
+			warnf("Circuit breaker of instance %s opened after %d failed GREEN-API calls: %v", b.idInstance, b.failures, err)
+		}
+	}
+	b.mu.Unlock()
+}
+
+// reached reports whether a failed call still got an answer from GREEN-API,
+// which a 4xx is: the service is up, the call was wrong.
+func reached(err error) bool {
+	var upstreamErr *UpstreamError
+	return errors.As(err, &upstreamErr) && upstreamErr.Status < 500
+}
+
+// unreachable reports whether a call failed because GREEN-API could not be
+// reached or could not answer: a network error, a timeout or a 5xx.
+func unreachable(err error) bool {
+	var (
+		timeoutErr  *TimeoutError
+		upstreamErr *UpstreamError
+		urlErr      *url.Error
+	)
+	switch {
+	case errors.As(err, &timeoutErr):
+		return true
+	case errors.As(err, &upstreamErr):
+		return upstreamErr.Status >= 500
+	default:
+		return errors.As(err, &urlErr) && !errors.Is(err, context.Canceled)
+	}
+}
+
+// OfflineResult answers a send held by -offline-queue.
+type OfflineResult struct {
+	ScheduledID int64  `json:"scheduledId"`
+	Status      string `json:"status"`
+	Message     string `json:"message"`
+}
+
+// queueOffline holds a send while the breaker of its instance is open and -offline-queue is
+// on, answering 202 with the ID it is listed under in /api/schedule. The
+// scheduler sends it once GREEN-API answers again. It returns false when
+// the send should be made now.
+func (a *App) queueOffline(w http.ResponseWriter, r *http.Request, rs *responder, send ScheduledSend, echo interface{}) bool {
+	if !a.liveConfig().OfflineQueue || !a.upstream.of(send.IDInstance).isOpen() {
+		return false
+	}
+
+	send.Actor = actorOf(r)
+	send.Reason = "offline"
+	send.SendAt = time.Now()
+	queued, err := a.scheduler.add(send)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return true
+	}
+	warnf("GREEN-API unreachable, queued %s to %s as scheduled send %d", send.Method, send.PhoneNumber, queued.ID)
+
+	rs.respondStatus(w, http.StatusAccepted, APIResponse{
+		URL:         send.URL,
+		RequestBody: echo,
+		Response: OfflineResult{
+			ScheduledID: queued.ID,
+			Status:      queued.Status,
+			Message:     translate(negotiateLanguage(r), "GREEN-API is unreachable, the message is queued and will be sent when it answers again"),
+		},
+		StatusCode: http.StatusAccepted,
+	})
+	return true
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func getStateRequest() *http.Request {
+	return apiRequest(http.MethodPost, "/api/get-state", `{"idInstance":"`+testInstance+`","apiTokenInstance":"`+testToken+`"}`)
+}
+
+func TestRetries(t *testing.T) {
+	sendBody := `{"idInstance":"` + testInstance + `","apiTokenInstance":"` + testToken + `","phoneNumber":"79001234567","messageText":"hi"}`
+	runHandlerCases(t, []handlerCase{
+		{
+			name:   "connection reset",
+			args:   []string{"-retries", "2"},
+			setup:  func(m *mockGreenAPI) { m.inject("getStateInstance", mockFault{reset: true}) },
+			req:    getStateRequest,
+			status: http.StatusOK,
+			check:  wantCalls("getStateInstance", 2),
+		},
+		{
+			name:   "truncated body",
+			args:   []string{"-retries", "2"},
+			setup:  func(m *mockGreenAPI) { m.inject("getStateInstance", mockFault{truncate: true}) },
+			req:    getStateRequest,
+			status: http.StatusOK,
+			check:  wantCalls("getStateInstance", 2),
+		},
+		{
+			name:   "429 burst",
+			args:   []string{"-retries", "2"},
+			setup:  func(m *mockGreenAPI) { m.inject("getStateInstance", tooManyRequests(2, "")...) },
+			req:    getStateRequest,
+			status: http.StatusOK,
+			check:  wantCalls("getStateInstance", 3),
+		},
+		{
+			name:   "429 burst longer than the retries",
+			args:   []string{"-retries", "1"},
+			setup:  func(m *mockGreenAPI) { m.inject("getStateInstance", tooManyRequests(3, "")...) },
+			req:    getStateRequest,
+			status: http.StatusTooManyRequests,
+			check:  wantCalls("getStateInstance", 2),
+		},
+		{
+			name:   "Retry-After past the budget",
+			args:   []string{"-retries", "2", "-timeouts", "getStateInstance=1s"},
+			setup:  func(m *mockGreenAPI) { m.inject("getStateInstance", tooManyRequests(1, "30")...) },
+			req:    getStateRequest,
+			status: http.StatusTooManyRequests,
+			check:  wantCalls("getStateInstance", 1),
+		},
+		{
+			name:   "latency past the budget",
+			args:   []string{"-retries", "2", "-timeouts", "getStateInstance=50ms"},
+			setup:  func(m *mockGreenAPI) { m.inject("getStateInstance", mockFault{latency: time.Second}) },
+			req:    getStateRequest,
+			status: http.StatusGatewayTimeout,
+			code:   "upstream_timeout",
+			check:  wantCalls("getStateInstance", 1),
+		},
+		{
+			name:   "latency within the budget",
+			args:   []string{"-retries", "2"},
+			setup:  func(m *mockGreenAPI) { m.inject("getStateInstance", mockFault{latency: 50 * time.Millisecond}) },
+			req:    getStateRequest,
+			status: http.StatusOK,
+			check:  wantCalls("getStateInstance", 1),
+		},
+		{
+			// A send may have gone out before the connection dropped
+			name:   "sends aren't retried",
+			args:   []string{"-retries", "2"},
+			setup:  func(m *mockGreenAPI) { m.inject("sendMessage", mockFault{reset: true}) },
+			req:    sendMessageRequest(sendBody),
+			status: http.StatusBadGateway,
+			code:   "upstream_unreachable",
+			check:  wantCalls("sendMessage", 1),
+		},
+	})
+}
+
+// wantCalls checks GREEN-API got n calls of method.
+func wantCalls(method string, n int) func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+	return func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+		t.Helper()
+		if calls := m.callsOf(method); len(calls) != n {
+			t.Errorf("%s called %d times, want %d", method, len(calls), n)
+		}
+	}
+}
+
+// breakerArgs open the breaker after two failed calls, for 100ms.
+var breakerArgs = []string{"-breaker-threshold", "2", "-breaker-cooldown", "100ms"}
+
+func TestBreaker(t *testing.T) {
+	m := newMockGreenAPI(t)
+	a := newTestApp(t, m, breakerArgs...)
+	m.inject("getStateInstance", mockFault{reset: true}, mockFault{status: http.StatusServiceUnavailable}, mockFault{reset: true})
+
+	for i := 0; i < 2; i++ {
+		if w := serve(a, getStateRequest()); w.Code != http.StatusBadGateway {
+			t.Fatalf("call %d: status %d, want 502", i, w.Code)
+		}
+	}
+	if !a.upstream.of(testInstance).isOpen() {
+		t.Fatal("the breaker is closed after two failed calls")
+	}
+
+	// Open: calls fail at once without reaching GREEN-API
+	w := serve(a, getStateRequest())
+	if w.Code != http.StatusServiceUnavailable || !bytes.Contains(w.Body.Bytes(), []byte("upstream_unavailable")) {
+		t.Errorf("open breaker: status %d: %s", w.Code, w.Body)
+	}
+	if calls := m.callsOf("getStateInstance"); len(calls) != 2 {
+		t.Errorf("%d calls reached GREEN-API, want 2", len(calls))
+	}
+
+	// A failed probe opens it again for another cooldown
+	time.Sleep(150 * time.Millisecond)
+	if w := serve(a, getStateRequest()); w.Code != http.StatusBadGateway || !a.upstream.of(testInstance).isOpen() {
+		t.Fatalf("failed probe: status %d, open %v", w.Code, a.upstream.of(testInstance).isOpen())
+	}
+	if w := serve(a, getStateRequest()); !bytes.Contains(w.Body.Bytes(), []byte("upstream_unavailable")) {
+		t.Errorf("after a failed probe: %s", w.Body)
+	}
+
+	// A probe that gets an answer closes it
+	time.Sleep(150 * time.Millisecond)
+	if w := serve(a, getStateRequest()); w.Code != http.StatusOK {
+		t.Fatalf("probe: status %d: %s", w.Code, w.Body)
+	}
+	if a.upstream.of(testInstance).isOpen() {
+		t.Error("the breaker is open after a successful probe")
+	}
+	if calls := m.callsOf("getStateInstance"); len(calls) != 4 {
+		t.Errorf("%d calls reached GREEN-API, want 4", len(calls))
+	}
+}
+
+func TestBreakerPerInstance(t *testing.T) {
+	const other = "1101000003"
+	m := newMockGreenAPI(t)
+	a := newTestApp(t, m, append(breakerArgs, "-instances", testInstance+":"+testToken+","+other+":"+testToken)...)
+	m.inject("getStateInstance", mockFault{reset: true}, mockFault{reset: true})
+	serve(a, getStateRequest())
+	serve(a, getStateRequest())
+	if !a.upstream.of(testInstance).isOpen() {
+		t.Fatal("the breaker didn't open")
+	}
+
+	// Another instance still gets through
+	w := serve(a, apiRequest(http.MethodPost, "/api/get-state", `{"idInstance":"`+other+`","apiTokenInstance":"`+testToken+`"}`))
+	if w.Code != http.StatusOK || a.upstream.of(other).isOpen() {
+		t.Errorf("other instance: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestBreakerProbe(t *testing.T) {
+	config := defaultConfig()
+	config.BreakerThreshold, config.BreakerCooldown = 1, time.Millisecond
+	b := &Breaker{idInstance: testInstance, closed: func() {}, config: func() *Config { return config }}
+	down := &TimeoutError{}
+
+	if ok, probe := b.allow(); !ok || probe {
+		t.Fatalf("closed breaker: allow = %v, %v", ok, probe)
+	}
+	b.record(false, down)
+	time.Sleep(5 * time.Millisecond)
+	if ok, probe := b.allow(); !ok || !probe {
+		t.Fatalf("after the cooldown: allow = %v, %v, want the probe", ok, probe)
+	}
+
+	// A call let through before the breaker opened fails late: the probe
+	// is still out, so no second one goes
+	b.record(false, down)
+	time.Sleep(5 * time.Millisecond)
+	if ok, _ := b.allow(); ok {
+		t.Fatal("a second probe went out while the first was out")
+	}
+
+	b.record(true, nil)
+	if b.isOpen() {
+		t.Error("the breaker is open after a successful probe")
+	}
+	if ok, probe := b.allow(); !ok || probe {
+		t.Errorf("after the probe: allow = %v, %v", ok, probe)
+	}
+}
+
+func TestBreakerIgnoresClientErrors(t *testing.T) {
+	m := newMockGreenAPI(t)
+	a := newTestApp(t, m, breakerArgs...)
+	m.reply("getStateInstance", http.StatusBadRequest, `{"message":"bad"}`)
+	for i := 0; i < 3; i++ {
+		serve(a, getStateRequest())
+	}
+	if a.upstream.of(testInstance).isOpen() {
+		t.Error("4xx answers opened the breaker")
+	}
+}
+
+func TestOfflineQueue(t *testing.T) {
+	m := newMockGreenAPI(t)
+	a := newTestApp(t, m, append(breakerArgs, "-offline-queue")...)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go a.scheduler.run(ctx)
+
+	m.inject("getStateInstance", mockFault{reset: true}, mockFault{reset: true})
+	serve(a, getStateRequest())
+	serve(a, getStateRequest())
+	if !a.upstream.of(testInstance).isOpen() {
+		t.Fatal("the breaker didn't open")
+	}
+
+	body := `{"idInstance":"` + testInstance + `","apiTokenInstance":"` + testToken + `","phoneNumber":"79001234567","messageText":"held"}`
+	w := serve(a, apiRequest(http.MethodPost, "/api/send-message", body))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("send while open: status %d: %s", w.Code, w.Body)
+	}
+	var response struct {
+		Response OfflineResult `json:"response"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if response.Response.ScheduledID == 0 || response.Response.Status != scheduledPending {
+		t.Fatalf("offline result = %+v", response.Response)
+	}
+	if calls := m.callsOf("sendMessage"); len(calls) != 0 {
+		t.Fatalf("the held send reached GREEN-API %d times", len(calls))
+	}
+
+	// Once the cooldown passes the held send goes out as the probe
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		send, _, _ := a.scheduler.store.get(response.Response.ScheduledID)
+		if send.Status == scheduledSent {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the held send is still %s", send.Status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	calls := m.callsOf("sendMessage")
+	if len(calls) != 1 || !bytes.Contains(calls[0].Body, []byte("held")) {
+		t.Errorf("sendMessage calls = %+v", calls)
+	}
+	if a.upstream.of(testInstance).isOpen() {
+		t.Error("the breaker is still open after the held send went out")
+	}
+}
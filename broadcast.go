@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// phoneList decodes a JSON array of phone numbers or a string of numbers
+// separated by commas, spaces or newlines, as sent by the form.
+type phoneList []string
+
+func (l *phoneList) UnmarshalJSON(data []byte) error {
+	var numbers []string
+	if err := json.Unmarshal(data, &numbers); err != nil {
+		var raw string
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return fmt.Errorf("phone numbers must be an array or a string")
+		}
+		numbers = strings.FieldsFunc(raw, func(c rune) bool {
+			return c == ',' || c == ';' || c == ' ' || c == '\n' || c == '\r' || c == '\t'
+		})
+	}
+
+	*l = nil
+	seen := make(map[string]bool)
+	for _, number := range numbers {
+		number = strings.TrimSpace(number)
+		if number == "" || seen[number] {
+			continue
+		}
+		seen[number] = true
+		*l = append(*l, number)
+	}
+	return nil
+}
+
+// RecipientResult is the outcome of one recipient of a broadcast.
+type RecipientResult struct {
+	PhoneNumber string                 `json:"phoneNumber"`
+	StatusCode  int                    `json:"statusCode,omitempty"`
+	Response    map[string]interface{} `json:"response,omitempty"`
+	Error       *ErrorBody             `json:"error,omitempty"`
+	ScheduledID int64                  `json:"scheduledId,omitempty"`
+	SendAt      *time.Time             `json:"sendAt,omitempty"`
+}
+
+// BatchResult sums up a broadcast.
+type BatchResult struct {
+	// ID names the batch in /api/batches/{id}/analytics.
+	ID         int64             `json:"id"`
+	Recipients int               `json:"recipients"`
+	Succeeded  int               `json:"succeeded"`
+	Failed     int               `json:"failed"`
+	Deferred   int               `json:"deferred"`
+	Results    []RecipientResult `json:"results"`
+}
+
+// validateRecipients rejects a broadcast that is too large before anything
+// is sent. Malformed numbers are reported per recipient instead.
+func (a *App) validateRecipients(w http.ResponseWriter, r *http.Request, phones phoneList) bool {
+	if !a.checkFeature(w, r, featureBroadcast) {
+		return false
+	}
+	if len(phones) > a.liveConfig().MaxRecipients {
+		writeErrorf(w, r, http.StatusBadRequest, "too_many_recipients", "At most %d recipients per request", a.liveConfig().MaxRecipients)
+		return false
+	}
+	return true
+}
+
+// Broadcast sends the same GREEN-API call to several chats of one instance.
+type Broadcast struct {
+	app        *App
+	IDInstance string
+	Method     string
+	URL        string
+	Phones     phoneList
+	// Content is what the audit log hashes for each recipient
+	Content    string
+	PayloadFor func(chatId string) map[string]interface{}
+	// Links are the short links -shortener put in the message.
+	Links []ShortLink
+}
+
+// payloads lists what a dry run would send.
+func (b *Broadcast) payloads() []map[string]interface{} {
+	payloads := make([]map[string]interface{}, len(b.Phones))
+	for i, phone := range b.Phones {
+		payloads[i] = b.PayloadFor(phone + "@c.us")
+	}
+	return payloads
+}
+
+// run sends to every recipient one at a time, paced by the instance's send
+// queue. Recipients in their quiet hours are handed to the scheduler, and
+// so is everyone while -offline-queue holds sends and whoever GREEN-API
+// turns away with a Retry-After. A failed recipient does not stop the
+// rest; cancelling the request does.
+func (b *Broadcast) run(ctx context.Context, r *http.Request) *BatchResult {
+	batch := &BatchResult{Recipients: len(b.Phones), Results: make([]RecipientResult, 0, len(b.Phones))}
+	batch.ID = b.app.batches.start(b.IDInstance, b.Method, len(b.Phones))
+	b.app.batches.linked(batch.ID, b.Links)
+
+	for _, phone := range b.Phones {
+		result := b.send(ctx, r, phone)
+		switch {
+		case result.Error != nil:
+			batch.Failed++
+		case result.ScheduledID != 0:
+			batch.Deferred++
+		default:
+			batch.Succeeded++
+			idMessage, _ := result.Response["idMessage"].(string)
+			b.app.batches.sent(batch.ID, idMessage, time.Now())
+		}
+		batch.Results = append(batch.Results, result)
+	}
+	return batch
+}
+
+func (b *Broadcast) send(ctx context.Context, r *http.Request, phone string) RecipientResult {
+	result := RecipientResult{PhoneNumber: phone}
+	lang := negotiateLanguage(r)
+	if len(phone) < 11 {
+		result.Error = &ErrorBody{Code: "invalid_phone_number", Message: translate(lang, "Phone number too short"), Status: http.StatusBadRequest}
+		return result
+	}
+	if result.Error = b.app.optOutError(r, phone); result.Error != nil {
+		return result
+	}
+
+	chatId := phone + "@c.us"
+	now := time.Now()
+	sendAt, reason := b.app.liveConfig().QuietHours.sendAt(b.IDInstance, phone, now), "quiet hours"
+	if !sendAt.After(now) && b.app.liveConfig().OfflineQueue && b.app.upstream.of(b.IDInstance).isOpen() {
+		sendAt, reason = now, "offline"
+	}
+	if sendAt.After(now) || reason == "offline" {
+		return b.schedule(r, result, sendAt, reason)
+	}
+
+	cancelled := &ErrorBody{Code: "broadcast_cancelled", Message: translate(lang, "Broadcast cancelled"), Status: http.StatusServiceUnavailable}
+	if err := b.app.sendQueue.wait(ctx, b.IDInstance); err != nil {
+		result.Error = cancelled
+		return result
+	}
+	unlock, err := b.app.chatLocks.lock(ctx, chatKey(b.IDInstance, chatId))
+	if err != nil {
+		result.Error = cancelled
+		return result
+	}
+	defer unlock()
+
+	result.Response, result.StatusCode, err = b.app.makeAPIRequestWithPayload(ctx, b.Method, b.URL, b.PayloadFor(chatId))
+	b.app.audit.record(actorOf(r), OutboundMessage{Method: b.Method, URL: b.URL, ChatID: chatId, Content: b.Content}, result.StatusCode, result.Response, err)
+	if wait := retryAfterOf(err); wait > 0 {
+		// GREEN-API turned the message away, so it can go out once it
+		// takes calls again
+		return b.schedule(r, RecipientResult{PhoneNumber: phone}, time.Now().Add(wait), "rate limited")
+	}
+	if err != nil {
+		body := upstreamErrorBody(r, err)
+		result.Error = &body
+		result.Response = nil
+	}
+	return result
+}
+
+// schedule hands the message of a recipient to the scheduler to go out at
+// sendAt.
+func (b *Broadcast) schedule(r *http.Request, result RecipientResult, sendAt time.Time, reason string) RecipientResult {
+	phone := result.PhoneNumber
+	scheduled, err := b.app.scheduler.add(ScheduledSend{
+		IDInstance:  b.IDInstance,
+		PhoneNumber: phone,
+		Method:      b.Method,
+		URL:         b.URL,
+		Payload:     b.PayloadFor(phone + "@c.us"),
+		Content:     b.Content,
+		Actor:       actorOf(r),
+		Reason:      reason,
+		SendAt:      sendAt,
+	})
+	if err != nil {
+		errorf("Failed to schedule %s to %s: %v", b.Method, phone, err)
+		result.Error = &ErrorBody{Code: "storage_error", Message: translate(negotiateLanguage(r), "Storage is unavailable, try again later"), Status: http.StatusInternalServerError}
+		return result
+	}
+	result.ScheduledID = scheduled.ID
+	result.SendAt = &scheduled.SendAt
+	return result
+}
+
+// respond writes the batch result, or the payloads alone on a dry run.
+func (b *Broadcast) respond(ctx context.Context, w http.ResponseWriter, r *http.Request, rs *responder, echo map[string]interface{}, dryRun bool) {
+	delete(echo, "phoneNumber")
+	echo["phoneNumbers"] = b.Phones
+
+	if dryRun {
+		rs.respond(w, APIResponse{
+			URL:         b.URL,
+			RequestBody: echo,
+			Payload:     b.payloads(),
+			DryRun:      true,
+		})
+		return
+	}
+
+	rs.respond(w, APIResponse{
+		URL:         b.URL,
+		RequestBody: echo,
+		Batch:       b.run(ctx, r),
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPhoneList(t *testing.T) {
+	cases := []struct {
+		data string
+		want phoneList
+	}{
+		{`["79001234567","79007654321"]`, phoneList{"79001234567", "79007654321"}},
+		{`"79001234567, 79007654321;79001112233\n79001234567"`, phoneList{"79001234567", "79007654321", "79001112233"}},
+		{`[" 79001234567 ","","79001234567"]`, phoneList{"79001234567"}},
+		{`""`, nil},
+		{`[]`, nil},
+	}
+	for _, tc := range cases {
+		var got phoneList
+		if err := json.Unmarshal([]byte(tc.data), &got); err != nil {
+			t.Errorf("%s: %v", tc.data, err)
+			continue
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s = %q, want %q", tc.data, got, tc.want)
+		}
+	}
+
+	var got phoneList
+	if err := json.Unmarshal([]byte(`{"phone":"79001234567"}`), &got); err == nil {
+		t.Errorf("an object decoded as %q", got)
+	}
+}
+
+func FuzzPhoneList(f *testing.F) {
+	for _, seed := range []string{
+		`["79001234567","79007654321"]`,
+		`"79001234567, 79007654321;79001112233\n"`,
+		`[" 79001234567 ","","79001234567"]`,
+		`"\t\r\n,;"`,
+		`[1,2]`,
+		`{}`,
+		`null`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var list phoneList
+		if json.Unmarshal(data, &list) != nil {
+			return
+		}
+		seen := make(map[string]bool)
+		for _, number := range list {
+			if number == "" || number != strings.TrimSpace(number) {
+				t.Errorf("number %q isn't trimmed", number)
+			}
+			if seen[number] {
+				t.Errorf("number %q is listed twice", number)
+			}
+			seen[number] = true
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// icalTime is the UTC date-time format of iCalendar.
+const icalTime = "20060102T150405Z"
+
+// icalEscape escapes a TEXT value.
+var icalEscape = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// icalFold writes a content line, folding it at 75 octets (counting the
+// space continuation lines start with) without splitting UTF-8 sequences.
+func icalFold(b *strings.Builder, line string) {
+	width := 75
+	for len(line) > width {
+		cut := width
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		width = 74
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// calendarHandler serves the pending scheduled sends as an iCalendar feed,
+// one minute-long event per send, so campaign timing can be reviewed in a
+// calendar app.
+func (a *App) calendarHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := a.scheduler.list(workspaceOf(r))
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	var b strings.Builder
+	icalFold(&b, "BEGIN:VCALENDAR")
+	icalFold(&b, "VERSION:2.0")
+	icalFold(&b, "PRODID:-//grapi//Scheduled sends//EN")
+	icalFold(&b, "CALSCALE:GREGORIAN")
+	icalFold(&b, "X-WR-CALNAME:"+icalEscape.Replace(translate(negotiateLanguage(r), "Scheduled sends")))
+
+	now := time.Now().UTC().Format(icalTime)
+	for _, send := range list {
+		if send.Status != scheduledPending {
+			continue
+		}
+		summary := fmt.Sprintf("%s → %s", send.Method, send.PhoneNumber)
+		description := send.Content
+		if send.Reason != "" {
+			description = strings.TrimSpace(description + "\n\n" + send.Reason)
+		}
+		icalFold(&b, "BEGIN:VEVENT")
+		icalFold(&b, fmt.Sprintf("UID:scheduled-%d@grapi", send.ID))
+		icalFold(&b, "DTSTAMP:"+now)
+		icalFold(&b, "DTSTART:"+send.SendAt.UTC().Format(icalTime))
+		icalFold(&b, "DURATION:PT1M")
+		icalFold(&b, "SUMMARY:"+icalEscape.Replace(summary))
+		if description != "" {
+			icalFold(&b, "DESCRIPTION:"+icalEscape.Replace(description))
+		}
+		icalFold(&b, "CATEGORIES:"+icalEscape.Replace(send.IDInstance))
+		icalFold(&b, "END:VEVENT")
+	}
+	icalFold(&b, "END:VCALENDAR")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="calendar.ics"`)
+	w.Write([]byte(b.String()))
+}
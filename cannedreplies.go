@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// CannedReply is a message saved under a shortcut, e.g. /thanks, so it can
+// be sent to a chat without typing it. Its text is a template like those
+// of /api/preview-message. Like presets, canned replies belong to the
+// workspace they were saved in.
+type CannedReply struct {
+	Shortcut  string    `json:"shortcut"`
+	Workspace string    `json:"workspace,omitempty"`
+	Text      string    `json:"text"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	UpdatedBy string    `json:"updatedBy,omitempty"`
+}
+
+// CannedReplyStore keeps canned replies by workspace and shortcut.
+type CannedReplyStore interface {
+	list() ([]CannedReply, error)
+	get(workspace, shortcut string) (CannedReply, bool, error)
+	put(reply CannedReply) error
+	delete(workspace, shortcut string) (bool, error)
+}
+
+// shortcutOf reads the shortcut of the request path, which may start with
+// the / it is typed with.
+func shortcutOf(r *http.Request) string {
+	return strings.TrimPrefix(r.PathValue("shortcut"), "/")
+}
+
+// cannedRepliesHandler lists the canned replies of the workspaces the
+// caller can see.
+func (a *App) cannedRepliesHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := a.cannedReplies.list()
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	workspace := workspaceOf(r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(slices.DeleteFunc(list, func(reply CannedReply) bool { return !canSee(workspace, reply.Workspace) }))
+}
+
+// saveCannedReplyHandler stores the text of a shortcut, checking it parses
+// as a template.
+func (a *App) saveCannedReplyHandler(w http.ResponseWriter, r *http.Request) {
+	shortcut := shortcutOf(r)
+	if !presetNamePattern.MatchString(shortcut) {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Shortcuts are lowercase letters, digits, - and _")
+		return
+	}
+	var requestBody struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	if strings.TrimSpace(requestBody.Text) == "" {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Message text is required")
+		return
+	}
+	if _, err := template.New("message").Parse(requestBody.Text); err != nil {
+		writeErrorf(w, r, http.StatusBadRequest, "invalid_template", "Invalid message template: %v", err)
+		return
+	}
+
+	reply := CannedReply{Shortcut: shortcut, Workspace: ownerWorkspace(r), Text: requestBody.Text, UpdatedAt: time.Now(), UpdatedBy: actorName(r)}
+	if err := a.cannedReplies.put(reply); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	infof("Canned reply /%s saved by %s", shortcut, actorName(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+// deleteCannedReplyHandler moves a canned reply to the trash.
+func (a *App) deleteCannedReplyHandler(w http.ResponseWriter, r *http.Request) {
+	shortcut, workspace := shortcutOf(r), ownerWorkspace(r)
+	reply, ok, err := a.cannedReplies.get(workspace, shortcut)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if !ok {
+		writeErrorf(w, r, http.StatusNotFound, "not_found", "Canned reply /%s not found", shortcut)
+		return
+	}
+	err = a.moveToTrash(r, trashCannedReply, shortcut, workspace, reply, func() error {
+		_, err := a.cannedReplies.delete(workspace, shortcut)
+		return err
+	})
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	infof("Canned reply /%s deleted by %s", shortcut, actorName(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SendCannedReplyRequest is the body of /api/canned-replies/{shortcut}/send.
+type SendCannedReplyRequest struct {
+	IDInstance       string `json:"idInstance" api:"required"`
+	APITokenInstance string `json:"apiTokenInstance" api:"required"`
+	PhoneNumber      string `json:"phoneNumber" api:"required"`
+	// Variables are filled in like those of /api/preview-message.
+	Variables stringMap `json:"variables"`
+	DryRun    formBool  `json:"dryRun"`
+	UpstreamOverrides
+}
+
+// sendCannedReplyHandler expands a shortcut for a chat and sends it through
+// /api/send-message, so it gets the same checks, queueing and audit as a
+// message typed out.
+func (a *App) sendCannedReplyHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody SendCannedReplyRequest
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	shortcut := shortcutOf(r)
+	reply, ok, err := a.cannedReplies.get(ownerWorkspace(r), shortcut)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if !ok {
+		writeErrorf(w, r, http.StatusNotFound, "not_found", "Canned reply /%s not found", shortcut)
+		return
+	}
+
+	text, err := renderMessage(reply.Text, requestBody.PhoneNumber, requestBody.Variables)
+	if err != nil {
+		writeErrorf(w, r, http.StatusBadRequest, "invalid_template", "Invalid message template: %v", err)
+		return
+	}
+	body, _ := json.Marshal(SendMessageRequest{
+		IDInstance:        requestBody.IDInstance,
+		APITokenInstance:  requestBody.APITokenInstance,
+		PhoneNumber:       requestBody.PhoneNumber,
+		MessageText:       text,
+		DryRun:            requestBody.DryRun,
+		UpstreamOverrides: requestBody.UpstreamOverrides,
+	})
+
+	sendRequest := r.Clone(r.Context())
+	sendRequest.Body = io.NopCloser(bytes.NewReader(body))
+	sendRequest.ContentLength = int64(len(body))
+	sendRequest.Header.Set("Content-Type", "application/json")
+	a.sendMessageHandler(w, sendRequest)
+}
+
+// memoryCannedReplies keeps canned replies in a map by workspaceKey, so
+// they are forgotten on restart.
+type memoryCannedReplies struct {
+	mu      sync.Mutex
+	replies map[string]CannedReply
+}
+
+func newMemoryCannedReplies() *memoryCannedReplies {
+	return &memoryCannedReplies{replies: make(map[string]CannedReply)}
+}
+
+func (s *memoryCannedReplies) list() ([]CannedReply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]CannedReply, 0, len(s.replies))
+	for _, reply := range s.replies {
+		list = append(list, reply)
+	}
+	slices.SortFunc(list, func(a, b CannedReply) int {
+		return cmp.Or(strings.Compare(a.Shortcut, b.Shortcut), strings.Compare(a.Workspace, b.Workspace))
+	})
+	return list, nil
+}
+
+func (s *memoryCannedReplies) get(workspace, shortcut string) (CannedReply, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reply, ok := s.replies[workspaceKey(workspace, shortcut)]
+	return reply, ok, nil
+}
+
+func (s *memoryCannedReplies) put(reply CannedReply) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replies[workspaceKey(reply.Workspace, reply.Shortcut)] = reply
+	return nil
+}
+
+func (s *memoryCannedReplies) delete(workspace, shortcut string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := workspaceKey(workspace, shortcut)
+	_, ok := s.replies[key]
+	delete(s.replies, key)
+	return ok, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// maxCaptionLength is the longest caption WhatsApp shows in full.
+const maxCaptionLength = 1024
+
+// captionFields are what a caption template can refer to, e.g.
+// "Invoice for {{.Phone}} of {{.Date}}".
+type captionFields struct {
+	Phone  string
+	ChatID string
+	Date   string
+}
+
+// captionTemplate is the caption of a file send, rendered for each
+// recipient so one upload can go out to a broadcast with a personal
+// caption. Text without {{ }} is sent as is.
+type captionTemplate struct {
+	tmpl *template.Template
+}
+
+// parseCaption parses a caption and renders it once, so unknown fields are
+// reported before anything is sent.
+func parseCaption(text string) (*captionTemplate, error) {
+	tmpl, err := template.New("caption").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	caption := &captionTemplate{tmpl: tmpl}
+	sample, err := caption.render("79001234567@c.us")
+	if err != nil {
+		return nil, err
+	}
+	if length := len([]rune(sample)); length > maxCaptionLength {
+		return nil, fmt.Errorf("caption is %d characters, at most %d are shown", length, maxCaptionLength)
+	}
+	return caption, nil
+}
+
+func (c *captionTemplate) render(chatId string) (string, error) {
+	var b strings.Builder
+	err := c.tmpl.Execute(&b, captionFields{
+		Phone:  strings.TrimSuffix(chatId, "@c.us"),
+		ChatID: chatId,
+		Date:   time.Now().Format(time.DateOnly),
+	})
+	return b.String(), err
+}
+
+// addTo sets the caption of a file payload for its chat, leaving it out
+// when the caption is empty.
+func (c *captionTemplate) addTo(payload map[string]interface{}) map[string]interface{} {
+	if c == nil {
+		return payload
+	}
+	chatId, _ := payload["chatId"].(string)
+	if caption, _ := c.render(chatId); caption != "" {
+		payload["caption"] = caption
+	}
+	return payload
+}
+
+// fileContent is what the audit log hashes for a file send.
+func fileContent(fileUrl, caption string) string {
+	if caption == "" {
+		return fileUrl
+	}
+	return fileUrl + "\n" + caption
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Typing indicators last between one and twenty seconds on GREEN-API's side.
+const (
+	defaultTypingSeconds = 5
+	maxTypingSeconds     = 20
+)
+
+// chatRequest is the body shared by the per-chat state endpoints.
+type chatRequest struct {
+	IDInstance       string `json:"idInstance" api:"required"`
+	APITokenInstance string `json:"apiTokenInstance" api:"required"`
+	PhoneNumber      string `json:"phoneNumber" api:"required"`
+	UpstreamOverrides
+}
+
+// ReadChatRequest is the body of /api/read-chat.
+type ReadChatRequest struct {
+	chatRequest
+	IDMessage string `json:"idMessage"`
+}
+
+// readChatHandler marks a chat, or a single message in it, as read.
+func (a *App) readChatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	var requestBody ReadChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	// Validate phone number (simple validation)
+	if len(requestBody.PhoneNumber) < 11 {
+		writeError(w, r, http.StatusBadRequest, "invalid_phone_number", "Phone number too short")
+		return
+	}
+
+	payload := map[string]interface{}{
+		"chatId": fmt.Sprintf("%s@c.us", requestBody.PhoneNumber),
+	}
+	idMessage := strings.TrimSpace(requestBody.IDMessage)
+	if idMessage != "" {
+		payload["idMessage"] = idMessage
+	}
+
+	a.callChatMethod(w, r, "readChat", requestBody.chatRequest, payload, map[string]interface{}{
+		"idMessage": idMessage,
+	})
+}
+
+// SendTypingRequest is the body of /api/send-typing.
+type SendTypingRequest struct {
+	chatRequest
+	TypingSeconds string   `json:"typingSeconds"`
+	Recording     formBool `json:"recording"`
+}
+
+// sendTypingHandler shows "typing…" (or "recording audio…") in a chat for a
+// few seconds.
+func (a *App) sendTypingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	var requestBody SendTypingRequest
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	// Validate inputs
+	if len(requestBody.PhoneNumber) < 11 {
+		writeError(w, r, http.StatusBadRequest, "invalid_phone_number", "Phone number too short")
+		return
+	}
+	seconds := defaultTypingSeconds
+	if value := strings.TrimSpace(requestBody.TypingSeconds); value != "" {
+		var err error
+		seconds, err = strconv.Atoi(value)
+		if err != nil || seconds < 1 || seconds > maxTypingSeconds {
+			writeErrorf(w, r, http.StatusBadRequest, "invalid_typing_time", "Typing time must be 1 to %d seconds", maxTypingSeconds)
+			return
+		}
+	}
+
+	payload := map[string]interface{}{
+		"chatId":     fmt.Sprintf("%s@c.us", requestBody.PhoneNumber),
+		"typingTime": seconds * 1000,
+	}
+	if requestBody.Recording {
+		payload["typingType"] = "recording"
+	}
+
+	a.callChatMethod(w, r, "sendTyping", requestBody.chatRequest, payload, map[string]interface{}{
+		"typingSeconds": seconds,
+		"recording":     bool(requestBody.Recording),
+	})
+}
+
+// callChatMethod posts payload to a per-chat GREEN-API method and writes the
+// envelope, echoing the request fields plus extra.
+func (a *App) callChatMethod(w http.ResponseWriter, r *http.Request, method string, request chatRequest, payload, extra map[string]interface{}) {
+	ctx, err := withOverrides(r.Context(), request.UpstreamOverrides)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "blocked_override", err.Error())
+		return
+	}
+	ctx, rs := newResponder(ctx)
+
+	apiUrl := a.methodURL(method, request.IDInstance, request.APITokenInstance)
+
+	echo := map[string]interface{}{
+		"phoneNumber":      request.PhoneNumber,
+		"idInstance":       request.IDInstance,
+		"apiTokenInstance": "••••••••", // Mask sensitive data
+	}
+	for key, value := range extra {
+		echo[key] = value
+	}
+
+	// Only sends are worth delivering late, not typing or read marks
+	if audited(method) && a.queueOffline(w, r, rs, ScheduledSend{
+		IDInstance:  request.IDInstance,
+		PhoneNumber: request.PhoneNumber,
+		Method:      method,
+		URL:         apiUrl,
+		Payload:     payload,
+		Content:     payloadMessage(method, apiUrl, payload).Content,
+	}, echo) {
+		return
+	}
+
+	// Keep sends to the same chat in order with the other send endpoints
+	if audited(method) {
+		unlock, err := a.chatLocks.lock(ctx, chatKey(request.IDInstance, request.PhoneNumber+"@c.us"))
+		if err != nil {
+			writeChatBusy(w, r)
+			return
+		}
+		defer unlock()
+	}
+
+	apiResponse, statusCode, err := a.makeAPIRequestWithPayload(ctx, method, apiUrl, payload)
+	if audited(method) {
+		a.audit.record(actorOf(r), payloadMessage(method, apiUrl, payload), statusCode, apiResponse, err)
+	}
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+
+	rs.respond(w, APIResponse{
+		URL:         apiUrl,
+		RequestBody: echo,
+		Response:    apiResponse,
+		StatusCode:  statusCode,
+		Snippets:    snippetsFor(ctx, jsonCall(apiUrl, payload)),
+	})
+}
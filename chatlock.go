@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// ChatLocks serializes sends to the same chat in arrival order so messages
+// are delivered in the order they were submitted. Sends to different chats
+// run concurrently.
+type ChatLocks struct {
+	mu     sync.Mutex
+	queues map[string]*chatQueue
+}
+
+type chatQueue struct {
+	busy    bool
+	waiters []chan struct{}
+}
+
+func newChatLocks() *ChatLocks {
+	return &ChatLocks{queues: make(map[string]*chatQueue)}
+}
+
+// chatKey scopes a chat to the instance sending to it.
+func chatKey(idInstance, chatId string) string {
+	return idInstance + "/" + chatId
+}
+
+// lock waits for the chat's turn and returns the function releasing it.
+func (c *ChatLocks) lock(ctx context.Context, key string) (func(), error) {
+	c.mu.Lock()
+	queue, ok := c.queues[key]
+	if !ok {
+		queue = &chatQueue{}
+		c.queues[key] = queue
+	}
+	if !queue.busy {
+		queue.busy = true
+		c.mu.Unlock()
+		return func() { c.release(key) }, nil
+	}
+	turn := make(chan struct{})
+	queue.waiters = append(queue.waiters, turn)
+	c.mu.Unlock()
+
+	select {
+	case <-turn:
+		return func() { c.release(key) }, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		for i, waiter := range queue.waiters {
+			if waiter == turn {
+				queue.waiters = append(queue.waiters[:i], queue.waiters[i+1:]...)
+				c.mu.Unlock()
+				return nil, ctx.Err()
+			}
+		}
+		c.mu.Unlock()
+		// The turn was handed over while giving up, pass it on
+		c.release(key)
+		return nil, ctx.Err()
+	}
+}
+
+// writeChatBusy answers a send that gave up waiting for its chat's turn,
+// because the client went away or the time budget ran out first.
+func writeChatBusy(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusServiceUnavailable, "chat_busy", "Earlier sends to this chat did not finish in time, try again")
+}
+
+// release hands the chat to the next waiter.
+func (c *ChatLocks) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	queue := c.queues[key]
+	if len(queue.waiters) == 0 {
+		delete(c.queues, key)
+		return
+	}
+	next := queue.waiters[0]
+	queue.waiters = queue.waiters[1:]
+	close(next)
+}
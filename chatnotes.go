@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limits of the notes of one chat.
+const (
+	maxNotesLength   = 10000
+	maxMetadataKeys  = 50
+	maxMetadataValue = 1000
+)
+
+// ChatNotes are free text notes and key/value metadata of a chat of an
+// instance, e.g. the scenario a test conversation plays through. They are
+// kept locally, like labels.
+type ChatNotes struct {
+	IDInstance string            `json:"idInstance"`
+	ChatID     string            `json:"chatId"`
+	Notes      string            `json:"notes"`
+	Metadata   map[string]string `json:"metadata"`
+	UpdatedAt  time.Time         `json:"updatedAt,omitzero"`
+	UpdatedBy  string            `json:"updatedBy,omitempty"`
+}
+
+// ChatNotesStore keeps the notes of chats by instance and chat. A chat
+// without notes or metadata isn't kept.
+type ChatNotesStore interface {
+	get(idInstance, chatId string) (ChatNotes, bool, error)
+	put(notes ChatNotes) error
+	delete(idInstance, chatId string) error
+}
+
+// errTooManyMetadataKeys rejects an edit leaving a chat with more than
+// maxMetadataKeys metadata keys.
+var errTooManyMetadataKeys = errors.New("too many metadata keys")
+
+// ChatNotebook edits the notes of chats.
+type ChatNotebook struct {
+	// mu keeps two edits of the same chat from losing one another's
+	// metadata.
+	mu    sync.Mutex
+	store ChatNotesStore
+}
+
+func (n *ChatNotebook) get(idInstance, chatId string) (ChatNotes, error) {
+	notes, ok, err := n.store.get(idInstance, chatId)
+	if err != nil || !ok {
+		return ChatNotes{IDInstance: idInstance, ChatID: chatId, Metadata: map[string]string{}}, err
+	}
+	return notes, nil
+}
+
+// update changes the notes of a chat, dropping them when nothing is left.
+// Nothing is written when change fails.
+func (n *ChatNotebook) update(idInstance, chatId, actor string, change func(*ChatNotes) error) (ChatNotes, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	notes, err := n.get(idInstance, chatId)
+	if err != nil {
+		return notes, err
+	}
+	if err := change(&notes); err != nil {
+		return notes, err
+	}
+	notes.UpdatedAt = time.Now()
+	notes.UpdatedBy = actor
+	if notes.Notes == "" && len(notes.Metadata) == 0 {
+		return notes, n.store.delete(idInstance, chatId)
+	}
+	return notes, n.store.put(notes)
+}
+
+func (a *App) chatNotesHandler(w http.ResponseWriter, r *http.Request) {
+	idInstance, chatId, ok := a.chatOfPath(w, r)
+	if !ok {
+		return
+	}
+	notes, err := a.chatNotes.get(idInstance, chatId)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notes)
+}
+
+// updateChatNotesHandler edits the notes of a chat as a merge patch: notes
+// are replaced when given, and metadata keys are set, or removed with a
+// null value, leaving the others as they are.
+func (a *App) updateChatNotesHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		Notes    *string            `json:"notes"`
+		Metadata map[string]*string `json:"metadata"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	if requestBody.Notes != nil && len([]rune(*requestBody.Notes)) > maxNotesLength {
+		writeErrorf(w, r, http.StatusBadRequest, "invalid_request", "Notes are longer than %d characters", maxNotesLength)
+		return
+	}
+	for key, value := range requestBody.Metadata {
+		if key == "" {
+			writeError(w, r, http.StatusBadRequest, "invalid_request", "Metadata keys must not be empty")
+			return
+		}
+		if value != nil && len([]rune(*value)) > maxMetadataValue {
+			writeErrorf(w, r, http.StatusBadRequest, "invalid_request", "Metadata value %s is longer than %d characters", key, maxMetadataValue)
+			return
+		}
+	}
+	idInstance, chatId, ok := a.chatOfPath(w, r)
+	if !ok {
+		return
+	}
+
+	notes, err := a.chatNotes.update(idInstance, chatId, actorName(r), func(notes *ChatNotes) error {
+		metadata := maps.Clone(notes.Metadata)
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		for key, value := range requestBody.Metadata {
+			if value == nil {
+				delete(metadata, key)
+			} else {
+				metadata[key] = *value
+			}
+		}
+		if len(metadata) > maxMetadataKeys {
+			return errTooManyMetadataKeys
+		}
+		notes.Metadata = metadata
+		if requestBody.Notes != nil {
+			notes.Notes = *requestBody.Notes
+		}
+		return nil
+	})
+	if errors.Is(err, errTooManyMetadataKeys) {
+		writeErrorf(w, r, http.StatusBadRequest, "invalid_request", "A chat has at most %d metadata keys", maxMetadataKeys)
+		return
+	}
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	infof("Notes of chat %s of instance %s edited by %s", chatId, idInstance, actorName(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notes)
+}
+
+// deleteChatNotesHandler clears the notes and metadata of a chat.
+func (a *App) deleteChatNotesHandler(w http.ResponseWriter, r *http.Request) {
+	idInstance, chatId, ok := a.chatOfPath(w, r)
+	if !ok {
+		return
+	}
+	if err := a.chatNotes.store.delete(idInstance, chatId); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	infof("Notes of chat %s of instance %s cleared by %s", chatId, idInstance, actorName(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// memoryChatNotes keeps the notes of chats in a map, so they are forgotten
+// on restart.
+type memoryChatNotes struct {
+	notes *SyncMap[string, ChatNotes]
+}
+
+func newMemoryChatNotes() *memoryChatNotes {
+	return &memoryChatNotes{notes: newSyncMap[string, ChatNotes]()}
+}
+
+func (s *memoryChatNotes) get(idInstance, chatId string) (ChatNotes, bool, error) {
+	notes, ok := s.notes.get(chatKey(idInstance, chatId))
+	return notes, ok, nil
+}
+
+func (s *memoryChatNotes) put(notes ChatNotes) error {
+	s.notes.set(chatKey(notes.IDInstance, notes.ChatID), notes)
+	return nil
+}
+
+func (s *memoryChatNotes) delete(idInstance, chatId string) error {
+	s.notes.delete(chatKey(idInstance, chatId))
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// checkTimeout bounds each network call of -check.
+const checkTimeout = 10 * time.Second
+
+// selfCheck prints a line per check of -check and remembers whether any
+// failed.
+type selfCheck struct {
+	out    io.Writer
+	failed bool
+}
+
+func (c *selfCheck) report(name string, err error, detail string) {
+	switch {
+	case err != nil:
+		c.failed = true
+		fmt.Fprintf(c.out, "FAIL  %-24s %v\n", name, err)
+	default:
+		fmt.Fprintf(c.out, "ok    %-24s %s\n", name, detail)
+	}
+}
+
+func (c *selfCheck) warn(name, detail string) {
+	fmt.Fprintf(c.out, "warn  %-24s %s\n", name, detail)
+}
+
+// runCheck validates the configuration, the storage, that GREEN-API can be
+// reached and the credentials of every configured instance, printing a
+// report to out. It returns whether everything passed, so -check can exit
+// non-zero in a deploy pipeline. Flags that fail to parse never get here:
+// loadConfig exits on them.
+func (a *App) runCheck(out io.Writer) bool {
+	c := &selfCheck{out: out}
+	c.report("config", nil, "flags and -config parsed")
+
+	c.report("secrets", a.resolveSecrets(), fmt.Sprintf("%d instances configured", len(a.config.Instances)))
+	c.report("features", a.featureFlags.configure(a.config.Features), "flags valid")
+	if a.vaultEnabled() {
+		ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+		a.vault.static = a.configuredInstances()
+		c.report("vault", a.vault.refresh(ctx), a.config.VaultAddr)
+		cancel()
+	}
+
+	if err := a.openStorage(a.config.Storage); err != nil {
+		c.report("storage", err, "")
+	} else {
+		err := a.instanceProfiles.load()
+		c.report("storage", err, fmt.Sprintf("%s, %d managed instances", a.config.Storage, len(a.instanceProfiles.list())))
+		a.closeStorage()
+		// Keep the calls checking credentials out of the stored history
+		a.history = newMemoryHistory(a.config.HistorySize)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	c.report("upstream "+a.upstreamBase(apiBase), a.checkReachable(ctx, a.upstreamBase(apiBase)), "reachable")
+
+	for _, instance := range a.configuredInstances() {
+		name := "instance " + instance.IDInstance
+		ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+		response, _, err := a.makeAPIRequest(ctx, "getStateInstance", a.instanceURL(instance, "getStateInstance"))
+		cancel()
+		if err != nil {
+			c.report(name, err, "")
+			continue
+		}
+		state, _ := response["stateInstance"].(string)
+		if state != "authorized" {
+			// The token works, the phone needs attention
+			c.warn(name, state)
+			continue
+		}
+		c.report(name, nil, state)
+	}
+
+	if c.failed {
+		fmt.Fprintln(out, "Check failed")
+	} else {
+		fmt.Fprintln(out, "Check passed")
+	}
+	return !c.failed
+}
+
+// checkReachable reports whether base answers HTTP at all; any status
+// will do.
+func (a *App) checkReachable(ctx context.Context, base string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, base, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
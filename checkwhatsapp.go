@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// NumberCheck is whether one number has WhatsApp.
+type NumberCheck struct {
+	PhoneNumber    string     `json:"phoneNumber"`
+	ExistsWhatsapp *bool      `json:"existsWhatsapp,omitempty"`
+	Error          *ErrorBody `json:"error,omitempty"`
+}
+
+// BulkCheckResult sums up a bulk check, with the numbers in the order
+// given.
+type BulkCheckResult struct {
+	Checked     int           `json:"checked"`
+	Reachable   int           `json:"reachable"`
+	Unreachable int           `json:"unreachable"`
+	Failed      int           `json:"failed"`
+	Numbers     []NumberCheck `json:"numbers"`
+}
+
+// BulkCheckRequest is the body of /api/check-whatsapp/bulk.
+type BulkCheckRequest struct {
+	IDInstance       string    `json:"idInstance" api:"required"`
+	APITokenInstance string    `json:"apiTokenInstance" api:"required"`
+	PhoneNumbers     phoneList `json:"phoneNumbers" api:"required"`
+	UpstreamOverrides
+}
+
+// bulkCheckHandler checks which of a list of numbers have WhatsApp, e.g.
+// before a broadcast. Up to -check-concurrency calls run at once, and the
+// instance makes at most one every -check-interval.
+func (a *App) bulkCheckHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody BulkCheckRequest
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	// Validate inputs
+	if len(requestBody.PhoneNumbers) == 0 {
+		writeError(w, r, http.StatusBadRequest, "missing_phone_numbers", "Phone numbers are required")
+		return
+	}
+	if len(requestBody.PhoneNumbers) > a.liveConfig().MaxCheckNumbers {
+		writeErrorf(w, r, http.StatusBadRequest, "too_many_numbers", "At most %d numbers per check", a.liveConfig().MaxCheckNumbers)
+		return
+	}
+
+	ctx, err := withOverrides(r.Context(), requestBody.UpstreamOverrides)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "blocked_override", err.Error())
+		return
+	}
+	ctx, rs := newResponder(ctx)
+
+	apiUrl := a.methodURL("checkWhatsapp", requestBody.IDInstance, requestBody.APITokenInstance)
+	result := BulkCheckResult{Numbers: make([]NumberCheck, len(requestBody.PhoneNumbers))}
+
+	var g errgroup.Group
+	g.SetLimit(max(a.liveConfig().CheckConcurrency, 1))
+	for i, phone := range requestBody.PhoneNumbers {
+		g.Go(func() error {
+			result.Numbers[i] = a.checkNumber(ctx, r, requestBody.IDInstance, apiUrl, phone)
+			return nil
+		})
+	}
+	g.Wait()
+
+	for _, check := range result.Numbers {
+		switch {
+		case check.Error != nil:
+			result.Failed++
+		case *check.ExistsWhatsapp:
+			result.Checked++
+			result.Reachable++
+		default:
+			result.Checked++
+			result.Unreachable++
+		}
+	}
+
+	rs.respond(w, APIResponse{
+		URL: apiUrl,
+		RequestBody: map[string]interface{}{
+			"phoneNumbers":     requestBody.PhoneNumbers,
+			"idInstance":       requestBody.IDInstance,
+			"apiTokenInstance": "••••••••", // Mask sensitive data
+		},
+		Response:   result,
+		StatusCode: http.StatusOK,
+	})
+}
+
+// checkNumber asks GREEN-API whether phone has WhatsApp once the instance's
+// check queue allows.
+func (a *App) checkNumber(ctx context.Context, r *http.Request, idInstance, apiUrl, phone string) NumberCheck {
+	check := NumberCheck{PhoneNumber: phone}
+	lang := negotiateLanguage(r)
+	number, err := strconv.ParseInt(phone, 10, 64)
+	if err != nil || len(phone) < 11 || len(phone) > 15 {
+		check.Error = &ErrorBody{Code: "invalid_phone_number", Message: translate(lang, "Please enter a valid phone number (digits only, 11-15 characters)"), Status: http.StatusBadRequest}
+		return check
+	}
+
+	if err := a.checkQueue.wait(ctx, idInstance); err != nil {
+		check.Error = &ErrorBody{Code: "check_cancelled", Message: translate(lang, "Check cancelled"), Status: http.StatusServiceUnavailable}
+		return check
+	}
+
+	response, _, err := a.makeAPIRequestWithPayload(ctx, "checkWhatsapp", apiUrl, map[string]interface{}{"phoneNumber": number})
+	if err != nil {
+		body := upstreamErrorBody(r, err)
+		check.Error = &body
+		return check
+	}
+	exists, _ := response["existsWhatsapp"].(bool)
+	check.ExistsWhatsapp = &exists
+	return check
+}
@@ -0,0 +1,618 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Config holds the server settings parsed from command-line flags.
+type Config struct {
+	Listen              string
+	BasePath            string
+	TrustedProxies      proxyList
+	DefaultTimeout      time.Duration
+	Timeouts            methodTimeouts
+	MaxUploadSize       int64
+	FilesDir            string
+	FileTTL             time.Duration
+	MediaDir            string
+	S3Endpoint          string
+	S3Bucket            string
+	S3Region            string
+	S3AccessKey         string
+	S3SecretKey         string
+	S3PresignTTL        time.Duration
+	PublicURL           string
+	HistorySize         int
+	HistoryMaxAge       time.Duration
+	HistoryMaxBody      int
+	WebhookHistorySize  int
+	WebhookMaxAge       time.Duration
+	ThumbnailCacheSize  int
+	ThumbnailMaxAge     time.Duration
+	SearchMaxAge        time.Duration
+	PruneInterval       time.Duration
+	TrashTTL            time.Duration
+	Storage             string
+	Retries             int
+	BreakerThreshold    int
+	BreakerCooldown     time.Duration
+	OfflineQueue        bool
+	MaxIdleConns        int
+	MaxIdlePerHost      int
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+	HTTP2               bool
+	UpstreamURL         string
+	SlowThreshold       time.Duration
+	WebhookToken        string
+	ForwardTo           forwardTargets
+	ForwardSecret       string
+	WebhookSecret       string
+	ReplayWindow        time.Duration
+	SMTPAddr            string
+	SMTPUser            string
+	SMTPPassword        string
+	SMTPFrom            string
+	EmailEvents         []string
+	EmailSubject        string
+	EmailBody           string
+	EmailRate           int
+	DedupTTL            time.Duration
+	Instances           instanceList
+	WatchInterval       time.Duration
+	DriftInterval       time.Duration
+	Jobs                jobSchedules
+	RegisterWebhook     bool
+	Tunnel              bool
+	SendInterval        time.Duration
+	MaxRecipients       int
+	Shortener           string
+	BitlyToken          string
+	CheckInterval       time.Duration
+	CheckConcurrency    int
+	MaxCheckNumbers     int
+	OptOutFile          string
+	StopKeywords        []string
+	StopReply           string
+	LabelRules          labelRules
+	QuietHours          quietHours
+	AuditFile           string
+	APIKeys             apiKeyList
+	SessionTTL          time.Duration
+	RememberTTL         time.Duration
+	OAuthProvider       string
+	OAuthClientID       string
+	OAuthClientSecret   string
+	OAuthAllowedDomains []string
+	OAuthAllowedUsers   []string
+	OAuthRole           Role
+	OAuthWorkspace      string
+	SecretsFile         string
+	SealSecrets         bool
+	Check               bool
+	Version             bool
+	Keyring             bool
+	VaultAddr           string
+	VaultToken          string
+	VaultMount          string
+	VaultPath           string
+	VaultRefresh        time.Duration
+	ConfigFile          string
+	Features            featureSettings
+	Middleware          middlewareChains
+	RateLimit           float64
+	RateBurst           int
+	InFlight            inFlightLimits
+	CORSOrigins         []string
+	LogLevel            string
+	LogOutput           []string
+	LogMaxSize          int64
+	LogMaxAge           time.Duration
+	LogMaxBackups       int
+	LogCompress         bool
+	Dev                 bool
+	Pprof               bool
+}
+
+// forwardTargets is a comma-separated list of URLs notifications are
+// forwarded to.
+type forwardTargets []string
+
+// Instance is a GREEN-API instance the server knows credentials for.
+type Instance struct {
+	Workspace        string
+	IDInstance       string
+	APITokenInstance string
+}
+
+// instanceList parses comma-separated [workspace/]idInstance:apiTokenInstance
+// pairs.
+type instanceList []Instance
+
+// methodTimeouts maps a GREEN-API method name to its response time budget.
+type methodTimeouts map[string]time.Duration
+
+// liveConfig is the config as reloaded at run time. Settings that reload
+// are read through it, everything else through a.config.
+func (a *App) liveConfig() *Config {
+	if c := a.live.Load(); c != nil {
+		return c
+	}
+	return a.config
+}
+
+// updateConfig publishes a copy of the live config changed by change.
+func (a *App) updateConfig(change func(*Config)) {
+	a.liveMu.Lock()
+	defer a.liveMu.Unlock()
+
+	next := *a.liveConfig()
+	change(&next)
+	a.live.Store(&next)
+}
+
+// configuredInstances returns the instances currently configured, which
+// Vault, config reloads and the admin API may change. Managed instances
+// come last and lose to configured ones with the same idInstance.
+func (a *App) configuredInstances() []Instance {
+	static := a.liveConfig().Instances
+	managed := a.instanceProfiles.list()
+	if len(managed) == 0 {
+		return static
+	}
+	instances := slices.Clip(static)
+	for _, instance := range managed {
+		if !a.staticInstance(instance.IDInstance) {
+			instances = append(instances, instance.Instance)
+		}
+	}
+	return instances
+}
+
+// lookupInstance returns the configured instance with the idInstance.
+func (a *App) lookupInstance(idInstance string) (Instance, bool) {
+	for _, instance := range a.configuredInstances() {
+		if instance.IDInstance == idInstance {
+			return instance, true
+		}
+	}
+	return Instance{}, false
+}
+
+func (a *App) setInstances(instances []Instance) {
+	a.updateConfig(func(c *Config) { c.Instances = instances })
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Listen:         ":8080",
+		DefaultTimeout: 10 * time.Second,
+		Timeouts: methodTimeouts{
+			"getStateInstance": 5 * time.Second,
+			"getSettings":      10 * time.Second,
+			"sendMessage":      15 * time.Second,
+			"sendFileByUrl":    time.Minute,
+			"sendFileByUpload": 5 * time.Minute,
+		},
+		MaxUploadSize:      100 << 20,
+		FileTTL:            time.Hour,
+		HistorySize:        500,
+		HistoryMaxBody:     64 << 10,
+		WebhookHistorySize: 200,
+		ThumbnailCacheSize: 200,
+		PruneInterval:      10 * time.Minute,
+		TrashTTL:           7 * 24 * time.Hour,
+		Storage:            "memory",
+		Retries:            2,
+		BreakerThreshold:   5,
+		BreakerCooldown:    30 * time.Second,
+		MaxIdleConns:       100,
+		MaxIdlePerHost:     32,
+		IdleConnTimeout:    90 * time.Second,
+		HTTP2:              true,
+		EmailEvents:        []string{"stateInstanceChanged:notAuthorized", "instanceStateAlert", "instanceSettingsDrift", deadLetterEvent},
+		EmailSubject:       defaultEmailSubject,
+		EmailBody:          defaultEmailBody,
+		EmailRate:          20,
+		DedupTTL:           time.Hour,
+		ReplayWindow:       5 * time.Minute,
+		WatchInterval:      time.Minute,
+		DriftInterval:      5 * time.Minute,
+		Jobs:               jobSchedules{},
+		SendInterval:       time.Second,
+		MaxRecipients:      100,
+		CheckInterval:      200 * time.Millisecond,
+		CheckConcurrency:   4,
+		MaxCheckNumbers:    1000,
+		StopKeywords:       []string{"stop", "стоп"},
+		StopReply:          "You are unsubscribed and won't get any more messages from us.",
+		LabelRules:         labelRules{},
+		QuietHours:         quietHours{},
+		SessionTTL:         12 * time.Hour,
+		RememberTTL:        30 * 24 * time.Hour,
+		OAuthRole:          roleSender,
+		VaultMount:         "secret",
+		VaultRefresh:       5 * time.Minute,
+		S3Region:           "us-east-1",
+		S3PresignTTL:       time.Hour,
+		Features:           featureSettings{},
+		Middleware:         defaultMiddleware(),
+		RateLimit:          10,
+		RateBurst:          20,
+		InFlight:           defaultInFlightLimits(),
+		LogLevel:           "info",
+		LogOutput:          []string{"stderr"},
+		LogMaxSize:         100,
+		LogMaxAge:          24 * time.Hour,
+		LogMaxBackups:      7,
+		LogCompress:        true,
+	}
+}
+
+// loadConfig parses the command line and -config into a Config. It exits on
+// invalid settings.
+func loadConfig() *Config {
+	c := defaultConfig()
+	c.register(flag.CommandLine)
+	flag.Parse()
+	if err := applyConfigFile(flag.CommandLine, c.ConfigFile); err != nil {
+		log.Fatal(err)
+	}
+	if err := c.resolve(); err != nil {
+		log.Fatal(err)
+	}
+	return c
+}
+
+// register defines the command-line flags setting c.
+func (c *Config) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.Listen, "listen", c.Listen, "address to serve on: host:port, unix:path for a Unix socket, or systemd for a socket passed by systemd socket activation")
+	fs.StringVar(&c.BasePath, "base-path", c.BasePath, "URL prefix the server is reached under behind a reverse proxy, e.g. /greenapi")
+	fs.Var(&c.TrustedProxies, "trusted-proxies", "comma-separated IPs or CIDRs of reverse proxies whose X-Forwarded-For, -Proto and -Host headers are believed")
+	fs.DurationVar(&c.DefaultTimeout, "timeout", c.DefaultTimeout, "default GREEN-API response time budget")
+	fs.Var(c.Timeouts, "timeouts", "per-method budgets, e.g. getStateInstance=5s,sendFileByUpload=5m")
+	fs.Int64Var(&c.MaxUploadSize, "max-upload-size", c.MaxUploadSize, "maximum file upload size in bytes")
+	fs.StringVar(&c.FilesDir, "files-dir", c.FilesDir, "directory for hosted files (default: a temp dir)")
+	fs.DurationVar(&c.FileTTL, "file-ttl", c.FileTTL, "how long hosted file links stay valid")
+	fs.StringVar(&c.MediaDir, "media-dir", c.MediaDir, "save the files of incoming messages in this directory (off when empty)")
+	fs.StringVar(&c.S3Bucket, "s3-bucket", c.S3Bucket, "save the files of incoming messages in this S3 bucket instead of -media-dir")
+	fs.StringVar(&c.S3Endpoint, "s3-endpoint", c.S3Endpoint, "URL of the S3 API, e.g. http://minio:9000 (default https://s3.<region>.amazonaws.com)")
+	fs.StringVar(&c.S3Region, "s3-region", c.S3Region, "region of -s3-bucket")
+	fs.StringVar(&c.S3AccessKey, "s3-access-key", c.S3AccessKey, "S3 access key ID (default $AWS_ACCESS_KEY_ID)")
+	fs.StringVar(&c.S3SecretKey, "s3-secret-key", c.S3SecretKey, "S3 secret access key (default $AWS_SECRET_ACCESS_KEY)")
+	fs.DurationVar(&c.S3PresignTTL, "s3-presign-ttl", c.S3PresignTTL, "how long the presigned links saved files are downloaded from stay valid")
+	fs.StringVar(&c.PublicURL, "public-url", c.PublicURL, "public base URL GREEN-API uses to reach this server")
+	fs.IntVar(&c.HistorySize, "history-size", c.HistorySize, "number of upstream calls kept in history")
+	fs.IntVar(&c.HistoryMaxBody, "history-max-body", c.HistoryMaxBody, "bytes of each request and response body kept in history, 0 for no limit")
+	fs.DurationVar(&c.HistoryMaxAge, "history-max-age", c.HistoryMaxAge, "drop history older than this, 0 to keep it until -history-size is reached")
+	fs.IntVar(&c.WebhookHistorySize, "webhook-history-size", c.WebhookHistorySize, "number of received webhooks kept per instance")
+	fs.DurationVar(&c.WebhookMaxAge, "webhook-max-age", c.WebhookMaxAge, "drop received webhooks older than this, 0 to keep them until an instance reaches -webhook-history-size")
+	fs.IntVar(&c.ThumbnailCacheSize, "thumbnail-cache-size", c.ThumbnailCacheSize, "number of media thumbnails cached")
+	fs.DurationVar(&c.ThumbnailMaxAge, "thumbnail-max-age", c.ThumbnailMaxAge, "drop cached thumbnails older than this, 0 to keep them until -thumbnail-cache-size is reached")
+	fs.DurationVar(&c.SearchMaxAge, "search-max-age", c.SearchMaxAge, "drop messages older than this from the search index of -storage, 0 to keep them")
+	fs.DurationVar(&c.PruneInterval, "prune-interval", c.PruneInterval, "how often history, webhooks and thumbnails are pruned, 0 to prune only through /api/admin/prune")
+	fs.DurationVar(&c.TrashTTL, "trash-ttl", c.TrashTTL, "how long deleted history entries and presets can be restored from the trash")
+	fs.StringVar(&c.Storage, "storage", c.Storage, "where history, sessions and scheduled sends are kept: memory, sqlite:path or a postgres:// URL")
+	fs.IntVar(&c.Retries, "retries", c.Retries, "retries for GET calls failing with a network error, 429 or 5xx")
+	fs.IntVar(&c.BreakerThreshold, "breaker-threshold", c.BreakerThreshold, "GREEN-API calls failing in a row with a network error, timeout or 5xx before calls are paused, 0 to never pause")
+	fs.DurationVar(&c.BreakerCooldown, "breaker-cooldown", c.BreakerCooldown, "how long GREEN-API calls stay paused before one is tried again")
+	fs.BoolVar(&c.OfflineQueue, "offline-queue", c.OfflineQueue, "while GREEN-API calls are paused, queue sends with 202 Accepted and send them once it answers again, instead of failing them")
+	fs.IntVar(&c.MaxIdleConns, "upstream-max-idle-conns", c.MaxIdleConns, "idle GREEN-API connections kept open in total, 0 for no limit")
+	fs.IntVar(&c.MaxIdlePerHost, "upstream-max-idle-per-host", c.MaxIdlePerHost, "idle connections kept open to each GREEN-API host")
+	fs.IntVar(&c.MaxConnsPerHost, "upstream-max-conns-per-host", c.MaxConnsPerHost, "connections open to each GREEN-API host at once, 0 for no limit")
+	fs.DurationVar(&c.IdleConnTimeout, "upstream-idle-timeout", c.IdleConnTimeout, "how long an idle GREEN-API connection is kept open")
+	fs.BoolVar(&c.HTTP2, "upstream-http2", c.HTTP2, "use HTTP/2 for GREEN-API calls when the host supports it")
+	fs.StringVar(&c.UpstreamURL, "upstream-url", c.UpstreamURL, "base URL every GREEN-API call goes to instead of the GREEN-API hosts, such as a mock server")
+	fs.DurationVar(&c.SlowThreshold, "slow-threshold", c.SlowThreshold, "log GREEN-API calls slower than this and list them on /api/slow-requests, 0 to disable")
+	fs.StringVar(&c.WebhookToken, "webhook-token", c.WebhookToken, "token GREEN-API sends in the Authorization header of webhooks (webhookUrlToken)")
+	fs.Var(&c.ForwardTo, "forward-to", "comma-separated URLs incoming webhooks are forwarded to, or mailto:address to email the -email-events")
+	fs.StringVar(&c.ForwardSecret, "forward-secret", c.ForwardSecret, "HMAC secret used to sign forwarded webhooks")
+	fs.StringVar(&c.WebhookSecret, "webhook-secret", c.WebhookSecret, "HMAC secret webhooks forwarded by another grapi with -forward-secret are signed with; unsigned webhooks are rejected when set")
+	fs.DurationVar(&c.ReplayWindow, "webhook-replay-window", c.ReplayWindow, "how far the timestamp of a signed webhook may be from now; each signature is accepted once within it")
+	fs.StringVar(&c.SMTPAddr, "smtp-addr", c.SMTPAddr, "host:port of the SMTP server emailing mailto: targets")
+	fs.StringVar(&c.SMTPUser, "smtp-user", c.SMTPUser, "SMTP user, no auth when empty")
+	fs.StringVar(&c.SMTPPassword, "smtp-password", c.SMTPPassword, "SMTP password (default $GREENAPI_SMTP_PASSWORD)")
+	fs.StringVar(&c.SMTPFrom, "smtp-from", c.SMTPFrom, "sender address of emails")
+	fs.Func("email-events", "comma-separated events emailed to mailto: targets, as typeWebhook or typeWebhook:stateInstance (default stateInstanceChanged:notAuthorized,instanceStateAlert,instanceSettingsDrift,deadLetter)", func(value string) error {
+		c.EmailEvents = splitList(value)
+		return nil
+	})
+	fs.StringVar(&c.EmailSubject, "email-subject", c.EmailSubject, "Go template of the email subject, with .Type, .IDInstance, .State, .Time and .Body")
+	fs.StringVar(&c.EmailBody, "email-body", c.EmailBody, "Go template of the email body, with the fields of -email-subject")
+	fs.IntVar(&c.EmailRate, "email-rate", c.EmailRate, "emails sent an hour at most, further events are counted in the next one; 0 for no limit")
+	fs.DurationVar(&c.DedupTTL, "dedup-ttl", c.DedupTTL, "how long delivered notifications are remembered to drop duplicates")
+	fs.Var(&c.Instances, "instances", "instances to watch as idInstance:apiTokenInstance pairs, or bare idInstance with -keyring, comma-separated (default $GREENAPI_INSTANCES)")
+	fs.DurationVar(&c.WatchInterval, "watch-interval", c.WatchInterval, "how often configured instances are polled for state changes, 0 to disable")
+	fs.DurationVar(&c.DriftInterval, "drift-interval", c.DriftInterval, "how often instances with desired settings are checked for drift, 0 to check only on request")
+	fs.Var(c.Jobs, "job", "schedule of a background job as name=cron expression, @hourly style shorthand, @every duration or off, e.g. prune=0 3 * * *; repeat for more jobs (jobs: scheduled-sends, prune, watch, drift, vault)")
+	fs.BoolVar(&c.RegisterWebhook, "register-webhook", c.RegisterWebhook, "point configured instances at this server's /webhook while it runs (needs -public-url)")
+	fs.BoolVar(&c.Tunnel, "tunnel", c.Tunnel, "expose /webhook through an ngrok tunnel and register it with configured instances")
+	fs.DurationVar(&c.SendInterval, "send-interval", c.SendInterval, "minimum delay between broadcast sends through one instance")
+	fs.IntVar(&c.MaxRecipients, "max-recipients", c.MaxRecipients, "maximum recipients of one broadcast request")
+	fs.StringVar(&c.Shortener, "shortener", c.Shortener, `shorten links in broadcast messages with "bitly" or a self-hosted shortener URL taking POST {"url"} and answering {"shortUrl"}`)
+	fs.StringVar(&c.BitlyToken, "bitly-token", c.BitlyToken, "Bitly access token of -shortener bitly (default $BITLY_TOKEN)")
+	fs.DurationVar(&c.CheckInterval, "check-interval", c.CheckInterval, "minimum delay between checkWhatsapp calls through one instance")
+	fs.IntVar(&c.CheckConcurrency, "check-concurrency", c.CheckConcurrency, "checkWhatsapp calls in flight at once for one bulk check")
+	fs.IntVar(&c.MaxCheckNumbers, "max-check-numbers", c.MaxCheckNumbers, "maximum numbers of one bulk checkWhatsapp request")
+	fs.StringVar(&c.OptOutFile, "optout-file", c.OptOutFile, "JSON file the opt-out list is kept in (default: memory only)")
+	fs.Func("stop-keywords", "comma-separated replies that add the sender to the opt-out list (default stop,стоп)", func(value string) error {
+		c.StopKeywords = splitList(value)
+		return nil
+	})
+	fs.StringVar(&c.StopReply, "stop-reply", c.StopReply, "Go template of the message confirming an opt-out by stop keyword, with .Phone and .Keyword; empty to send none")
+	fs.Var(c.LabelRules, "label-rule", "label put on chats whose incoming messages match a regexp, as label=regexp, e.g. lead=(?i)price|buy; repeat for more labels")
+	fs.Var(c.QuietHours, "quiet-hours", "windows in which broadcasts are held back, e.g. 22:00-08:00@recipient or 1101=21:00-09:00@Europe/Moscow")
+	fs.StringVar(&c.AuditFile, "audit-file", c.AuditFile, "append-only JSON lines file the message audit log is kept in (default: memory only)")
+	fs.Var(&c.APIKeys, "api-keys", "enable auth with comma-separated name:role:key API keys, roles are viewer, sender and admin (default $GREENAPI_API_KEYS)")
+	fs.DurationVar(&c.SessionTTL, "session-ttl", c.SessionTTL, "how long a browser sign-in lasts")
+	fs.DurationVar(&c.RememberTTL, "remember-ttl", c.RememberTTL, "how long a sign-in with \"remember me\" lasts")
+	fs.StringVar(&c.OAuthProvider, "oauth-provider", c.OAuthProvider, "enable sign-in through github, google or the issuer URL of an OIDC provider")
+	fs.StringVar(&c.OAuthClientID, "oauth-client-id", c.OAuthClientID, "OAuth client ID registered with the provider")
+	fs.StringVar(&c.OAuthClientSecret, "oauth-client-secret", c.OAuthClientSecret, "OAuth client secret (default $GREENAPI_OAUTH_CLIENT_SECRET)")
+	fs.Func("oauth-allowed-domains", "comma-separated email domains whose users may sign in through the provider", func(value string) error {
+		c.OAuthAllowedDomains = splitList(value)
+		return nil
+	})
+	fs.Func("oauth-allowed-users", "comma-separated logins or emails that may sign in through the provider", func(value string) error {
+		c.OAuthAllowedUsers = splitList(value)
+		return nil
+	})
+	fs.Func("oauth-role", "role of users signed in through the provider (default sender)", func(value string) (err error) {
+		c.OAuthRole, err = parseRole(value)
+		return err
+	})
+	fs.StringVar(&c.OAuthWorkspace, "oauth-workspace", c.OAuthWorkspace, "workspace of users signed in through the provider (default: all workspaces)")
+	fs.StringVar(&c.SecretsFile, "secrets-file", c.SecretsFile, "encrypted file of instance credentials, unlocked with $GREENAPI_SECRETS_PASSPHRASE or a prompt")
+	fs.BoolVar(&c.SealSecrets, "seal-secrets", c.SealSecrets, "encrypt idInstance:apiTokenInstance lines from stdin into -secrets-file and exit")
+	fs.BoolVar(&c.Check, "check", c.Check, "check the config, storage, GREEN-API reachability and instance credentials, print a report and exit, non-zero on failure")
+	fs.BoolVar(&c.Version, "version", c.Version, "print the version and exit")
+	fs.BoolVar(&c.Keyring, "keyring", c.Keyring, "look up tokens of -instances given without one in the OS keyring (service \"grapi\", account idInstance)")
+	fs.StringVar(&c.VaultAddr, "vault-addr", c.VaultAddr, "Vault server address (default $VAULT_ADDR)")
+	fs.StringVar(&c.VaultToken, "vault-token", c.VaultToken, "Vault token (default $VAULT_TOKEN)")
+	fs.StringVar(&c.VaultMount, "vault-mount", c.VaultMount, "mount of the Vault KV v2 secrets engine")
+	fs.StringVar(&c.VaultPath, "vault-path", c.VaultPath, "KV v2 secret mapping idInstance to apiTokenInstance, e.g. grapi/instances")
+	fs.DurationVar(&c.VaultRefresh, "vault-refresh", c.VaultRefresh, "how often instance credentials are read again from Vault, 0 to read once")
+	fs.StringVar(&c.ConfigFile, "config", c.ConfigFile, "file of flag=value lines, reloaded on change or SIGHUP; command-line flags take precedence")
+	fs.Var(c.Features, "features", "switch features on or off, e.g. broadcast=off,polls=on")
+	fs.Var(c.Middleware, "middleware", "middleware stages of the api, pages and webhook routes, e.g. api=recover+log+ratelimit+metrics+auth; stages are "+strings.Join(middlewareStages, ", "))
+	fs.Float64Var(&c.RateLimit, "rate-limit", c.RateLimit, "requests a second each client may make to routes with the ratelimit middleware, 0 for no limit")
+	fs.IntVar(&c.RateBurst, "rate-burst", c.RateBurst, "requests a client may make at once before -rate-limit applies")
+	fs.Var(c.InFlight, "max-in-flight", "requests of a class that may run at once before the rest get 503, as class=n pairs, 0 for no limit; classes are "+strings.Join(inFlightClasses, " and "))
+	fs.Func("cors-origins", "comma-separated origins, or *, allowed to call routes with the cors middleware from a browser", func(value string) error {
+		c.CORSOrigins = splitList(value)
+		return nil
+	})
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "least severe log lines written: debug, info, warn or error")
+	fs.Func("log-output", "comma-separated log sinks: stderr, syslog, syslog+udp://host:port, syslog+tcp://host:port or a file path (default stderr)", func(value string) error {
+		c.LogOutput = splitList(value)
+		return nil
+	})
+	fs.Int64Var(&c.LogMaxSize, "log-max-size", c.LogMaxSize, "megabytes a log file may grow to before it is rotated, 0 for no limit")
+	fs.DurationVar(&c.LogMaxAge, "log-max-age", c.LogMaxAge, "how long a log file is written before it is rotated, 0 for no limit")
+	fs.IntVar(&c.LogMaxBackups, "log-max-backups", c.LogMaxBackups, "rotated log files kept, 0 to keep them all")
+	fs.BoolVar(&c.LogCompress, "log-compress", c.LogCompress, "gzip rotated log files")
+	fs.BoolVar(&c.Pprof, "pprof", c.Pprof, "serve Go runtime profiles on /debug/pprof to admins")
+	fs.BoolVar(&c.Dev, "dev", c.Dev, "serve templates and static files from the working directory instead of the binary")
+}
+
+// resolve fills settings left empty from the environment and checks the
+// combination is usable.
+func (c *Config) resolve() error {
+	if len(c.Instances) == 0 {
+		if err := c.Instances.Set(os.Getenv("GREENAPI_INSTANCES")); err != nil {
+			return fmt.Errorf("invalid GREENAPI_INSTANCES: %w", err)
+		}
+	}
+
+	if len(c.APIKeys) == 0 {
+		if err := c.APIKeys.Set(os.Getenv("GREENAPI_API_KEYS")); err != nil {
+			return fmt.Errorf("invalid GREENAPI_API_KEYS: %w", err)
+		}
+	}
+
+	if c.OAuthProvider != "" {
+		if c.OAuthClientSecret == "" {
+			c.OAuthClientSecret = os.Getenv("GREENAPI_OAUTH_CLIENT_SECRET")
+		}
+		if c.OAuthClientID == "" || c.OAuthClientSecret == "" {
+			return errors.New("-oauth-provider needs -oauth-client-id and -oauth-client-secret")
+		}
+		if len(c.OAuthAllowedDomains) == 0 && len(c.OAuthAllowedUsers) == 0 {
+			return errors.New("-oauth-provider needs -oauth-allowed-domains or -oauth-allowed-users")
+		}
+	}
+
+	if c.VaultPath != "" {
+		if c.VaultAddr == "" {
+			c.VaultAddr = os.Getenv("VAULT_ADDR")
+		}
+		if c.VaultToken == "" {
+			c.VaultToken = os.Getenv("VAULT_TOKEN")
+		}
+		if c.VaultAddr == "" || c.VaultToken == "" {
+			return errors.New("-vault-path needs -vault-addr and -vault-token")
+		}
+	}
+
+	if c.S3Bucket != "" {
+		if c.MediaDir != "" {
+			return errors.New("-s3-bucket and -media-dir can't be used together")
+		}
+		if c.S3AccessKey == "" {
+			c.S3AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		}
+		if c.S3SecretKey == "" {
+			c.S3SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+		if c.S3AccessKey == "" || c.S3SecretKey == "" {
+			return errors.New("-s3-bucket needs -s3-access-key and -s3-secret-key")
+		}
+		if c.S3PresignTTL < time.Second || c.S3PresignTTL > 7*24*time.Hour {
+			return errors.New("-s3-presign-ttl must be between 1s and 7 days")
+		}
+		if c.S3Endpoint = strings.TrimSuffix(c.S3Endpoint, "/"); c.S3Endpoint == "" {
+			c.S3Endpoint = "https://s3." + c.S3Region + ".amazonaws.com"
+		}
+		if u, err := url.Parse(c.S3Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid -s3-endpoint %q, expected an http(s) URL", c.S3Endpoint)
+		}
+	}
+
+	if c.WebhookSecret != "" && c.ReplayWindow <= 0 {
+		return errors.New("-webhook-replay-window must be positive")
+	}
+
+	if len(mailTargets(c.ForwardTo)) > 0 {
+		if c.SMTPPassword == "" {
+			c.SMTPPassword = os.Getenv("GREENAPI_SMTP_PASSWORD")
+		}
+		if c.SMTPAddr == "" || c.SMTPFrom == "" {
+			return errors.New("mailto: targets in -forward-to need -smtp-addr and -smtp-from")
+		}
+		for name, text := range map[string]string{"-email-subject": c.EmailSubject, "-email-body": c.EmailBody} {
+			if _, err := template.New(name).Parse(text); err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+		}
+	}
+	if _, err := template.New("stop-reply").Parse(c.StopReply); err != nil {
+		return fmt.Errorf("invalid -stop-reply: %w", err)
+	}
+
+	switch {
+	case c.Shortener == "bitly":
+		if c.BitlyToken == "" {
+			c.BitlyToken = os.Getenv("BITLY_TOKEN")
+		}
+		if c.BitlyToken == "" {
+			return errors.New("-shortener bitly needs -bitly-token")
+		}
+	case c.Shortener != "":
+		if u, err := url.Parse(c.Shortener); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid -shortener %q, expected bitly or an http(s) URL", c.Shortener)
+		}
+	}
+
+	if c.UpstreamURL = strings.TrimSuffix(c.UpstreamURL, "/"); c.UpstreamURL != "" {
+		if u, err := url.Parse(c.UpstreamURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid -upstream-url %q, expected an http(s) URL", c.UpstreamURL)
+		}
+	}
+
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+
+	if c.BreakerThreshold < 0 || c.BreakerCooldown <= 0 {
+		return errors.New("-breaker-threshold must not be negative and -breaker-cooldown must be positive")
+	}
+
+	c.PublicURL = strings.TrimSuffix(c.PublicURL, "/")
+	if c.BasePath = strings.TrimSuffix(c.BasePath, "/"); c.BasePath != "" && !strings.HasPrefix(c.BasePath, "/") {
+		c.BasePath = "/" + c.BasePath
+	}
+	if c.Tunnel {
+		if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+			return errors.New("-tunnel needs a host:port -listen address")
+		}
+	}
+	if c.RegisterWebhook && c.PublicURL == "" && !c.Tunnel {
+		return errors.New("-register-webhook needs -public-url")
+	}
+	return nil
+}
+
+// splitList parses a comma-separated flag value, skipping empty entries.
+func splitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// timeoutFor returns the response time budget for a GREEN-API method.
+func (c *Config) timeoutFor(method string) time.Duration {
+	if timeout, ok := c.Timeouts[method]; ok {
+		return timeout
+	}
+	return c.DefaultTimeout
+}
+
+func (t methodTimeouts) String() string {
+	pairs := make([]string, 0, len(t))
+	for method, timeout := range t {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", method, timeout))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (t methodTimeouts) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		method, rawTimeout, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || method == "" {
+			return fmt.Errorf("invalid timeout %q, expected method=duration", pair)
+		}
+		timeout, err := time.ParseDuration(rawTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout for %s: %w", method, err)
+		}
+		t[method] = timeout
+	}
+	return nil
+}
+
+func (t *forwardTargets) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t *forwardTargets) Set(value string) error {
+	for _, target := range strings.Split(value, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			*t = append(*t, target)
+		}
+	}
+	return nil
+}
+
+func (l *instanceList) String() string {
+	ids := make([]string, len(*l))
+	for i, instance := range *l {
+		ids[i] = instance.IDInstance
+		if instance.Workspace != "" {
+			ids[i] = instance.Workspace + "/" + ids[i]
+		}
+	}
+	return strings.Join(ids, ",")
+}
+
+func (l *instanceList) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		// A bare idInstance takes its token from the keyring
+		id, token, ok := strings.Cut(pair, ":")
+		workspace, id := splitWorkspace(id)
+		if id == "" || (ok && token == "") {
+			return fmt.Errorf("invalid instance %q, expected idInstance:apiTokenInstance", id)
+		}
+		*l = append(*l, Instance{Workspace: workspace, IDInstance: id, APITokenInstance: token})
+	}
+	return nil
+}
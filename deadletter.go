@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const maxDeadLetters = 500
+
+// DeadLetter is a delivery that failed after exhausting its retries.
+type DeadLetter struct {
+	ID     int64  `json:"id"`
+	Kind   string `json:"kind"`
+	Target string `json:"target"`
+	// IDInstance is the instance the delivery was for, which decides the
+	// workspace that sees the letter.
+	IDInstance string          `json:"idInstance,omitempty"`
+	Payload    json.RawMessage `json:"payload"`
+	Reason     string          `json:"reason"`
+	Attempts   int             `json:"attempts"`
+	FailedAt   time.Time       `json:"failedAt"`
+	// send is the failed send of a send letter, with the token its
+	// Payload and Target have masked.
+	send *ScheduledSend
+}
+
+// Dead letter kinds: webhook forwards and scheduled or offline-queued sends.
+const (
+	deadLetterForward = "forward"
+	deadLetterSend    = "send"
+)
+
+// deadLetterRetryers redeliver dead letters by kind, counting the attempts
+// they make.
+var deadLetterRetryers = map[string]func(*App, *DeadLetter) error{
+	deadLetterForward: (*App).retryForward,
+	deadLetterSend:    (*App).retrySend,
+}
+
+// DeadLetterQueue keeps failed deliveries until they are retried or purged.
+type DeadLetterQueue struct {
+	mu     sync.Mutex
+	items  []DeadLetter
+	nextID int64
+}
+
+func (q *DeadLetterQueue) add(letter DeadLetter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	letter.ID = q.nextID
+	q.nextID++
+	letter.FailedAt = time.Now()
+	if len(q.items) >= maxDeadLetters {
+		q.items = q.items[1:]
+	}
+	q.items = append(q.items, letter)
+}
+
+func (q *DeadLetterQueue) list() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]DeadLetter{}, q.items...)
+}
+
+// take removes and returns a dead letter, if visible to the caller.
+func (q *DeadLetterQueue) take(id int64, visible func(DeadLetter) bool) (DeadLetter, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, letter := range q.items {
+		if letter.ID == id && visible(letter) {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			return letter, true
+		}
+	}
+	return DeadLetter{}, false
+}
+
+// purge removes the dead letters visible to the caller and returns how
+// many it removed.
+func (q *DeadLetterQueue) purge(visible func(DeadLetter) bool) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	count := len(q.items)
+	q.items = slices.DeleteFunc(q.items, visible)
+	return count - len(q.items)
+}
+
+// retryForward redelivers a notification to the forwarder target it failed on.
+func (a *App) retryForward(letter *DeadLetter) error {
+	var notification Notification
+	if err := json.Unmarshal(letter.Payload, &notification); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	letter.Attempts += forwardAttempts
+	return a.forwarder.deliver(letter.Target, notification)
+}
+
+// deadSend files a scheduled or offline-queued send that failed.
+func (a *App) deadSend(send ScheduledSend, err error) {
+	payload, _ := json.Marshal(scheduledView{ScheduledSend: send, URL: maskToken(send.URL)})
+	letter := DeadLetter{
+		Kind:       deadLetterSend,
+		Target:     maskToken(send.URL),
+		IDInstance: send.IDInstance,
+		Payload:    payload,
+		Reason:     err.Error(),
+		Attempts:   1,
+		send:       &send,
+	}
+	a.deadLetters.add(letter)
+	a.mailer.deadLetter(letter)
+}
+
+// retrySend schedules a failed send again, due now. It goes out through
+// the scheduler like the first time, and is filed again if it fails.
+func (a *App) retrySend(letter *DeadLetter) error {
+	if letter.send == nil {
+		return errors.New("the send is no longer known")
+	}
+	send := *letter.send
+	_, err := a.scheduler.add(ScheduledSend{
+		IDInstance:  send.IDInstance,
+		PhoneNumber: send.PhoneNumber,
+		Method:      send.Method,
+		URL:         send.URL,
+		Payload:     send.Payload,
+		Content:     send.Content,
+		Actor:       send.Actor,
+		Reason:      "retried from the dead letter queue",
+		SendAt:      time.Now(),
+	})
+	return err
+}
+
+// deadLetterVisible returns whether the caller of r may see a dead letter:
+// those of instances of other workspaces are hidden, like their webhooks.
+func (a *App) deadLetterVisible(r *http.Request) func(DeadLetter) bool {
+	workspace := workspaceOf(r)
+	return func(letter DeadLetter) bool {
+		return canSee(workspace, a.instanceWorkspace(letter.IDInstance))
+	}
+}
+
+func (a *App) deadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	visible := a.deadLetterVisible(r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(slices.DeleteFunc(a.deadLetters.list(), func(letter DeadLetter) bool { return !visible(letter) }))
+}
+
+func (a *App) deadLetterRetryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Dead letter id must be a number")
+		return
+	}
+
+	letter, ok := a.deadLetters.take(id, a.deadLetterVisible(r))
+	if !ok {
+		writeErrorf(w, r, http.StatusNotFound, "dead_letter_not_found", "Dead letter %d not found", id)
+		return
+	}
+
+	retry, ok := deadLetterRetryers[letter.Kind]
+	if !ok {
+		a.deadLetters.add(letter)
+		writeErrorf(w, r, http.StatusUnprocessableEntity, "unsupported_dead_letter", "Dead letters of kind %s cannot be retried", letter.Kind)
+		return
+	}
+
+	if err := retry(a, &letter); err != nil {
+		letter.Reason = err.Error()
+		a.deadLetters.add(letter)
+		writeErrorf(w, r, http.StatusBadGateway, "retry_failed", "Retry failed: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"retried": id})
+}
+
+func (a *App) deadLetterDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Dead letter id must be a number")
+		return
+	}
+
+	if _, ok := a.deadLetters.take(id, a.deadLetterVisible(r)); !ok {
+		writeErrorf(w, r, http.StatusNotFound, "dead_letter_not_found", "Dead letter %d not found", id)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) deadLettersPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"purged": a.deadLetters.purge(a.deadLetterVisible(r))})
+}
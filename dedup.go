@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// SeenSet remembers notification keys for a while so redelivered events are
+// processed once.
+type SeenSet struct {
+	store SeenStore
+}
+
+// SeenStore keeps the keys of a SeenSet until they expire. With SQL storage
+// they outlive restarts, so events GREEN-API redelivers after one are still
+// dropped.
+type SeenStore interface {
+	// add records key for ttl and reports whether it wasn't already
+	// recorded.
+	add(key string, ttl time.Duration) (bool, error)
+	removeExpired() error
+}
+
+func newSeenSet() *SeenSet {
+	return &SeenSet{store: newMemorySeen()}
+}
+
+// firstSeenFor records the key for ttl and reports whether it wasn't
+// already recorded. Keys the store fails to record count as new: handling
+// an event twice beats dropping it.
+func (s *SeenSet) firstSeenFor(key string, ttl time.Duration) bool {
+	if ttl <= 0 {
+		// Nothing is remembered
+		return true
+	}
+	first, err := s.store.add(key, ttl)
+	if err != nil {
+		errorf("Failed to record seen key %s: %v", key, err)
+		return true
+	}
+	return first
+}
+
+// runJanitor drops expired keys every interval.
+func (s *SeenSet) runJanitor(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := s.store.removeExpired(); err != nil {
+			errorf("Failed to remove expired seen keys: %v", err)
+		}
+	}
+}
+
+// memorySeen keeps seen keys in a map, so they are forgotten on restart.
+type memorySeen struct {
+	keys *SyncMap[string, struct{}]
+}
+
+func newMemorySeen() *memorySeen {
+	return &memorySeen{keys: newSyncMap[string, struct{}]()}
+}
+
+func (s *memorySeen) add(key string, ttl time.Duration) (bool, error) {
+	return s.keys.add(key, struct{}{}, ttl), nil
+}
+
+func (s *memorySeen) removeExpired() error {
+	s.keys.removeExpired(time.Now())
+	return nil
+}
+
+// notificationKey identifies a notification by its message id, falling back
+// to a hash of the body for events that carry none. Status updates share the
+// idMessage of the message, so the status is part of the key.
+func notificationKey(typeWebhook string, body []byte) string {
+	var ids struct {
+		IDMessage string `json:"idMessage"`
+		Status    string `json:"status"`
+	}
+	if json.Unmarshal(body, &ids) == nil && ids.IDMessage != "" {
+		return typeWebhook + "/" + ids.IDMessage + "/" + ids.Status
+	}
+
+	sum := sha256.Sum256(body)
+	return typeWebhook + "/" + hex.EncodeToString(sum[:])
+}
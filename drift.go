@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// driftTopic is the event hub topic settings drift is published on.
+const driftTopic = "drift"
+
+// readOnlySettings are returned by getSettings but describe the account
+// rather than settings, so snapshots leave them out.
+var readOnlySettings = []string{"wid", "countryInstance", "typeAccount"}
+
+// DesiredSettings are the settings an instance should have. Settings left
+// out aren't checked.
+type DesiredSettings struct {
+	IDInstance string                 `json:"idInstance"`
+	Settings   map[string]interface{} `json:"settings"`
+	UpdatedAt  time.Time              `json:"updatedAt"`
+}
+
+// DesiredSettingsStore keeps desired settings by idInstance.
+type DesiredSettingsStore interface {
+	list() ([]DesiredSettings, error)
+	get(idInstance string) (DesiredSettings, bool, error)
+	put(desired DesiredSettings) error
+	delete(idInstance string) (bool, error)
+}
+
+// DriftReport compares the desired settings of an instance with what
+// getSettings returned. Changes go from the desired value (a) to the
+// actual one (b).
+type DriftReport struct {
+	IDInstance string       `json:"idInstance"`
+	Drifted    bool         `json:"drifted"`
+	Changes    []DiffChange `json:"changes"`
+	CheckedAt  time.Time    `json:"checkedAt"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// DriftDetector checks configured instances against their desired settings,
+// so a setting someone else changed on a shared test instance is noticed.
+type DriftDetector struct {
+	app   *App
+	store DesiredSettingsStore
+
+	mu      sync.Mutex
+	reports map[string]DriftReport
+}
+
+func newDriftDetector() *DriftDetector {
+	return &DriftDetector{store: newMemoryDesiredSettings(), reports: make(map[string]DriftReport)}
+}
+
+// checkAll checks every instance with desired settings. It is the drift
+// job, by default run every -drift-interval.
+func (d *DriftDetector) checkAll(ctx context.Context) error {
+	for _, instance := range d.app.configuredInstances() {
+		desired, ok, err := d.store.get(instance.IDInstance)
+		if err != nil {
+			return fmt.Errorf("failed to read desired settings of instance %s: %w", instance.IDInstance, err)
+		}
+		if ok {
+			d.check(ctx, instance, desired)
+		}
+	}
+	return nil
+}
+
+// check compares the instance's settings with desired and alerts when the
+// drift is new or differs from the last check. A failed getSettings keeps
+// the last verdict.
+func (d *DriftDetector) check(ctx context.Context, instance Instance, desired DesiredSettings) DriftReport {
+	report := DriftReport{IDInstance: instance.IDInstance, Changes: []DiffChange{}, CheckedAt: time.Now()}
+	current, _, err := d.app.makeAPIRequest(ctx, "getSettings", d.app.instanceURL(instance, "getSettings"))
+
+	d.mu.Lock()
+	previous, known := d.reports[instance.IDInstance]
+	if err != nil {
+		report.Drifted, report.Changes = previous.Drifted, previous.Changes
+		report.Error = err.Error()
+		d.reports[instance.IDInstance] = report
+		d.mu.Unlock()
+		return report
+	}
+	actual := make(map[string]interface{}, len(desired.Settings))
+	for key := range desired.Settings {
+		if value, ok := current[key]; ok {
+			actual[key] = value
+		}
+	}
+	if changes := diffJSON("$", desired.Settings, actual); changes != nil {
+		report.Drifted, report.Changes = true, changes
+	}
+	d.reports[instance.IDInstance] = report
+	d.mu.Unlock()
+
+	if report.Drifted && (!known || !reflect.DeepEqual(previous.Changes, report.Changes)) {
+		warnf("Settings of instance %s drifted: %d differ from the desired ones", instance.IDInstance, len(report.Changes))
+		d.app.publishScoped(driftTopic, instance.Workspace, report)
+		d.alert(report)
+	}
+	return report
+}
+
+// alert forwards the drift to the configured forwarder targets.
+func (d *DriftDetector) alert(report DriftReport) {
+	body, err := json.Marshal(map[string]interface{}{
+		"typeWebhook":  "instanceSettingsDrift",
+		"instanceData": map[string]string{"idInstance": report.IDInstance},
+		"changes":      report.Changes,
+		"timestamp":    report.CheckedAt.Unix(),
+	})
+	if err != nil {
+		errorf("Failed to encode drift alert: %v", err)
+		return
+	}
+	d.app.forwarder.forward(Notification{
+		ReceivedAt:  report.CheckedAt,
+		TypeWebhook: "instanceSettingsDrift",
+		Body:        body,
+	})
+}
+
+func (d *DriftDetector) forget(idInstance string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.reports, idInstance)
+}
+
+// driftHandler reports the last drift check of an instance, checking right
+// away when there is none yet or refresh is set.
+func (a *App) driftHandler(w http.ResponseWriter, r *http.Request) {
+	instance, ok := a.pathInstance(w, r)
+	if !ok {
+		return
+	}
+	desired, ok, err := a.drift.store.get(instance.IDInstance)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if !ok {
+		writeErrorf(w, r, http.StatusNotFound, "not_found", "Instance %s has no desired settings", instance.IDInstance)
+		return
+	}
+
+	a.drift.mu.Lock()
+	report, checked := a.drift.reports[instance.IDInstance]
+	a.drift.mu.Unlock()
+	if !checked || r.URL.Query().Get("refresh") == "true" {
+		report = a.drift.check(r.Context(), instance, desired)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// setDesiredSettingsHandler sets the desired settings of an instance from
+// settings, a preset, or with neither from its current settings.
+func (a *App) setDesiredSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	instance, ok := a.pathInstance(w, r)
+	if !ok {
+		return
+	}
+	var requestBody struct {
+		Settings map[string]interface{} `json:"settings"`
+		Preset   string                 `json:"preset"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	settings := requestBody.Settings
+	switch {
+	case requestBody.Preset != "":
+		preset, ok, err := a.lookupPreset(instance.Workspace, requestBody.Preset)
+		if err != nil {
+			writeStorageError(w, r, err)
+			return
+		}
+		if !ok {
+			writeErrorf(w, r, http.StatusNotFound, "not_found", "Preset %s not found", requestBody.Preset)
+			return
+		}
+		settings = preset.Settings
+	case len(settings) == 0:
+		current, _, err := a.makeAPIRequest(r.Context(), "getSettings", a.instanceURL(instance, "getSettings"))
+		if err != nil {
+			writeUpstreamError(w, r, err)
+			return
+		}
+		for _, key := range readOnlySettings {
+			delete(current, key)
+		}
+		settings = current
+	}
+
+	desired := DesiredSettings{IDInstance: instance.IDInstance, Settings: settings, UpdatedAt: time.Now()}
+	if err := a.drift.store.put(desired); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	a.drift.forget(instance.IDInstance)
+	infof("Desired settings of instance %s set by %s", instance.IDInstance, actorName(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(desired)
+}
+
+func (a *App) deleteDesiredSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	instance, ok := a.pathInstance(w, r)
+	if !ok {
+		return
+	}
+	ok, err := a.drift.store.delete(instance.IDInstance)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if !ok {
+		writeErrorf(w, r, http.StatusNotFound, "not_found", "Instance %s has no desired settings", instance.IDInstance)
+		return
+	}
+	a.drift.forget(instance.IDInstance)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) driftStreamHandler(w http.ResponseWriter, r *http.Request) {
+	a.serveScopedEvents(w, r, driftTopic)
+}
+
+// memoryDesiredSettings keeps desired settings in a map, so they are
+// forgotten on restart.
+type memoryDesiredSettings struct {
+	mu      sync.Mutex
+	desired map[string]DesiredSettings
+}
+
+func newMemoryDesiredSettings() *memoryDesiredSettings {
+	return &memoryDesiredSettings{desired: make(map[string]DesiredSettings)}
+}
+
+func (s *memoryDesiredSettings) list() ([]DesiredSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]DesiredSettings, 0, len(s.desired))
+	for _, desired := range s.desired {
+		list = append(list, desired)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].IDInstance < list[j].IDInstance })
+	return list, nil
+}
+
+func (s *memoryDesiredSettings) get(idInstance string) (DesiredSettings, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	desired, ok := s.desired[idInstance]
+	return desired, ok, nil
+}
+
+func (s *memoryDesiredSettings) put(desired DesiredSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.desired[desired.IDInstance] = desired
+	return nil
+}
+
+func (s *memoryDesiredSettings) delete(idInstance string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.desired[idInstance]
+	delete(s.desired, idInstance)
+	return ok, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// mailtoPrefix marks -forward-to targets that are email addresses.
+const mailtoPrefix = "mailto:"
+
+// deadLetterEvent is the event emailed when a forward ends up in the dead
+// letter queue.
+const deadLetterEvent = "deadLetter"
+
+const (
+	defaultEmailSubject = `[grapi] {{.Type}}{{with .IDInstance}} on {{.}}{{end}}{{with .State}}: {{.}}{{end}}`
+	defaultEmailBody    = "{{.Type}} at {{.Time.Format \"2006-01-02 15:04:05 MST\"}}\n\n{{.Body}}\n"
+)
+
+// EmailEvent is what the -email-subject and -email-body templates see.
+type EmailEvent struct {
+	Type       string
+	IDInstance string
+	State      string
+	Time       time.Time
+	// Body is the indented JSON of the notification.
+	Body string
+}
+
+// Mailer emails the events selected by -email-events to the mailto:
+// targets of -forward-to, at most -email-rate an hour so a flapping
+// instance doesn't flood the inbox.
+type Mailer struct {
+	mu         sync.Mutex
+	sent       []time.Time
+	suppressed int
+	// config returns the live config.
+	config func() *Config
+	// deadLetters is the queue whose length dead letter emails report.
+	deadLetters *DeadLetterQueue
+}
+
+// mailTargets returns the addresses among the forwarder targets.
+func mailTargets(targets []string) []string {
+	var addresses []string
+	for _, target := range targets {
+		if address, ok := strings.CutPrefix(target, mailtoPrefix); ok {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// emailEvent reads the parts of a notification the filters and templates
+// use.
+func emailEvent(notification Notification) EmailEvent {
+	event := EmailEvent{Type: notification.TypeWebhook, Time: notification.ReceivedAt}
+	var body struct {
+		InstanceData struct {
+			IDInstance json.Number `json:"idInstance"`
+		} `json:"instanceData"`
+		StateInstance string `json:"stateInstance"`
+	}
+	if json.Unmarshal(notification.Body, &body) == nil {
+		event.IDInstance, event.State = body.InstanceData.IDInstance.String(), body.StateInstance
+	}
+	var indented bytes.Buffer
+	if json.Indent(&indented, notification.Body, "", "  ") == nil {
+		event.Body = indented.String()
+	} else {
+		event.Body = string(notification.Body)
+	}
+	return event
+}
+
+// wanted reports whether an -email-events filter, a type or type:state,
+// selects the event.
+func (e EmailEvent) wanted(filters []string) bool {
+	return slices.ContainsFunc(filters, func(filter string) bool {
+		typ, state, withState := strings.Cut(filter, ":")
+		return typ == e.Type && (!withState || state == e.State)
+	})
+}
+
+// notify emails the notification in the background when it is selected.
+func (m *Mailer) notify(notification Notification) {
+	live := m.config()
+	to := mailTargets(live.ForwardTo)
+	if len(to) == 0 {
+		return
+	}
+	event := emailEvent(notification)
+	if !event.wanted(live.EmailEvents) {
+		return
+	}
+	suppressed, ok := m.allow(time.Now(), live.EmailRate)
+	if !ok {
+		return
+	}
+	go func() {
+		if err := m.send(live, to, event, suppressed); err != nil {
+			errorf("Failed to email %s to %s: %v", event.Type, strings.Join(to, ", "), err)
+		}
+	}()
+}
+
+// deadLetter emails that a forward or a scheduled send failed for good.
+func (m *Mailer) deadLetter(letter DeadLetter) {
+	body, err := json.Marshal(map[string]interface{}{
+		"typeWebhook": deadLetterEvent,
+		"kind":        letter.Kind,
+		"target":      letter.Target,
+		"reason":      letter.Reason,
+		"attempts":    letter.Attempts,
+		"queued":      len(m.deadLetters.list()),
+	})
+	if err != nil {
+		errorf("Failed to encode dead letter email: %v", err)
+		return
+	}
+	m.notify(Notification{ReceivedAt: time.Now(), TypeWebhook: deadLetterEvent, Body: body})
+}
+
+// allow takes one of the emails of the past hour, returning how many were
+// suppressed since the last one went out.
+func (m *Mailer) allow(now time.Time, perHour int) (int, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = slices.DeleteFunc(m.sent, func(at time.Time) bool { return now.Sub(at) >= time.Hour })
+	if perHour > 0 && len(m.sent) >= perHour {
+		if m.suppressed == 0 {
+			warnf("Email rate of %d an hour reached, suppressing further emails", perHour)
+		}
+		m.suppressed++
+		return 0, false
+	}
+	m.sent = append(m.sent, now)
+	suppressed := m.suppressed
+	m.suppressed = 0
+	return suppressed, true
+}
+
+func (m *Mailer) send(live *Config, to []string, event EmailEvent, suppressed int) error {
+	subject, err := renderEmail("subject", live.EmailSubject, event)
+	if err != nil {
+		return err
+	}
+	body, err := renderEmail("body", live.EmailBody, event)
+	if err != nil {
+		return err
+	}
+	if suppressed > 0 {
+		body += fmt.Sprintf("\n%d more events were not emailed to stay within -email-rate.\n", suppressed)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", live.SMTPFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	qp.Write([]byte(body))
+	qp.Close()
+
+	var auth smtp.Auth
+	if live.SMTPUser != "" {
+		host, _, _ := net.SplitHostPort(live.SMTPAddr)
+		auth = smtp.PlainAuth("", live.SMTPUser, live.SMTPPassword, host)
+	}
+	return smtp.SendMail(live.SMTPAddr, auth, live.SMTPFrom, to, msg.Bytes())
+}
+
+func renderEmail(name, text string, event EmailEvent) (string, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, event); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrorResponse is the structured error envelope returned by every /api endpoint.
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Code           string `json:"code"`
+	Message        string `json:"message"`
+	Status         int    `json:"status"`
+	UpstreamStatus int    `json:"upstreamStatus,omitempty"`
+	// RetryAfter is how many seconds to wait before trying again, as
+	// GREEN-API asked or while the server is busy. It is also sent as the
+	// Retry-After header.
+	RetryAfter int `json:"retryAfter,omitempty"`
+}
+
+// UpstreamError is returned when GREEN-API answers with a 4xx/5xx status.
+// RetryAfter is the wait its Retry-After header asked for, if any.
+type UpstreamError struct {
+	Method     string
+	Status     int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("api error %d: %s", e.Status, e.Body)
+}
+
+// TimeoutError is returned when GREEN-API does not answer within the method's budget.
+type TimeoutError struct {
+	Method string
+	Budget time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s exceeded its %s response time budget", e.Method, e.Budget)
+}
+
+// upstreamErrorCodes maps GREEN-API status codes to actionable explanations.
+var upstreamErrorCodes = map[int]struct {
+	code    string
+	message string
+}{
+	http.StatusBadRequest:          {"invalid_parameters", "GREEN-API rejected the request parameters — check the phone number, message and file URL"},
+	http.StatusUnauthorized:        {"instance_not_authorized", "Instance not authorized — scan the QR code in the GREEN-API console or check apiTokenInstance"},
+	http.StatusForbidden:           {"access_denied", "Access denied — check idInstance and apiTokenInstance, the instance may be blocked or expired"},
+	http.StatusTooManyRequests:     {"rate_limited", "Too many requests — GREEN-API rate limit reached, retry later"},
+	466:                            {"quota_exceeded", "Quota exceeded on Developer plan — upgrade the plan or message only allowed numbers"},
+	http.StatusInternalServerError: {"upstream_internal_error", "GREEN-API internal error — retry later or contact support"},
+}
+
+// writeError writes the error envelope with the message translated into the
+// request's language.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeErrorBody(w, ErrorBody{Code: code, Message: translate(negotiateLanguage(r), message), Status: status})
+}
+
+func writeErrorf(w http.ResponseWriter, r *http.Request, status int, code, format string, args ...interface{}) {
+	writeErrorBody(w, ErrorBody{Code: code, Message: translatef(negotiateLanguage(r), format, args...), Status: status})
+}
+
+func writeErrorBody(w http.ResponseWriter, body ErrorBody) {
+	if body.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(body.RetryAfter))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(body.Status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: body})
+}
+
+// writeUpstreamError converts a failed GREEN-API call into the error envelope.
+func writeUpstreamError(w http.ResponseWriter, r *http.Request, err error) {
+	writeErrorBody(w, upstreamErrorBody(r, err))
+}
+
+// writeStorageError reports a failed read or write of the storage backend.
+func writeStorageError(w http.ResponseWriter, r *http.Request, err error) {
+	errorf("Storage failed: %v", err)
+	writeError(w, r, http.StatusInternalServerError, "storage_error", "Storage is unavailable, try again later")
+}
+
+// upstreamErrorBody maps a failed GREEN-API call to the error it is reported as.
+func upstreamErrorBody(r *http.Request, err error) ErrorBody {
+	lang := negotiateLanguage(r)
+
+	if errors.Is(err, errForeignInstance) {
+		return ErrorBody{
+			Code:    "forbidden_instance",
+			Message: translate(lang, "This instance belongs to another workspace"),
+			Status:  http.StatusForbidden,
+		}
+	}
+
+	if errors.Is(err, errUpstreamDown) {
+		return ErrorBody{
+			Code:    "upstream_unavailable",
+			Message: translate(lang, "GREEN-API is temporarily unavailable — retry later"),
+			Status:  http.StatusServiceUnavailable,
+		}
+	}
+
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		warnf("API request timed out: %v", err)
+		return ErrorBody{
+			Code:    "upstream_timeout",
+			Message: translatef(lang, "GREEN-API did not respond to %s within %s", timeoutErr.Method, timeoutErr.Budget),
+			Status:  http.StatusGatewayTimeout,
+		}
+	}
+
+	var upstreamErr *UpstreamError
+	if !errors.As(err, &upstreamErr) {
+		errorf("API request failed: %v", err)
+		return ErrorBody{
+			Code:    "upstream_unreachable",
+			Message: translate(lang, "Failed to communicate with WhatsApp API"),
+			Status:  http.StatusBadGateway,
+		}
+	}
+
+	warnf("%s failed with status %d: %s", upstreamErr.Method, upstreamErr.Status, upstreamErr.Body)
+
+	mapped, ok := upstreamErrorCodes[upstreamErr.Status]
+	switch {
+	case ok:
+		mapped.message = translate(lang, mapped.message)
+	case upstreamErr.Status >= 500:
+		mapped.code, mapped.message = "upstream_unavailable", translate(lang, "GREEN-API is temporarily unavailable — retry later")
+	default:
+		mapped.code, mapped.message = "upstream_error", translatef(lang, "GREEN-API returned status %d", upstreamErr.Status)
+	}
+
+	status := upstreamErr.Status
+	if status >= 500 {
+		status = http.StatusBadGateway
+	}
+
+	return ErrorBody{
+		Code:           mapped.code,
+		Message:        mapped.message,
+		Status:         status,
+		UpstreamStatus: upstreamErr.Status,
+		// Whole seconds, rounded up
+		RetryAfter: int((upstreamErr.RetryAfter + time.Second - 1) / time.Second),
+	}
+}
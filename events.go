@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// EventHub fans out JSON events to Server-Sent Events subscribers by topic.
+type EventHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan []byte]struct{}
+}
+
+func newEventHub() *EventHub {
+	return &EventHub{subscribers: make(map[string]map[chan []byte]struct{})}
+}
+
+func (h *EventHub) subscribe(topic string) (<-chan []byte, func()) {
+	ch := make(chan []byte, 16)
+
+	h.mu.Lock()
+	if h.subscribers[topic] == nil {
+		h.subscribers[topic] = make(map[chan []byte]struct{})
+	}
+	h.subscribers[topic][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subscribers[topic], ch)
+		if len(h.subscribers[topic]) == 0 {
+			delete(h.subscribers, topic)
+		}
+	}
+}
+
+// publish delivers the event to every subscriber of the topic. Slow
+// subscribers miss events rather than blocking the publisher.
+func (h *EventHub) publish(topic string, event interface{}) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		errorf("Failed to encode %s event: %v", topic, err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers[topic] {
+		select {
+		case ch <- data:
+		default:
+		}
+	}
+}
+
+// serveEvents streams a topic to the client until it disconnects.
+func (a *App) serveEvents(w http.ResponseWriter, r *http.Request, topic string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, "streaming_unsupported", "Streaming not supported")
+		return
+	}
+
+	ch, unsubscribe := a.events.subscribe(topic)
+	defer unsubscribe()
+
+	a.stats.streamOpened()
+	defer a.stats.streamClosed()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data := <-ch:
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
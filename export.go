@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// archiveVersion is bumped whenever the archive layout changes incompatibly.
+const archiveVersion = 1
+
+// StateArchive is a portable snapshot of the tool's state that can be
+// imported on another machine. Sections an archive leaves out, such as
+// those of archives exported before they existed, aren't touched by an
+// import.
+type StateArchive struct {
+	Version    int            `json:"version"`
+	ExportedAt time.Time      `json:"exportedAt"`
+	History    []HistoryEntry `json:"history"`
+	// Redacted is set when tokens were replaced with redactedToken.
+	Redacted        bool              `json:"redacted,omitempty"`
+	Instances       []archiveInstance `json:"instances"`
+	Presets         []SettingsPreset  `json:"presets"`
+	DesiredSettings []DesiredSettings `json:"desiredSettings"`
+	CannedReplies   []CannedReply     `json:"cannedReplies"`
+	ChatLabels      []ChatLabels      `json:"chatLabels"`
+	OptOuts         []OptOut          `json:"optOuts"`
+}
+
+// archiveInstance is a managed instance in an archive.
+type archiveInstance struct {
+	IDInstance       string    `json:"idInstance"`
+	APITokenInstance string    `json:"apiTokenInstance"`
+	Workspace        string    `json:"workspace,omitempty"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+}
+
+// redactSettings masks the webhookUrlToken of settings in a copy.
+func redactSettings(settings map[string]interface{}) map[string]interface{} {
+	if token, _ := settings["webhookUrlToken"].(string); token == "" {
+		return settings
+	}
+	settings = maps.Clone(settings)
+	settings["webhookUrlToken"] = redactedToken
+	return settings
+}
+
+// unredactSettings drops a masked webhookUrlToken, so importing redacted
+// settings leaves the token alone rather than set it to the mask.
+func unredactSettings(settings map[string]interface{}) map[string]interface{} {
+	if settings["webhookUrlToken"] != redactedToken {
+		return settings
+	}
+	settings = maps.Clone(settings)
+	delete(settings, "webhookUrlToken")
+	return settings
+}
+
+// exportHandler downloads the state the caller can see. Tokens are left
+// in for admins unless redact=true, and always masked for others.
+func (a *App) exportHandler(w http.ResponseWriter, r *http.Request) {
+	workspace := workspaceOf(r)
+	entries, err := a.history.list(workspace, 0)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	slices.Reverse(entries)
+
+	archive := StateArchive{
+		Version:    archiveVersion,
+		ExportedAt: time.Now(),
+		History:    entries,
+		Redacted:   r.URL.Query().Get("redact") == "true" || !a.holdsRole(r, roleAdmin),
+	}
+	if err := a.exportState(&archive, workspace); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	fileName := fmt.Sprintf("greenapi-state-%s.json", archive.ExportedAt.Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	json.NewEncoder(w).Encode(archive)
+}
+
+// exportState adds everything but the history to archive. Instances, their
+// desired settings, presets, canned replies and chat labels are those of
+// workspace.
+func (a *App) exportState(archive *StateArchive, workspace string) error {
+	archive.Instances = []archiveInstance{}
+	archive.Presets = []SettingsPreset{}
+	archive.DesiredSettings = []DesiredSettings{}
+	archive.CannedReplies = []CannedReply{}
+	archive.ChatLabels = []ChatLabels{}
+	for _, instance := range a.instanceProfiles.list() {
+		if !canSee(workspace, instance.Workspace) {
+			continue
+		}
+		token := instance.APITokenInstance
+		if archive.Redacted {
+			token = redactedToken
+		}
+		archive.Instances = append(archive.Instances, archiveInstance{
+			IDInstance:       instance.IDInstance,
+			APITokenInstance: token,
+			Workspace:        instance.Workspace,
+			CreatedAt:        instance.CreatedAt,
+			UpdatedAt:        instance.UpdatedAt,
+		})
+	}
+	slices.SortFunc(archive.Instances, func(a, b archiveInstance) int {
+		return strings.Compare(a.IDInstance, b.IDInstance)
+	})
+
+	stored, err := a.presets.list()
+	if err != nil {
+		return err
+	}
+	for _, preset := range stored {
+		if !canSee(workspace, preset.Workspace) {
+			continue
+		}
+		if archive.Redacted {
+			preset.Settings = redactSettings(preset.Settings)
+		}
+		archive.Presets = append(archive.Presets, preset)
+	}
+
+	desired, err := a.drift.store.list()
+	if err != nil {
+		return err
+	}
+	for _, d := range desired {
+		if !canSee(workspace, a.instanceWorkspace(d.IDInstance)) {
+			continue
+		}
+		if archive.Redacted {
+			d.Settings = redactSettings(d.Settings)
+		}
+		archive.DesiredSettings = append(archive.DesiredSettings, d)
+	}
+
+	replies, err := a.cannedReplies.list()
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if canSee(workspace, reply.Workspace) {
+			archive.CannedReplies = append(archive.CannedReplies, reply)
+		}
+	}
+
+	labels, err := a.labeler.store.list()
+	if err != nil {
+		return err
+	}
+	for _, l := range labels {
+		if canSee(workspace, a.instanceWorkspace(l.IDInstance)) {
+			archive.ChatLabels = append(archive.ChatLabels, l)
+		}
+	}
+
+	archive.OptOuts = a.optOuts.list()
+	return nil
+}
+
+func (a *App) importHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, a.config.MaxUploadSize)
+
+	var archive StateArchive
+	if err := json.NewDecoder(r.Body).Decode(&archive); err != nil {
+		writeErrorf(w, r, http.StatusBadRequest, "invalid_request", "Invalid archive: %v", err)
+		return
+	}
+
+	if archive.Version != archiveVersion {
+		writeErrorf(w, r, http.StatusUnprocessableEntity, "unsupported_archive_version",
+			"Archive version %d is not supported, expected %d", archive.Version, archiveVersion)
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "merge"
+	}
+	if mode != "merge" && mode != "replace" {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Import mode must be merge or replace")
+		return
+	}
+
+	workspace := workspaceOf(r)
+	imported, err := a.history.restore(workspace, archive.History, mode == "replace")
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	result := stateImport{
+		app:       a,
+		workspace: workspace,
+		replace:   mode == "replace",
+		imported:  map[string]int{"history": imported},
+		skipped:   map[string]int{},
+	}
+	if err := result.restore(archive); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"imported": result.imported,
+		"skipped":  result.skipped,
+		"mode":     mode,
+	})
+}
+
+// stateImport restores the sections of an archive besides the history,
+// counting what it imported and skipped by section.
+type stateImport struct {
+	app       *App
+	workspace string
+	replace   bool
+	imported  map[string]int
+	skipped   map[string]int
+}
+
+func (s *stateImport) restore(archive StateArchive) error {
+	if archive.Instances != nil {
+		if err := s.restoreInstances(archive.Instances); err != nil {
+			return err
+		}
+	}
+	if archive.Presets != nil {
+		if err := s.restorePresets(archive.Presets); err != nil {
+			return err
+		}
+	}
+	if archive.DesiredSettings != nil {
+		if err := s.restoreDesiredSettings(archive.DesiredSettings); err != nil {
+			return err
+		}
+	}
+	if archive.CannedReplies != nil {
+		if err := s.restoreCannedReplies(archive.CannedReplies); err != nil {
+			return err
+		}
+	}
+	if archive.ChatLabels != nil {
+		if err := s.restoreChatLabels(archive.ChatLabels); err != nil {
+			return err
+		}
+	}
+	if archive.OptOuts != nil {
+		entries := slices.DeleteFunc(slices.Clone(archive.OptOuts), func(entry OptOut) bool {
+			return entry.PhoneNumber == ""
+		})
+		if skipped := len(archive.OptOuts) - len(entries); skipped > 0 {
+			s.skipped["optOuts"] = skipped
+		}
+		s.imported["optOuts"] = s.app.optOuts.restore(entries, s.replace)
+	}
+	return nil
+}
+
+// restoreInstances adds managed instances to the caller's workspace. A
+// redacted instance keeps the token it already has here, and is skipped
+// when it has none; instances configured other than through the admin API
+// are skipped too.
+func (s *stateImport) restoreInstances(instances []archiveInstance) error {
+	existing := make(map[string]ManagedInstance)
+	for _, instance := range s.app.instanceProfiles.list() {
+		if !canSee(s.workspace, instance.Workspace) {
+			continue
+		}
+		existing[instance.IDInstance] = instance
+		if s.replace {
+			if _, err := s.app.instanceProfiles.delete(instance.IDInstance); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, imported := range instances {
+		current, managed := existing[imported.IDInstance]
+		if _, ok := s.app.lookupInstance(imported.IDInstance); imported.IDInstance == "" || (ok && !managed) {
+			s.skipped["instances"]++
+			continue
+		}
+		token := imported.APITokenInstance
+		if token == redactedToken {
+			token = current.APITokenInstance
+		}
+		if token == "" {
+			s.skipped["instances"]++
+			continue
+		}
+		instance := ManagedInstance{
+			Instance: Instance{
+				Workspace:        imported.Workspace,
+				IDInstance:       imported.IDInstance,
+				APITokenInstance: token,
+			},
+			CreatedAt: imported.CreatedAt,
+			UpdatedAt: imported.UpdatedAt,
+		}
+		if s.workspace != "" {
+			instance.Workspace = s.workspace
+		}
+		if err := s.app.instanceProfiles.put(instance); err != nil {
+			return err
+		}
+		s.imported["instances"]++
+	}
+	return nil
+}
+
+// restorePresets adds presets to the workspaces they were exported from,
+// or all to the caller's workspace when it has one.
+func (s *stateImport) restorePresets(list []SettingsPreset) error {
+	if s.replace {
+		stored, err := s.app.presets.list()
+		if err != nil {
+			return err
+		}
+		for _, preset := range stored {
+			if !canSee(s.workspace, preset.Workspace) {
+				continue
+			}
+			if _, err := s.app.presets.delete(preset.Workspace, preset.Name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, preset := range list {
+		preset.Settings = unredactSettings(preset.Settings)
+		if preset.BuiltIn || !presetNamePattern.MatchString(preset.Name) || len(preset.Settings) == 0 {
+			s.skipped["presets"]++
+			continue
+		}
+		if s.workspace != "" {
+			preset.Workspace = s.workspace
+		}
+		if err := s.app.presets.put(preset); err != nil {
+			return err
+		}
+		s.imported["presets"]++
+	}
+	return nil
+}
+
+func (s *stateImport) restoreDesiredSettings(list []DesiredSettings) error {
+	if s.replace {
+		stored, err := s.app.drift.store.list()
+		if err != nil {
+			return err
+		}
+		for _, desired := range stored {
+			if !canSee(s.workspace, s.app.instanceWorkspace(desired.IDInstance)) {
+				continue
+			}
+			if _, err := s.app.drift.store.delete(desired.IDInstance); err != nil {
+				return err
+			}
+		}
+	}
+	for _, desired := range list {
+		desired.Settings = unredactSettings(desired.Settings)
+		if desired.IDInstance == "" || len(desired.Settings) == 0 || !canSee(s.workspace, s.app.instanceWorkspace(desired.IDInstance)) {
+			s.skipped["desiredSettings"]++
+			continue
+		}
+		if err := s.app.drift.store.put(desired); err != nil {
+			return err
+		}
+		s.imported["desiredSettings"]++
+	}
+	return nil
+}
+
+// restoreCannedReplies adds canned replies to workspaces like
+// restorePresets.
+func (s *stateImport) restoreCannedReplies(list []CannedReply) error {
+	if s.replace {
+		stored, err := s.app.cannedReplies.list()
+		if err != nil {
+			return err
+		}
+		for _, reply := range stored {
+			if !canSee(s.workspace, reply.Workspace) {
+				continue
+			}
+			if _, err := s.app.cannedReplies.delete(reply.Workspace, reply.Shortcut); err != nil {
+				return err
+			}
+		}
+	}
+	for _, reply := range list {
+		if !presetNamePattern.MatchString(reply.Shortcut) {
+			s.skipped["cannedReplies"]++
+			continue
+		}
+		if _, err := template.New("message").Parse(reply.Text); err != nil {
+			s.skipped["cannedReplies"]++
+			continue
+		}
+		if s.workspace != "" {
+			reply.Workspace = s.workspace
+		}
+		if err := s.app.cannedReplies.put(reply); err != nil {
+			return err
+		}
+		s.imported["cannedReplies"]++
+	}
+	return nil
+}
+
+// restoreChatLabels sets the labels of chats. The -label-rule patterns are
+// flags, so they aren't archived, only the labels they applied.
+func (s *stateImport) restoreChatLabels(list []ChatLabels) error {
+	s.app.labeler.mu.Lock()
+	defer s.app.labeler.mu.Unlock()
+
+	if s.replace {
+		stored, err := s.app.labeler.store.list()
+		if err != nil {
+			return err
+		}
+		for _, labels := range stored {
+			if !canSee(s.workspace, s.app.instanceWorkspace(labels.IDInstance)) {
+				continue
+			}
+			if err := s.app.labeler.store.delete(labels.IDInstance, labels.ChatID); err != nil {
+				return err
+			}
+		}
+	}
+	for _, labels := range list {
+		if labels.IDInstance == "" || labels.ChatID == "" || len(labels.Labels) == 0 || !canSee(s.workspace, s.app.instanceWorkspace(labels.IDInstance)) {
+			s.skipped["chatLabels"]++
+			continue
+		}
+		if err := s.app.labeler.store.put(labels); err != nil {
+			return err
+		}
+		s.imported["chatLabels"]++
+	}
+	return nil
+}
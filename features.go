@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Feature flag names.
+const (
+	featureBroadcast = "broadcast"
+	featureGraphQL   = "graphql"
+	featurePolls     = "polls"
+	featureSchedule  = "schedule"
+)
+
+// FeatureFlag gates a feature that can be switched on and off at runtime.
+type FeatureFlag struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Default     bool       `json:"default"`
+	Enabled     bool       `json:"enabled"`
+	UpdatedAt   *time.Time `json:"updatedAt,omitempty"`
+	UpdatedBy   string     `json:"updatedBy,omitempty"`
+}
+
+// FeatureFlags holds every known flag. New experimental features add their
+// flag here, usually with Default false, and check it with requireFeature.
+type FeatureFlags struct {
+	mu    sync.Mutex
+	flags map[string]*FeatureFlag
+}
+
+// knownFeatures are the flags of a new App, as they are until -features or
+// /api/features switch them.
+var knownFeatures = []FeatureFlag{
+	FeatureFlag{Name: featureBroadcast, Description: "Send one message or file to a list of recipients", Default: true},
+	FeatureFlag{Name: featurePolls, Description: "Poll results and live vote streams", Default: true},
+	FeatureFlag{Name: featureSchedule, Description: "Review and cancel sends held back by quiet hours", Default: true},
+	FeatureFlag{Name: featureGraphQL, Description: "Query chats, messages, contacts, batches and history through /graphql", Default: false},
+}
+
+func newFeatureFlags(flags ...FeatureFlag) *FeatureFlags {
+	f := &FeatureFlags{flags: make(map[string]*FeatureFlag, len(flags))}
+	for _, flag := range flags {
+		flag.Enabled = flag.Default
+		f.flags[flag.Name] = &flag
+	}
+	return f
+}
+
+// featureSettings parses comma-separated name=on|off pairs.
+type featureSettings map[string]bool
+
+func (s featureSettings) String() string {
+	pairs := make([]string, 0, len(s))
+	for name, enabled := range s {
+		pairs = append(pairs, fmt.Sprintf("%s=%t", name, enabled))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (s featureSettings) Set(value string) error {
+	for _, pair := range splitList(value) {
+		name, rawEnabled, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid feature %q, expected name=on or name=off", pair)
+		}
+		var enabled formBool
+		if err := enabled.UnmarshalJSON([]byte(rawEnabled)); err != nil {
+			return fmt.Errorf("invalid feature %q, expected name=on or name=off", pair)
+		}
+		s[strings.TrimSpace(name)] = bool(enabled)
+	}
+	return nil
+}
+
+// configure applies -features over the defaults.
+func (f *FeatureFlags) configure(settings featureSettings) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for name := range settings {
+		if _, ok := f.flags[name]; !ok {
+			return fmt.Errorf("unknown feature %q", name)
+		}
+	}
+	for name, flag := range f.flags {
+		enabled, ok := settings[name]
+		if !ok {
+			enabled = flag.Default
+		}
+		if flag.Enabled != enabled {
+			infof("Feature %s %s by config", name, enabledWord(enabled))
+		}
+		flag.Enabled = enabled
+		flag.UpdatedAt = nil
+		flag.UpdatedBy = ""
+	}
+	return nil
+}
+
+func (f *FeatureFlags) enabled(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	flag, ok := f.flags[name]
+	return ok && flag.Enabled
+}
+
+func (f *FeatureFlags) set(name string, enabled bool, by string) (FeatureFlag, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	flag, ok := f.flags[name]
+	if !ok {
+		return FeatureFlag{}, false
+	}
+	now := time.Now()
+	flag.Enabled = enabled
+	flag.UpdatedAt = &now
+	flag.UpdatedBy = by
+	return *flag, true
+}
+
+func (f *FeatureFlags) list() []FeatureFlag {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	list := make([]FeatureFlag, 0, len(f.flags))
+	for _, flag := range f.flags {
+		list = append(list, *flag)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func enabledWord(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+// checkFeature writes 404 feature_disabled unless the feature is on.
+func (a *App) checkFeature(w http.ResponseWriter, r *http.Request, name string) bool {
+	if a.featureFlags.enabled(name) {
+		return true
+	}
+	writeErrorf(w, r, http.StatusNotFound, "feature_disabled", "The %s feature is disabled", name)
+	return false
+}
+
+// requireFeature wraps a handler so it is only reachable while the feature
+// is on.
+func (a *App) requireFeature(name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.checkFeature(w, r, name) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (a *App) featuresHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.featureFlags.list())
+}
+
+// setFeatureHandler switches a feature on or off until the next restart or
+// config reload.
+func (a *App) setFeatureHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		Enabled *formBool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil || requestBody.Enabled == nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "enabled must be true or false")
+		return
+	}
+
+	name := r.PathValue("name")
+	enabled := bool(*requestBody.Enabled)
+	by := actorOf(r).User
+	if by == "" {
+		by = r.RemoteAddr
+	}
+	flag, ok := a.featureFlags.set(name, enabled, by)
+	if !ok {
+		writeErrorf(w, r, http.StatusNotFound, "feature_not_found", "No feature %s", name)
+		return
+	}
+	infof("Feature %s %s by %s", name, enabledWord(enabled), by)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(flag)
+}
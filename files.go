@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HostedFile is a file uploaded by a tester and served publicly until it
+// expires, so it can be passed to sendFileByUrl.
+type HostedFile struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	URL       string    `json:"url"`
+}
+
+// FileHost stores hosted files on disk and signs their public URLs.
+type FileHost struct {
+	mu     sync.Mutex
+	dir    string
+	secret []byte
+	files  map[string]*HostedFile
+	// ttl is how long links stay valid.
+	ttl time.Duration
+}
+
+func newFileHost(dir string, ttl time.Duration) (*FileHost, error) {
+	if dir == "" {
+		tempDir, err := os.MkdirTemp("", "greenapi-files-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create files dir: %w", err)
+		}
+		dir = tempDir
+	} else if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create files dir: %w", err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	return &FileHost{dir: dir, secret: secret, files: make(map[string]*HostedFile), ttl: ttl}, nil
+}
+
+func (h *FileHost) sign(id, name string, expires int64) string {
+	mac := hmac.New(sha256.New, h.secret)
+	fmt.Fprintf(mac, "%s/%s/%d", id, name, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h *FileHost) store(name string, content io.Reader, baseURL string) (*HostedFile, error) {
+	id := newMediaID()
+
+	file, err := os.Create(filepath.Join(h.dir, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+	size, err := io.Copy(file, content)
+	file.Close()
+	if err != nil {
+		os.Remove(file.Name())
+		return nil, &uploadReadError{err: err}
+	}
+
+	expiresAt := time.Now().Add(h.ttl).Truncate(time.Second)
+	token := h.sign(id, name, expiresAt.Unix())
+	hosted := &HostedFile{
+		ID:        id,
+		Name:      name,
+		Size:      size,
+		ExpiresAt: expiresAt,
+		URL: fmt.Sprintf("%s/files/%s/%s?expires=%d&token=%s",
+			baseURL, id, url.PathEscape(name), expiresAt.Unix(), token),
+	}
+
+	h.mu.Lock()
+	h.files[id] = hosted
+	h.mu.Unlock()
+
+	return hosted, nil
+}
+
+func (h *FileHost) lookup(id string) (*HostedFile, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hosted, ok := h.files[id]
+	if !ok || time.Now().After(hosted.ExpiresAt) {
+		return nil, false
+	}
+	return hosted, true
+}
+
+// removeExpired deletes files whose TTL has passed.
+func (h *FileHost) removeExpired() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	for id, hosted := range h.files {
+		if now.After(hosted.ExpiresAt) {
+			if err := os.Remove(filepath.Join(h.dir, id)); err != nil && !os.IsNotExist(err) {
+				errorf("Failed to remove expired file %s: %v", id, err)
+			}
+			delete(h.files, id)
+		}
+	}
+}
+
+func (h *FileHost) runJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.removeExpired()
+	}
+}
+
+// requestBaseURL builds the absolute URL GREEN-API should use to reach this
+// server, preferring the configured public URL.
+func (a *App) requestBaseURL(r *http.Request) string {
+	if a.config.PublicURL != "" {
+		return a.config.PublicURL
+	}
+	return fmt.Sprintf("%s://%s%s", requestScheme(r), r.Host, a.config.BasePath)
+}
+
+func (a *App) uploadFileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, a.config.MaxUploadSize)
+
+	_, filePart, err := readUploadForm(r)
+	if err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+
+	hosted, err := a.fileHost.store(filepath.Base(filePart.FileName()), filePart, a.requestBaseURL(r))
+	if err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hosted)
+}
+
+// serveFileHandler serves an uploaded file to whoever holds its signed link,
+// usually GREEN-API fetching it for sendFileByUrl. Uploads can be anything,
+// e.g. HTML or SVG, so they are served as downloads the browser won't render
+// or sniff.
+func (a *App) serveFileHandler(w http.ResponseWriter, r *http.Request) {
+	id, name := r.PathValue("id"), r.PathValue("name")
+
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		http.Error(w, translate(negotiateLanguage(r), "Link expired"), http.StatusGone)
+		return
+	}
+
+	expected := a.fileHost.sign(id, name, expires)
+	if !hmac.Equal([]byte(expected), []byte(r.URL.Query().Get("token"))) {
+		http.Error(w, translate(negotiateLanguage(r), "Invalid token"), http.StatusForbidden)
+		return
+	}
+
+	hosted, ok := a.fileHost.lookup(id)
+	if !ok || hosted.Name != name {
+		http.NotFound(w, r)
+		return
+	}
+
+	file, err := os.Open(filepath.Join(a.fileHost.dir, id))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", hosted.Name))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	http.ServeContent(w, r, hosted.Name, time.Time{}, file)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// forwardAttempts is how many times a notification is offered to a target.
+const forwardAttempts = 3
+
+const forwardTimeout = 10 * time.Second
+
+// maxForwardWait is the longest Retry-After of a target a delivery waits
+// for; a longer one sends it to the dead letter queue right away.
+const maxForwardWait = time.Minute
+
+// Forwarder relays received notifications to downstream receivers. When a
+// secret is set every delivery is signed so receivers can authenticate it.
+type Forwarder struct {
+	client *http.Client
+	// config returns the live config.
+	config func() *Config
+	// mailer emails the notifications for mailto: targets, deadLetters
+	// keeps the deliveries that failed.
+	mailer      *Mailer
+	deadLetters *DeadLetterQueue
+}
+
+// signPayload returns the hex HMAC-SHA256 of "timestamp.body". Including the
+// timestamp lets receivers reject replayed deliveries.
+func signPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signRequest sets the timestamp and signature headers of a delivery of
+// body, unless secret is empty.
+func signRequest(req *http.Request, secret string, body []byte) {
+	if secret == "" {
+		return
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signPayload(secret, timestamp, body))
+}
+
+// verifyWebhookSignature checks a webhook forwarded by another grapi
+// signing with -forward-secret, when -webhook-secret is set. Its timestamp
+// must be within -webhook-replay-window of now, and each signature is
+// accepted once.
+func (a *App) verifyWebhookSignature(r *http.Request, body []byte) error {
+	secret, window := a.config.WebhookSecret, a.config.ReplayWindow
+	if secret == "" {
+		return nil
+	}
+	timestamp, signature := r.Header.Get("X-Webhook-Timestamp"), r.Header.Get("X-Webhook-Signature")
+	if timestamp == "" || signature == "" {
+		return errors.New("the webhook isn't signed")
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid X-Webhook-Timestamp")
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > window || age < -window {
+		return errors.New("the timestamp is outside the replay window")
+	}
+	if !hmac.Equal([]byte(signature), []byte("sha256="+signPayload(secret, timestamp, body))) {
+		return errors.New("the signature doesn't match")
+	}
+	// Remembered until the timestamp can't pass the window check anyway
+	if !a.seenSignatures.firstSeenFor(signature, 2*window) {
+		return errors.New("the signature was already used")
+	}
+	return nil
+}
+
+// forward delivers the notification to every configured target in the
+// background. mailto: targets are left to the mailer.
+func (f *Forwarder) forward(notification Notification) {
+	f.mailer.notify(notification)
+	for _, target := range f.config().ForwardTo {
+		if strings.HasPrefix(target, mailtoPrefix) {
+			continue
+		}
+		go func(target string) {
+			if err := f.deliver(target, notification); err != nil {
+				errorf("Failed to forward notification %d to %s: %v", notification.ID, target, err)
+				payload, _ := json.Marshal(notification)
+				letter := DeadLetter{
+					Kind:       deadLetterForward,
+					Target:     target,
+					IDInstance: strconv.FormatInt(notification.IDInstance, 10),
+					Payload:    payload,
+					Reason:     err.Error(),
+					Attempts:   forwardAttempts,
+				}
+				f.deadLetters.add(letter)
+				f.mailer.deadLetter(letter)
+			}
+		}(target)
+	}
+}
+
+// deliver posts the notification body to the target, retrying with
+// exponential backoff on network errors and 5xx responses, or after the
+// Retry-After the target answered with.
+func (f *Forwarder) deliver(target string, notification Notification) error {
+	var err error
+	var wait time.Duration
+	for attempt := 0; attempt < forwardAttempts; attempt++ {
+		if attempt > 0 {
+			if wait == 0 {
+				wait = retryBackoff << (attempt - 1)
+			}
+			time.Sleep(wait)
+		}
+
+		var retry bool
+		retry, wait, err = f.send(target, notification)
+		if err == nil || !retry {
+			return err
+		}
+		if wait > maxForwardWait {
+			return fmt.Errorf("%w, retry asked in %s", err, wait)
+		}
+	}
+	return err
+}
+
+// send makes one delivery. A failure worth retrying comes with the wait
+// the target asked for, if any.
+func (f *Forwarder) send(target string, notification Notification) (retry bool, wait time.Duration, err error) {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(notification.Body))
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", strconv.FormatInt(notification.ID, 10))
+	req.Header.Set("X-Webhook-Type", notification.TypeWebhook)
+
+	// Signed afresh on every attempt, so retries stay within the replay
+	// window of the receiver
+	signRequest(req, f.config().ForwardSecret, notification.Body)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return true, 0, fmt.Errorf("request failed: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests,
+			retryAfter(resp.Header, time.Now()),
+			fmt.Errorf("target returned status %d", resp.StatusCode)
+	}
+	return false, 0, nil
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// update rewrites the golden files from what the handlers answer now:
+//
+//	go test -run TestGolden -update
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// goldenUpstream replaces the address of the mock in golden files, which
+// changes every run.
+const goldenUpstream = "http://greenapi.test"
+
+// volatileFields change every call, so golden files hold a placeholder.
+// Timestamps in RFC 3339 are blanked wherever they are.
+var volatileFields = []string{
+	"processedAt", "requestTime", "timestamp", "duration", "averageLatency", "uptime",
+	// the audit chain hashes the time of each record
+	"hash", "prevHash",
+	"goVersion",
+}
+
+// goldenEnvelope is what a golden file records of a response.
+type goldenEnvelope struct {
+	Status      int             `json:"status"`
+	ContentType string          `json:"contentType"`
+	Body        json.RawMessage `json:"body"`
+}
+
+// goldenBody normalizes a JSON response body for comparison: the mock's
+// address is replaced and volatile fields and timestamps are blanked.
+func goldenBody(t *testing.T, body []byte, upstreamURL string) json.RawMessage {
+	t.Helper()
+	body = bytes.ReplaceAll(body, []byte(upstreamURL), []byte(goldenUpstream))
+	var decoded interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("response is not JSON: %v: %s", err, body)
+	}
+	return encodeGolden(t, blankVolatile(decoded), "")
+}
+
+// blankVolatile replaces volatile fields and timestamps anywhere in v with
+// a placeholder.
+func blankVolatile(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if slices.Contains(volatileFields, key) {
+				v[key] = "volatile"
+			} else {
+				v[key] = blankVolatile(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = blankVolatile(value)
+		}
+	case string:
+		if _, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return "volatile"
+		}
+	}
+	return v
+}
+
+// encodeGolden encodes v indented by indent, leaving <, > and & as they are
+// so snippets stay readable.
+func encodeGolden(t *testing.T, v interface{}, indent string) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", indent)
+	if err := encoder.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	return encoded.Bytes()
+}
+
+// checkGolden compares got with testdata/golden/name.json, or writes it
+// there with -update.
+func checkGolden(t *testing.T, name string, got goldenEnvelope) {
+	t.Helper()
+	encoded := encodeGolden(t, got, "  ")
+
+	path := filepath.Join("testdata", "golden", name+".json")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, encoded, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v, run with -update to create it", err)
+	}
+	if !bytes.Equal(encoded, want) {
+		t.Errorf("response differs from %s, run with -update if the change is intended\ngot:\n%s\nwant:\n%s", path, encoded, want)
+	}
+}
+
+func TestGoldenEnvelopes(t *testing.T) {
+	sendMessage := `{"idInstance":"` + testInstance + `","apiTokenInstance":"` + testToken + `","phoneNumber":"79001234567","messageText":"hi"}`
+	sendFile := `{"idInstance":"` + testInstance + `","apiTokenInstance":"` + testToken + `","phoneNumber":"79001234567","fileUrl":"https://example.com/report.pdf","caption":"report"}`
+	getState := `{"idInstance":"` + testInstance + `","apiTokenInstance":"` + testToken + `"}`
+	uploadFields := map[string]string{"idInstance": testInstance, "apiTokenInstance": testToken, "phoneNumber": "79001234567", "caption": "notes"}
+
+	runGoldenCases(t, []goldenCase{
+		{name: "send-message", req: sendMessageRequest(sendMessage)},
+		{name: "send-message-dry-run", req: sendMessageRequest(strings.TrimSuffix(sendMessage, "}") + `,"dryRun":true}`)},
+		{name: "send-file", req: func() *http.Request { return apiRequest(http.MethodPost, "/api/send-file", sendFile) }},
+		{name: "send-file-upload", req: func() *http.Request { return uploadRequest(uploadFields, "notes.txt", []byte("file contents")) }},
+		{name: "get-state", req: func() *http.Request { return apiRequest(http.MethodPost, "/api/get-state", getState) }},
+		{name: "error-invalid-request", req: sendMessageRequest(`{`)},
+		{name: "error-invalid-phone-number", req: sendMessageRequest(`{"phoneNumber":"7900"}`)},
+		{name: "error-method-not-allowed", req: func() *http.Request { return apiRequest(http.MethodGet, "/api/send-message", "") }},
+		{
+			name: "error-unauthorized",
+			req: func() *http.Request {
+				r := sendMessageRequest(sendMessage)()
+				r.Header.Del("X-API-Key")
+				return r
+			},
+		},
+		{
+			name: "error-forbidden",
+			req: func() *http.Request {
+				r := sendMessageRequest(sendMessage)()
+				r.Header.Set("X-API-Key", testViewerKey)
+				return r
+			},
+		},
+		{
+			name: "error-russian",
+			req: func() *http.Request {
+				r := sendMessageRequest(`{`)()
+				r.Header.Set("Accept-Language", "ru")
+				return r
+			},
+		},
+		{
+			name:  "error-upstream-400",
+			setup: func(m *mockGreenAPI) { m.reply("sendMessage", http.StatusBadRequest, `{"message":"bad chatId"}`) },
+			req:   sendMessageRequest(sendMessage),
+		},
+		{
+			name:  "error-upstream-500",
+			setup: func(m *mockGreenAPI) { m.reply("sendMessage", http.StatusInternalServerError, `{}`) },
+			req:   sendMessageRequest(sendMessage),
+		},
+		{
+			name:  "error-upstream-timeout",
+			args:  []string{"-timeouts", "sendMessage=50ms"},
+			setup: func(m *mockGreenAPI) { m.stall("sendMessage", 2*time.Second) },
+			req:   sendMessageRequest(sendMessage),
+		},
+		{
+			name: "error-file-too-large",
+			args: []string{"-max-upload-size", "64"},
+			req:  func() *http.Request { return uploadRequest(uploadFields, "big.bin", bytes.Repeat([]byte("x"), 1024)) },
+		},
+		{
+			name: "error-feature-disabled",
+			req:  func() *http.Request { return apiRequest(http.MethodGet, "/graphql/schema", "") },
+		},
+	})
+}
+
+// goldenCase is a request whose answer is recorded in testdata/golden.
+type goldenCase struct {
+	name string
+	// args are command line flags on top of newTestApp's.
+	args  []string
+	setup func(m *mockGreenAPI)
+	// seed prepares the App before the request.
+	seed func(a *App)
+	req  func() *http.Request
+}
+
+func runGoldenCases(t *testing.T, cases []goldenCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMockGreenAPI(t)
+			if tc.setup != nil {
+				tc.setup(m)
+			}
+			a := newTestApp(t, m, tc.args...)
+			if tc.seed != nil {
+				tc.seed(a)
+			}
+
+			w := serve(a, tc.req())
+			checkGolden(t, tc.name, goldenEnvelope{
+				Status:      w.Code,
+				ContentType: w.Header().Get("Content-Type"),
+				Body:        goldenBody(t, w.Body.Bytes(), m.URL),
+			})
+		})
+	}
+}
+
+// goldenWebhook is an incoming text message, with a fixed idMessage so the
+// answers listing it don't change between runs.
+const goldenWebhook = `{"typeWebhook":"incomingMessageReceived","instanceData":{"idInstance":1101000001,"wid":"79001234567@c.us","typeInstance":"whatsapp"},` +
+	`"timestamp":1700000000,"idMessage":"BAE5F4886F6F2D09","senderData":{"chatId":"79009876543@c.us","sender":"79009876543@c.us","senderName":"Ivan"},` +
+	`"messageData":{"typeMessage":"textMessage","textMessageData":{"textMessage":"about the invoice"}}}`
+
+// seedRequests returns a seed serving each request in turn, failing the
+// test when one isn't answered with a 2xx status.
+func seedRequests(t *testing.T, reqs ...func() *http.Request) func(a *App) {
+	return func(a *App) {
+		for _, req := range reqs {
+			r := req()
+			if w := serve(a, r); w.Code >= 300 {
+				t.Fatalf("seeding %s %s: status %d: %s", r.Method, r.URL, w.Code, w.Body)
+			}
+		}
+	}
+}
+
+// TestGoldenEndpoints records a success and an error of each JSON endpoint
+// not covered by TestGoldenEnvelopes.
+func TestGoldenEndpoints(t *testing.T) {
+	instanceArgs := []string{"-instances", testInstance + ":" + testToken}
+	credentials := `"idInstance":"` + testInstance + `","apiTokenInstance":"` + testToken + `"`
+	chat := credentials + `,"phoneNumber":"79001234567"`
+	post := func(target, body string) func() *http.Request {
+		return func() *http.Request { return apiRequest(http.MethodPost, target, body) }
+	}
+	get := func(target string) func() *http.Request {
+		return func() *http.Request { return apiRequest(http.MethodGet, target, "") }
+	}
+	put := func(target, body string) func() *http.Request {
+		return func() *http.Request { return apiRequest(http.MethodPut, target, body) }
+	}
+	patch := func(target, body string) func() *http.Request {
+		return func() *http.Request { return apiRequest(http.MethodPatch, target, body) }
+	}
+	del := func(target string) func() *http.Request {
+		return func() *http.Request { return apiRequest(http.MethodDelete, target, "") }
+	}
+	webhook := webhookRequest(testWebhookToken, goldenWebhook)
+	sent := post("/api/send-message", `{`+chat+`,"messageText":"hi"}`)
+	savePreset := put("/api/settings-presets/quiet", `{"keepOnlineStatus":"no"}`)
+	saveReply := put("/api/canned-replies/thanks", `{"text":"Thank you, {{.name}}!"}`)
+	addAttachment := func(a *App) {
+		a.mediaDownloader.store.add(Attachment{
+			IDMessage: "BAE5F4886F6F2D09", IDInstance: testInstance, ChatID: "79009876543@c.us", TypeMessage: "documentMessage",
+			FileName: "invoice.pdf", MimeType: "application/pdf", Size: 2048, Path: "0123456789abcdef", DownloadedAt: time.Now(),
+		})
+	}
+	// receiver is where webhook-test posts to: the mock, whose address is
+	// replaced in golden files
+	var receiver string
+	desiredSettings := put("/api/instances/"+testInstance+"/desired-settings", `{"settings":{"keepOnlineStatus":"no"}}`)
+
+	runGoldenCases(t, []goldenCase{
+		{name: "get-settings", req: post("/api/get-settings", `{`+credentials+`}`)},
+		{name: "get-settings-invalid-request", req: post("/api/get-settings", `{`)},
+		{name: "instance-overview", req: post("/api/instance-overview", `{`+credentials+`}`)},
+		{
+			name:  "instance-overview-upstream-500",
+			setup: func(m *mockGreenAPI) { m.reply("getStateInstance", http.StatusInternalServerError, `{}`) },
+			req:   post("/api/instance-overview", `{`+credentials+`}`),
+		},
+		{name: "preview-message", req: post("/api/preview-message", `{"messageText":"Hi {{.name}}, see https://example.com","variables":{"name":"Ivan"}}`)},
+		{name: "preview-message-invalid-request", req: post("/api/preview-message", `{`)},
+		{
+			name: "send-message-broadcast",
+			args: []string{"-send-interval", "0"},
+			req:  post("/api/send-message", `{`+credentials+`,"phoneNumbers":["79001234567","79007654321"],"messageText":"hi"}`),
+		},
+		{name: "send-voice", req: func() *http.Request {
+			return formRequest("/api/send-voice", map[string]string{"idInstance": testInstance, "apiTokenInstance": testToken, "phoneNumber": "79001234567"}, "voice.ogg", []byte("OggS"))
+		}},
+		{name: "send-voice-invalid-phone-number", req: func() *http.Request {
+			return formRequest("/api/send-voice", map[string]string{"idInstance": testInstance, "apiTokenInstance": testToken, "phoneNumber": "7900"}, "voice.ogg", []byte("OggS"))
+		}},
+		{name: "read-chat", req: post("/api/read-chat", `{`+chat+`}`)},
+		{name: "read-chat-method-not-allowed", req: get("/api/read-chat")},
+		{name: "send-typing", req: post("/api/send-typing", `{`+chat+`,"typingSeconds":"5"}`)},
+		{name: "send-typing-invalid-phone-number", req: post("/api/send-typing", `{`+credentials+`,"phoneNumber":"7900"}`)},
+		{
+			name: "chat-history",
+			setup: func(m *mockGreenAPI) {
+				m.reply("getChatHistory", http.StatusOK, `[{"type":"incoming","idMessage":"BAE5F4886F6F2D09","timestamp":1700000000,"typeMessage":"textMessage","chatId":"79001234567@c.us","textMessage":"hi"}]`)
+			},
+			req: post("/api/chat-history", `{`+chat+`}`),
+		},
+		{name: "chat-history-invalid-phone-number", req: post("/api/chat-history", `{`+credentials+`,"phoneNumber":"7900"}`)},
+		{
+			name: "contacts",
+			setup: func(m *mockGreenAPI) {
+				m.reply("getContacts", http.StatusOK, `[{"id":"79001234567@c.us","name":"Ivan","type":"user"}]`)
+			},
+			req: post("/api/contacts", `{`+credentials+`}`),
+		},
+		{name: "contacts-invalid-request", req: post("/api/contacts", `{`)},
+		{
+			name:  "check-whatsapp-bulk",
+			args:  []string{"-check-interval", "0"},
+			setup: func(m *mockGreenAPI) { m.reply("checkWhatsapp", http.StatusOK, `{"existsWhatsapp":true}`) },
+			req:   post("/api/check-whatsapp/bulk", `{`+credentials+`,"phoneNumbers":["79001234567"]}`),
+		},
+		{name: "check-whatsapp-bulk-invalid-request", req: post("/api/check-whatsapp/bulk", `{`+credentials+`,"phoneNumbers":[]}`)},
+		{
+			name: "group-invite-link",
+			setup: func(m *mockGreenAPI) {
+				m.reply("getGroupData", http.StatusOK, `{"groupId":"120363043968066561@g.us","subject":"Team","owner":"79001234567@c.us","groupInviteLink":"https://chat.whatsapp.com/abc","participants":[{},{}]}`)
+			},
+			req: post("/api/group-invite-link", `{`+credentials+`,"groupId":"120363043968066561@g.us"}`),
+		},
+		{name: "group-invite-link-invalid-request", req: post("/api/group-invite-link", `{`)},
+		{name: "send-reaction", req: post("/api/send-reaction", `{`+chat+`,"idMessage":"BAE5F4886F6F2D09","reaction":"👍"}`)},
+		{name: "send-reaction-missing-message-id", req: post("/api/send-reaction", `{`+chat+`,"reaction":"👍"}`)},
+		{
+			name:  "set-profile-name",
+			setup: func(m *mockGreenAPI) { m.reply("setProfileName", http.StatusOK, `{"setProfileName":true}`) },
+			req:   post("/api/set-profile-name", `{`+credentials+`,"name":"Support"}`),
+		},
+		{name: "set-profile-name-invalid-request", req: post("/api/set-profile-name", `{`)},
+		{
+			name:  "set-profile-picture",
+			setup: func(m *mockGreenAPI) { m.reply("setProfilePicture", http.StatusOK, `{"setProfilePicture":true}`) },
+			req: func() *http.Request {
+				return formRequest("/api/set-profile-picture", map[string]string{"idInstance": testInstance, "apiTokenInstance": testToken}, "me.png", []byte("\x89PNG"))
+			},
+		},
+		{name: "set-profile-picture-unsupported-media-type", req: func() *http.Request {
+			return formRequest("/api/set-profile-picture", map[string]string{"idInstance": testInstance, "apiTokenInstance": testToken}, "me.txt", []byte("hi"))
+		}},
+		{name: "upload-progress-invalid-request", req: get("/api/upload-progress")},
+		{name: "raw", req: rawRequest(testAdminKey, "getSettings", "")},
+		{name: "raw-invalid-method", req: rawRequest(testAdminKey, "getSettings/../reboot", "")},
+		{name: "methods", req: get("/api/methods")},
+		{name: "schema", req: get("/api/schema")},
+		{name: "schema-endpoint", req: get("/api/schema/send-message")},
+		{name: "schema-not-found", req: get("/api/schema/teleport")},
+		{name: "history", seed: seedRequests(t, sent), req: get("/api/history")},
+		{name: "history-invalid-request", req: get("/api/history?limit=many")},
+		{name: "history-entry", seed: seedRequests(t, sent), req: get("/api/history/1")},
+		{name: "history-entry-not-found", req: get("/api/history/9")},
+		{name: "history-diff", seed: seedRequests(t, sent, sent), req: get("/api/history/diff?a=1&b=2")},
+		{name: "history-diff-invalid-request", req: get("/api/history/diff?a=one&b=2")},
+		{name: "history-delete-not-found", req: del("/api/history/9")},
+		{name: "export", seed: seedRequests(t, savePreset), req: get("/api/export")},
+		{name: "import", req: post("/api/import", `{"version":1,"presets":[{"name":"quiet","settings":{"keepOnlineStatus":"no"}}]}`)},
+		{name: "import-unsupported-archive-version", req: post("/api/import", `{"version":99}`)},
+		{name: "attachments", args: []string{"-media-dir", t.TempDir()}, seed: addAttachment, req: get("/api/attachments")},
+		{name: "attachments-invalid-request", req: get("/api/attachments?sort=color")},
+		{name: "attachment-not-found", req: get("/api/attachments/" + testInstance + "/BAE5F4886F6F2D09")},
+		{name: "chats", seed: seedRequests(t, put("/api/chats/"+testInstance+"/79009876543@c.us/labels", `{"labels":["vip"]}`)), req: get("/api/chats")},
+		{name: "chats-invalid-request", req: get("/api/chats?limit=-1")},
+		{name: "search", seed: seedRequests(t, webhook), req: get("/api/search?q=invoice")},
+		{name: "search-invalid-request", req: get("/api/search")},
+		{name: "chat-export-not-found", req: get("/api/chats/79009876543/export")},
+		{name: "chat-labels", req: put("/api/chats/"+testInstance+"/79009876543@c.us/labels", `{"labels":["vip","billing"]}`)},
+		{name: "chat-labels-invalid-label", req: put("/api/chats/"+testInstance+"/79009876543@c.us/labels", `{"labels":["V I P"]}`)},
+		{name: "chat-label-add", req: put("/api/chats/"+testInstance+"/79009876543@c.us/labels/vip", "")},
+		{name: "chat-label-add-invalid-label", req: put("/api/chats/"+testInstance+"/79009876543@c.us/labels/V%20I%20P", "")},
+		{
+			name: "chat-label-remove",
+			seed: seedRequests(t, put("/api/chats/"+testInstance+"/79009876543@c.us/labels", `{"labels":["vip","billing"]}`)),
+			req:  del("/api/chats/" + testInstance + "/79009876543@c.us/labels/vip"),
+		},
+		{name: "chat-notes", req: get("/api/chats/" + testInstance + "/79009876543@c.us/notes")},
+		{name: "chat-notes-update", req: patch("/api/chats/"+testInstance+"/79009876543@c.us/notes", `{"notes":"Prefers email","metadata":{"tier":"gold"}}`)},
+		{name: "chat-notes-update-invalid-request", req: patch("/api/chats/"+testInstance+"/79009876543@c.us/notes", `{"metadata":{"":"x"}}`)},
+		{name: "media-thumb-not-found", req: get("/api/media/0123456789abcdef/thumb")},
+		{name: "stats", seed: seedRequests(t, sent), req: get("/api/stats")},
+		{name: "stats-method-not-allowed", req: post("/api/stats", "")},
+		{name: "stats-volume", seed: seedRequests(t, webhook), req: get("/api/stats/volume")},
+		{name: "graphql", args: []string{"-features", "graphql=on"}, req: post("/graphql", `{"query":"{ __typename }"}`)},
+		{name: "graphql-invalid-request", args: []string{"-features", "graphql=on"}, req: post("/graphql", `{"query":""}`)},
+		{name: "version", req: get("/api/version")},
+		{name: "jobs", req: get("/api/jobs")},
+		{name: "job-run-not-found", req: post("/api/jobs/teleport/run", "")},
+		{name: "slow-requests", req: get("/api/slow-requests")},
+		{name: "webhooks", seed: seedRequests(t, webhook), req: get("/api/webhooks")},
+		{name: "webhooks-invalid-request", req: get("/api/webhooks?limit=many")},
+		{name: "webhooks-anomalies", seed: seedRequests(t, webhook), req: get("/api/webhooks/anomalies")},
+		{name: "webhooks-schema", req: get("/api/webhooks/schema")},
+		{name: "webhook-invalid-request", req: webhookRequest(testWebhookToken, `{`)},
+		{
+			name:  "webhook-test",
+			setup: func(m *mockGreenAPI) { receiver = m.URL + "/hook" },
+			req: func() *http.Request {
+				return post("/api/webhook-test", `{"type":"outgoingMessageStatus","idMessage":"BAE5F4886F6F2D09","url":"`+receiver+`"}`)()
+			},
+		},
+		{name: "webhook-test-invalid-type", req: post("/api/webhook-test", `{"type":"teleport"}`)},
+		{name: "poll-results-not-found", req: get("/api/polls/BAE5F4886F6F2D09/results")},
+		{name: "instances", args: instanceArgs, req: get("/api/instances")},
+		{name: "drift", args: instanceArgs, seed: seedRequests(t, desiredSettings), req: get("/api/instances/" + testInstance + "/drift")},
+		{name: "drift-not-found", args: instanceArgs, req: get("/api/instances/" + testInstance + "/drift")},
+		{name: "desired-settings", args: instanceArgs, req: desiredSettings},
+		{name: "desired-settings-not-found", args: instanceArgs, req: put("/api/instances/"+testInstance+"/desired-settings", `{"preset":"missing"}`)},
+		{name: "desired-settings-delete-not-found", args: instanceArgs, req: del("/api/instances/" + testInstance + "/desired-settings")},
+		{name: "inbox", seed: seedRequests(t, webhook), req: get("/api/inbox")},
+		{name: "inbox-invalid-request", req: get("/api/inbox?limit=many")},
+		{name: "inbox-update", seed: seedRequests(t, webhook), req: patch("/api/inbox/"+testInstance+"/79009876543@c.us", `{"state":"closed"}`)},
+		{name: "inbox-update-invalid-state", req: patch("/api/inbox/"+testInstance+"/79009876543@c.us", `{"state":"snoozed"}`)},
+		{name: "optouts", seed: seedRequests(t, post("/api/optouts", `{"phoneNumber":"79001234567","reason":"asked"}`)), req: get("/api/optouts")},
+		{name: "optout-add", req: post("/api/optouts", `{"phoneNumber":"79001234567","reason":"asked"}`)},
+		{name: "optout-add-invalid-phone-number", req: post("/api/optouts", `{"phoneNumber":"7900"}`)},
+		{name: "optout-remove-not-found", req: del("/api/optouts/79001234567")},
+		{name: "audit", seed: seedRequests(t, sent), req: get("/api/audit")},
+		{name: "audit-invalid-request", req: get("/api/audit?limit=0")},
+		{name: "schedule", seed: scheduleSend, req: get("/api/schedule")},
+		{name: "schedule-cancel", seed: scheduleSend, req: del("/api/schedule/1")},
+		{name: "schedule-cancel-not-found", req: del("/api/schedule/9")},
+		{
+			name: "batch-analytics",
+			args: []string{"-send-interval", "0"},
+			seed: seedRequests(t, post("/api/send-message", `{`+credentials+`,"phoneNumbers":["79001234567","79007654321"],"messageText":"hi"}`)),
+			req:  get("/api/batches/1/analytics"),
+		},
+		{name: "batch-analytics-not-found", req: get("/api/batches/9/analytics")},
+		{
+			name: "batch-links",
+			args: []string{"-send-interval", "0"},
+			seed: seedRequests(t, post("/api/send-message", `{`+credentials+`,"phoneNumbers":["79001234567","79007654321"],"messageText":"hi"}`)),
+			req:  get("/api/batches/1/links"),
+		},
+		{name: "batch-links-not-found", req: get("/api/batches/9/links")},
+		{name: "dlq", seed: sendLetter, req: get("/api/dlq")},
+		{name: "dlq-purge", seed: sendLetter, req: del("/api/dlq")},
+		{name: "dlq-retry", seed: sendLetter, req: post("/api/dlq/1/retry", "")},
+		{name: "dlq-retry-dead-letter-not-found", req: post("/api/dlq/7/retry", "")},
+		{name: "dlq-delete-invalid-request", req: del("/api/dlq/one")},
+		{name: "features", req: get("/api/features")},
+		{name: "feature-set", req: put("/api/features/graphql", `{"enabled":true}`)},
+		{name: "feature-set-not-found", req: put("/api/features/teleport", `{"enabled":true}`)},
+		{name: "canned-replies", seed: seedRequests(t, saveReply), req: get("/api/canned-replies")},
+		{name: "canned-reply-save", req: saveReply},
+		{name: "canned-reply-save-invalid-template", req: put("/api/canned-replies/thanks", `{"text":"{{.name"}`)},
+		{name: "canned-reply-delete-not-found", req: del("/api/canned-replies/thanks")},
+		{name: "canned-reply-send", seed: seedRequests(t, saveReply), req: post("/api/canned-replies/thanks/send", `{`+chat+`,"variables":{"name":"Ivan"}}`)},
+		{name: "canned-reply-send-not-found", req: post("/api/canned-replies/thanks/send", `{`+chat+`}`)},
+		{name: "settings-presets", seed: seedRequests(t, savePreset), req: get("/api/settings-presets")},
+		{name: "settings-preset-save", req: savePreset},
+		{name: "settings-preset-save-invalid-request", req: put("/api/settings-presets/quiet", `{}`)},
+		{name: "settings-preset-delete-not-found", req: del("/api/settings-presets/quiet")},
+		{name: "settings-preset-apply", seed: seedRequests(t, savePreset), req: post("/api/settings-presets/quiet/apply", `{`+credentials+`}`)},
+		{name: "settings-preset-apply-not-found", req: post("/api/settings-presets/quiet/apply", `{`+credentials+`}`)},
+		{name: "admin-instances", args: instanceArgs, req: get("/api/admin/instances")},
+		{name: "admin-instance-create", req: post("/api/admin/instances", `{"idInstance":"1101000003","apiTokenInstance":"managedtoken"}`)},
+		{name: "admin-instance-create-instance-exists", args: instanceArgs, req: post("/api/admin/instances", `{`+credentials+`}`)},
+		{
+			name: "admin-instance-update",
+			seed: seedRequests(t, post("/api/admin/instances", `{"idInstance":"1101000003","apiTokenInstance":"managedtoken"}`)),
+			req:  put("/api/admin/instances/1101000003", `{"apiTokenInstance":"newtoken"}`),
+		},
+		{name: "admin-instance-update-instance-not-managed", args: instanceArgs, req: put("/api/admin/instances/"+testInstance, `{"apiTokenInstance":"x"}`)},
+		{name: "admin-instance-delete-not-found", req: del("/api/admin/instances/1101000009")},
+		{name: "admin-instance-test", args: instanceArgs, req: post("/api/admin/instances/"+testInstance+"/test", "")},
+		{name: "admin-instance-test-not-found", req: post("/api/admin/instances/1101000009/test", "")},
+		{name: "trash", seed: seedRequests(t, savePreset, del("/api/settings-presets/quiet")), req: get("/api/trash")},
+		{name: "trash-invalid-request", req: get("/api/trash?limit=many")},
+		{name: "trash-restore", seed: seedRequests(t, savePreset, del("/api/settings-presets/quiet")), req: post("/api/trash/1/restore", "")},
+		{name: "trash-restore-not-found", req: post("/api/trash/9/restore", "")},
+		{name: "trash-purge-not-found", req: del("/api/trash/9")},
+		{name: "prune", seed: seedRequests(t, sent), req: post("/api/admin/prune", "")},
+		{name: "backup-error", req: get("/api/admin/backup")},
+		{name: "restore-error", req: post("/api/admin/restore", "")},
+	})
+}
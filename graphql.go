@@ -0,0 +1,1039 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// gqlMaxDepth bounds how deep a /graphql query may nest, so one request
+// can't fan out over every chat's every message's chat.
+const gqlMaxDepth = 8
+
+// /graphql runs queries, the read-only part of GraphQL, against a schema of
+// gqlTypes. It knows fields with arguments, aliases, variables, fragments,
+// @skip and @include, and __typename; it doesn't know mutations,
+// subscriptions or introspection, which /graphql/schema stands in for.
+
+// gqlType is an object type of the schema.
+type gqlType struct {
+	name        string
+	description string
+	fields      map[string]*gqlField
+}
+
+// gqlField is a field of an object type. Its type is written as in SDL,
+// e.g. [Chat!]!, and so are its arguments'. Without resolve, the field is
+// read from the source struct by its JSON name.
+type gqlField struct {
+	typ         string
+	description string
+	args        map[string]string
+	resolve     func(rc *gqlRequest, source interface{}, args map[string]interface{}) (interface{}, error)
+}
+
+// gqlSchema is the query type and every object type by name.
+type gqlSchema struct {
+	query *gqlType
+	types map[string]*gqlType
+}
+
+// gqlScalars are the scalar types of the schema. JSON and Time are any JSON
+// value and an RFC 3339 time.
+var gqlScalars = []string{"Boolean", "Float", "ID", "Int", "JSON", "String", "Time"}
+
+// gqlBaseType strips the list and non-null marks of a type.
+func gqlBaseType(typ string) string {
+	return strings.Trim(typ, "[]!")
+}
+
+// gqlError is an entry of the errors of a GraphQL response.
+type gqlError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// gqlObject is a result object, which keeps its fields in query order.
+type gqlObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *gqlObject) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *gqlObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		value, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// Parsing
+
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+type gqlOperation struct {
+	kind       string
+	name       string
+	variables  []gqlVariableDef
+	selections []*gqlSelection
+}
+
+type gqlVariableDef struct {
+	name       string
+	typ        string
+	def        interface{}
+	hasDefault bool
+}
+
+type gqlFragment struct {
+	on         string
+	selections []*gqlSelection
+}
+
+// gqlSelection is a field, a fragment spread (spread is set) or an inline
+// fragment (inline is set, on may be).
+type gqlSelection struct {
+	alias      string
+	name       string
+	args       map[string]interface{}
+	directives map[string]map[string]interface{}
+	selections []*gqlSelection
+	spread     string
+	inline     bool
+	on         string
+}
+
+func (s *gqlSelection) key() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+// gqlVariable is a $variable in a value, filled in when the query runs.
+type gqlVariable string
+
+// gqlEnum is an enum value, which resolvers see as its name.
+type gqlEnum string
+
+type gqlToken struct {
+	kind  byte // 'p'unctuator, 'n'ame, 'i'nt, 'f'loat, 's'tring or 0 at the end
+	value string
+	pos   int
+}
+
+type gqlParser struct {
+	src   string
+	pos   int
+	token gqlToken
+}
+
+func parseGraphQL(src string) (doc *gqlDocument, err error) {
+	p := &gqlParser{src: src}
+	defer func() {
+		// Syntax errors unwind the recursive descent as panics
+		if r := recover(); r != nil {
+			syntax, ok := r.(gqlSyntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = syntax
+		}
+	}()
+	p.next()
+	doc = &gqlDocument{fragments: make(map[string]*gqlFragment)}
+	for p.token.kind != 0 {
+		switch {
+		case p.token.kind == 'p' && p.token.value == "{":
+			doc.operations = append(doc.operations, &gqlOperation{kind: "query", selections: p.selectionSet()})
+		case p.token.kind == 'n' && p.token.value == "fragment":
+			p.next()
+			name := p.name()
+			if p.name() != "on" {
+				p.fail("expected on")
+			}
+			fragment := &gqlFragment{on: p.name()}
+			p.directives()
+			fragment.selections = p.selectionSet()
+			doc.fragments[name] = fragment
+		case p.token.kind == 'n':
+			operation := &gqlOperation{kind: p.name()}
+			if p.token.kind == 'n' {
+				operation.name = p.name()
+			}
+			if p.accept("(") {
+				for !p.accept(")") {
+					p.expect("$")
+					variable := gqlVariableDef{name: p.name()}
+					p.expect(":")
+					variable.typ = p.typeRef()
+					if p.accept("=") {
+						variable.def, variable.hasDefault = p.value(true), true
+					}
+					operation.variables = append(operation.variables, variable)
+				}
+			}
+			p.directives()
+			operation.selections = p.selectionSet()
+			doc.operations = append(doc.operations, operation)
+		default:
+			p.fail("unexpected " + p.token.value)
+		}
+	}
+	return doc, nil
+}
+
+type gqlSyntaxError struct {
+	message string
+	line    int
+	column  int
+}
+
+func (e gqlSyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %d:%d: %s", e.line, e.column, e.message)
+}
+
+func (p *gqlParser) fail(message string) {
+	before := p.src[:p.token.pos]
+	line := strings.Count(before, "\n") + 1
+	column := utf8.RuneCountInString(before[strings.LastIndex(before, "\n")+1:]) + 1
+	panic(gqlSyntaxError{message: message, line: line, column: column})
+}
+
+// next reads the token after the current one, skipping whitespace, commas
+// and comments.
+func (p *gqlParser) next() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		}
+		if strings.HasPrefix(p.src[p.pos:], "\uFEFF") {
+			p.pos += len("\uFEFF")
+			continue
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' && c != ',' {
+			break
+		}
+		p.pos++
+	}
+	start := p.pos
+	p.token = gqlToken{pos: start}
+	if p.pos >= len(p.src) {
+		return
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		p.token.kind, p.token.value = 'p', "..."
+	case strings.ContainsRune("!$():=@[]{}|&", rune(c)):
+		p.pos++
+		p.token.kind, p.token.value = 'p', string(c)
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		for p.pos < len(p.src) && isGraphQLNameByte(p.src[p.pos]) {
+			p.pos++
+		}
+		p.token.kind, p.token.value = 'n', p.src[start:p.pos]
+	case c == '-' || c >= '0' && c <= '9':
+		p.pos++
+		p.token.kind = 'i'
+		for p.pos < len(p.src) {
+			c := p.src[p.pos]
+			switch {
+			case c >= '0' && c <= '9':
+			case c == '.' || c == 'e' || c == 'E' || (c == '+' || c == '-') && p.token.kind == 'f':
+				p.token.kind = 'f'
+			default:
+				p.token.value = p.src[start:p.pos]
+				return
+			}
+			p.pos++
+		}
+		p.token.value = p.src[start:p.pos]
+	case c == '"':
+		p.token.kind = 's'
+		if strings.HasPrefix(p.src[p.pos:], `"""`) {
+			end := strings.Index(p.src[p.pos+3:], `"""`)
+			if end < 0 {
+				p.fail("unterminated string")
+			}
+			p.token.value = strings.TrimSpace(p.src[p.pos+3 : p.pos+3+end])
+			p.pos += end + 6
+			return
+		}
+		p.pos++
+		for p.pos < len(p.src) && p.src[p.pos] != '"' && p.src[p.pos] != '\n' {
+			if p.src[p.pos] == '\\' {
+				p.pos++
+			}
+			p.pos++
+		}
+		if p.pos >= len(p.src) || p.src[p.pos] != '"' {
+			p.fail("unterminated string")
+		}
+		p.pos++
+		value, err := strconv.Unquote(strings.ReplaceAll(p.src[start:p.pos], `\/`, `/`))
+		if err != nil {
+			p.fail("invalid string")
+		}
+		p.token.value = value
+	default:
+		p.fail(fmt.Sprintf("unexpected character %q", c))
+	}
+}
+
+func isGraphQLNameByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// accept skips the punctuator value if it comes next.
+func (p *gqlParser) accept(value string) bool {
+	if p.token.kind == 'p' && p.token.value == value {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *gqlParser) expect(value string) {
+	if !p.accept(value) {
+		p.fail("expected " + value)
+	}
+}
+
+func (p *gqlParser) name() string {
+	if p.token.kind != 'n' {
+		p.fail("expected a name")
+	}
+	name := p.token.value
+	p.next()
+	return name
+}
+
+func (p *gqlParser) typeRef() string {
+	var typ string
+	if p.accept("[") {
+		typ = "[" + p.typeRef() + "]"
+		p.expect("]")
+	} else {
+		typ = p.name()
+	}
+	if p.accept("!") {
+		typ += "!"
+	}
+	return typ
+}
+
+func (p *gqlParser) selectionSet() []*gqlSelection {
+	p.expect("{")
+	var selections []*gqlSelection
+	for !p.accept("}") {
+		if p.token.kind == 0 {
+			p.fail("expected }")
+		}
+		selection := &gqlSelection{}
+		if p.accept("...") {
+			switch {
+			case p.token.kind == 'n' && p.token.value == "on":
+				p.next()
+				selection.inline, selection.on = true, p.name()
+			case p.token.kind == 'n':
+				selection.spread = p.name()
+			default:
+				selection.inline = true
+			}
+			selection.directives = p.directives()
+			if selection.inline {
+				selection.selections = p.selectionSet()
+			}
+			selections = append(selections, selection)
+			continue
+		}
+
+		selection.name = p.name()
+		if p.accept(":") {
+			selection.alias, selection.name = selection.name, p.name()
+		}
+		selection.args = p.arguments()
+		selection.directives = p.directives()
+		if p.token.kind == 'p' && p.token.value == "{" {
+			selection.selections = p.selectionSet()
+		}
+		selections = append(selections, selection)
+	}
+	return selections
+}
+
+func (p *gqlParser) arguments() map[string]interface{} {
+	args := make(map[string]interface{})
+	if !p.accept("(") {
+		return args
+	}
+	for !p.accept(")") {
+		name := p.name()
+		p.expect(":")
+		args[name] = p.value(false)
+	}
+	return args
+}
+
+func (p *gqlParser) directives() map[string]map[string]interface{} {
+	directives := make(map[string]map[string]interface{})
+	for p.accept("@") {
+		name := p.name()
+		directives[name] = p.arguments()
+	}
+	return directives
+}
+
+// value reads a value; constant ones, such as variable defaults, can't hold
+// variables.
+func (p *gqlParser) value(constant bool) interface{} {
+	token := p.token
+	switch token.kind {
+	case 'p':
+		switch {
+		case p.accept("$"):
+			if constant {
+				p.fail("unexpected variable")
+			}
+			return gqlVariable(p.name())
+		case p.accept("["):
+			list := []interface{}{}
+			for !p.accept("]") {
+				if p.token.kind == 0 {
+					p.fail("expected ]")
+				}
+				list = append(list, p.value(constant))
+			}
+			return list
+		case p.accept("{"):
+			object := make(map[string]interface{})
+			for !p.accept("}") {
+				name := p.name()
+				p.expect(":")
+				object[name] = p.value(constant)
+			}
+			return object
+		}
+	case 'i':
+		p.next()
+		n, err := strconv.ParseInt(token.value, 10, 64)
+		if err != nil {
+			p.fail("invalid integer " + token.value)
+		}
+		return n
+	case 'f':
+		p.next()
+		f, err := strconv.ParseFloat(token.value, 64)
+		if err != nil {
+			p.fail("invalid float " + token.value)
+		}
+		return f
+	case 's':
+		p.next()
+		return token.value
+	case 'n':
+		p.next()
+		switch token.value {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return gqlEnum(token.value)
+	}
+	p.fail("expected a value")
+	return nil
+}
+
+// Execution
+
+// gqlRequest is one query being run.
+type gqlRequest struct {
+	r         *http.Request
+	workspace string
+	schema    *gqlSchema
+	doc       *gqlDocument
+	variables map[string]interface{}
+	errors    []gqlError
+}
+
+// validate checks the fields of a selection set against typ before
+// anything runs, so a typo fails the whole query rather than one field.
+func (rc *gqlRequest) validate(typ *gqlType, selections []*gqlSelection, depth int, seen map[string]bool) error {
+	if depth > gqlMaxDepth {
+		return fmt.Errorf("query is nested deeper than %d levels", gqlMaxDepth)
+	}
+	for _, selection := range selections {
+		switch {
+		case selection.spread != "":
+			fragment, ok := rc.doc.fragments[selection.spread]
+			if !ok {
+				return fmt.Errorf("unknown fragment %s", selection.spread)
+			}
+			if seen[selection.spread] {
+				return fmt.Errorf("fragment %s spreads itself", selection.spread)
+			}
+			if rc.schema.types[fragment.on] == nil {
+				return fmt.Errorf("unknown type %s of fragment %s", fragment.on, selection.spread)
+			}
+			seen[selection.spread] = true
+			err := rc.validate(typ, fragment.selections, depth, seen)
+			delete(seen, selection.spread)
+			if err != nil {
+				return err
+			}
+			continue
+		case selection.inline:
+			if selection.on != "" && rc.schema.types[selection.on] == nil {
+				return fmt.Errorf("unknown type %s", selection.on)
+			}
+			if err := rc.validate(typ, selection.selections, depth, seen); err != nil {
+				return err
+			}
+			continue
+		case selection.name == "__typename":
+			if selection.selections != nil {
+				return fmt.Errorf("field __typename of %s has no fields", typ.name)
+			}
+			continue
+		}
+
+		field, ok := typ.fields[selection.name]
+		if !ok {
+			return fmt.Errorf("cannot query field %s on type %s", selection.name, typ.name)
+		}
+		for name := range selection.args {
+			if _, ok := field.args[name]; !ok {
+				return fmt.Errorf("unknown argument %s of field %s.%s", name, typ.name, selection.name)
+			}
+		}
+		for name, argType := range field.args {
+			if _, ok := selection.args[name]; !ok && strings.HasSuffix(argType, "!") {
+				return fmt.Errorf("field %s.%s needs argument %s", typ.name, selection.name, name)
+			}
+		}
+		for name := range selection.directives {
+			if name != "skip" && name != "include" {
+				return fmt.Errorf("unknown directive @%s", name)
+			}
+		}
+
+		fieldType := rc.schema.types[gqlBaseType(field.typ)]
+		switch {
+		case fieldType == nil && selection.selections != nil:
+			return fmt.Errorf("field %s.%s of type %s has no fields", typ.name, selection.name, field.typ)
+		case fieldType != nil && selection.selections == nil:
+			return fmt.Errorf("field %s.%s of type %s needs a selection of fields", typ.name, selection.name, field.typ)
+		case fieldType != nil:
+			if err := rc.validate(fieldType, selection.selections, depth+1, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// gqlCollected is a response key of a selection set with the selections
+// of every field merged into it.
+type gqlCollected struct {
+	key        string
+	field      *gqlSelection
+	selections []*gqlSelection
+}
+
+// collect flattens the fragments of a selection set that apply to typ and
+// drops the fields @skip or @include leave out.
+func (rc *gqlRequest) collect(typ *gqlType, selections []*gqlSelection, into []*gqlCollected) ([]*gqlCollected, error) {
+	for _, selection := range selections {
+		included, err := rc.included(selection)
+		if err != nil {
+			return nil, err
+		}
+		if !included {
+			continue
+		}
+		switch {
+		case selection.spread != "":
+			fragment := rc.doc.fragments[selection.spread]
+			if fragment.on != typ.name {
+				continue
+			}
+			if into, err = rc.collect(typ, fragment.selections, into); err != nil {
+				return nil, err
+			}
+		case selection.inline:
+			if selection.on != "" && selection.on != typ.name {
+				continue
+			}
+			if into, err = rc.collect(typ, selection.selections, into); err != nil {
+				return nil, err
+			}
+		default:
+			i := slices.IndexFunc(into, func(c *gqlCollected) bool { return c.key == selection.key() })
+			if i < 0 {
+				into = append(into, &gqlCollected{key: selection.key(), field: selection})
+				i = len(into) - 1
+			}
+			into[i].selections = append(into[i].selections, selection.selections...)
+		}
+	}
+	return into, nil
+}
+
+func (rc *gqlRequest) included(selection *gqlSelection) (bool, error) {
+	for name, want := range map[string]bool{"skip": false, "include": true} {
+		args, ok := selection.directives[name]
+		if !ok {
+			continue
+		}
+		value, err := rc.coerce("Boolean!", args["if"])
+		if err != nil {
+			return false, fmt.Errorf("@%s(if:): %w", name, err)
+		}
+		if value.(bool) != want {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// object runs a selection set on source as an object of typ.
+func (rc *gqlRequest) object(typ *gqlType, source interface{}, selections []*gqlSelection, path []interface{}) *gqlObject {
+	result := &gqlObject{values: make(map[string]interface{})}
+	fields, err := rc.collect(typ, selections, nil)
+	if err != nil {
+		rc.errors = append(rc.errors, gqlError{Message: err.Error(), Path: path})
+		return result
+	}
+	for _, collected := range fields {
+		fieldPath := append(slices.Clone(path), collected.key)
+		if collected.field.name == "__typename" {
+			result.set(collected.key, typ.name)
+			continue
+		}
+		field := typ.fields[collected.field.name]
+		value, err := rc.resolve(field, source, collected.field)
+		if err != nil {
+			rc.errors = append(rc.errors, gqlError{Message: err.Error(), Path: fieldPath})
+			result.set(collected.key, nil)
+			continue
+		}
+		result.set(collected.key, rc.complete(field.typ, value, collected.selections, fieldPath))
+	}
+	return result
+}
+
+func (rc *gqlRequest) resolve(field *gqlField, source interface{}, selection *gqlSelection) (interface{}, error) {
+	args := make(map[string]interface{}, len(field.args))
+	for name, argType := range field.args {
+		value, err := rc.coerce(argType, selection.args[name])
+		if err != nil {
+			return nil, fmt.Errorf("argument %s: %w", name, err)
+		}
+		args[name] = value
+	}
+	if field.resolve == nil {
+		return jsonField(source, selection.name), nil
+	}
+	return field.resolve(rc, source, args)
+}
+
+// complete turns a resolved value into its result: objects run their
+// selections and lists complete each item.
+func (rc *gqlRequest) complete(typ string, value interface{}, selections []*gqlSelection, path []interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	typ = strings.TrimSuffix(typ, "!")
+	if strings.HasPrefix(typ, "[") {
+		items := reflect.ValueOf(value)
+		if items.Kind() != reflect.Slice {
+			return nil
+		}
+		list := make([]interface{}, items.Len())
+		for i := range list {
+			list[i] = rc.complete(typ[1:len(typ)-1], items.Index(i).Interface(), selections, append(slices.Clone(path), i))
+		}
+		return list
+	}
+	if object := rc.schema.types[typ]; object != nil {
+		if v := reflect.ValueOf(value); v.Kind() == reflect.Pointer && v.IsNil() {
+			return nil
+		}
+		return rc.object(object, value, selections, path)
+	}
+	return gqlScalar(gqlBaseType(typ), value)
+}
+
+// gqlScalar prepares a scalar for the response: IDs are strings, and zero
+// times and empty JSON are null.
+func gqlScalar(typ string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		if v.IsZero() {
+			return nil
+		}
+	case json.RawMessage:
+		if len(v) == 0 {
+			return nil
+		}
+	}
+	if typ == "ID" {
+		return fmt.Sprint(value)
+	}
+	return value
+}
+
+// value fills in the variables of a parsed value.
+func (rc *gqlRequest) value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case gqlVariable:
+		return rc.variables[string(v)]
+	case gqlEnum:
+		return string(v)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = rc.value(item)
+		}
+		return list
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for name, item := range v {
+			object[name] = rc.value(item)
+		}
+		return object
+	}
+	return v
+}
+
+// coerce checks a value against an input type, turning numbers into the
+// int or float64 resolvers expect.
+func (rc *gqlRequest) coerce(typ string, v interface{}) (interface{}, error) {
+	v = rc.value(v)
+	nonNull := strings.HasSuffix(typ, "!")
+	typ = strings.TrimSuffix(typ, "!")
+	if v == nil {
+		if nonNull {
+			return nil, fmt.Errorf("expected a non-null %s", typ)
+		}
+		return nil, nil
+	}
+	if strings.HasPrefix(typ, "[") {
+		items, ok := v.([]interface{})
+		if !ok {
+			items = []interface{}{v}
+		}
+		list := make([]interface{}, len(items))
+		for i, item := range items {
+			var err error
+			if list[i], err = rc.coerce(typ[1:len(typ)-1], item); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+
+	switch typ {
+	case "Int":
+		switch n := v.(type) {
+		case int:
+			return n, nil
+		case int64:
+			if n >= math.MinInt32 && n <= math.MaxInt32 {
+				return int(n), nil
+			}
+		case float64:
+			if n == math.Trunc(n) && n >= math.MinInt32 && n <= math.MaxInt32 {
+				return int(n), nil
+			}
+		}
+	case "Float":
+		switch n := v.(type) {
+		case int:
+			return float64(n), nil
+		case int64:
+			return float64(n), nil
+		case float64:
+			return n, nil
+		}
+	case "Boolean":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case "String", "Time":
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case "ID":
+		switch id := v.(type) {
+		case string:
+			return id, nil
+		case int:
+			return strconv.Itoa(id), nil
+		case int64:
+			return strconv.FormatInt(id, 10), nil
+		case float64:
+			return strconv.FormatFloat(id, 'f', -1, 64), nil
+		}
+	default:
+		return v, nil
+	}
+	return nil, fmt.Errorf("expected %s, got %v", typ, v)
+}
+
+// jsonField reads the field of a struct, or the key of a map, with the
+// given JSON name.
+func jsonField(source interface{}, name string) interface{} {
+	v := reflect.ValueOf(source)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		if item := v.MapIndex(reflect.ValueOf(name)); item.IsValid() {
+			return item.Interface()
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := range t.NumField() {
+			tag, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			if tag == name && t.Field(i).IsExported() {
+				return v.Field(i).Interface()
+			}
+		}
+		for i := range t.NumField() {
+			// Fields of embedded structs
+			if t.Field(i).Anonymous && t.Field(i).IsExported() {
+				if value := jsonField(v.Field(i).Interface(), name); value != nil {
+					return value
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// GraphQLRequest is the body of POST /graphql, or the query string of GET.
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// GraphQLResponse is the answer of /graphql: data, or null when the query
+// couldn't run, and the errors it met.
+type GraphQLResponse struct {
+	Data   *gqlObject `json:"data"`
+	Errors []gqlError `json:"errors,omitempty"`
+}
+
+// execute runs the operation of a request. Errors of the query itself are
+// returned; those of fields are reported with the data.
+func (s *gqlSchema) execute(r *http.Request, request GraphQLRequest) (GraphQLResponse, error) {
+	doc, err := parseGraphQL(request.Query)
+	if err != nil {
+		return GraphQLResponse{}, err
+	}
+	var operation *gqlOperation
+	for _, candidate := range doc.operations {
+		if request.OperationName == "" || candidate.name == request.OperationName {
+			if operation != nil {
+				return GraphQLResponse{}, fmt.Errorf("the document has several operations, choose one with operationName")
+			}
+			operation = candidate
+		}
+	}
+	switch {
+	case operation == nil && request.OperationName != "":
+		return GraphQLResponse{}, fmt.Errorf("unknown operation %s", request.OperationName)
+	case operation == nil:
+		return GraphQLResponse{}, fmt.Errorf("the document has no operation")
+	case operation.kind != "query":
+		return GraphQLResponse{}, fmt.Errorf("only queries are supported, not %s", operation.kind)
+	}
+
+	rc := &gqlRequest{r: r, workspace: workspaceOf(r), schema: s, doc: doc, variables: make(map[string]interface{})}
+	for _, variable := range operation.variables {
+		value, ok := request.Variables[variable.name]
+		if !ok && variable.hasDefault {
+			value = variable.def
+		}
+		coerced, err := rc.coerce(variable.typ, value)
+		if err != nil {
+			return GraphQLResponse{}, fmt.Errorf("variable $%s: %w", variable.name, err)
+		}
+		rc.variables[variable.name] = coerced
+	}
+	if err := rc.validate(s.query, operation.selections, 1, make(map[string]bool)); err != nil {
+		return GraphQLResponse{}, err
+	}
+
+	data := rc.object(s.query, nil, operation.selections, nil)
+	return GraphQLResponse{Data: data, Errors: rc.errors}, nil
+}
+
+// sdl writes the schema in the GraphQL schema language.
+func (s *gqlSchema) sdl() string {
+	var b strings.Builder
+	for _, scalar := range gqlScalars {
+		if !slices.Contains([]string{"Boolean", "Float", "ID", "Int", "String"}, scalar) {
+			fmt.Fprintf(&b, "scalar %s\n\n", scalar)
+		}
+	}
+	names := make([]string, 0, len(s.types))
+	for name := range s.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// Query comes first, the rest by name
+	slices.SortStableFunc(names, func(a, b string) int {
+		switch {
+		case a == s.query.name:
+			return -1
+		case b == s.query.name:
+			return 1
+		}
+		return 0
+	})
+	for _, name := range names {
+		typ := s.types[name]
+		if typ.description != "" {
+			fmt.Fprintf(&b, "\"\"\"%s\"\"\"\n", typ.description)
+		}
+		fmt.Fprintf(&b, "type %s {\n", name)
+		fields := make([]string, 0, len(typ.fields))
+		for field := range typ.fields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, name := range fields {
+			field := typ.fields[name]
+			if field.description != "" {
+				fmt.Fprintf(&b, "  \"%s\"\n", field.description)
+			}
+			b.WriteString("  " + name)
+			if len(field.args) > 0 {
+				args := make([]string, 0, len(field.args))
+				for arg, argType := range field.args {
+					args = append(args, arg+": "+argType)
+				}
+				sort.Strings(args)
+				b.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			b.WriteString(": " + field.typ + "\n")
+		}
+		b.WriteString("}\n\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// graphqlHandler runs a GraphQL query over the local data, sent as JSON by
+// POST or in the query string by GET.
+func (a *App) graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var request GraphQLRequest
+	switch r.Method {
+	case http.MethodGet:
+		request.Query = r.URL.Query().Get("query")
+		request.OperationName = r.URL.Query().Get("operationName")
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid variables")
+				return
+			}
+		}
+	default:
+		decoder := json.NewDecoder(r.Body)
+		decoder.UseNumber()
+		if err := decoder.Decode(&request); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+			return
+		}
+		request.Variables = jsonNumbers(request.Variables).(map[string]interface{})
+	}
+	if strings.TrimSpace(request.Query) == "" {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Query is required")
+		return
+	}
+
+	response, err := a.graphqlSchema.execute(r, request)
+	status := http.StatusOK
+	if err != nil {
+		response, status = GraphQLResponse{Errors: []gqlError{{Message: err.Error()}}}, http.StatusBadRequest
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// jsonNumbers turns the json.Numbers of decoded variables into int64 or
+// float64, as the parser reads literals.
+func jsonNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonNumbers(v[i])
+		}
+	case map[string]interface{}:
+		for name := range v {
+			v[name] = jsonNumbers(v[name])
+		}
+	}
+	return v
+}
+
+// graphqlSchemaHandler describes what /graphql can query, in place of
+// introspection.
+func (a *App) graphqlSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, a.graphqlSchema.sdl())
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// gqlChat is a chat of an instance, whose fields are looked up in the
+// stores keeping labels, notes, conversations and messages.
+type gqlChat struct {
+	IDInstance string `json:"idInstance"`
+	ChatID     string `json:"chatId"`
+}
+
+// gqlContact is a contact shared in a message.
+type gqlContact struct {
+	SharedContact
+	message Notification
+}
+
+// gqlPaged adds first and offset to the arguments of a list field.
+func gqlPaged(args map[string]string) map[string]string {
+	if args == nil {
+		args = make(map[string]string)
+	}
+	args["first"] = "Int"
+	args["offset"] = "Int"
+	return args
+}
+
+// gqlPaging reads first, defaultPageSize without it, and offset.
+func gqlPaging(args map[string]interface{}) (first, offset int, err error) {
+	first = defaultPageSize
+	if n, ok := args["first"].(int); ok {
+		first = n
+	}
+	if n, ok := args["offset"].(int); ok {
+		offset = n
+	}
+	if first < 0 || first > maxPageSize {
+		return 0, 0, fmt.Errorf("first must be between 0 and %d", maxPageSize)
+	}
+	if offset < 0 {
+		return 0, 0, fmt.Errorf("offset must not be negative")
+	}
+	return first, offset, nil
+}
+
+// gqlPage cuts the page of a list picked by first and offset.
+func gqlPage[T any](list []T, args map[string]interface{}) ([]T, error) {
+	first, offset, err := gqlPaging(args)
+	if err != nil {
+		return nil, err
+	}
+	start := min(offset, len(list))
+	end := min(start+first, len(list))
+	return list[start:end], nil
+}
+
+// gqlString reads an optional String or ID argument, "" without it.
+func gqlString(args map[string]interface{}, name string) string {
+	s, _ := args[name].(string)
+	return s
+}
+
+// gqlChatID lets a bare phone number stand for its personal chat, as in
+// the REST paths.
+func gqlChatID(chatId string) string {
+	if chatId != "" && !strings.Contains(chatId, "@") {
+		chatId += "@c.us"
+	}
+	return chatId
+}
+
+// gqlMessages lists the messages kept in memory the caller may see,
+// newest first, keeping those matching every filter given.
+func (a *App) gqlMessages(rc *gqlRequest, idInstance, chatId, typeWebhook string) []Notification {
+	return slices.DeleteFunc(a.notifications.list(rc.workspace), func(n Notification) bool {
+		switch {
+		case !isMessage(n):
+			return true
+		case idInstance != "" && strconv.FormatInt(n.IDInstance, 10) != idInstance:
+			return true
+		case chatId != "" && n.ChatID != chatId:
+			return true
+		default:
+			return typeWebhook != "" && n.TypeWebhook != typeWebhook
+		}
+	})
+}
+
+// gqlChats lists the chats known from conversations, labels and messages
+// kept in memory, by instance and chat.
+func (a *App) gqlChats(rc *gqlRequest, idInstance string) ([]gqlChat, error) {
+	seen := make(map[gqlChat]bool)
+	add := func(chat gqlChat) {
+		if (idInstance == "" || chat.IDInstance == idInstance) && canSee(rc.workspace, a.instanceWorkspace(chat.IDInstance)) {
+			seen[chat] = true
+		}
+	}
+	conversations, err := a.inbox.store.list()
+	if err != nil {
+		return nil, err
+	}
+	for _, conversation := range conversations {
+		add(gqlChat{conversation.IDInstance, conversation.ChatID})
+	}
+	labelled, err := a.labeler.store.list()
+	if err != nil {
+		return nil, err
+	}
+	for _, labels := range labelled {
+		add(gqlChat{labels.IDInstance, labels.ChatID})
+	}
+	for _, message := range a.gqlMessages(rc, idInstance, "", "") {
+		add(gqlChat{strconv.FormatInt(message.IDInstance, 10), message.ChatID})
+	}
+
+	chats := make([]gqlChat, 0, len(seen))
+	for chat := range seen {
+		chats = append(chats, chat)
+	}
+	slices.SortFunc(chats, func(a, b gqlChat) int {
+		return strings.Compare(a.IDInstance+" "+a.ChatID, b.IDInstance+" "+b.ChatID)
+	})
+	return chats, nil
+}
+
+func (a *App) chatLabels(chat gqlChat) ([]string, error) {
+	labels, ok, err := a.labeler.store.get(chat.IDInstance, chat.ChatID)
+	if err != nil || !ok {
+		return []string{}, err
+	}
+	return labels.Labels, nil
+}
+
+// gqlBatch is the analytics of a tracked broadcast the caller may see.
+func (a *App) gqlBatch(rc *gqlRequest, id int64) (*BatchAnalytics, error) {
+	analytics, ok := a.batches.analytics(id)
+	if !ok || !canSee(rc.workspace, a.instanceWorkspace(analytics.IDInstance)) {
+		return nil, nil
+	}
+	return &analytics, nil
+}
+
+func (a *App) newGraphQLSchema() *gqlSchema {
+	query := &gqlType{name: "Query", fields: map[string]*gqlField{
+		"chats": {
+			typ:         "[Chat!]!",
+			description: "Chats known from the inbox, labels and recent messages; label keeps those with a label.",
+			args:        gqlPaged(map[string]string{"idInstance": "ID", "label": "String"}),
+			resolve: func(rc *gqlRequest, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				chats, err := a.gqlChats(rc, gqlString(args, "idInstance"))
+				if err != nil {
+					return nil, err
+				}
+				if label := gqlString(args, "label"); label != "" {
+					var labelled []gqlChat
+					for _, chat := range chats {
+						labels, err := a.chatLabels(chat)
+						if err != nil {
+							return nil, err
+						}
+						if slices.Contains(labels, label) {
+							labelled = append(labelled, chat)
+						}
+					}
+					chats = labelled
+				}
+				return gqlPage(chats, args)
+			},
+		},
+		"chat": {
+			typ:  "Chat",
+			args: map[string]string{"idInstance": "ID!", "chatId": "String!"},
+			resolve: func(rc *gqlRequest, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				idInstance := gqlString(args, "idInstance")
+				if !canSee(rc.workspace, a.instanceWorkspace(idInstance)) {
+					return nil, nil
+				}
+				return gqlChat{IDInstance: idInstance, ChatID: gqlChatID(gqlString(args, "chatId"))}, nil
+			},
+		},
+		"messages": {
+			typ:         "[Message!]!",
+			description: "Messages of the webhooks kept in memory, newest first.",
+			args:        gqlPaged(map[string]string{"idInstance": "ID", "chatId": "String", "typeWebhook": "String"}),
+			resolve: func(rc *gqlRequest, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				messages := a.gqlMessages(rc, gqlString(args, "idInstance"), gqlChatID(gqlString(args, "chatId")), gqlString(args, "typeWebhook"))
+				return gqlPage(messages, args)
+			},
+		},
+		"search": {
+			typ:         "[SearchHit!]!",
+			description: "Messages found by their text, best matches first, as by /api/search.",
+			args:        gqlPaged(map[string]string{"q": "String!", "idInstance": "ID", "chatId": "String"}),
+			resolve: func(rc *gqlRequest, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				terms := searchTerms(gqlString(args, "q"))
+				if len(terms) == 0 {
+					return nil, fmt.Errorf("q has no terms to search for")
+				}
+				first, offset, err := gqlPaging(args)
+				if err != nil {
+					return nil, err
+				}
+				hits, _, err := a.messageIndex.search(SearchQuery{
+					Terms:      terms,
+					Workspace:  rc.workspace,
+					IDInstance: gqlString(args, "idInstance"),
+					ChatID:     gqlChatID(gqlString(args, "chatId")),
+					Offset:     offset,
+					Limit:      first,
+				})
+				return hits, err
+			},
+		},
+		"contacts": {
+			typ:         "[SharedContact!]!",
+			description: "Contacts shared in the messages kept in memory, newest first.",
+			args:        gqlPaged(map[string]string{"idInstance": "ID", "chatId": "String"}),
+			resolve: func(rc *gqlRequest, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				var contacts []gqlContact
+				for _, message := range a.gqlMessages(rc, gqlString(args, "idInstance"), gqlChatID(gqlString(args, "chatId")), "") {
+					for _, contact := range message.Contacts {
+						contacts = append(contacts, gqlContact{contact, message})
+					}
+				}
+				return gqlPage(contacts, args)
+			},
+		},
+		"batches": {
+			typ:         "[Batch!]!",
+			description: "Recent broadcasts, newest first.",
+			args:        gqlPaged(nil),
+			resolve: func(rc *gqlRequest, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				var list []*BatchAnalytics
+				for _, id := range a.batches.ids() {
+					if batch, _ := a.gqlBatch(rc, id); batch != nil {
+						list = append(list, batch)
+					}
+				}
+				return gqlPage(list, args)
+			},
+		},
+		"batch": {
+			typ:  "Batch",
+			args: map[string]string{"id": "ID!"},
+			resolve: func(rc *gqlRequest, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				id, err := strconv.ParseInt(gqlString(args, "id"), 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid batch ID")
+				}
+				return a.gqlBatch(rc, id)
+			},
+		},
+		"history": {
+			typ:         "[HistoryEntry!]!",
+			description: "Calls made to GREEN-API, newest first; method keeps those of one API method.",
+			args:        gqlPaged(map[string]string{"method": "String"}),
+			resolve: func(rc *gqlRequest, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				entries, err := a.history.list(rc.workspace, 0)
+				if err != nil {
+					return nil, err
+				}
+				if method := gqlString(args, "method"); method != "" {
+					entries = slices.DeleteFunc(entries, func(e HistoryEntry) bool { return e.Method != method })
+				}
+				return gqlPage(entries, args)
+			},
+		},
+	}}
+
+	chat := &gqlType{name: "Chat", fields: map[string]*gqlField{
+		"idInstance": {typ: "ID!"},
+		"chatId":     {typ: "String!"},
+		"labels": {
+			typ: "[String!]!",
+			resolve: func(_ *gqlRequest, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				return a.chatLabels(source.(gqlChat))
+			},
+		},
+		"notes": {
+			typ: "String!",
+			resolve: func(_ *gqlRequest, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				chat := source.(gqlChat)
+				notes, err := a.chatNotes.get(chat.IDInstance, chat.ChatID)
+				return notes.Notes, err
+			},
+		},
+		"metadata": {
+			typ: "JSON!",
+			resolve: func(_ *gqlRequest, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				chat := source.(gqlChat)
+				notes, err := a.chatNotes.get(chat.IDInstance, chat.ChatID)
+				return notes.Metadata, err
+			},
+		},
+		"conversation": {
+			typ:         "Conversation",
+			description: "The inbox conversation of the chat, null until a message comes in.",
+			resolve: func(_ *gqlRequest, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				chat := source.(gqlChat)
+				conversation, ok, err := a.inbox.store.get(chat.IDInstance, chat.ChatID)
+				if err != nil || !ok {
+					return nil, err
+				}
+				return &conversation, nil
+			},
+		},
+		"messages": {
+			typ:  "[Message!]!",
+			args: gqlPaged(map[string]string{"typeWebhook": "String"}),
+			resolve: func(rc *gqlRequest, source interface{}, args map[string]interface{}) (interface{}, error) {
+				chat := source.(gqlChat)
+				return gqlPage(a.gqlMessages(rc, chat.IDInstance, chat.ChatID, gqlString(args, "typeWebhook")), args)
+			},
+		},
+	}}
+
+	message := &gqlType{name: "Message", fields: map[string]*gqlField{
+		"id":          {typ: "ID!"},
+		"receivedAt":  {typ: "Time!"},
+		"typeWebhook": {typ: "String!"},
+		"idInstance":  {typ: "ID!"},
+		"chatId":      {typ: "String!"},
+		"idMessage":   {typ: "String"},
+		"typeMessage": {typ: "String"},
+		"text":        {typ: "String"},
+		"location":    {typ: "JSON"},
+		"file":        {typ: "JSON"},
+		"quoted":      {typ: "JSON"},
+		"anomalies":   {typ: "[String!]"},
+		"body":        {typ: "JSON"},
+		"direction": {
+			typ:         "String!",
+			description: "incoming or outgoing.",
+			resolve: func(_ *gqlRequest, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				return messageDirection(source.(Notification).TypeWebhook), nil
+			},
+		},
+		"chat": {
+			typ: "Chat!",
+			resolve: func(_ *gqlRequest, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				n := source.(Notification)
+				return gqlChat{IDInstance: strconv.FormatInt(n.IDInstance, 10), ChatID: n.ChatID}, nil
+			},
+		},
+		"contacts": {
+			typ: "[SharedContact!]!",
+			resolve: func(_ *gqlRequest, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				n := source.(Notification)
+				contacts := make([]gqlContact, 0, len(n.Contacts))
+				for _, contact := range n.Contacts {
+					contacts = append(contacts, gqlContact{contact, n})
+				}
+				return contacts, nil
+			},
+		},
+	}}
+
+	contact := &gqlType{name: "SharedContact", fields: map[string]*gqlField{
+		"displayName": {typ: "String!"},
+		"phones":      {typ: "[String!]!"},
+		"vcard":       {typ: "String!"},
+		"message": {
+			typ:         "Message!",
+			description: "The message the contact was shared in.",
+			resolve: func(_ *gqlRequest, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				return source.(gqlContact).message, nil
+			},
+		},
+	}}
+
+	conversation := &gqlType{name: "Conversation", fields: map[string]*gqlField{
+		"idInstance":    {typ: "ID!"},
+		"chatId":        {typ: "String!"},
+		"state":         {typ: "String!"},
+		"assignee":      {typ: "String"},
+		"lastMessage":   {typ: "String"},
+		"lastMessageAt": {typ: "Time"},
+		"updatedAt":     {typ: "Time"},
+		"updatedBy":     {typ: "String"},
+	}}
+
+	searchHit := &gqlType{name: "SearchHit", fields: map[string]*gqlField{
+		"idInstance":  {typ: "ID!"},
+		"chatId":      {typ: "String!"},
+		"idMessage":   {typ: "String"},
+		"typeWebhook": {typ: "String!"},
+		"time":        {typ: "Time!"},
+		"snippet":     {typ: "String!", description: "The HTML-escaped text around the terms, which are marked with <b></b>."},
+		"rank":        {typ: "Float!"},
+		"chat": {
+			typ: "Chat!",
+			resolve: func(_ *gqlRequest, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				hit := source.(SearchHit)
+				return gqlChat{IDInstance: hit.IDInstance, ChatID: hit.ChatID}, nil
+			},
+		},
+	}}
+
+	batch := &gqlType{name: "Batch", fields: map[string]*gqlField{
+		"id":            {typ: "ID!"},
+		"idInstance":    {typ: "ID!"},
+		"method":        {typ: "String!"},
+		"createdAt":     {typ: "Time!"},
+		"recipients":    {typ: "Int!"},
+		"sent":          {typ: "Int!"},
+		"delivered":     {typ: "Int!"},
+		"read":          {typ: "Int!"},
+		"failed":        {typ: "Int!"},
+		"pending":       {typ: "Int!"},
+		"deliveryRate":  {typ: "Float!"},
+		"readRate":      {typ: "Float!"},
+		"timeToDeliver": {typ: "TimingSummary!"},
+		"timeToRead":    {typ: "TimingSummary!"},
+		"links": {
+			typ:         "[ShortLink!]!",
+			description: "The short links the messages of the batch were sent with.",
+			resolve: func(_ *gqlRequest, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				_, links, _ := a.batches.links(source.(*BatchAnalytics).ID)
+				return links, nil
+			},
+		},
+	}}
+
+	timings := &gqlType{name: "TimingSummary", fields: map[string]*gqlField{
+		"p50": {typ: "String"},
+		"p90": {typ: "String"},
+		"p99": {typ: "String"},
+		"max": {typ: "String"},
+	}}
+
+	link := &gqlType{name: "ShortLink", fields: map[string]*gqlField{
+		"longUrl":  {typ: "String!"},
+		"shortUrl": {typ: "String!"},
+		"clicks":   {typ: "Int"},
+	}}
+
+	historyEntry := &gqlType{name: "HistoryEntry", fields: map[string]*gqlField{
+		"id":                {typ: "ID!"},
+		"time":              {typ: "Time!"},
+		"method":            {typ: "String!"},
+		"httpMethod":        {typ: "String!"},
+		"url":               {typ: "String!"},
+		"status":            {typ: "Int!"},
+		"duration":          {typ: "String!"},
+		"error":             {typ: "String"},
+		"request":           {typ: "JSON"},
+		"requestSize":       {typ: "Int"},
+		"requestTruncated":  {typ: "Boolean"},
+		"response":          {typ: "JSON"},
+		"responseSize":      {typ: "Int"},
+		"responseTruncated": {typ: "Boolean"},
+	}}
+
+	schema := &gqlSchema{query: query, types: make(map[string]*gqlType)}
+	for _, typ := range []*gqlType{query, chat, message, contact, conversation, searchHit, batch, timings, link, historyEntry} {
+		schema.types[typ.name] = typ
+	}
+	return schema
+}
@@ -0,0 +1,1286 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// handlerCase is a request to the App and what it should answer.
+type handlerCase struct {
+	name string
+	// args are command line flags on top of newTestApp's.
+	args  []string
+	setup func(m *mockGreenAPI)
+	// seed prepares the App before the request.
+	seed func(a *App)
+	req  func() *http.Request
+	// status is the expected status and code the error code, empty when
+	// the request succeeds.
+	status int
+	code   string
+	check  func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder)
+}
+
+func runHandlerCases(t *testing.T, cases []handlerCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMockGreenAPI(t)
+			if tc.setup != nil {
+				tc.setup(m)
+			}
+			a := newTestApp(t, m, tc.args...)
+			if tc.seed != nil {
+				tc.seed(a)
+			}
+
+			w := serve(a, tc.req())
+			if w.Code != tc.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tc.status, w.Body)
+			}
+			if tc.code != "" {
+				var envelope ErrorResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+					t.Fatalf("error envelope: %v: %s", err, w.Body)
+				}
+				if envelope.Error.Code != tc.code || envelope.Error.Status != tc.status {
+					t.Errorf("error = %+v, want code %s and status %d", envelope.Error, tc.code, tc.status)
+				}
+			}
+			if tc.check != nil {
+				tc.check(t, a, m, w)
+			}
+		})
+	}
+}
+
+// decodeResponse decodes the APIResponse a proxied call answers with.
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder) APIResponse {
+	t.Helper()
+	var response APIResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("response: %v: %s", err, w.Body)
+	}
+	return response
+}
+
+// upstreamErrorCases are the ways a GREEN-API call of method fails, as
+// answered to req.
+func upstreamErrorCases(method string, req func() *http.Request) []handlerCase {
+	return []handlerCase{
+		{
+			name:   "upstream 400",
+			setup:  func(m *mockGreenAPI) { m.reply(method, http.StatusBadRequest, `{"message":"bad chatId"}`) },
+			req:    req,
+			status: http.StatusBadRequest,
+			code:   "invalid_parameters",
+		},
+		{
+			name:   "upstream 466",
+			setup:  func(m *mockGreenAPI) { m.reply(method, 466, `{"message":"quota exceeded"}`) },
+			req:    req,
+			status: 466,
+			code:   "quota_exceeded",
+		},
+		{
+			name:   "upstream 500",
+			setup:  func(m *mockGreenAPI) { m.reply(method, http.StatusInternalServerError, `{}`) },
+			req:    req,
+			status: http.StatusBadGateway,
+			code:   "upstream_internal_error",
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				var envelope ErrorResponse
+				json.Unmarshal(w.Body.Bytes(), &envelope)
+				if envelope.Error.UpstreamStatus != http.StatusInternalServerError {
+					t.Errorf("upstreamStatus = %d, want 500", envelope.Error.UpstreamStatus)
+				}
+			},
+		},
+		{
+			name:   "upstream 503",
+			setup:  func(m *mockGreenAPI) { m.reply(method, http.StatusServiceUnavailable, `{}`) },
+			req:    req,
+			status: http.StatusBadGateway,
+			code:   "upstream_unavailable",
+		},
+		{
+			name:   "upstream timeout",
+			args:   []string{"-timeouts", method + "=50ms"},
+			setup:  func(m *mockGreenAPI) { m.stall(method, 2*time.Second) },
+			req:    req,
+			status: http.StatusGatewayTimeout,
+			code:   "upstream_timeout",
+		},
+	}
+}
+
+func sendMessageRequest(body string) func() *http.Request {
+	return func() *http.Request { return apiRequest(http.MethodPost, "/api/send-message", body) }
+}
+
+func TestSendMessage(t *testing.T) {
+	valid := sendMessageRequest(`{"idInstance":"` + testInstance + `","apiTokenInstance":"` + testToken + `","phoneNumber":"79001234567","messageText":"hi"}`)
+	cases := []handlerCase{
+		{
+			name:   "sent",
+			req:    valid,
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				calls := m.callsOf("sendMessage")
+				if len(calls) != 1 {
+					t.Fatalf("sendMessage called %d times, want 1", len(calls))
+				}
+				call := calls[0]
+				if call.Verb != http.MethodPost || call.IDInstance != testInstance || call.Token != testToken {
+					t.Errorf("call = %s %s/%s", call.Verb, call.IDInstance, call.Token)
+				}
+				var payload map[string]interface{}
+				json.Unmarshal(call.Body, &payload)
+				if payload["chatId"] != "79001234567@c.us" || payload["message"] != "hi" {
+					t.Errorf("payload = %v", payload)
+				}
+
+				response := decodeResponse(t, w)
+				if response.StatusCode != http.StatusOK {
+					t.Errorf("statusCode = %d, want 200", response.StatusCode)
+				}
+				if got := response.Response.(map[string]interface{})["idMessage"]; got != "BAE5F4886F6F2D05" {
+					t.Errorf("idMessage = %v", got)
+				}
+				if token := response.RequestBody.(map[string]interface{})["apiTokenInstance"]; token != redactedToken {
+					t.Errorf("requestBody echoes apiTokenInstance %v", token)
+				}
+			},
+		},
+		{
+			name:   "dry run",
+			req:    sendMessageRequest(`{"idInstance":"` + testInstance + `","apiTokenInstance":"` + testToken + `","phoneNumber":"79001234567","messageText":"hi","dryRun":true}`),
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				if calls := m.callsOf("sendMessage"); len(calls) != 0 {
+					t.Errorf("dry run called sendMessage %d times", len(calls))
+				}
+				if !decodeResponse(t, w).DryRun {
+					t.Error("dryRun = false")
+				}
+			},
+		},
+		{
+			name:   "bad JSON",
+			req:    sendMessageRequest(`{"idInstance":`),
+			status: http.StatusBadRequest,
+			code:   "invalid_request",
+		},
+		{
+			name:   "short phone number",
+			req:    sendMessageRequest(`{"idInstance":"` + testInstance + `","apiTokenInstance":"` + testToken + `","phoneNumber":"7900","messageText":"hi"}`),
+			status: http.StatusBadRequest,
+			code:   "invalid_phone_number",
+		},
+		{
+			name:   "wrong verb",
+			req:    func() *http.Request { return apiRequest(http.MethodGet, "/api/send-message", "") },
+			status: http.StatusMethodNotAllowed,
+			code:   "method_not_allowed",
+		},
+		{
+			name: "no API key",
+			req: func() *http.Request {
+				r := valid()
+				r.Header.Del("X-API-Key")
+				return r
+			},
+			status: http.StatusUnauthorized,
+			code:   "unauthorized",
+		},
+		{
+			name: "viewer",
+			req: func() *http.Request {
+				r := valid()
+				r.Header.Set("X-API-Key", testViewerKey)
+				return r
+			},
+			status: http.StatusForbidden,
+			code:   "forbidden",
+		},
+	}
+	runHandlerCases(t, append(cases, upstreamErrorCases("sendMessage", valid)...))
+}
+
+func TestSendFile(t *testing.T) {
+	valid := func() *http.Request {
+		return apiRequest(http.MethodPost, "/api/send-file",
+			`{"idInstance":"`+testInstance+`","apiTokenInstance":"`+testToken+`","phoneNumber":"79001234567","fileUrl":"https://example.com/report.pdf","caption":"report"}`)
+	}
+	cases := []handlerCase{
+		{
+			name:   "sent",
+			req:    valid,
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				calls := m.callsOf("sendFileByUrl")
+				if len(calls) != 1 {
+					t.Fatalf("sendFileByUrl called %d times, want 1", len(calls))
+				}
+				var payload map[string]interface{}
+				json.Unmarshal(calls[0].Body, &payload)
+				if payload["urlFile"] != "https://example.com/report.pdf" || payload["fileName"] != "report.pdf" {
+					t.Errorf("payload = %v", payload)
+				}
+			},
+		},
+		{
+			name:   "bad JSON",
+			req:    func() *http.Request { return apiRequest(http.MethodPost, "/api/send-file", `[`) },
+			status: http.StatusBadRequest,
+			code:   "invalid_request",
+		},
+	}
+	runHandlerCases(t, append(cases, upstreamErrorCases("sendFileByUrl", valid)...))
+}
+
+// uploadRequest is a /api/send-file-upload call.
+func uploadRequest(fields map[string]string, fileName string, file []byte) *http.Request {
+	return formRequest("/api/send-file-upload", fields, fileName, file)
+}
+
+// formRequest is a multipart form of fields followed by a file, posted to
+// target with the admin API key.
+func formRequest(target string, fields map[string]string, fileName string, file []byte) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, name := range []string{"idInstance", "apiTokenInstance", "phoneNumber", "caption", "dryRun"} {
+		if value, ok := fields[name]; ok {
+			form.WriteField(name, value)
+		}
+	}
+	if fileName != "" {
+		part, _ := form.CreateFormFile("file", fileName)
+		part.Write(file)
+	}
+	form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, target, &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	r.Header.Set("X-API-Key", testAdminKey)
+	return r
+}
+
+func TestSendFileUpload(t *testing.T) {
+	fields := map[string]string{"idInstance": testInstance, "apiTokenInstance": testToken, "phoneNumber": "79001234567", "caption": "notes"}
+	valid := func() *http.Request { return uploadRequest(fields, "notes.txt", []byte("file contents")) }
+	cases := []handlerCase{
+		{
+			name:   "uploaded",
+			req:    valid,
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				calls := m.callsOf("sendFileByUpload")
+				if len(calls) != 1 {
+					t.Fatalf("sendFileByUpload called %d times, want 1", len(calls))
+				}
+				call := calls[0]
+				if !strings.HasPrefix(call.ContentType, "multipart/form-data") {
+					t.Errorf("Content-Type = %s", call.ContentType)
+				}
+				for _, want := range []string{"79001234567@c.us", "notes.txt", "file contents", "notes"} {
+					if !bytes.Contains(call.Body, []byte(want)) {
+						t.Errorf("upload form lacks %q", want)
+					}
+				}
+				response := decodeResponse(t, w)
+				if size := response.RequestBody.(map[string]interface{})["fileSize"]; size != float64(len("file contents")) {
+					t.Errorf("fileSize = %v", size)
+				}
+			},
+		},
+		{
+			name: "dry run",
+			req: func() *http.Request {
+				dryRun := map[string]string{"dryRun": "true"}
+				for name, value := range fields {
+					dryRun[name] = value
+				}
+				return uploadRequest(dryRun, "notes.txt", []byte("file contents"))
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				if calls := m.callsOf("sendFileByUpload"); len(calls) != 0 {
+					t.Errorf("dry run called sendFileByUpload %d times", len(calls))
+				}
+			},
+		},
+		{
+			name:   "no file",
+			req:    func() *http.Request { return uploadRequest(fields, "", nil) },
+			status: http.StatusBadRequest,
+			code:   "invalid_request",
+		},
+		{
+			name: "not multipart",
+			req: func() *http.Request {
+				return apiRequest(http.MethodPost, "/api/send-file-upload", `{"idInstance":"`+testInstance+`"}`)
+			},
+			status: http.StatusBadRequest,
+			code:   "invalid_request",
+		},
+		{
+			name:   "too large",
+			args:   []string{"-max-upload-size", "64"},
+			req:    func() *http.Request { return uploadRequest(fields, "big.bin", bytes.Repeat([]byte("x"), 1024)) },
+			status: http.StatusRequestEntityTooLarge,
+			code:   "file_too_large",
+		},
+	}
+	runHandlerCases(t, append(cases, upstreamErrorCases("sendFileByUpload", valid)...))
+}
+
+func TestGetState(t *testing.T) {
+	valid := func() *http.Request {
+		return apiRequest(http.MethodPost, "/api/get-state", `{"idInstance":"`+testInstance+`","apiTokenInstance":"`+testToken+`"}`)
+	}
+	cases := []handlerCase{
+		{
+			name:   "authorized",
+			req:    valid,
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				if calls := m.callsOf("getStateInstance"); len(calls) != 1 || calls[0].Verb != http.MethodGet {
+					t.Fatalf("getStateInstance calls = %+v", calls)
+				}
+				if state := decodeResponse(t, w).Response.(map[string]interface{})["stateInstance"]; state != "authorized" {
+					t.Errorf("stateInstance = %v", state)
+				}
+			},
+		},
+		{
+			name: "viewer",
+			req: func() *http.Request {
+				r := valid()
+				r.Header.Set("X-API-Key", testViewerKey)
+				return r
+			},
+			status: http.StatusOK,
+		},
+		{
+			name:   "bad JSON",
+			req:    func() *http.Request { return apiRequest(http.MethodPost, "/api/get-state", `nope`) },
+			status: http.StatusBadRequest,
+			code:   "invalid_request",
+		},
+	}
+	runHandlerCases(t, append(cases, upstreamErrorCases("getStateInstance", valid)...))
+}
+
+func webhookRequest(token, body string) func() *http.Request {
+	return func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		return r
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	incoming := `{"typeWebhook":"incomingMessageReceived","instanceData":{"idInstance":1101000001,"wid":"79001234567@c.us","typeInstance":"whatsapp"},` +
+		`"timestamp":1700000000,"idMessage":"BAE5F4886F6F2D09","senderData":{"chatId":"79009876543@c.us","sender":"79009876543@c.us"},` +
+		`"messageData":{"typeMessage":"textMessage","textMessageData":{"textMessage":"hello from the harness"}}}`
+	runHandlerCases(t, []handlerCase{
+		{
+			name:   "received",
+			req:    webhookRequest(testWebhookToken, incoming),
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				list := serve(a, apiRequest(http.MethodGet, "/api/webhooks", ""))
+				var notifications []Notification
+				if err := json.Unmarshal(list.Body.Bytes(), &notifications); err != nil {
+					t.Fatalf("webhooks: %v: %s", err, list.Body)
+				}
+				if len(notifications) != 1 {
+					t.Fatalf("%d notifications listed, want 1", len(notifications))
+				}
+				n := notifications[0]
+				if n.IDInstance != 1101000001 || n.ChatID != "79009876543@c.us" || n.Text != "hello from the harness" {
+					t.Errorf("notification = %+v", n)
+				}
+			},
+		},
+		{
+			name:   "wrong token",
+			req:    webhookRequest("guess", incoming),
+			status: http.StatusUnauthorized,
+			code:   "invalid_webhook_token",
+		},
+		{
+			name:   "no token",
+			req:    webhookRequest("", incoming),
+			status: http.StatusUnauthorized,
+			code:   "invalid_webhook_token",
+		},
+		{
+			name:   "bad JSON",
+			req:    webhookRequest(testWebhookToken, `{"typeWebhook":`),
+			status: http.StatusBadRequest,
+			code:   "invalid_request",
+		},
+		{
+			name:   "no typeWebhook",
+			req:    webhookRequest(testWebhookToken, `{"instanceData":{"idInstance":1101000001}}`),
+			status: http.StatusBadRequest,
+			code:   "invalid_request",
+		},
+		{
+			name:   "wrong verb",
+			req:    func() *http.Request { return httptest.NewRequest(http.MethodGet, "/webhook", nil) },
+			status: http.StatusMethodNotAllowed,
+			code:   "method_not_allowed",
+		},
+	})
+}
+
+func TestServeFile(t *testing.T) {
+	a := newTestApp(t, newMockGreenAPI(t))
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"/>`
+	hosted, err := a.fileHost.store("image.svg", strings.NewReader(svg), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(a, httptest.NewRequest(http.MethodGet, hosted.URL, nil))
+	if w.Code != http.StatusOK || w.Body.String() != svg {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	for header, want := range map[string]string{
+		"Content-Disposition":     `attachment; filename="image.svg"`,
+		"X-Content-Type-Options":  "nosniff",
+		"Content-Security-Policy": "sandbox",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+
+	if w := serve(a, httptest.NewRequest(http.MethodGet, strings.Replace(hosted.URL, "token=", "token=0", 1), nil)); w.Code != http.StatusForbidden {
+		t.Errorf("wrong token: status %d", w.Code)
+	}
+}
+
+// fileNotification is an incoming document of instance idInstance whose
+// webhook points at downloadUrl.
+func fileNotification(idInstance int64, idMessage, downloadUrl string) Notification {
+	return Notification{
+		TypeWebhook: "incomingMessageReceived",
+		IDInstance:  idInstance,
+		IDMessage:   idMessage,
+		ChatID:      "79009876543@c.us",
+		TypeMessage: "documentMessage",
+		File:        &MessageFile{DownloadURL: downloadUrl, FileName: "page.html", MimeType: "text/html"},
+	}
+}
+
+func TestMediaDownloader(t *testing.T) {
+	var fetched []string
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = append(fetched, r.URL.Path)
+		io.WriteString(w, "<script>alert(1)</script>")
+	}))
+	t.Cleanup(files.Close)
+
+	m := newMockGreenAPI(t)
+	m.reply("downloadFile", http.StatusOK, `{"downloadUrl":"`+files.URL+`/fresh"}`)
+	a := newTestApp(t, m, "-media-dir", t.TempDir(), "-instances", testInstance+":"+testToken)
+	ctx := context.Background()
+
+	// A file of an instance that isn't configured is never fetched, not
+	// even from the link in the webhook
+	if err := a.mediaDownloader.save(ctx, fileNotification(1101000002, "BAE5F4886F6F2D10", files.URL+"/webhook")); err == nil {
+		t.Error("saved the file of an instance that isn't configured")
+	}
+	if len(fetched) != 0 {
+		t.Fatalf("fetched %v for an instance that isn't configured", fetched)
+	}
+
+	if err := a.mediaDownloader.save(ctx, fileNotification(1101000001, "BAE5F4886F6F2D10", files.URL+"/webhook")); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(fetched) != "[/fresh]" {
+		t.Errorf("fetched %v, want only the link downloadFile returned", fetched)
+	}
+
+	// The same idMessage of another instance is another file
+	if w := serve(a, apiRequest(http.MethodGet, "/api/attachments/1101000002/BAE5F4886F6F2D10", "")); w.Code != http.StatusNotFound {
+		t.Errorf("attachment of another instance: status %d", w.Code)
+	}
+	w := serve(a, apiRequest(http.MethodGet, "/api/attachments/"+testInstance+"/BAE5F4886F6F2D10", ""))
+	if w.Code != http.StatusOK || w.Body.String() != "<script>alert(1)</script>" {
+		t.Fatalf("attachment: status %d: %s", w.Code, w.Body)
+	}
+	for header, want := range map[string]string{
+		"Content-Disposition":     `attachment; filename="page.html"`,
+		"X-Content-Type-Options":  "nosniff",
+		"Content-Security-Policy": "sandbox",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestDeadLettersByWorkspace(t *testing.T) {
+	a := newTestApp(t, newMockGreenAPI(t), workspaceArgs...)
+	for _, idInstance := range []string{testInstance, testWorkspaceInstance, testInstance, testWorkspaceInstance} {
+		a.deadLetters.add(DeadLetter{Kind: deadLetterForward, Target: "https://example.com/hook", IDInstance: idInstance, Payload: json.RawMessage(`{}`)})
+	}
+	// IDs 1 and 3 are of the unscoped instance, 2 and 4 of acme's
+	listIDs := func(key string) string {
+		w := serve(a, keyRequest(key, http.MethodGet, "/api/dlq", ""))
+		var letters []DeadLetter
+		if err := json.Unmarshal(w.Body.Bytes(), &letters); err != nil {
+			t.Fatalf("dlq: %v: %s", err, w.Body)
+		}
+		ids := make([]int64, len(letters))
+		for i, letter := range letters {
+			ids[i] = letter.ID
+		}
+		return fmt.Sprint(ids)
+	}
+
+	if got := listIDs(testWorkspaceKey); got != "[2 4]" {
+		t.Errorf("acme lists %s, want [2 4]", got)
+	}
+	for _, req := range []*http.Request{
+		keyRequest(testWorkspaceKey, http.MethodDelete, "/api/dlq/1", ""),
+		keyRequest(testWorkspaceKey, http.MethodPost, "/api/dlq/3/retry", ""),
+	} {
+		if w := serve(a, req); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s of another workspace: status %d", req.Method, req.URL, w.Code)
+		}
+	}
+	if w := serve(a, keyRequest(testWorkspaceKey, http.MethodDelete, "/api/dlq/2", "")); w.Code != http.StatusNoContent {
+		t.Errorf("delete: status %d: %s", w.Code, w.Body)
+	}
+
+	w := serve(a, keyRequest(testWorkspaceKey, http.MethodDelete, "/api/dlq", ""))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"purged":1`) {
+		t.Errorf("purge: status %d: %s", w.Code, w.Body)
+	}
+	if got := listIDs(testAdminKey); got != "[1 3]" {
+		t.Errorf("after acme's purge the unscoped admin lists %s, want [1 3]", got)
+	}
+}
+
+func TestStatsByWorkspace(t *testing.T) {
+	a := newTestApp(t, newMockGreenAPI(t), workspaceArgs...)
+	serve(a, apiRequest(http.MethodPost, "/api/send-message", `{"idInstance":"`+testInstance+`","apiTokenInstance":"`+testToken+`","phoneNumber":"79001234567","messageText":"hi"}`))
+	serve(a, keyRequest(testWorkspaceKey, http.MethodPost, "/api/get-state", `{"idInstance":"`+testWorkspaceInstance+`","apiTokenInstance":"`+testToken+`"}`))
+	stats := func(key string) StatsResponse {
+		w := serve(a, keyRequest(key, http.MethodGet, "/api/stats", ""))
+		var response StatsResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("stats: %v: %s", err, w.Body)
+		}
+		return response
+	}
+
+	acme := stats(testWorkspaceKey)
+	if fmt.Sprint(slices.Sorted(maps.Keys(acme.Methods))) != "[getStateInstance]" || acme.MessagesToday != 0 || len(acme.Endpoints) != 0 {
+		t.Errorf("acme sees methods %v, %d messages today and %d endpoints", slices.Sorted(maps.Keys(acme.Methods)), acme.MessagesToday, len(acme.Endpoints))
+	}
+	all := stats(testAdminKey)
+	if fmt.Sprint(slices.Sorted(maps.Keys(all.Methods))) != "[getStateInstance sendMessage]" || all.MessagesToday != 1 || len(all.Endpoints) == 0 {
+		t.Errorf("the unscoped admin sees methods %v, %d messages today and %d endpoints", slices.Sorted(maps.Keys(all.Methods)), all.MessagesToday, len(all.Endpoints))
+	}
+}
+
+func TestTemplatesByWorkspace(t *testing.T) {
+	for _, storage := range []string{"memory", "sqlite"} {
+		t.Run(storage, func(t *testing.T) {
+			args := workspaceArgs
+			if storage == "sqlite" {
+				args = append(slices.Clone(args), "-storage", "sqlite:"+filepath.Join(t.TempDir(), "grapi.db"))
+			}
+			a := newTestApp(t, newMockGreenAPI(t), args...)
+			for _, key := range []string{testAdminKey, testWorkspaceKey} {
+				body := `{"text":"hello from ` + key[:4] + `"}`
+				if w := serve(a, keyRequest(key, http.MethodPut, "/api/canned-replies/greet", body)); w.Code != http.StatusOK {
+					t.Fatalf("save canned reply: status %d: %s", w.Code, w.Body)
+				}
+				if w := serve(a, keyRequest(key, http.MethodPut, "/api/settings-presets/greet", `{"keepOnlineStatus":"yes"}`)); w.Code != http.StatusOK {
+					t.Fatalf("save preset: status %d: %s", w.Code, w.Body)
+				}
+			}
+
+			// acme sees its own, the unscoped admin both
+			if replies := listedBy[CannedReply](t, a, testWorkspaceKey, "/api/canned-replies"); len(replies) != 1 || replies[0].Workspace != "acme" {
+				t.Errorf("acme lists canned replies %+v", replies)
+			}
+			if replies := listed[CannedReply](t, a, "/api/canned-replies"); len(replies) != 2 {
+				t.Errorf("the unscoped admin lists canned replies %+v", replies)
+			}
+			presets := listedBy[SettingsPreset](t, a, testWorkspaceKey, "/api/settings-presets")
+			for _, preset := range presets {
+				if !preset.BuiltIn && preset.Workspace != "acme" {
+					t.Errorf("acme lists the preset %+v", preset)
+				}
+			}
+
+			// Sending a shortcut uses the caller's text
+			send := `{"idInstance":"` + testWorkspaceInstance + `","apiTokenInstance":"` + testToken + `","phoneNumber":"79001234567","dryRun":true}`
+			w := serve(a, keyRequest(testWorkspaceKey, http.MethodPost, "/api/canned-replies/greet/send", send))
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "hello from acme") {
+				t.Errorf("send: status %d: %s", w.Code, w.Body)
+			}
+
+			// Deleting leaves the other workspace's alone
+			if w := serve(a, keyRequest(testWorkspaceKey, http.MethodDelete, "/api/settings-presets/greet", "")); w.Code != http.StatusNoContent {
+				t.Fatalf("delete: status %d: %s", w.Code, w.Body)
+			}
+			if w := serve(a, keyRequest(testWorkspaceKey, http.MethodDelete, "/api/settings-presets/greet", "")); w.Code != http.StatusNotFound {
+				t.Errorf("second delete: status %d", w.Code)
+			}
+			if _, ok, _ := a.presets.get("", "greet"); !ok {
+				t.Error("acme deleted the unscoped preset")
+			}
+		})
+	}
+}
+
+func TestExportImportByWorkspace(t *testing.T) {
+	a := newTestApp(t, newMockGreenAPI(t), workspaceArgs...)
+	for _, workspace := range []string{"", "acme"} {
+		a.presets.put(SettingsPreset{Name: "greet", Workspace: workspace, Settings: map[string]interface{}{"keepOnlineStatus": "yes"}})
+		a.cannedReplies.put(CannedReply{Shortcut: "greet", Workspace: workspace, Text: "hello"})
+	}
+
+	w := serve(a, keyRequest(testWorkspaceKey, http.MethodGet, "/api/export", ""))
+	var archive StateArchive
+	if err := json.Unmarshal(w.Body.Bytes(), &archive); err != nil {
+		t.Fatalf("export: %v: %s", err, w.Body)
+	}
+	if len(archive.Presets) != 1 || archive.Presets[0].Workspace != "acme" {
+		t.Errorf("acme exports presets %+v", archive.Presets)
+	}
+	if len(archive.CannedReplies) != 1 || archive.CannedReplies[0].Workspace != "acme" {
+		t.Errorf("acme exports canned replies %+v", archive.CannedReplies)
+	}
+
+	// Whatever workspace an archive names, acme imports into its own, and
+	// replacing leaves the other workspaces alone
+	archive.Presets = []SettingsPreset{{Name: "moved", Workspace: "globex", Settings: map[string]interface{}{"keepOnlineStatus": "no"}}}
+	archive.CannedReplies = []CannedReply{{Shortcut: "moved", Workspace: "globex", Text: "hi"}}
+	body, _ := json.Marshal(archive)
+	if w := serve(a, keyRequest(testWorkspaceKey, http.MethodPost, "/api/import?mode=replace", string(body))); w.Code != http.StatusOK {
+		t.Fatalf("import: status %d: %s", w.Code, w.Body)
+	}
+	presets, _ := a.presets.list()
+	replies, _ := a.cannedReplies.list()
+	if len(presets) != 2 || presets[0].Workspace != "" || presets[1].Workspace != "acme" || presets[1].Name != "moved" {
+		t.Errorf("presets after the import: %+v", presets)
+	}
+	if len(replies) != 2 || replies[0].Workspace != "" || replies[1].Workspace != "acme" || replies[1].Shortcut != "moved" {
+		t.Errorf("canned replies after the import: %+v", replies)
+	}
+}
+
+func TestGetSettings(t *testing.T) {
+	valid := func() *http.Request {
+		return apiRequest(http.MethodPost, "/api/get-settings", `{"idInstance":"`+testInstance+`","apiTokenInstance":"`+testToken+`"}`)
+	}
+	cases := []handlerCase{
+		{
+			name:   "settings",
+			req:    valid,
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				if calls := m.callsOf("getSettings"); len(calls) != 1 || calls[0].Verb != http.MethodGet || calls[0].Token != testToken {
+					t.Fatalf("getSettings calls = %+v", calls)
+				}
+				response := decodeResponse(t, w)
+				if wid := response.Response.(map[string]interface{})["wid"]; wid != "79001234567@c.us" {
+					t.Errorf("wid = %v", wid)
+				}
+				if token := response.RequestBody.(map[string]interface{})["apiTokenInstance"]; token == testToken {
+					t.Error("the token is echoed unmasked")
+				}
+			},
+		},
+		{
+			name: "viewer",
+			req: func() *http.Request {
+				return keyRequest(testViewerKey, http.MethodPost, "/api/get-settings", `{"idInstance":"`+testInstance+`"}`)
+			},
+			status: http.StatusOK,
+		},
+		{
+			name:   "bad JSON",
+			req:    func() *http.Request { return apiRequest(http.MethodPost, "/api/get-settings", `{`) },
+			status: http.StatusBadRequest,
+			code:   "invalid_request",
+		},
+		{
+			name:   "wrong verb",
+			req:    func() *http.Request { return apiRequest(http.MethodGet, "/api/get-settings", "") },
+			status: http.StatusMethodNotAllowed,
+			code:   "method_not_allowed",
+		},
+	}
+	runHandlerCases(t, append(cases, upstreamErrorCases("getSettings", valid)...))
+}
+
+// rawRequest is a /api/raw call of method made with key.
+func rawRequest(key, method, body string) func() *http.Request {
+	return func() *http.Request {
+		return keyRequest(key, http.MethodPost, "/api/raw",
+			`{"idInstance":"`+testInstance+`","apiTokenInstance":"`+testToken+`","method":"`+method+`","body":`+cmp.Or(body, `""`)+`}`)
+	}
+}
+
+func TestRaw(t *testing.T) {
+	send := `{"chatId":"79001234567@c.us","message":"hi"}`
+	cases := []handlerCase{
+		{
+			name:   "read",
+			req:    rawRequest(testAdminKey, "getSettings", ""),
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				if calls := m.callsOf("getSettings"); len(calls) != 1 || calls[0].Verb != http.MethodGet {
+					t.Fatalf("getSettings calls = %+v", calls)
+				}
+			},
+		},
+		{
+			name:   "send",
+			req:    rawRequest(testAdminKey, "sendMessage", send),
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				calls := m.callsOf("sendMessage")
+				if len(calls) != 1 || calls[0].Verb != http.MethodPost || !bytes.Contains(calls[0].Body, []byte(`"message":"hi"`)) {
+					t.Fatalf("sendMessage calls = %+v", calls)
+				}
+				if id := decodeResponse(t, w).Response.(map[string]interface{})["idMessage"]; id != "BAE5F4886F6F2D05" {
+					t.Errorf("idMessage = %v", id)
+				}
+			},
+		},
+		{
+			name:   "uncatalogued method",
+			req:    rawRequest(testAdminKey, "getSomethingNew", ""),
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				if calls := m.callsOf("getSomethingNew"); len(calls) != 1 {
+					t.Errorf("getSomethingNew called %d times", len(calls))
+				}
+			},
+		},
+		{
+			name:   "viewer reads",
+			req:    rawRequest(testViewerKey, "getSettings", ""),
+			status: http.StatusOK,
+		},
+		{
+			name:   "viewer sends",
+			req:    rawRequest(testViewerKey, "sendMessage", send),
+			status: http.StatusForbidden,
+			code:   "forbidden",
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				if calls := m.callsOf("sendMessage"); len(calls) != 0 {
+					t.Errorf("a forbidden call reached GREEN-API %d times", len(calls))
+				}
+			},
+		},
+		{
+			name:   "viewer calls an uncatalogued write",
+			req:    rawRequest(testViewerKey, "wipeEverything", ""),
+			status: http.StatusForbidden,
+			code:   "forbidden",
+		},
+		{
+			name:   "path injection",
+			req:    rawRequest(testAdminKey, "getSettings/../reboot", ""),
+			status: http.StatusBadRequest,
+			code:   "invalid_method",
+		},
+		{
+			name: "wrong verb for the method",
+			req: func() *http.Request {
+				return apiRequest(http.MethodPost, "/api/raw", `{"idInstance":"`+testInstance+`","apiTokenInstance":"`+testToken+`","method":"sendMessage","httpMethod":"GET"}`)
+			},
+			status: http.StatusBadRequest,
+			code:   "invalid_http_method",
+		},
+		{
+			name:   "invalid payload",
+			req:    rawRequest(testAdminKey, "sendMessage", `{"chatId":5}`),
+			status: http.StatusBadRequest,
+			code:   "invalid_body",
+		},
+	}
+	runHandlerCases(t, append(cases, upstreamErrorCases("getSettings", rawRequest(testAdminKey, "getSettings", ""))...))
+}
+
+// TestChatOrder checks every way of sending to a chat waits for the send
+// already going out to it.
+func TestChatOrder(t *testing.T) {
+	const stall = 300 * time.Millisecond
+	chat := `"idInstance":"` + testInstance + `","apiTokenInstance":"` + testToken + `","phoneNumber":"79001234567"`
+	message := apiRequest(http.MethodPost, "/api/send-message", `{`+chat+`,"messageText":"first"}`)
+	reaction := func() *http.Request {
+		return apiRequest(http.MethodPost, "/api/send-reaction", `{`+chat+`,"idMessage":"BAE5F4886F6F2D05","reaction":"👍"}`)
+	}
+	cases := []struct {
+		name    string
+		stalled string
+		first   *http.Request
+		next    func() *http.Request
+	}{
+		{name: "reaction", stalled: "sendMessage", first: message, next: reaction},
+		{name: "raw send", stalled: "sendReaction", first: reaction(),
+			next: rawRequest(testAdminKey, "sendMessage", `{"chatId":"79001234567@c.us","message":"next"}`)},
+		{name: "canned reply", stalled: "sendReaction", first: reaction(), next: func() *http.Request {
+			return apiRequest(http.MethodPost, "/api/canned-replies/thanks/send", `{`+chat+`}`)
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMockGreenAPI(t)
+			a := newTestApp(t, m)
+			serve(a, apiRequest(http.MethodPut, "/api/canned-replies/thanks", `{"text":"Thank you!"}`))
+			m.stall(tc.stalled, stall)
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				serve(a, tc.first)
+			}()
+			for len(m.callsOf(tc.stalled)) == 0 {
+				time.Sleep(5 * time.Millisecond)
+			}
+			start := time.Now()
+			if w := serve(a, tc.next()); w.Code != http.StatusOK {
+				t.Errorf("status %d: %s", w.Code, w.Body)
+			}
+			if took := time.Since(start); took < stall/2 {
+				t.Errorf("the send took %s, it didn't wait for the one going out", took)
+			}
+			<-done
+		})
+	}
+}
+
+func TestAdminInstances(t *testing.T) {
+	staticArgs := []string{"-instances", testInstance + ":" + testToken}
+	create := func(body string) func() *http.Request {
+		return func() *http.Request { return apiRequest(http.MethodPost, "/api/admin/instances", body) }
+	}
+	addManaged := func(a *App) {
+		a.instanceProfiles.put(ManagedInstance{Instance: Instance{IDInstance: "1101000003", APITokenInstance: "managedtoken"}})
+	}
+	runHandlerCases(t, []handlerCase{
+		{
+			name:   "list",
+			args:   staticArgs,
+			seed:   addManaged,
+			req:    func() *http.Request { return apiRequest(http.MethodGet, "/api/admin/instances", "") },
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				var list []instanceView
+				json.Unmarshal(w.Body.Bytes(), &list)
+				if len(list) != 2 || list[0].IDInstance != testInstance || list[0].Managed || !list[1].Managed {
+					t.Errorf("instances = %+v", list)
+				}
+				if strings.Contains(w.Body.String(), "managedtoken") {
+					t.Error("the list shows a token")
+				}
+			},
+		},
+		{
+			name:   "create",
+			req:    create(`{"idInstance":"1101000003","apiTokenInstance":"managedtoken"}`),
+			status: http.StatusCreated,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				if instance, ok := a.lookupInstance("1101000003"); !ok || instance.APITokenInstance != "managedtoken" {
+					t.Errorf("created instance = %+v, %v", instance, ok)
+				}
+			},
+		},
+		{
+			name:   "create without a token",
+			req:    create(`{"idInstance":"1101000003"}`),
+			status: http.StatusBadRequest,
+			code:   "invalid_request",
+		},
+		{
+			name:   "create a configured one",
+			args:   staticArgs,
+			req:    create(`{"idInstance":"` + testInstance + `","apiTokenInstance":"other"}`),
+			status: http.StatusConflict,
+			code:   "instance_exists",
+		},
+		{
+			name: "update",
+			seed: addManaged,
+			req: func() *http.Request {
+				return apiRequest(http.MethodPut, "/api/admin/instances/1101000003", `{"apiTokenInstance":"newtoken"}`)
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				if instance, _ := a.lookupInstance("1101000003"); instance.APITokenInstance != "newtoken" {
+					t.Errorf("token = %s after the update", instance.APITokenInstance)
+				}
+			},
+		},
+		{
+			name: "update a static one",
+			args: staticArgs,
+			req: func() *http.Request {
+				return apiRequest(http.MethodPut, "/api/admin/instances/"+testInstance, `{"apiTokenInstance":"x"}`)
+			},
+			status: http.StatusConflict,
+			code:   "instance_not_managed",
+		},
+		{
+			name:   "delete",
+			seed:   addManaged,
+			req:    func() *http.Request { return apiRequest(http.MethodDelete, "/api/admin/instances/1101000003", "") },
+			status: http.StatusNoContent,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				if _, ok := a.lookupInstance("1101000003"); ok {
+					t.Error("the instance is still configured")
+				}
+			},
+		},
+		{
+			name:   "delete unknown",
+			req:    func() *http.Request { return apiRequest(http.MethodDelete, "/api/admin/instances/1101000009", "") },
+			status: http.StatusNotFound,
+			code:   "not_found",
+		},
+		{
+			name: "test",
+			args: staticArgs,
+			req: func() *http.Request {
+				return apiRequest(http.MethodPost, "/api/admin/instances/"+testInstance+"/test", "")
+			},
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				var result InstanceTest
+				json.Unmarshal(w.Body.Bytes(), &result)
+				if !result.OK || result.StateInstance != "authorized" {
+					t.Errorf("test = %+v", result)
+				}
+			},
+		},
+		{
+			name: "another workspace's instance",
+			args: workspaceArgs,
+			req: func() *http.Request {
+				return keyRequest(testWorkspaceKey, http.MethodPost, "/api/admin/instances/"+testInstance+"/test", "")
+			},
+			status: http.StatusNotFound,
+			code:   "not_found",
+		},
+	})
+}
+
+// sendLetter files a failed scheduled send as dead letter 1.
+func sendLetter(a *App) {
+	a.deadSend(ScheduledSend{
+		IDInstance:  testInstance,
+		PhoneNumber: "79001234567",
+		Method:      "sendMessage",
+		URL:         a.methodURL("sendMessage", testInstance, testToken),
+		Payload:     map[string]interface{}{"chatId": "79001234567@c.us", "message": "hi"},
+	}, errors.New("upstream unavailable"))
+}
+
+func TestDeadLetters(t *testing.T) {
+	runHandlerCases(t, []handlerCase{
+		{
+			name:   "list",
+			seed:   sendLetter,
+			req:    func() *http.Request { return keyRequest(testViewerKey, http.MethodGet, "/api/dlq", "") },
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				var letters []DeadLetter
+				json.Unmarshal(w.Body.Bytes(), &letters)
+				if len(letters) != 1 || letters[0].Kind != deadLetterSend || letters[0].IDInstance != testInstance {
+					t.Fatalf("dead letters = %+v", letters)
+				}
+				if strings.Contains(w.Body.String(), testToken) {
+					t.Error("a dead letter shows the token")
+				}
+			},
+		},
+		{
+			name:   "retry",
+			seed:   sendLetter,
+			req:    func() *http.Request { return apiRequest(http.MethodPost, "/api/dlq/1/retry", "") },
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				if letters := a.deadLetters.list(); len(letters) != 0 {
+					t.Errorf("%d dead letters left", len(letters))
+				}
+				sends, _ := a.scheduler.list("")
+				if len(sends) != 1 || sends[0].Status != scheduledPending {
+					t.Errorf("scheduled sends = %+v", sends)
+				}
+			},
+		},
+		{
+			name:   "retry unknown",
+			req:    func() *http.Request { return apiRequest(http.MethodPost, "/api/dlq/7/retry", "") },
+			status: http.StatusNotFound,
+			code:   "dead_letter_not_found",
+		},
+		{
+			name:   "retry a kind that can't be",
+			seed:   func(a *App) { a.deadLetters.add(DeadLetter{Kind: "carrier-pigeon"}) },
+			req:    func() *http.Request { return apiRequest(http.MethodPost, "/api/dlq/1/retry", "") },
+			status: http.StatusUnprocessableEntity,
+			code:   "unsupported_dead_letter",
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				if letters := a.deadLetters.list(); len(letters) != 1 {
+					t.Errorf("%d dead letters left, want the letter back", len(letters))
+				}
+			},
+		},
+		{
+			name:   "bad id",
+			req:    func() *http.Request { return apiRequest(http.MethodDelete, "/api/dlq/one", "") },
+			status: http.StatusBadRequest,
+			code:   "invalid_request",
+		},
+		{
+			name:   "delete",
+			seed:   sendLetter,
+			req:    func() *http.Request { return apiRequest(http.MethodDelete, "/api/dlq/1", "") },
+			status: http.StatusNoContent,
+		},
+		{
+			name:   "purge",
+			seed:   func(a *App) { sendLetter(a); sendLetter(a) },
+			req:    func() *http.Request { return apiRequest(http.MethodDelete, "/api/dlq", "") },
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				if !strings.Contains(w.Body.String(), `"purged":2`) {
+					t.Errorf("purge answered %s", w.Body)
+				}
+			},
+		},
+	})
+}
+
+func TestExportImport(t *testing.T) {
+	archive := func(version int) string {
+		return fmt.Sprintf(`{"version":%d,"history":[],"presets":[{"name":"quiet","settings":{"keepOnlineStatus":"no"}},{"name":"Bad Name","settings":{"a":"b"}}]}`, version)
+	}
+	importRequest := func(query, body string) func() *http.Request {
+		return func() *http.Request { return apiRequest(http.MethodPost, "/api/import"+query, body) }
+	}
+	runHandlerCases(t, []handlerCase{
+		{
+			name: "export",
+			seed: func(a *App) {
+				a.instanceProfiles.put(ManagedInstance{Instance: Instance{IDInstance: "1101000003", APITokenInstance: "managedtoken"}})
+			},
+			req:    func() *http.Request { return apiRequest(http.MethodGet, "/api/export", "") },
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				if disposition := w.Header().Get("Content-Disposition"); !strings.HasPrefix(disposition, "attachment;") {
+					t.Errorf("Content-Disposition = %q", disposition)
+				}
+				var archive StateArchive
+				json.Unmarshal(w.Body.Bytes(), &archive)
+				if archive.Version != archiveVersion || archive.Redacted || len(archive.Instances) != 1 || archive.Instances[0].APITokenInstance != "managedtoken" {
+					t.Errorf("archive = %+v", archive)
+				}
+			},
+		},
+		{
+			name: "viewer export is redacted",
+			seed: func(a *App) {
+				a.instanceProfiles.put(ManagedInstance{Instance: Instance{IDInstance: "1101000003", APITokenInstance: "managedtoken"}})
+			},
+			req:    func() *http.Request { return keyRequest(testViewerKey, http.MethodGet, "/api/export", "") },
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				if strings.Contains(w.Body.String(), "managedtoken") || !strings.Contains(w.Body.String(), `"redacted":true`) {
+					t.Errorf("viewer export: %s", w.Body)
+				}
+			},
+		},
+		{
+			name:   "import",
+			req:    importRequest("", archive(archiveVersion)),
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				var result struct {
+					Imported map[string]int `json:"imported"`
+					Skipped  map[string]int `json:"skipped"`
+					Mode     string         `json:"mode"`
+				}
+				json.Unmarshal(w.Body.Bytes(), &result)
+				if result.Imported["presets"] != 1 || result.Skipped["presets"] != 1 || result.Mode != "merge" {
+					t.Errorf("import = %+v", result)
+				}
+				if _, ok, _ := a.presets.get("", "quiet"); !ok {
+					t.Error("the preset wasn't imported")
+				}
+			},
+		},
+		{
+			name:   "unsupported version",
+			req:    importRequest("", archive(archiveVersion+1)),
+			status: http.StatusUnprocessableEntity,
+			code:   "unsupported_archive_version",
+		},
+		{
+			name:   "unknown mode",
+			req:    importRequest("?mode=overwrite", archive(archiveVersion)),
+			status: http.StatusBadRequest,
+			code:   "invalid_request",
+		},
+		{
+			name:   "bad JSON",
+			req:    importRequest("", `{"version":`),
+			status: http.StatusBadRequest,
+			code:   "invalid_request",
+		},
+		{
+			name: "viewer import",
+			req: func() *http.Request {
+				return keyRequest(testViewerKey, http.MethodPost, "/api/import", archive(archiveVersion))
+			},
+			status: http.StatusForbidden,
+			code:   "forbidden",
+		},
+	})
+}
+
+// scheduleSend holds a send back for an hour as scheduled send 1.
+func scheduleSend(a *App) {
+	a.scheduler.add(ScheduledSend{
+		IDInstance:  testInstance,
+		PhoneNumber: "79001234567",
+		Method:      "sendMessage",
+		URL:         a.methodURL("sendMessage", testInstance, testToken),
+		Payload:     map[string]interface{}{"chatId": "79001234567@c.us", "message": "later"},
+		Reason:      "quiet hours",
+		SendAt:      time.Now().Add(time.Hour),
+	})
+}
+
+func TestSchedule(t *testing.T) {
+	runHandlerCases(t, []handlerCase{
+		{
+			name:   "list",
+			seed:   scheduleSend,
+			req:    func() *http.Request { return keyRequest(testViewerKey, http.MethodGet, "/api/schedule", "") },
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				var sends []scheduledView
+				json.Unmarshal(w.Body.Bytes(), &sends)
+				if len(sends) != 1 || sends[0].Status != scheduledPending || sends[0].Reason != "quiet hours" {
+					t.Fatalf("scheduled sends = %+v", sends)
+				}
+				if strings.Contains(w.Body.String(), testToken) {
+					t.Error("a scheduled send shows the token")
+				}
+			},
+		},
+		{
+			name:   "calendar",
+			seed:   scheduleSend,
+			req:    func() *http.Request { return apiRequest(http.MethodGet, "/api/schedule/calendar.ics", "") },
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				if !strings.Contains(w.Body.String(), "BEGIN:VEVENT") {
+					t.Errorf("calendar: %s", w.Body)
+				}
+			},
+		},
+		{
+			name:   "cancel",
+			seed:   scheduleSend,
+			req:    func() *http.Request { return apiRequest(http.MethodDelete, "/api/schedule/1", "") },
+			status: http.StatusOK,
+			check: func(t *testing.T, a *App, m *mockGreenAPI, w *httptest.ResponseRecorder) {
+				send, _, _ := a.scheduler.store.get(1)
+				if send.Status != scheduledCancelled {
+					t.Errorf("status = %s after cancelling", send.Status)
+				}
+			},
+		},
+		{
+			name:   "cancel unknown",
+			req:    func() *http.Request { return apiRequest(http.MethodDelete, "/api/schedule/9", "") },
+			status: http.StatusNotFound,
+			code:   "scheduled_send_not_found",
+		},
+		{
+			name:   "viewer cancels",
+			seed:   scheduleSend,
+			req:    func() *http.Request { return keyRequest(testViewerKey, http.MethodDelete, "/api/schedule/1", "") },
+			status: http.StatusForbidden,
+			code:   "forbidden",
+		},
+	})
+}
+
+// TestRoles checks each route turns away callers below its role, and
+// callers without a key.
+func TestRoles(t *testing.T) {
+	var cases []handlerCase
+	for _, route := range []struct{ method, target string }{
+		{http.MethodPost, "/api/send-message"},
+		{http.MethodPost, "/api/admin/instances"},
+		{http.MethodDelete, "/api/dlq"},
+		{http.MethodPost, "/api/dlq/1/retry"},
+		{http.MethodPost, "/api/import"},
+		{http.MethodDelete, "/api/schedule/1"},
+		{http.MethodPut, "/api/settings-presets/quiet"},
+		{http.MethodPut, "/api/canned-replies/thanks"},
+	} {
+		cases = append(cases, handlerCase{
+			name:   "viewer " + route.method + " " + route.target,
+			req:    func() *http.Request { return keyRequest(testViewerKey, route.method, route.target, "{}") },
+			status: http.StatusForbidden,
+			code:   "forbidden",
+		})
+	}
+	for _, target := range []string{"/api/get-settings", "/api/raw", "/api/dlq", "/api/export", "/api/schedule", "/api/admin/instances"} {
+		cases = append(cases, handlerCase{
+			name: "no key " + target,
+			req: func() *http.Request {
+				r := apiRequest(http.MethodGet, target, "")
+				r.Header.Del("X-API-Key")
+				return r
+			},
+			status: http.StatusUnauthorized,
+			code:   "unauthorized",
+		})
+	}
+	cases = append(cases, handlerCase{
+		name:   "unknown key",
+		req:    func() *http.Request { return keyRequest("notakey1234567890", http.MethodGet, "/api/dlq", "") },
+		status: http.StatusUnauthorized,
+		code:   "unauthorized",
+	})
+	runHandlerCases(t, cases)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAdminKey     = "adminkey1234567890"
+	testViewerKey    = "viewerkey123456789"
+	testWebhookToken = "webhooktoken"
+	testInstance     = "1101000001"
+	testToken        = "instancetoken"
+
+	// testWorkspaceKey is the admin key of the acme workspace, which owns
+	// testWorkspaceInstance when newTestApp runs with workspaceArgs.
+	testWorkspaceKey      = "acmekey12345678901"
+	testWorkspaceInstance = "1101000002"
+)
+
+// workspaceArgs add the acme workspace, with its own admin and instance,
+// next to the unscoped keys of newTestApp.
+var workspaceArgs = []string{
+	"-api-keys", "ops:admin:" + testAdminKey + ",viewer:viewer:" + testViewerKey + ",acme/acme:admin:" + testWorkspaceKey,
+	"-instances", testInstance + ":" + testToken + ",acme/" + testWorkspaceInstance + ":" + testToken,
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// mockCall is a request the mock GREEN-API received.
+type mockCall struct {
+	Verb        string
+	Method      string
+	IDInstance  string
+	Token       string
+	ContentType string
+	Body        []byte
+}
+
+// mockReply is how the mock answers a method: with status and body, after
+// delay.
+type mockReply struct {
+	status int
+	body   string
+	delay  time.Duration
+}
+
+// mockFault is how the mock fails one call, on top of its reply.
+type mockFault struct {
+	// latency delays the answer.
+	latency time.Duration
+	// status answers with this status and an empty object instead, with
+	// retryAfter as its Retry-After when set.
+	status     int
+	retryAfter string
+	// truncate sends the headers and half the body, then hangs up.
+	truncate bool
+	// reset drops the connection as the answer starts. Go's transport
+	// quietly replays idempotent calls on a reused connection dropped
+	// before any answer, so some of it is sent first.
+	reset bool
+}
+
+// tooManyRequests is a burst of n 429 answers.
+func tooManyRequests(n int, retryAfter string) []mockFault {
+	faults := make([]mockFault, n)
+	for i := range faults {
+		faults[i] = mockFault{status: http.StatusTooManyRequests, retryAfter: retryAfter}
+	}
+	return faults
+}
+
+// mockGreenAPI stands in for GREEN-API. Methods answer 200 with a canned
+// body unless a test sets another reply or injects faults.
+type mockGreenAPI struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	calls   []mockCall
+	replies map[string]mockReply
+	faults  map[string][]mockFault
+}
+
+// defaultReplies are the bodies GREEN-API answers its methods with.
+var defaultReplies = map[string]string{
+	"sendMessage":      `{"idMessage":"BAE5F4886F6F2D05"}`,
+	"sendFileByUrl":    `{"idMessage":"BAE5F4886F6F2D06"}`,
+	"sendFileByUpload": `{"idMessage":"BAE5F4886F6F2D07","urlFile":"https://sw-media-out.storage.greenapi.net/1101000001/file.png"}`,
+	"getStateInstance": `{"stateInstance":"authorized"}`,
+	"getSettings":      `{"wid":"79001234567@c.us","webhookUrl":"","incomingWebhook":"yes","outgoingWebhook":"yes"}`,
+	"getWaSettings":    `{"phone":"79001234567","stateInstance":"authorized"}`,
+	"readChat":         `{"setRead":true}`,
+	"sendTyping":       `{}`,
+	"sendReaction":     `{"idMessage":"BAE5F4886F6F2D08"}`,
+}
+
+func newMockGreenAPI(t testing.TB) *mockGreenAPI {
+	m := &mockGreenAPI{replies: make(map[string]mockReply), faults: make(map[string][]mockFault)}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.serve))
+	t.Cleanup(m.Close)
+	return m
+}
+
+// serve answers /waInstance{idInstance}/{method}/{apiTokenInstance}.
+func (m *mockGreenAPI) serve(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	if len(parts) < 3 || !strings.HasPrefix(parts[0], "waInstance") {
+		http.NotFound(w, r)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	call := mockCall{
+		Verb:        r.Method,
+		Method:      parts[1],
+		IDInstance:  strings.TrimPrefix(parts[0], "waInstance"),
+		Token:       parts[2],
+		ContentType: r.Header.Get("Content-Type"),
+		Body:        body,
+	}
+
+	m.mu.Lock()
+	m.calls = append(m.calls, call)
+	reply, ok := m.replies[call.Method]
+	var fault mockFault
+	if faults := m.faults[call.Method]; len(faults) > 0 {
+		fault, m.faults[call.Method] = faults[0], faults[1:]
+	}
+	m.mu.Unlock()
+	if !ok {
+		reply = mockReply{status: http.StatusOK, body: defaultReplies[call.Method]}
+		if reply.body == "" {
+			reply.body = "{}"
+		}
+	}
+
+	if delay := reply.delay + fault.latency; delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	switch {
+	case fault.reset:
+		conn, _, _ := http.NewResponseController(w).Hijack()
+		io.WriteString(conn, "HTTP/1.1 ")
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			// Send a RST rather than a FIN
+			tcp.SetLinger(0)
+		}
+		conn.Close()
+		return
+	case fault.truncate:
+		conn, _, _ := http.NewResponseController(w).Hijack()
+		fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s",
+			reply.status, http.StatusText(reply.status), len(reply.body), reply.body[:len(reply.body)/2])
+		conn.Close()
+		return
+	case fault.status != 0:
+		reply.status, reply.body = fault.status, "{}"
+		if fault.retryAfter != "" {
+			w.Header().Set("Retry-After", fault.retryAfter)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(reply.status)
+	io.WriteString(w, reply.body)
+}
+
+// inject makes the next calls of method fail, one fault each, after which
+// it answers as before.
+func (m *mockGreenAPI) inject(method string, faults ...mockFault) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.faults[method] = append(m.faults[method], faults...)
+}
+
+// reply makes method answer with status and body.
+func (m *mockGreenAPI) reply(method string, status int, body string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.replies[method] = mockReply{status: status, body: body}
+}
+
+// stall makes method answer only after delay.
+func (m *mockGreenAPI) stall(method string, delay time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.replies[method] = mockReply{status: http.StatusOK, body: defaultReplies[method], delay: delay}
+}
+
+// callsOf returns the calls made to method, oldest first.
+func (m *mockGreenAPI) callsOf(method string) []mockCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var calls []mockCall
+	for _, call := range m.calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// newTestApp builds the App from the command line args, on memory storage
+// and with GREEN-API calls going to upstream, and registers its routes.
+// Calls aren't retried and never open the circuit breaker, so error cases
+// answer at once and don't leak into other tests.
+func newTestApp(t testing.TB, upstream *mockGreenAPI, args ...string) *App {
+	t.Helper()
+	c := defaultConfig()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	c.register(fs)
+	defaults := []string{
+		"-upstream-url", upstream.URL,
+		"-api-keys", "ops:admin:" + testAdminKey + ",viewer:viewer:" + testViewerKey,
+		"-webhook-token", testWebhookToken,
+		"-files-dir", t.TempDir(),
+		"-retries", "0",
+		"-breaker-threshold", "0",
+	}
+	if err := fs.Parse(append(defaults, args...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.resolve(); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := newApp(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.close() })
+	a.routes()
+	return a
+}
+
+// apiRequest is a JSON request made with the admin API key.
+func apiRequest(method, target, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-API-Key", testAdminKey)
+	return r
+}
+
+// keyRequest is a JSON request made with the API key.
+func keyRequest(key, method, target, body string) *http.Request {
+	r := apiRequest(method, target, body)
+	r.Header.Set("X-API-Key", key)
+	return r
+}
+
+// serve runs r through the App's middleware and routes.
+func serve(a *App, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, r)
+	return w
+}
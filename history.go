@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// HistoryEntry records one upstream GREEN-API call. Request and Response
+// hold JSON bodies as they are and other text as a JSON string. A body over
+// -history-max-body is cut and kept as a string, binary bodies such as
+// media uploads aren't kept at all; both set the Truncated flag.
+type HistoryEntry struct {
+	ID                int64           `json:"id"`
+	Time              time.Time       `json:"time"`
+	Method            string          `json:"method"`
+	HTTPMethod        string          `json:"httpMethod"`
+	URL               string          `json:"url"`
+	Status            int             `json:"status"`
+	Duration          string          `json:"duration"`
+	Error             string          `json:"error,omitempty"`
+	Request           json.RawMessage `json:"request,omitempty"`
+	RequestSize       int             `json:"requestSize,omitempty"`
+	RequestTruncated  bool            `json:"requestTruncated,omitempty"`
+	Response          json.RawMessage `json:"response,omitempty"`
+	ResponseSize      int             `json:"responseSize,omitempty"`
+	ResponseTruncated bool            `json:"responseTruncated,omitempty"`
+	Workspace         string          `json:"workspace,omitempty"`
+}
+
+// HistoryStore keeps upstream calls. Entries get their ID when added.
+type HistoryStore interface {
+	add(entry HistoryEntry) error
+	get(id int64) (HistoryEntry, bool, error)
+	// list returns up to limit entries workspace may see, newest first,
+	// all of them when limit is 0.
+	list(workspace string, limit int) ([]HistoryEntry, error)
+	// restore appends imported entries with fresh ids, optionally dropping
+	// the history workspace may see first.
+	restore(workspace string, entries []HistoryEntry, replace bool) (int, error)
+	// prune drops entries made before before, unless it is zero, and all
+	// but the newest keep, unless it is 0.
+	prune(before time.Time, keep int) (int, error)
+	delete(id int64) (bool, error)
+}
+
+// newHistoryEntry describes one finished upstream call. request is the
+// request body, known is false when it couldn't be read without consuming
+// it.
+func (a *App) newHistoryEntry(workspace, method, verb, apiUrl, contentType string, request []byte, known bool, status int, response []byte, err error, duration time.Duration) HistoryEntry {
+	entry := HistoryEntry{
+		Workspace:    workspace,
+		Time:         time.Now(),
+		Method:       method,
+		HTTPMethod:   verb,
+		URL:          maskToken(apiUrl),
+		Status:       status,
+		Duration:     duration.String(),
+		RequestSize:  len(request),
+		ResponseSize: len(response),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if known {
+		entry.Request, entry.RequestTruncated = a.captureBody(request, !textContent(contentType))
+	} else {
+		entry.RequestTruncated = contentType != ""
+	}
+	entry.Response, entry.ResponseTruncated = a.captureBody(response, !utf8.Valid(response))
+	return entry
+}
+
+// captureBody returns what history keeps of a body and whether that is
+// less than all of it.
+func (a *App) captureBody(data []byte, binary bool) (json.RawMessage, bool) {
+	if len(data) == 0 {
+		return nil, false
+	}
+	if binary {
+		return nil, true
+	}
+
+	if limit := a.config.HistoryMaxBody; limit > 0 && len(data) > limit {
+		cut := data[:limit]
+		// Don't split a multi-byte character
+		for len(cut) > 0 && !utf8.Valid(cut) {
+			cut = cut[:len(cut)-1]
+		}
+		quoted, _ := json.Marshal(string(cut))
+		return quoted, true
+	}
+	if json.Valid(data) {
+		return json.RawMessage(data), false
+	}
+	quoted, _ := json.Marshal(string(data))
+	return quoted, false
+}
+
+// textContent reports whether a body of contentType is text worth keeping.
+func textContent(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "json") ||
+		mediaType == "application/x-www-form-urlencoded"
+}
+
+// peekBody returns a request body without consuming it, for the readers
+// requests are built from. It reports false for other readers.
+func peekBody(body io.Reader) ([]byte, bool) {
+	switch b := body.(type) {
+	case nil:
+		return nil, true
+	case *bytes.Buffer:
+		return b.Bytes(), true
+	case *bytes.Reader:
+		data := make([]byte, b.Len())
+		b.ReadAt(data, b.Size()-int64(b.Len()))
+		return data, true
+	case *strings.Reader:
+		data := make([]byte, b.Len())
+		b.ReadAt(data, b.Size()-int64(b.Len()))
+		return data, true
+	}
+	return nil, false
+}
+
+// importedEntry prepares an archived entry for restore. Entries imported
+// into a workspace are moved to it.
+func importedEntry(workspace string, entry HistoryEntry) HistoryEntry {
+	if workspace != "" {
+		entry.Workspace = workspace
+	}
+	entry.URL = maskToken(entry.URL)
+	return entry
+}
+
+// historyInstances returns the idInstance values seen in the history of
+// workspace, most recently used first.
+func (a *App) historyInstances(workspace string) ([]string, error) {
+	entries, err := a.history.list(workspace, 0)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var ids []string
+	for _, entry := range entries {
+		id := instanceID(entry.URL)
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// memoryHistory keeps the most recent upstream calls in a ring buffer.
+type memoryHistory struct {
+	mu      sync.Mutex
+	entries []HistoryEntry
+	limit   int
+	nextID  int64
+}
+
+func newMemoryHistory(limit int) *memoryHistory {
+	return &memoryHistory{limit: limit, nextID: 1}
+}
+
+// append adds an entry with the next id. The caller holds mu.
+func (h *memoryHistory) append(entry HistoryEntry) {
+	entry.ID = h.nextID
+	h.nextID++
+	if len(h.entries) >= max(h.limit, 1) {
+		h.entries = h.entries[1:]
+	}
+	h.entries = append(h.entries, entry)
+}
+
+func (h *memoryHistory) add(entry HistoryEntry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.append(entry)
+	return nil
+}
+
+func (h *memoryHistory) get(id int64) (HistoryEntry, bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := sort.Search(len(h.entries), func(i int) bool { return h.entries[i].ID >= id })
+	if i < len(h.entries) && h.entries[i].ID == id {
+		return h.entries[i], true, nil
+	}
+	return HistoryEntry{}, false, nil
+}
+
+func (h *memoryHistory) list(workspace string, limit int) ([]HistoryEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := make([]HistoryEntry, 0, len(h.entries))
+	for i := len(h.entries) - 1; i >= 0 && (limit <= 0 || len(entries) < limit); i-- {
+		if canSee(workspace, h.entries[i].Workspace) {
+			entries = append(entries, h.entries[i])
+		}
+	}
+	return entries, nil
+}
+
+func (h *memoryHistory) restore(workspace string, entries []HistoryEntry, replace bool) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if replace {
+		kept := h.entries[:0]
+		for _, entry := range h.entries {
+			if !canSee(workspace, entry.Workspace) {
+				kept = append(kept, entry)
+			}
+		}
+		h.entries = kept
+	}
+	for _, entry := range entries {
+		h.append(importedEntry(workspace, entry))
+	}
+	return len(entries), nil
+}
+
+func (h *memoryHistory) prune(before time.Time, keep int) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	drop := 0
+	for drop < len(h.entries) && ((keep > 0 && len(h.entries)-drop > keep) || h.entries[drop].Time.Before(before)) {
+		drop++
+	}
+	h.entries = h.entries[drop:]
+	return drop, nil
+}
+
+func (h *memoryHistory) delete(id int64) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := sort.Search(len(h.entries), func(i int) bool { return h.entries[i].ID >= id })
+	if i == len(h.entries) || h.entries[i].ID != id {
+		return false, nil
+	}
+	h.entries = slices.Delete(h.entries, i, i+1)
+	return true, nil
+}
+
+// redactedToken stands in for tokens that are not shown.
+const redactedToken = "••••••••"
+
+// maskToken hides the apiTokenInstance segment of a GREEN-API URL
+// (.../waInstance{id}/{method}/{token}/...).
+func maskToken(apiUrl string) string {
+	parts := strings.Split(apiUrl, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, "waInstance") && i+2 < len(parts) {
+			token, query, _ := strings.Cut(parts[i+2], "?")
+			if token != "" {
+				parts[i+2] = redactedToken
+				if query != "" {
+					parts[i+2] += "?" + query
+				}
+			}
+			break
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// instanceID extracts idInstance from a GREEN-API URL.
+func instanceID(apiUrl string) string {
+	for _, part := range strings.Split(apiUrl, "/") {
+		if id, ok := strings.CutPrefix(part, "waInstance"); ok {
+			unescaped, err := url.PathUnescape(id)
+			if err != nil {
+				return id
+			}
+			return unescaped
+		}
+	}
+	return ""
+}
+
+// DiffChange is a single difference between two JSON documents.
+type DiffChange struct {
+	Path string      `json:"path"`
+	Type string      `json:"type"`
+	A    interface{} `json:"a,omitempty"`
+	B    interface{} `json:"b,omitempty"`
+}
+
+// diffJSON walks both documents and reports added, removed and changed
+// values by JSON path.
+func diffJSON(path string, a, b interface{}) []DiffChange {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool)
+		for key := range a {
+			keys[key] = true
+		}
+		for key := range b {
+			keys[key] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+
+		var changes []DiffChange
+		for _, key := range sorted {
+			childPath := path + "." + key
+			aValue, inA := a[key]
+			bValue, inB := b[key]
+			switch {
+			case !inA:
+				changes = append(changes, DiffChange{Path: childPath, Type: "added", B: bValue})
+			case !inB:
+				changes = append(changes, DiffChange{Path: childPath, Type: "removed", A: aValue})
+			default:
+				changes = append(changes, diffJSON(childPath, aValue, bValue)...)
+			}
+		}
+		return changes
+
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		var changes []DiffChange
+		for i := 0; i < max(len(a), len(b)); i++ {
+			childPath := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= len(a):
+				changes = append(changes, DiffChange{Path: childPath, Type: "added", B: b[i]})
+			case i >= len(b):
+				changes = append(changes, DiffChange{Path: childPath, Type: "removed", A: a[i]})
+			default:
+				changes = append(changes, diffJSON(childPath, a[i], b[i])...)
+			}
+		}
+		return changes
+	}
+
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+	return []DiffChange{{Path: path, Type: "changed", A: a, B: b}}
+}
+
+// historySpec sorts history by time (newest first by default), method,
+// status or duration, and searches methods, URLs, errors and bodies.
+var historySpec = listSpec[HistoryEntry]{
+	sorts: map[string]func(a, b HistoryEntry) int{
+		"time":   byNumber(func(e HistoryEntry) int64 { return e.ID }),
+		"method": byString(func(e HistoryEntry) string { return e.Method }),
+		"status": byNumber(func(e HistoryEntry) int { return e.Status }),
+		"duration": byNumber(func(e HistoryEntry) time.Duration {
+			d, _ := time.ParseDuration(e.Duration)
+			return d
+		}),
+	},
+	text: func(e HistoryEntry) []string {
+		return []string{e.Method, e.HTTPMethod, e.URL, e.Error, string(e.Request), string(e.Response)}
+	},
+}
+
+func (a *App) historyHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseListQuery(w, r)
+	if !ok || !historySpec.check(w, r, &q, maxPageSize) {
+		return
+	}
+	entries, err := a.history.list(workspaceOf(r), 0)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	page, total := historySpec.apply(entries, q)
+	writeList(w, page, total)
+}
+
+func (a *App) historyEntryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "History id must be a number")
+		return
+	}
+
+	entry, ok, err := a.history.get(id)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if !ok || !canSee(workspaceOf(r), entry.Workspace) {
+		writeErrorf(w, r, http.StatusNotFound, "history_not_found", "History entry %d not found", id)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+// deleteHistoryHandler moves a history entry to the trash.
+func (a *App) deleteHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "History id must be a number")
+		return
+	}
+	entry, ok, err := a.history.get(id)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if !ok || !canSee(workspaceOf(r), entry.Workspace) {
+		writeErrorf(w, r, http.StatusNotFound, "history_not_found", "History entry %d not found", id)
+		return
+	}
+	err = a.moveToTrash(r, trashHistory, strconv.FormatInt(id, 10), entry.Workspace, entry, func() error {
+		_, err := a.history.delete(id)
+		return err
+	})
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) historyDiffHandler(w http.ResponseWriter, r *http.Request) {
+	var entries [2]HistoryEntry
+	for i, param := range []string{"a", "b"} {
+		id, err := strconv.ParseInt(r.URL.Query().Get(param), 10, 64)
+		if err != nil {
+			writeErrorf(w, r, http.StatusBadRequest, "invalid_request", "Query parameter %s must be a history id", param)
+			return
+		}
+		entry, ok, err := a.history.get(id)
+		if err != nil {
+			writeStorageError(w, r, err)
+			return
+		}
+		if !ok || !canSee(workspaceOf(r), entry.Workspace) {
+			writeErrorf(w, r, http.StatusNotFound, "history_not_found", "History entry %d not found", id)
+			return
+		}
+		entries[i] = entry
+	}
+
+	var documents [2]interface{}
+	for i, entry := range entries {
+		if len(entry.Response) > 0 {
+			json.Unmarshal(entry.Response, &documents[i])
+		}
+	}
+
+	changes := diffJSON("$", documents[0], documents[1])
+	if changes == nil {
+		changes = []DiffChange{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"a":       entries[0].ID,
+		"b":       entries[1].ID,
+		"equal":   len(changes) == 0,
+		"changes": changes,
+	})
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLanguage is used when neither the lang query parameter nor
+// Accept-Language names a supported language. Messages are written in it,
+// so it needs no catalog.
+const defaultLanguage = "en"
+
+// catalogs translate English messages, keyed by the message itself (or its
+// format string for messages with arguments).
+var catalogs = map[string]map[string]string{
+	"ru": {
+		// Pages
+		"Settings App":                       "Настройки GREEN-API",
+		"Settings":                           "Настройки",
+		"Response":                           "Ответ",
+		"Send a request to see the response": "Отправьте запрос, чтобы увидеть ответ",
+		"ID Instance:":                       "ID инстанса:",
+		"API Token Instance:":                "API токен инстанса:",
+		"Advanced":                           "Расширенные настройки",
+		"Extra headers (JSON):":              "Дополнительные заголовки (JSON):",
+		"Extra query params (JSON):":         "Дополнительные параметры запроса (JSON):",
+		"Get Settings":                       "Получить настройки",
+		"Get State Instance":                 "Получить состояние",
+		"Instance Overview":                  "Обзор инстанса",
+		"Phone Number (with country code):":  "Номер телефона (с кодом страны):",
+		"Message:":                           "Сообщение:",
+		"Send Message":                       "Отправить сообщение",
+		"File URL:":                          "URL файла:",
+		"Caption (optional, {{.Phone}} and {{.Date}} are filled in):": "Подпись (необязательно, {{.Phone}} и {{.Date}} подставляются):",
+		"Validate file before sending":                                "Проверить файл перед отправкой",
+		"Send File":                                                   "Отправить файл",
+		"Upload File:":                                                "Загрузить файл:",
+		"Send File Upload":                                            "Отправить загруженный файл",
+		"Send Voice Note":                                             "Отправить голосовое сообщение",
+		"Host File for URL":                                           "Разместить файл по ссылке",
+		"Raw Request":                                                 "Произвольный запрос",
+		"Method:":                                                     "Метод:",
+		"HTTP Method:":                                                "HTTP метод:",
+		"Auto":                                                        "Авто",
+		"Body (JSON):":                                                "Тело (JSON):",
+		"Broadcast to (one number per line):":                         "Рассылка (по одному номеру в строке):",
+		"used instead of the phone number field":                      "используется вместо поля номера телефона",
+		"Dry run: show what would be sent":                            "Пробный запуск: показать, что будет отправлено",
+		"Chat":                                                        "Чат",
+		"Message ID:":                                                 "ID сообщения:",
+		"Reaction (empty to remove):":                                 "Реакция (пусто — убрать):",
+		"Send Reaction":                                               "Отправить реакцию",
+		"Mark as Read":                                                "Отметить прочитанным",
+		"Typing time, seconds:":                                       "Время набора, секунд:",
+		"Show as recording audio":                                     "Показать запись аудио",
+		"Send Typing":                                                 "Показать набор текста",
+		"Sign in":                                                     "Вход",
+		"API key:":                                                    "API-ключ:",
+		"Remember me":                                                 "Запомнить меня",
+		"Invalid API key":                                             "Неверный API-ключ",
+		"Log out":                                                     "Выйти",
+		"Signed in as %s":                                             "Вы вошли как %s",
+		"Sign in with %s":                                             "Войти через %s",
+		"Sign-in failed":                                              "Не удалось войти",
+		"Sign-in expired, please try again":                           "Время входа истекло, попробуйте ещё раз",
+		"This account is not allowed to sign in":                      "Этой учётной записи вход запрещён",
+		"Profile":                                                     "Профиль",
+		"Display name:":                                               "Отображаемое имя:",
+		"Set Profile Name":                                            "Сменить имя профиля",
+		"Profile picture:":                                            "Фото профиля:",
+		"Set Profile Picture":                                         "Сменить фото профиля",
+		"Send Raw Request":                                            "Отправить запрос",
+		"Failed to format the response":                               "Ошибка форматирования ответа",
+		"Request failed":                                              "Ошибка запроса",
+		"Choose a file to send":                                       "Выберите файл для отправки",
+		"File upload failed":                                          "Ошибка загрузки файла",
+		"Please enter a valid URL":                                    "Введите корректный URL",
+		"Please enter a valid phone number (digits only, 11-15 characters)": "Введите корректный номер телефона (только цифры, 11-15 символов)",
+		"Please enter a valid group ID":                                     "Введите корректный ID группы",
+		"Stats Dashboard":                                                   "Панель статистики",
+		"Statistics":                                                        "Статистика",
+		"Loading...":                                                        "Загрузка...",
+		"Endpoints":                                                         "Эндпоинты",
+		"Route":                                                             "Маршрут",
+		"Requests":                                                          "Запросы",
+		"Errors":                                                            "Ошибки",
+		"GREEN-API Methods":                                                 "Методы GREEN-API",
+		"Method":                                                            "Метод",
+		"Calls":                                                             "Вызовы",
+		"Success Rate":                                                      "Успешность",
+		"Avg Latency":                                                       "Средняя задержка",
+		"Latency distribution":                                              "Распределение задержек",
+		"Upstream errors by instance":                                       "Ошибки GREEN-API по инстансам",
+		"Instance":                                                          "Инстанс",
+		"Last 24 hours":                                                     "За 24 часа",
+		"Uptime":                                                            "Время работы",
+		"Messages sent today":                                               "Отправлено сообщений сегодня",
+		"Active streams":                                                    "Активные потоки",
+		"Failed to load statistics":                                         "Не удалось загрузить статистику",
+		"← Back":                                                            "← Назад",
+		"ffmpeg not found, original file sent":                              "ffmpeg не найден, отправлен исходный файл",
+		"up to %d MB":                                                       "до %d МБ",
+		"ffmpeg is not installed, voice notes are sent without conversion": "ffmpeg не установлен, голосовые сообщения отправляются без конвертации",
+		"File uploads require JavaScript":                                  "Для загрузки файлов нужен JavaScript",
+		"Recent requests":                                                  "Последние запросы",
+		"No requests yet":                                                  "Запросов пока нет",
+		"Time":                                                             "Время",
+		"Status":                                                           "Статус",
+		"Duration":                                                         "Длительность",
+
+		// API errors
+		"Method not allowed":     "Метод не поддерживается",
+		"Invalid request body":   "Некорректное тело запроса",
+		"Phone number too short": "Номер телефона слишком короткий",
+		"File URL is required":   "Укажите URL файла",
+		"Invalid file URL":       "Некорректный URL файла",
+		"Invalid URL":            "Некорректный URL",
+		"No schema for /api/%s":  "Нет схемы для /api/%s",
+		"GREEN-API is unreachable, the message is queued and will be sent when it answers again": "GREEN-API недоступен, сообщение поставлено в очередь и будет отправлено, когда он снова ответит",
+		"Scheduled sends":                                     "Запланированные отправки",
+		"format must be html or pdf":                          "format должен быть html или pdf",
+		"No stored messages for chat %s":                      "Нет сохранённых сообщений чата %s",
+		"wkhtmltopdf not found, export as html instead":       "wkhtmltopdf не найден, экспортируйте в html",
+		"wkhtmltopdf failed: %s":                              "Ошибка wkhtmltopdf: %s",
+		"Exported":                                            "Экспортировано",
+		"Messages":                                            "Сообщения",
+		"Trash item ID must be a number":                      "ID элемента корзины должен быть числом",
+		"Trash item %d not found":                             "Элемент корзины %d не найден",
+		"%s %s exists again, delete it first":                 "%s %s уже создан заново, сначала удалите его",
+		"Instance %s has no desired settings":                 "У инстанса %s нет желаемых настроек",
+		"Preset names are lowercase letters, digits, - and _": "Имя пресета — строчные латинские буквы, цифры, - и _",
+		"A preset needs a JSON object of settings":            "Пресету нужен JSON-объект настроек",
+		"Setting %s must be a string, number or boolean":      "Настройка %s должна быть строкой, числом или логическим значением",
+		"Preset %s not found":                                 "Пресет %s не найден",
+		"idInstance and apiTokenInstance are required":        "Укажите idInstance и apiTokenInstance",
+		"idInstance can't be changed":                         "idInstance нельзя изменить",
+		"Instance %s already exists":                          "Инстанс %s уже существует",
+		"Instance %s not found":                               "Инстанс %s не найден",
+		"Instance %s is configured by flags or Vault and can't be changed here": "Инстанс %s задан флагами или в Vault, здесь его изменить нельзя",
+		"Internal server error":                                        "Внутренняя ошибка сервера",
+		"Too many requests, slow down":                                 "Слишком много запросов, помедленнее",
+		"Type must be one of: %s":                                      "Тип должен быть одним из: %s",
+		"idInstance must be a number":                                  "idInstance должен быть числом",
+		"File not found or expired":                                    "Файл не найден или срок его хранения истёк",
+		"Invalid caption: %v":                                          "Некорректная подпись: %v",
+		"Earlier sends to this chat did not finish in time, try again": "Предыдущие отправки в этот чат не завершились вовремя, повторите попытку",
+		"Job %s not found":                                             "Задача %s не найдена",
+		"Job %s is already running":                                    "Задача %s уже выполняется",
+		"Dead letter id must be a number":                              "id недоставленного сообщения должен быть числом",
+		"Dead letter %d not found":                                     "Недоставленное сообщение %d не найдено",
+		"Dead letters of kind %s cannot be retried":                    "Недоставленные сообщения вида %s нельзя отправить повторно",
+		"Retry failed: %v":                                             "Повторная попытка не удалась: %v",
+		"Too many %s in progress, try again shortly":                   "Слишком много выполняемых запросов (%s), повторите чуть позже",
+		"File URL must end in a file name":                             "URL файла должен оканчиваться именем файла",
+		"Webhook signature rejected: %v":                               "Подпись вебхука отклонена: %v",
+		"Invalid variables":                                            "Некорректные переменные",
+		"Query is required":                                            "Запрос обязателен",
+		"The range spans %d buckets, at most %d are allowed":           "Диапазон охватывает %d интервалов, допускается не больше %d",
+		"from must be before to":                                       "from должен быть раньше to",
+		"Query parameter %s must be an RFC 3339 time or a date":        "Параметр запроса %s должен быть временем RFC 3339 или датой",
+		"Granularity must be hour or day":                              "Гранулярность должна быть hour или day",
+		"Query parameter q is required":                                "Параметр запроса q обязателен",
+		"A chat has at most %d metadata keys":                          "У чата может быть не больше %d ключей метаданных",
+		"Metadata value %s is longer than %d characters":               "Значение метаданных %s длиннее %d символов",
+		"Metadata keys must not be empty":                              "Ключи метаданных не должны быть пустыми",
+		"Notes are longer than %d characters":                          "Заметки длиннее %d символов",
+		"Labels are lowercase letters, digits, - and _":                "Метки состоят из строчных букв, цифр, - и _",
+		"Shortcuts are lowercase letters, digits, - and _":             "Сокращения состоят из строчных букв, цифр, - и _",
+		"Canned reply /%s not found":                                   "Шаблон ответа /%s не найден",
+		"State must be open or closed":                                 "Состояние должно быть open или closed",
+		"Assigning conversations needs -api-keys or -oauth-provider":   "Для назначения диалогов нужен -api-keys или -oauth-provider",
+		"Conversation %s of instance %s not found":                     "Диалог %s инстанса %s не найден",
+		"Failed to shorten links: %v":                                  "Не удалось сократить ссылки: %v",
+		"Invalid message template: %v":                                 "Некорректный шаблон сообщения: %v",
+		"Message text is required":                                     "Укажите текст сообщения",
+		"File URL is not reachable: %v":                                "URL файла недоступен: %v",
+		"File URL returned status %d":                                  "URL файла вернул статус %d",
+		"File is %d MB, GREEN-API accepts files up to %d MB":           "Файл весит %d МБ, GREEN-API принимает файлы до %d МБ",
+		"Content type %q is not supported by WhatsApp":                 "Тип содержимого %q не поддерживается WhatsApp",
+		"File URL points to a web page, not a file":                    "URL файла указывает на веб-страницу, а не на файл",
+		"File exceeds the %d byte upload limit":                        "Файл превышает лимит загрузки в %d байт",
+		"Method name must contain letters only":                        "Имя метода должно состоять только из букв",
+		"%s takes a file upload, use its own form":                     "%s принимает загрузку файла, используйте его форму",
+		"%s is called with %s":                                         "%s вызывается методом %s",
+		"%s takes %d path parameters":                                  "%s принимает параметров пути: %d",
+		"HTTP method must be GET, POST or DELETE":                      "HTTP метод должен быть GET, POST или DELETE",
+		"Streaming not supported":                                      "Потоковая передача не поддерживается",
+		"Thumbnail not found":                                          "Миниатюра не найдена",
+		"Attachment not found":                                         "Вложение не найдено",
+		"Upload id is required":                                        "Укажите идентификатор загрузки",
+		"History id must be a number":                                  "Идентификатор записи истории должен быть числом",
+		"History entry %d not found":                                   "Запись истории %d не найдена",
+		"Batch %d not found":                                           "Рассылка %d не найдена",
+		"Invalid batch ID":                                             "Некорректный ID рассылки",
+		"Query parameter %s must be a history id":                      "Параметр запроса %s должен быть идентификатором записи истории",
+		"Invalid archive: %v":                                          "Некорректный архив: %v",
+		"Archive version %d is not supported, expected %d":             "Версия архива %d не поддерживается, ожидается %d",
+		"Import mode must be merge or replace":                         "Режим импорта должен быть merge или replace",
+		"Link expired":                                                 "Срок действия ссылки истёк",
+		"Invalid token":                                                "Некорректный токен",
+		"Webhook token does not match":                                 "Токен вебхука не совпадает",
+		"Profile name must be 1 to %d characters":                      "Имя профиля должно содержать от 1 до %d символов",
+		"Limit must be 1 to %d":                                        "Лимит должен быть от 1 до %d",
+		"Offset must not be negative":                                  "Смещение не может быть отрицательным",
+		"Query parameter %s must be a number":                          "Параметр запроса %s должен быть числом",
+		"Sort by one of: %s":                                           "Сортировка возможна по полям: %s",
+		"This list can't be sorted":                                    "Этот список нельзя сортировать",
+		"This list can't be searched":                                  "В этом списке нельзя искать",
+		"Unknown profile":                                              "Неизвестный профиль",
+		"Seconds must be 1 to %d":                                      "Длительность должна быть от 1 до %d секунд",
+		"A CPU profile is already being recorded":                      "Профиль CPU уже записывается",
+		"Profile picture must be a JPEG, PNG or GIF image":             "Фото профиля должно быть изображением JPEG, PNG или GIF",
+		"Typing time must be 1 to %d seconds":                          "Время набора должно быть от 1 до %d секунд",
+		"Message ID is required":                                       "Требуется ID сообщения",
+		"Poll %s not found":                                            "Опрос %s не найден",
+		"At most %d recipients per request":                            "Не более %d получателей в одном запросе",
+		"Broadcast cancelled":                                          "Рассылка отменена",
+		"Phone numbers are required":                                   "Укажите номера телефонов",
+		"At most %d numbers per check":                                 "Не более %d номеров за одну проверку",
+		"Check cancelled":                                              "Проверка отменена",
+		"Check WhatsApp":                                               "Проверить WhatsApp",
+		"%s opted out: %s":                                             "%s отказался от сообщений: %s",
+		"%s is not on the opt-out list":                                "%s нет в списке отказов",
+		"Scheduled send id must be a number":                           "Идентификатор отложенной отправки должен быть числом",
+		"No pending scheduled send %d":                                 "Нет ожидающей отложенной отправки %d",
+		"limit must be a positive number":                              "limit должен быть положительным числом",
+		"A valid API key is required":                                  "Требуется действующий API-ключ",
+		"The %s role is not allowed to do this, %s is required":        "Роли %s это запрещено, требуется %s",
+		"The %s feature is disabled":                                   "Функция %s отключена",
+		"This instance belongs to another workspace":                   "Этот инстанс принадлежит другому рабочему пространству",
+		"No feature %s":                                                "Нет функции %s",
+		"enabled must be true or false":                                "enabled должно быть true или false",
+		"GREEN-API did not respond to %s within %s":                    "GREEN-API не ответил на %s за %s",
+		"Failed to communicate with WhatsApp API":                      "Не удалось связаться с WhatsApp API",
+		"Storage is unavailable, try again later":                      "Хранилище недоступно, повторите попытку позже",
+		"Backup is from a newer version of this server":                "Резервная копия создана более новой версией сервера",
+		"Not a backup of this server":                                  "Это не резервная копия этого сервера",
+		"Backups cover every workspace":                                "Резервная копия охватывает все рабочие пространства",
+		"Backups need -storage sqlite:path":                            "Резервные копии доступны только с -storage sqlite:path",
+		"GREEN-API returned status %d":                                 "GREEN-API вернул статус %d",
+		"GREEN-API is temporarily unavailable — retry later":           "GREEN-API временно недоступен — повторите позже",
+		"GREEN-API rejected the request parameters — check the phone number, message and file URL":      "GREEN-API отклонил параметры запроса — проверьте номер телефона, сообщение и URL файла",
+		"Instance not authorized — scan the QR code in the GREEN-API console or check apiTokenInstance": "Инстанс не авторизован — отсканируйте QR-код в консоли GREEN-API или проверьте apiTokenInstance",
+		"Access denied — check idInstance and apiTokenInstance, the instance may be blocked or expired": "Доступ запрещён — проверьте idInstance и apiTokenInstance, инстанс может быть заблокирован или истёк",
+		"Too many requests — GREEN-API rate limit reached, retry later":                                 "Слишком много запросов — достигнут лимит GREEN-API, повторите позже",
+		"Quota exceeded on Developer plan — upgrade the plan or message only allowed numbers":           "Превышена квота тарифа Developer — смените тариф или пишите только на разрешённые номера",
+		"GREEN-API internal error — retry later or contact support":                                     "Внутренняя ошибка GREEN-API — повторите позже или обратитесь в поддержку",
+	},
+}
+
+// negotiateLanguage picks the response language from the lang query
+// parameter, then from Accept-Language by quality.
+func negotiateLanguage(r *http.Request) string {
+	if lang := normalizeLanguage(r.URL.Query().Get("lang")); lang != "" {
+		return lang
+	}
+
+	type candidate struct {
+		lang    string
+		quality float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(value, 64); err == nil {
+				quality = q
+			}
+		}
+		if lang := normalizeLanguage(tag); lang != "" && quality > 0 {
+			candidates = append(candidates, candidate{lang, quality})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+	if len(candidates) > 0 {
+		return candidates[0].lang
+	}
+	return defaultLanguage
+}
+
+// normalizeLanguage reduces a language tag such as ru-RU to its primary
+// subtag and returns it only if there is a catalog for it.
+func normalizeLanguage(tag string) string {
+	primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	if primary == defaultLanguage {
+		return primary
+	}
+	if _, ok := catalogs[primary]; ok {
+		return primary
+	}
+	return ""
+}
+
+// translate returns the message in the given language, falling back to
+// English when the catalog has no entry.
+func translate(lang, message string) string {
+	if translated, ok := catalogs[lang][message]; ok {
+		return translated
+	}
+	return message
+}
+
+// translatef translates a format string before applying its arguments.
+func translatef(lang, format string, args ...interface{}) string {
+	return fmt.Sprintf(translate(lang, format), args...)
+}
+
+// parsePage parses a page template. The "t" and "lang" template functions
+// are bound to the language negotiated for r.
+func (a *App) parsePage(r *http.Request, name string) (*template.Template, error) {
+	lang := negotiateLanguage(r)
+	return template.New(name).Funcs(template.FuncMap{
+		"t": func(message string) string {
+			return translate(lang, message)
+		},
+		"lang": func() string {
+			return lang
+		},
+		"base": func() string {
+			return a.config.BasePath
+		},
+	}).ParseFS(a.assetFS(templates), "templates/"+name)
+}
+
+// renderPage executes a page template with its view model.
+func (a *App) renderPage(w http.ResponseWriter, r *http.Request, status int, name string, data interface{}) {
+	tmpl, err := a.parsePage(r, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Language", negotiateLanguage(r))
+	w.Header().Add("Vary", "Accept-Language")
+	w.WriteHeader(status)
+	tmpl.Execute(w, data)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Conversation states.
+const (
+	conversationOpen   = "open"
+	conversationClosed = "closed"
+)
+
+// Conversation is a chat in the inbox: open while it waits for an answer,
+// and assigned to whoever is answering it.
+type Conversation struct {
+	IDInstance    string    `json:"idInstance"`
+	ChatID        string    `json:"chatId"`
+	State         string    `json:"state"`
+	Assignee      string    `json:"assignee,omitempty"`
+	LastMessage   string    `json:"lastMessage,omitempty"`
+	LastMessageAt time.Time `json:"lastMessageAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+	UpdatedBy     string    `json:"updatedBy,omitempty"`
+}
+
+// ConversationStore keeps conversations by instance and chat.
+type ConversationStore interface {
+	get(idInstance, chatId string) (Conversation, bool, error)
+	put(conversation Conversation) error
+	list() ([]Conversation, error)
+}
+
+// Inbox turns incoming messages into conversations that can be assigned
+// and closed, a minimal help desk over the chats of the instances.
+type Inbox struct {
+	// mu keeps a webhook and an update of the same conversation from
+	// overwriting each other.
+	mu    sync.Mutex
+	store ConversationStore
+}
+
+// received opens a conversation for an incoming message, reopening it when
+// it was closed. Its assignee is kept.
+func (i *Inbox) received(notification Notification) {
+	if notification.TypeWebhook != "incomingMessageReceived" || notification.ChatID == "" {
+		return
+	}
+	idInstance := strconv.FormatInt(notification.IDInstance, 10)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	conversation, ok, err := i.store.get(idInstance, notification.ChatID)
+	if err != nil {
+		errorf("Failed to read conversation %s of instance %s: %v", notification.ChatID, idInstance, err)
+		return
+	}
+	if !ok {
+		conversation = Conversation{IDInstance: idInstance, ChatID: notification.ChatID, UpdatedAt: notification.ReceivedAt}
+	}
+	if conversation.State != conversationOpen {
+		conversation.State = conversationOpen
+		conversation.UpdatedAt = notification.ReceivedAt
+		conversation.UpdatedBy = ""
+	}
+	conversation.LastMessage = notification.Text
+	conversation.LastMessageAt = notification.ReceivedAt
+	if err := i.store.put(conversation); err != nil {
+		errorf("Failed to save conversation %s of instance %s: %v", notification.ChatID, idInstance, err)
+	}
+}
+
+// update changes a conversation, reporting false when there is none.
+func (i *Inbox) update(idInstance, chatId string, change func(*Conversation)) (Conversation, bool, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	conversation, ok, err := i.store.get(idInstance, chatId)
+	if err != nil || !ok {
+		return conversation, ok, err
+	}
+	change(&conversation)
+	conversation.UpdatedAt = time.Now()
+	return conversation, true, i.store.put(conversation)
+}
+
+// inboxSpec sorts conversations by their last message (newest first by
+// default), last update or chat, and searches their chats, assignees and
+// last messages.
+var inboxSpec = listSpec[Conversation]{
+	sorts: map[string]func(a, b Conversation) int{
+		"time":    byNumber(func(c Conversation) int64 { return c.LastMessageAt.UnixNano() }),
+		"updated": byNumber(func(c Conversation) int64 { return c.UpdatedAt.UnixNano() }),
+		"chat":    byString(func(c Conversation) string { return c.ChatID }),
+	},
+	text: func(c Conversation) []string { return []string{c.ChatID, c.Assignee, c.LastMessage} },
+}
+
+// inboxHandler lists the conversations of the instances the caller may
+// see. ?state= keeps open or closed ones, ?assignee= those of a user, "me"
+// for the caller's own or "none" for unassigned ones.
+func (a *App) inboxHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseListQuery(w, r)
+	if !ok || !inboxSpec.check(w, r, &q, defaultPageSize) {
+		return
+	}
+	state := r.URL.Query().Get("state")
+	if state != "" && state != conversationOpen && state != conversationClosed {
+		writeError(w, r, http.StatusBadRequest, "invalid_state", "State must be open or closed")
+		return
+	}
+	assignee := r.URL.Query().Get("assignee")
+	if assignee == "me" {
+		assignee = actorOf(r).User
+	}
+
+	all, err := a.inbox.store.list()
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	workspace := workspaceOf(r)
+	conversations := slices.DeleteFunc(all, func(c Conversation) bool {
+		switch {
+		case !canSee(workspace, a.instanceWorkspace(c.IDInstance)):
+			return true
+		case state != "" && c.State != state:
+			return true
+		case assignee == "none":
+			return c.Assignee != ""
+		default:
+			return assignee != "" && c.Assignee != assignee
+		}
+	})
+	slices.SortStableFunc(conversations, func(a, b Conversation) int { return b.LastMessageAt.Compare(a.LastMessageAt) })
+
+	page, total := inboxSpec.apply(conversations, q)
+	writeList(w, page, total)
+}
+
+// updateConversationHandler closes or reopens a conversation and assigns
+// it. An assignee of "me" is the caller and "" unassigns; assigning needs
+// auth, so there are users to assign to.
+func (a *App) updateConversationHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		State    *string `json:"state"`
+		Assignee *string `json:"assignee"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	if state := requestBody.State; state != nil && *state != conversationOpen && *state != conversationClosed {
+		writeError(w, r, http.StatusBadRequest, "invalid_state", "State must be open or closed")
+		return
+	}
+	if requestBody.Assignee != nil {
+		if !a.authEnabled() {
+			writeError(w, r, http.StatusBadRequest, "auth_disabled", "Assigning conversations needs -api-keys or -oauth-provider")
+			return
+		}
+		assignee := strings.TrimSpace(*requestBody.Assignee)
+		if assignee == "me" {
+			assignee = actorOf(r).User
+		}
+		requestBody.Assignee = &assignee
+	}
+
+	idInstance, chatId := r.PathValue("idInstance"), r.PathValue("chatId")
+	if !canSee(workspaceOf(r), a.instanceWorkspace(idInstance)) {
+		writeErrorf(w, r, http.StatusNotFound, "not_found", "Conversation %s of instance %s not found", chatId, idInstance)
+		return
+	}
+	conversation, ok, err := a.inbox.update(idInstance, chatId, func(c *Conversation) {
+		if requestBody.State != nil {
+			c.State = *requestBody.State
+		}
+		if requestBody.Assignee != nil {
+			c.Assignee = *requestBody.Assignee
+		}
+		c.UpdatedBy = actorName(r)
+	})
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if !ok {
+		writeErrorf(w, r, http.StatusNotFound, "not_found", "Conversation %s of instance %s not found", chatId, idInstance)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversation)
+}
+
+// memoryConversations keeps conversations in a map, so they are forgotten
+// on restart.
+type memoryConversations struct {
+	conversations *SyncMap[string, Conversation]
+}
+
+func newMemoryConversations() *memoryConversations {
+	return &memoryConversations{conversations: newSyncMap[string, Conversation]()}
+}
+
+func (s *memoryConversations) get(idInstance, chatId string) (Conversation, bool, error) {
+	conversation, ok := s.conversations.get(chatKey(idInstance, chatId))
+	return conversation, ok, nil
+}
+
+func (s *memoryConversations) put(conversation Conversation) error {
+	s.conversations.set(chatKey(conversation.IDInstance, conversation.ChatID), conversation)
+	return nil
+}
+
+func (s *memoryConversations) list() ([]Conversation, error) {
+	return s.conversations.values(), nil
+}
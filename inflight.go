@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// In-flight classes. Routes that hold memory or GREEN-API quota while they
+// run name one, and at most -max-in-flight of its requests run at once.
+const (
+	inFlightUploads = "uploads"
+	inFlightSends   = "sends"
+)
+
+var inFlightClasses = []string{inFlightSends, inFlightUploads}
+
+// inFlightRetryAfter is the Retry-After, in seconds, of a request turned
+// away because its class is full. Slots free up as soon as a request ends.
+const inFlightRetryAfter = 1
+
+// inFlightLimits maps an in-flight class to how many of its requests may
+// run at once, set as class=n pairs, e.g. uploads=5,sends=50. 0 means no
+// limit.
+type inFlightLimits map[string]int
+
+func defaultInFlightLimits() inFlightLimits {
+	return inFlightLimits{inFlightUploads: 5, inFlightSends: 50}
+}
+
+func (l inFlightLimits) String() string {
+	pairs := make([]string, 0, len(l))
+	for class, limit := range l {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", class, limit))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (l inFlightLimits) Set(value string) error {
+	for _, pair := range splitList(value) {
+		class, rawLimit, ok := strings.Cut(pair, "=")
+		class = strings.TrimSpace(class)
+		if !ok || !slices.Contains(inFlightClasses, class) {
+			return fmt.Errorf("invalid in-flight limit %q, expected %s=n", pair, strings.Join(inFlightClasses, " or "))
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(rawLimit))
+		if err != nil || limit < 0 {
+			return fmt.Errorf("invalid in-flight limit for %s, expected a number of requests, 0 for no limit", class)
+		}
+		l[class] = limit
+	}
+	return nil
+}
+
+// InFlight counts the running requests of each class, and those turned
+// away because it was full.
+type InFlight struct {
+	mu       sync.Mutex
+	running  map[string]int
+	rejected map[string]int
+	// config returns the live config.
+	config func() *Config
+}
+
+func newInFlight() *InFlight {
+	return &InFlight{running: make(map[string]int), rejected: make(map[string]int)}
+}
+
+// acquire takes a slot of class, or reports that all are taken. The limit
+// is read on every call, so a reload applies to the next request.
+func (f *InFlight) acquire(class string) bool {
+	limit := f.config().InFlight[class]
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if limit > 0 && f.running[class] >= limit {
+		f.rejected[class]++
+		return false
+	}
+	f.running[class]++
+	return true
+}
+
+func (f *InFlight) release(class string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.running[class]--
+}
+
+// counts returns copies of the running and rejected requests by class.
+func (f *InFlight) counts() (running, rejected map[string]int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return maps.Clone(f.running), maps.Clone(f.rejected)
+}
+
+// withInFlightLimit answers 503 with a Retry-After while class has as many
+// requests running as -max-in-flight allows.
+func (a *App) withInFlightLimit(class string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.inFlight.acquire(class) {
+			writeErrorBody(w, ErrorBody{
+				Code:       "overloaded",
+				Message:    translatef(negotiateLanguage(r), "Too many %s in progress, try again shortly", class),
+				Status:     http.StatusServiceUnavailable,
+				RetryAfter: inFlightRetryAfter,
+			})
+			return
+		}
+		defer a.inFlight.release(class)
+		next(w, r)
+	}
+}
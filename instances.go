@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// instanceTestTimeout bounds the getStateInstance call testing an instance.
+const instanceTestTimeout = 10 * time.Second
+
+// ManagedInstance is an instance added through /api/admin/instances rather
+// than -instances, the secrets file or Vault.
+type ManagedInstance struct {
+	Instance
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// InstanceStore keeps managed instances by idInstance.
+type InstanceStore interface {
+	list() ([]ManagedInstance, error)
+	put(instance ManagedInstance) error
+	delete(idInstance string) (bool, error)
+}
+
+// InstanceProfiles adds the managed instances to the configured ones, so new
+// test instances need neither a config change nor a restart. They are kept
+// in -storage, where tokens are stored as is.
+type InstanceProfiles struct {
+	store InstanceStore
+
+	mu sync.Mutex
+	// managed is replaced on every change, never modified.
+	managed []ManagedInstance
+}
+
+// load reads the managed instances from the store, e.g. after a restore.
+func (p *InstanceProfiles) load() error {
+	managed, err := p.store.list()
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.managed = managed
+	return nil
+}
+
+func (p *InstanceProfiles) list() []ManagedInstance {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.managed
+}
+
+func (p *InstanceProfiles) get(idInstance string) (ManagedInstance, bool) {
+	for _, instance := range p.list() {
+		if instance.IDInstance == idInstance {
+			return instance, true
+		}
+	}
+	return ManagedInstance{}, false
+}
+
+// put adds or replaces a managed instance.
+func (p *InstanceProfiles) put(instance ManagedInstance) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.store.put(instance); err != nil {
+		return err
+	}
+	managed := slices.DeleteFunc(slices.Clone(p.managed), func(m ManagedInstance) bool {
+		return m.IDInstance == instance.IDInstance
+	})
+	p.managed = append(managed, instance)
+	return nil
+}
+
+func (p *InstanceProfiles) delete(idInstance string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ok, err := p.store.delete(idInstance)
+	if err != nil || !ok {
+		return ok, err
+	}
+	p.managed = slices.DeleteFunc(slices.Clone(p.managed), func(m ManagedInstance) bool {
+		return m.IDInstance == idInstance
+	})
+	return true, nil
+}
+
+// staticInstance reports whether idInstance is configured other than
+// through the admin API.
+func (a *App) staticInstance(idInstance string) bool {
+	return slices.ContainsFunc(a.liveConfig().Instances, func(instance Instance) bool {
+		return instance.IDInstance == idInstance
+	})
+}
+
+// instanceView is how /api/admin/instances lists an instance, without its
+// token.
+type instanceView struct {
+	IDInstance string `json:"idInstance"`
+	Workspace  string `json:"workspace,omitempty"`
+	// Managed is whether the instance can be changed through the admin API.
+	Managed   bool       `json:"managed"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+func viewManaged(instance ManagedInstance) instanceView {
+	return instanceView{
+		IDInstance: instance.IDInstance,
+		Workspace:  instance.Workspace,
+		Managed:    true,
+		CreatedAt:  &instance.CreatedAt,
+		UpdatedAt:  &instance.UpdatedAt,
+	}
+}
+
+func (a *App) adminInstancesHandler(w http.ResponseWriter, r *http.Request) {
+	workspace := workspaceOf(r)
+	list := []instanceView{}
+	for _, instance := range a.configuredInstances() {
+		if !canSee(workspace, instance.Workspace) {
+			continue
+		}
+		if managed, ok := a.instanceProfiles.get(instance.IDInstance); ok && !a.staticInstance(instance.IDInstance) {
+			list = append(list, viewManaged(managed))
+		} else {
+			list = append(list, instanceView{IDInstance: instance.IDInstance, Workspace: instance.Workspace})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].IDInstance < list[j].IDInstance })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// instanceRequest is the body creating or changing a managed instance.
+// Callers in a workspace add instances to theirs.
+type instanceRequest struct {
+	IDInstance       string  `json:"idInstance"`
+	APITokenInstance string  `json:"apiTokenInstance"`
+	Workspace        *string `json:"workspace"`
+}
+
+func decodeInstanceRequest(w http.ResponseWriter, r *http.Request) (instanceRequest, bool) {
+	var requestBody instanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return requestBody, false
+	}
+	if requestBody.Workspace != nil && !canSee(workspaceOf(r), *requestBody.Workspace) {
+		writeError(w, r, http.StatusForbidden, "forbidden_instance", "This instance belongs to another workspace")
+		return requestBody, false
+	}
+	return requestBody, true
+}
+
+func (a *App) createInstanceHandler(w http.ResponseWriter, r *http.Request) {
+	requestBody, ok := decodeInstanceRequest(w, r)
+	if !ok {
+		return
+	}
+	if requestBody.IDInstance == "" || requestBody.APITokenInstance == "" {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "idInstance and apiTokenInstance are required")
+		return
+	}
+	if slices.ContainsFunc(a.configuredInstances(), func(instance Instance) bool {
+		return instance.IDInstance == requestBody.IDInstance
+	}) {
+		writeErrorf(w, r, http.StatusConflict, "instance_exists", "Instance %s already exists", requestBody.IDInstance)
+		return
+	}
+
+	now := time.Now()
+	instance := ManagedInstance{
+		Instance: Instance{
+			Workspace:        workspaceOf(r),
+			IDInstance:       requestBody.IDInstance,
+			APITokenInstance: requestBody.APITokenInstance,
+		},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if requestBody.Workspace != nil {
+		instance.Workspace = *requestBody.Workspace
+	}
+	if err := a.instanceProfiles.put(instance); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	infof("Instance %s added by %s", instance.IDInstance, actorName(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(viewManaged(instance))
+}
+
+// managedInstance looks up the managed instance of the request path,
+// writing the error when the caller can't change it.
+func (a *App) managedInstance(w http.ResponseWriter, r *http.Request) (ManagedInstance, bool) {
+	idInstance := r.PathValue("idInstance")
+	instance, ok := a.instanceProfiles.get(idInstance)
+	switch {
+	case a.staticInstance(idInstance) && canSee(workspaceOf(r), a.instanceWorkspace(idInstance)):
+		writeErrorf(w, r, http.StatusConflict, "instance_not_managed", "Instance %s is configured by flags or Vault and can't be changed here", idInstance)
+		return instance, false
+	case !ok || !canSee(workspaceOf(r), instance.Workspace):
+		writeErrorf(w, r, http.StatusNotFound, "not_found", "Instance %s not found", idInstance)
+		return instance, false
+	}
+	return instance, true
+}
+
+func (a *App) updateInstanceHandler(w http.ResponseWriter, r *http.Request) {
+	instance, ok := a.managedInstance(w, r)
+	if !ok {
+		return
+	}
+	requestBody, ok := decodeInstanceRequest(w, r)
+	if !ok {
+		return
+	}
+	if requestBody.IDInstance != "" && requestBody.IDInstance != instance.IDInstance {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "idInstance can't be changed")
+		return
+	}
+	if requestBody.APITokenInstance != "" {
+		instance.APITokenInstance = requestBody.APITokenInstance
+	}
+	if requestBody.Workspace != nil {
+		instance.Workspace = *requestBody.Workspace
+	}
+	instance.UpdatedAt = time.Now()
+	if err := a.instanceProfiles.put(instance); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	infof("Instance %s changed by %s", instance.IDInstance, actorName(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(viewManaged(instance))
+}
+
+func (a *App) deleteInstanceHandler(w http.ResponseWriter, r *http.Request) {
+	instance, ok := a.managedInstance(w, r)
+	if !ok {
+		return
+	}
+	if _, err := a.instanceProfiles.delete(instance.IDInstance); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	infof("Instance %s removed by %s", instance.IDInstance, actorName(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pathInstance looks up the configured instance of the request path,
+// writing a 404 when the caller can't see it.
+func (a *App) pathInstance(w http.ResponseWriter, r *http.Request) (Instance, bool) {
+	idInstance := r.PathValue("idInstance")
+	instances := a.configuredInstances()
+	index := slices.IndexFunc(instances, func(instance Instance) bool {
+		return instance.IDInstance == idInstance
+	})
+	if index < 0 || !canSee(workspaceOf(r), instances[index].Workspace) {
+		writeErrorf(w, r, http.StatusNotFound, "not_found", "Instance %s not found", idInstance)
+		return Instance{}, false
+	}
+	return instances[index], true
+}
+
+// InstanceTest is what calling getStateInstance with the stored credentials
+// of an instance gave.
+type InstanceTest struct {
+	IDInstance    string `json:"idInstance"`
+	OK            bool   `json:"ok"`
+	StateInstance string `json:"stateInstance,omitempty"`
+	Error         string `json:"error,omitempty"`
+	Duration      string `json:"duration"`
+}
+
+// testInstanceHandler checks that the credentials of any configured instance
+// work. A rejected token isn't an error of the request, so the outcome is
+// reported in the body.
+func (a *App) testInstanceHandler(w http.ResponseWriter, r *http.Request) {
+	instance, ok := a.pathInstance(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), instanceTestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	result := InstanceTest{IDInstance: instance.IDInstance}
+	response, _, err := a.makeAPIRequest(ctx, "getStateInstance", a.instanceURL(instance, "getStateInstance"))
+	if err != nil {
+		result.Error = upstreamErrorBody(r, err).Message
+	} else {
+		result.StateInstance, _ = response["stateInstance"].(string)
+		result.OK = true
+	}
+	result.Duration = time.Since(start).Round(time.Millisecond).String()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// actorName names the caller in the log.
+func actorName(r *http.Request) string {
+	if by := actorOf(r).User; by != "" {
+		return by
+	}
+	return r.RemoteAddr
+}
+
+// memoryInstances keeps managed instances in a map, so they are forgotten
+// on restart.
+type memoryInstances struct {
+	mu        sync.Mutex
+	instances map[string]ManagedInstance
+}
+
+func newMemoryInstances() *memoryInstances {
+	return &memoryInstances{instances: make(map[string]ManagedInstance)}
+}
+
+func (s *memoryInstances) list() ([]ManagedInstance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]ManagedInstance, 0, len(s.instances))
+	for _, instance := range s.instances {
+		list = append(list, instance)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list, nil
+}
+
+func (s *memoryInstances) put(instance ManagedInstance) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.instances[instance.IDInstance] = instance
+	return nil
+}
+
+func (s *memoryInstances) delete(idInstance string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.instances[idInstance]
+	delete(s.instances, idInstance)
+	return ok, nil
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jobsOff is the -job schedule switching a job off.
+const jobsOff = "off"
+
+// cronSchedule is when a job runs, from a five-field cron expression
+// (minute hour day-of-month month day-of-week), a @daily style shorthand or
+// @every with a duration.
+type cronSchedule struct {
+	every time.Duration
+	// Bit n is set when value n matches.
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny are set when the field is *; with both restricted
+	// a day matching either runs, as in cron.
+	domAny, dowAny bool
+}
+
+var cronShorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+func parseCron(spec string) (*cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if every, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(every))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid schedule %q: @every needs a positive duration", spec)
+		}
+		return &cronSchedule{every: d}, nil
+	}
+	if expr, ok := cronShorthands[spec]; ok {
+		spec = expr
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected minute hour day-of-month month day-of-week", spec)
+	}
+	s := &cronSchedule{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	var err error
+	for i, field := range []struct {
+		bits     *uint64
+		min, max int
+	}{{&s.minute, 0, 59}, {&s.hour, 0, 23}, {&s.dom, 1, 31}, {&s.month, 1, 12}, {&s.dow, 0, 7}} {
+		if *field.bits, err = parseCronField(fields[i], field.min, field.max); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+	}
+	// 7 is Sunday too
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseCronField reads a comma-separated list of *, n, a-b, each
+// optionally with a /step.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+		lo, hi := min, max
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return 0, fmt.Errorf("invalid value in %q", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// next is the first time after after the schedule matches, zero when it
+// never does within five years (e.g. 30 February).
+func (s *cronSchedule) next(after time.Time) time.Time {
+	if s.every > 0 {
+		return after.Add(s.every)
+	}
+	loc := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case s.month&(1<<t.Month()) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<t.Weekday()) != 0
+	switch {
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// jobSchedules are the -job overrides by job name.
+type jobSchedules map[string]string
+
+func (j jobSchedules) String() string {
+	names := make([]string, 0, len(j))
+	for name := range j {
+		names = append(names, name+"="+j[name])
+	}
+	sort.Strings(names)
+	return strings.Join(names, "; ")
+}
+
+func (j jobSchedules) Set(value string) error {
+	name, spec, ok := strings.Cut(value, "=")
+	name, spec = strings.TrimSpace(name), strings.TrimSpace(spec)
+	if !ok || name == "" {
+		return fmt.Errorf("expected name=schedule, got %q", value)
+	}
+	if spec != jobsOff {
+		if _, err := parseCron(spec); err != nil {
+			return err
+		}
+	}
+	j[name] = spec
+	return nil
+}
+
+// addJobs registers the built-in jobs. Each runs every interval of its
+// older flag unless -job gives it a schedule.
+func (a *App) addJobs() error {
+	builtin := []Job{
+		{Name: "scheduled-sends", Schedule: a.jobSpec("scheduled-sends", time.Minute), Run: a.scheduler.dispatchDue},
+		{Name: "prune", Schedule: a.jobSpec("prune", a.config.PruneInterval), Run: a.pruneJob},
+		{Name: "watch", Schedule: a.jobSpec("watch", a.config.WatchInterval), RunAtStart: true, Run: a.watcher.checkAll},
+		{Name: "drift", Schedule: a.jobSpec("drift", a.config.DriftInterval), Run: a.drift.checkAll},
+	}
+	if a.vaultEnabled() {
+		builtin = append(builtin, Job{Name: "vault", Schedule: a.jobSpec("vault", a.config.VaultRefresh), Run: a.vault.refreshJob})
+	}
+	for name := range a.config.Jobs {
+		if !slices.ContainsFunc(builtin, func(job Job) bool { return job.Name == name }) {
+			return fmt.Errorf("unknown job %s in -job", name)
+		}
+	}
+	for _, job := range builtin {
+		if err := a.jobs.add(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jobSpec is the schedule of a job: its -job override, or else every
+// interval, empty when the job is off.
+func (a *App) jobSpec(name string, interval time.Duration) string {
+	if spec, ok := a.config.Jobs[name]; ok {
+		if spec == jobsOff {
+			return ""
+		}
+		return spec
+	}
+	if interval <= 0 {
+		return ""
+	}
+	return "@every " + interval.String()
+}
+
+// JobRun is the outcome of a job's last run.
+type JobRun struct {
+	StartedAt time.Time `json:"startedAt"`
+	Duration  string    `json:"duration"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Job is background work run on a schedule.
+type Job struct {
+	Name     string
+	Schedule string
+	// RunAtStart runs the job once when the runner starts.
+	RunAtStart bool
+	Run        func(ctx context.Context) error
+
+	schedule *cronSchedule
+	next     time.Time
+	running  bool
+	last     *JobRun
+}
+
+// jobView is how /api/jobs lists a job.
+type jobView struct {
+	Name     string     `json:"name"`
+	Schedule string     `json:"schedule"`
+	Next     *time.Time `json:"next,omitempty"`
+	Running  bool       `json:"running"`
+	Last     *JobRun    `json:"last,omitempty"`
+}
+
+// JobRunner runs the background jobs: pruning, instance watching, drift
+// checks, picking up scheduled sends and Vault refreshes. A job still
+// running when it is due again is skipped that time.
+type JobRunner struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+	wake chan struct{}
+}
+
+func newJobRunner() *JobRunner {
+	return &JobRunner{jobs: make(map[string]*Job), wake: make(chan struct{}, 1)}
+}
+
+// add registers a job. Jobs without a schedule are left out.
+func (jr *JobRunner) add(job Job) error {
+	if job.Schedule == "" {
+		return nil
+	}
+	schedule, err := parseCron(job.Schedule)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", job.Name, err)
+	}
+	job.schedule = schedule
+	job.next = schedule.next(time.Now())
+	if job.RunAtStart {
+		job.next = time.Now()
+	}
+
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.jobs[job.Name] = &job
+	return nil
+}
+
+// run starts jobs as they come due until the context ends.
+func (jr *JobRunner) run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-jr.wake:
+		}
+
+		now := time.Now()
+		wait := time.Hour
+		jr.mu.Lock()
+		for _, job := range jr.jobs {
+			if !job.next.IsZero() && !job.next.After(now) {
+				if !job.running {
+					jr.start(ctx, job)
+				}
+				job.next = job.schedule.next(now)
+			}
+			if !job.next.IsZero() {
+				wait = min(wait, job.next.Sub(now))
+			}
+		}
+		jr.mu.Unlock()
+		timer.Reset(wait)
+	}
+}
+
+// start runs a job in the background. The caller holds mu.
+func (jr *JobRunner) start(ctx context.Context, job *Job) {
+	job.running = true
+	go func() {
+		started := time.Now()
+		err := job.Run(ctx)
+		run := &JobRun{StartedAt: started, Duration: time.Since(started).Round(time.Millisecond).String()}
+		if err != nil && ctx.Err() == nil {
+			run.Error = err.Error()
+			errorf("Job %s failed: %v", job.Name, err)
+		}
+
+		jr.mu.Lock()
+		defer jr.mu.Unlock()
+		job.running = false
+		job.last = run
+	}()
+}
+
+// trigger runs a job now, false when there is no such job or it is already
+// running.
+func (jr *JobRunner) trigger(name string) (found, started bool) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	job, ok := jr.jobs[name]
+	if !ok || job.running {
+		return ok, false
+	}
+	job.next = time.Now()
+	select {
+	case jr.wake <- struct{}{}:
+	default:
+	}
+	return true, true
+}
+
+func (jr *JobRunner) list() []jobView {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+
+	list := make([]jobView, 0, len(jr.jobs))
+	for _, job := range jr.jobs {
+		view := jobView{Name: job.Name, Schedule: job.Schedule, Running: job.running, Last: job.last}
+		if !job.next.IsZero() {
+			next := job.next
+			view.Next = &next
+		}
+		list = append(list, view)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (a *App) jobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.jobs.list())
+}
+
+// runJobHandler runs a job right away.
+func (a *App) runJobHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	found, started := a.jobs.trigger(name)
+	switch {
+	case !found:
+		writeErrorf(w, r, http.StatusNotFound, "not_found", "Job %s not found", name)
+	case !started:
+		writeErrorf(w, r, http.StatusConflict, "job_running", "Job %s is already running", name)
+	default:
+		infof("Job %s started by %s", name, actorName(r))
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// bodyTap counts a streamed response body and keeps its first bytes for
+// history: keep+1 of them so history can tell the body was cut, or all of
+// it when keep is 0.
+type bodyTap struct {
+	r    io.Reader
+	kept []byte
+	keep int
+	size int
+}
+
+func newBodyTap(r io.Reader, keep int) *bodyTap {
+	if keep <= 0 {
+		return &bodyTap{r: r}
+	}
+	return &bodyTap{r: r, keep: keep + 1, kept: make([]byte, 0, keep+1)}
+}
+
+func (t *bodyTap) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.size += n
+	if t.keep <= 0 {
+		t.kept = append(t.kept, p[:n]...)
+	} else if room := t.keep - len(t.kept); room > 0 {
+		t.kept = append(t.kept, p[:min(n, room)]...)
+	}
+	return n, err
+}
+
+// decodeArray reads the JSON array in r one element at a time, calling
+// each with the decoder positioned before the element. each must consume
+// the element.
+func decodeArray(r io.Reader, each func(*json.Decoder) error) error {
+	dec := json.NewDecoder(r)
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != json.Delim('[') {
+		return fmt.Errorf("expected an array, got %v", token)
+	}
+	for dec.More() {
+		if err := each(dec); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// ChatMessage is a message from getChatHistory. GREEN-API sends more fields
+// for some message types; these are the ones shown.
+type ChatMessage struct {
+	Type          string `json:"type"`
+	IDMessage     string `json:"idMessage"`
+	Timestamp     int64  `json:"timestamp"`
+	TypeMessage   string `json:"typeMessage"`
+	ChatID        string `json:"chatId"`
+	SenderID      string `json:"senderId,omitempty"`
+	SenderName    string `json:"senderName,omitempty"`
+	TextMessage   string `json:"textMessage,omitempty"`
+	Caption       string `json:"caption,omitempty"`
+	DownloadURL   string `json:"downloadUrl,omitempty"`
+	SavedURL      string `json:"savedUrl,omitempty"`
+	StatusMessage string `json:"statusMessage,omitempty"`
+}
+
+// chatHistorySpec only pages: GREEN-API returns the latest messages, so
+// sorting or searching them would only cover those fetched.
+var chatHistorySpec = listSpec[ChatMessage]{}
+
+// ChatHistoryPage is a page of a chat's messages, newest first.
+type ChatHistoryPage struct {
+	Messages []ChatMessage `json:"messages"`
+	Offset   int           `json:"offset"`
+	Limit    int           `json:"limit"`
+	More     bool          `json:"more"`
+}
+
+// linkAttachments points messages whose file was saved by the media
+// downloader at the saved copy.
+func (a *App) linkAttachments(idInstance string, messages []ChatMessage) {
+	if a.mediaDownloader.blobs == nil {
+		return
+	}
+	for i, message := range messages {
+		if message.DownloadURL == "" {
+			continue
+		}
+		if _, ok, _ := a.mediaDownloader.store.get(idInstance, message.IDMessage); ok {
+			messages[i].SavedURL = a.attachmentURL(idInstance, message.IDMessage)
+		}
+	}
+}
+
+// Contact is an entry of getContacts.
+type Contact struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	ContactName string `json:"contactName,omitempty"`
+	Type        string `json:"type"`
+}
+
+var contactsSpec = listSpec[Contact]{
+	sorts: map[string]func(a, b Contact) int{
+		"id":   byString(func(c Contact) string { return c.ID }),
+		"name": byString(func(c Contact) string { return cmp.Or(c.ContactName, c.Name) }),
+		"type": byString(func(c Contact) string { return c.Type }),
+	},
+	text: func(c Contact) []string { return []string{c.ID, c.Name, c.ContactName} },
+}
+
+// ContactsPage is a page of an instance's contacts.
+type ContactsPage struct {
+	Contacts []Contact `json:"contacts"`
+	Offset   int       `json:"offset"`
+	Limit    int       `json:"limit"`
+	Total    int       `json:"total"`
+	Sort     string    `json:"sort,omitempty"`
+	Search   string    `json:"q,omitempty"`
+}
+
+// ChatHistoryRequest is the body of /api/chat-history.
+type ChatHistoryRequest struct {
+	chatRequest
+	listQuery
+}
+
+// chatHistoryHandler returns a page of a chat's messages. GREEN-API only
+// takes a count, so the messages before the page are fetched and skipped.
+func (a *App) chatHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody ChatHistoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	// Validate phone number (simple validation)
+	if len(requestBody.PhoneNumber) < 11 {
+		writeError(w, r, http.StatusBadRequest, "invalid_phone_number", "Phone number too short")
+		return
+	}
+	if !chatHistorySpec.check(w, r, &requestBody.listQuery, defaultPageSize) {
+		return
+	}
+
+	ctx, err := withOverrides(r.Context(), requestBody.UpstreamOverrides)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "blocked_override", err.Error())
+		return
+	}
+	ctx, rs := newResponder(ctx)
+
+	apiUrl := a.methodURL("getChatHistory", requestBody.IDInstance, requestBody.APITokenInstance)
+	count := requestBody.Offset + requestBody.Limit
+	payload := map[string]interface{}{
+		"chatId": fmt.Sprintf("%s@c.us", requestBody.PhoneNumber),
+		"count":  count,
+	}
+	jsonPayload, _ := json.Marshal(payload)
+
+	page := ChatHistoryPage{Offset: requestBody.Offset, Limit: requestBody.Limit}
+	statusCode, err := a.streamAPIRequest(ctx, "getChatHistory", http.MethodPost, apiUrl, "application/json", bytes.NewReader(jsonPayload), func(body io.Reader) error {
+		total, err := chatHistorySpec.decode(body, requestBody.listQuery, &page.Messages)
+		page.More = total >= count
+		return err
+	})
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+	a.linkAttachments(requestBody.IDInstance, page.Messages)
+
+	rs.respond(w, APIResponse{
+		URL: apiUrl,
+		RequestBody: map[string]interface{}{
+			"phoneNumber":      requestBody.PhoneNumber,
+			"offset":           requestBody.Offset,
+			"limit":            requestBody.Limit,
+			"idInstance":       requestBody.IDInstance,
+			"apiTokenInstance": "••••••••", // Mask sensitive data
+		},
+		Response:   page,
+		StatusCode: statusCode,
+		Snippets:   snippetsFor(ctx, jsonCall(apiUrl, payload)),
+	})
+}
+
+// ContactsRequest is the body of /api/contacts.
+type ContactsRequest struct {
+	IDInstance       string `json:"idInstance" api:"required"`
+	APITokenInstance string `json:"apiTokenInstance" api:"required"`
+	listQuery
+	UpstreamOverrides
+}
+
+// contactsHandler returns a page of an instance's contacts.
+func (a *App) contactsHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody ContactsRequest
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	if !contactsSpec.check(w, r, &requestBody.listQuery, defaultPageSize) {
+		return
+	}
+
+	ctx, err := withOverrides(r.Context(), requestBody.UpstreamOverrides)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "blocked_override", err.Error())
+		return
+	}
+	ctx, rs := newResponder(ctx)
+
+	apiUrl := a.methodURL("getContacts", requestBody.IDInstance, requestBody.APITokenInstance)
+
+	page := ContactsPage{Offset: requestBody.Offset, Limit: requestBody.Limit, Sort: requestBody.Sort, Search: requestBody.Search}
+	statusCode, err := a.streamAPIRequest(ctx, "getContacts", http.MethodGet, apiUrl, "", nil, func(body io.Reader) (err error) {
+		page.Total, err = contactsSpec.decode(body, requestBody.listQuery, &page.Contacts)
+		return err
+	})
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+
+	rs.respond(w, APIResponse{
+		URL: apiUrl,
+		RequestBody: map[string]interface{}{
+			"offset":           requestBody.Offset,
+			"limit":            requestBody.Limit,
+			"sort":             requestBody.Sort,
+			"q":                requestBody.Search,
+			"idInstance":       requestBody.IDInstance,
+			"apiTokenInstance": "••••••••", // Mask sensitive data
+		},
+		Response:   page,
+		StatusCode: statusCode,
+		Snippets:   snippetsFor(ctx, UpstreamCall{Verb: http.MethodGet, URL: apiUrl}),
+	})
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// labelRuleActor is who labels applied by -label-rule are put down to.
+const labelRuleActor = "label-rule"
+
+// ChatLabels are the labels of a chat of an instance, e.g. lead or vip,
+// kept locally to sort chats by; WhatsApp knows nothing of them.
+type ChatLabels struct {
+	IDInstance string    `json:"idInstance"`
+	ChatID     string    `json:"chatId"`
+	Labels     []string  `json:"labels"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	UpdatedBy  string    `json:"updatedBy,omitempty"`
+}
+
+// LabelStore keeps the labels of chats by instance and chat. A chat without
+// labels isn't kept.
+type LabelStore interface {
+	get(idInstance, chatId string) (ChatLabels, bool, error)
+	put(labels ChatLabels) error
+	delete(idInstance, chatId string) error
+	list() ([]ChatLabels, error)
+}
+
+// Labeler labels chats, by hand through the API and by -label-rule as their
+// messages come in.
+type Labeler struct {
+	// mu keeps a rule and a request labelling the same chat from
+	// overwriting each other.
+	mu    sync.Mutex
+	store LabelStore
+	// config returns the live config.
+	config func() *Config
+}
+
+// labelRules are the -label-rule patterns by label.
+type labelRules map[string]*regexp.Regexp
+
+func (l labelRules) String() string {
+	rules := make([]string, 0, len(l))
+	for label, pattern := range l {
+		rules = append(rules, label+"="+pattern.String())
+	}
+	sort.Strings(rules)
+	return strings.Join(rules, "; ")
+}
+
+func (l labelRules) Set(value string) error {
+	label, expr, ok := strings.Cut(value, "=")
+	label = strings.TrimSpace(label)
+	if !ok || label == "" || expr == "" {
+		return fmt.Errorf("expected label=regexp, got %q", value)
+	}
+	if !presetNamePattern.MatchString(label) {
+		return fmt.Errorf("invalid label %q, labels are lowercase letters, digits, - and _", label)
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("invalid pattern of label %s: %w", label, err)
+	}
+	l[label] = pattern
+	return nil
+}
+
+// received applies the labels whose -label-rule matches the text of an
+// incoming message.
+func (l *Labeler) received(notification Notification) {
+	if notification.TypeWebhook != "incomingMessageReceived" || notification.ChatID == "" || notification.Text == "" {
+		return
+	}
+	var matched []string
+	for label, pattern := range l.config().LabelRules {
+		if pattern.MatchString(notification.Text) {
+			matched = append(matched, label)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+	idInstance := strconv.FormatInt(notification.IDInstance, 10)
+	_, err := l.update(idInstance, notification.ChatID, labelRuleActor, func(labels []string) []string {
+		return append(labels, matched...)
+	})
+	if err != nil {
+		errorf("Failed to label chat %s of instance %s: %v", notification.ChatID, idInstance, err)
+	}
+}
+
+// update changes the labels of a chat, sorting them and dropping
+// duplicates. Labels that don't change aren't written again.
+func (l *Labeler) update(idInstance, chatId, actor string, change func([]string) []string) (ChatLabels, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, ok, err := l.store.get(idInstance, chatId)
+	if err != nil {
+		return ChatLabels{}, err
+	}
+	if !ok {
+		current = ChatLabels{IDInstance: idInstance, ChatID: chatId, Labels: []string{}}
+	}
+	labels := change(slices.Clone(current.Labels))
+	slices.Sort(labels)
+	labels = slices.Compact(labels)
+	if labels == nil {
+		labels = []string{}
+	}
+	if slices.Equal(labels, current.Labels) {
+		return current, nil
+	}
+
+	updated := ChatLabels{IDInstance: idInstance, ChatID: chatId, Labels: labels, UpdatedAt: time.Now(), UpdatedBy: actor}
+	if len(labels) == 0 {
+		return updated, l.store.delete(idInstance, chatId)
+	}
+	return updated, l.store.put(updated)
+}
+
+// chatsSpec sorts labelled chats by chat or last change, and searches their
+// chats and labels.
+var chatsSpec = listSpec[ChatLabels]{
+	sorts: map[string]func(a, b ChatLabels) int{
+		"chat":    byString(func(c ChatLabels) string { return c.ChatID }),
+		"updated": byNumber(func(c ChatLabels) int64 { return c.UpdatedAt.UnixNano() }),
+	},
+	text: func(c ChatLabels) []string { return append([]string{c.ChatID}, c.Labels...) },
+}
+
+// chatsHandler lists the labelled chats of the instances the caller may
+// see. ?label= keeps those with a label and ?idInstance= those of an
+// instance.
+func (a *App) chatsHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseListQuery(w, r)
+	if !ok || !chatsSpec.check(w, r, &q, defaultPageSize) {
+		return
+	}
+	label := r.URL.Query().Get("label")
+	idInstance := r.URL.Query().Get("idInstance")
+
+	all, err := a.labeler.store.list()
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	workspace := workspaceOf(r)
+	chats := slices.DeleteFunc(all, func(c ChatLabels) bool {
+		switch {
+		case !canSee(workspace, a.instanceWorkspace(c.IDInstance)):
+			return true
+		case idInstance != "" && c.IDInstance != idInstance:
+			return true
+		default:
+			return label != "" && !slices.Contains(c.Labels, label)
+		}
+	})
+	slices.SortStableFunc(chats, func(a, b ChatLabels) int {
+		return strings.Compare(a.IDInstance+" "+a.ChatID, b.IDInstance+" "+b.ChatID)
+	})
+
+	page, total := chatsSpec.apply(chats, q)
+	writeList(w, page, total)
+}
+
+// setChatLabelsHandler replaces the labels of a chat; an empty list removes
+// them all.
+func (a *App) setChatLabelsHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		Labels []string `json:"labels"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	for _, label := range requestBody.Labels {
+		if !presetNamePattern.MatchString(label) {
+			writeError(w, r, http.StatusBadRequest, "invalid_label", "Labels are lowercase letters, digits, - and _")
+			return
+		}
+	}
+	a.changeChatLabels(w, r, func([]string) []string { return requestBody.Labels })
+}
+
+// addChatLabelHandler puts a label on a chat, which is fine when it has it
+// already.
+func (a *App) addChatLabelHandler(w http.ResponseWriter, r *http.Request) {
+	label := r.PathValue("label")
+	if !presetNamePattern.MatchString(label) {
+		writeError(w, r, http.StatusBadRequest, "invalid_label", "Labels are lowercase letters, digits, - and _")
+		return
+	}
+	a.changeChatLabels(w, r, func(labels []string) []string { return append(labels, label) })
+}
+
+// removeChatLabelHandler takes a label off a chat.
+func (a *App) removeChatLabelHandler(w http.ResponseWriter, r *http.Request) {
+	label := r.PathValue("label")
+	a.changeChatLabels(w, r, func(labels []string) []string {
+		return slices.DeleteFunc(labels, func(l string) bool { return l == label })
+	})
+}
+
+// chatOfPath reads the instance and chat of the request path, a bare phone
+// number standing for its personal chat. It writes a 404 when the caller
+// may not see the instance.
+func (a *App) chatOfPath(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	idInstance, chatId := r.PathValue("idInstance"), r.PathValue("chatId")
+	if !canSee(workspaceOf(r), a.instanceWorkspace(idInstance)) {
+		writeErrorf(w, r, http.StatusNotFound, "not_found", "Instance %s not found", idInstance)
+		return "", "", false
+	}
+	if !strings.Contains(chatId, "@") {
+		chatId += "@c.us"
+	}
+	return idInstance, chatId, true
+}
+
+func (a *App) changeChatLabels(w http.ResponseWriter, r *http.Request, change func([]string) []string) {
+	idInstance, chatId, ok := a.chatOfPath(w, r)
+	if !ok {
+		return
+	}
+	labels, err := a.labeler.update(idInstance, chatId, actorName(r), change)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	infof("Labels of chat %s of instance %s set to %v by %s", chatId, idInstance, labels.Labels, actorName(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(labels)
+}
+
+// memoryLabels keeps the labels of chats in a map, so they are forgotten
+// on restart.
+type memoryLabels struct {
+	labels *SyncMap[string, ChatLabels]
+}
+
+func newMemoryLabels() *memoryLabels {
+	return &memoryLabels{labels: newSyncMap[string, ChatLabels]()}
+}
+
+func (s *memoryLabels) get(idInstance, chatId string) (ChatLabels, bool, error) {
+	labels, ok := s.labels.get(chatKey(idInstance, chatId))
+	return labels, ok, nil
+}
+
+func (s *memoryLabels) put(labels ChatLabels) error {
+	s.labels.set(chatKey(labels.IDInstance, labels.ChatID), labels)
+	return nil
+}
+
+func (s *memoryLabels) delete(idInstance, chatId string) error {
+	s.labels.delete(chatKey(idInstance, chatId))
+	return nil
+}
+
+func (s *memoryLabels) list() ([]ChatLabels, error) {
+	return s.labels.values(), nil
+}
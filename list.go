@@ -0,0 +1,182 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Long lists are returned a page at a time.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// listQuery chooses what a list endpoint returns: ?offset=&limit=&sort=&q=
+// on GET endpoints, the same fields in the body of POST ones. Sort names a
+// field, with a leading "-" for descending order; q keeps the items
+// containing it, ignoring case.
+type listQuery struct {
+	Offset int    `json:"offset"`
+	Limit  int    `json:"limit"`
+	Sort   string `json:"sort"`
+	Search string `json:"q"`
+}
+
+// parseListQuery reads a listQuery from the query string.
+func parseListQuery(w http.ResponseWriter, r *http.Request) (listQuery, bool) {
+	values := r.URL.Query()
+	q := listQuery{Sort: values.Get("sort"), Search: values.Get("q")}
+	for name, dest := range map[string]*int{"offset": &q.Offset, "limit": &q.Limit} {
+		value := values.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			writeErrorf(w, r, http.StatusBadRequest, "invalid_page", "Query parameter %s must be a number", name)
+			return q, false
+		}
+		*dest = n
+	}
+	return q, true
+}
+
+// listSpec is how a list of T can be sorted and searched.
+type listSpec[T any] struct {
+	// sorts compare two items by the named field, ascending.
+	sorts map[string]func(a, b T) int
+	// text returns the fields q is looked for in, nil when the list can't
+	// be searched.
+	text func(item T) []string
+}
+
+// check fills in the default limit and reports whether q suits the list.
+func (s listSpec[T]) check(w http.ResponseWriter, r *http.Request, q *listQuery, defaultLimit int) bool {
+	if q.Limit == 0 {
+		q.Limit = defaultLimit
+	}
+	if q.Limit < 0 || q.Limit > maxPageSize {
+		writeErrorf(w, r, http.StatusBadRequest, "invalid_page", "Limit must be 1 to %d", maxPageSize)
+		return false
+	}
+	if q.Offset < 0 {
+		writeError(w, r, http.StatusBadRequest, "invalid_page", "Offset must not be negative")
+		return false
+	}
+	if q.Sort != "" && s.sorts[strings.TrimPrefix(q.Sort, "-")] == nil {
+		fields := slices.Sorted(maps.Keys(s.sorts))
+		if len(fields) == 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid_sort", "This list can't be sorted")
+		} else {
+			writeErrorf(w, r, http.StatusBadRequest, "invalid_sort", "Sort by one of: %s", strings.Join(fields, ", "))
+		}
+		return false
+	}
+	q.Search = strings.TrimSpace(q.Search)
+	if q.Search != "" && s.text == nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_search", "This list can't be searched")
+		return false
+	}
+	return true
+}
+
+// matches reports whether item contains search in one of its text fields.
+func (s listSpec[T]) matches(item T, search string) bool {
+	if search == "" {
+		return true
+	}
+	search = strings.ToLower(search)
+	for _, field := range s.text(item) {
+		if strings.Contains(strings.ToLower(field), search) {
+			return true
+		}
+	}
+	return false
+}
+
+// sort orders items by q.Sort, keeping the current order for ties.
+func (s listSpec[T]) sort(items []T, q listQuery) {
+	field, desc := strings.CutPrefix(q.Sort, "-")
+	compare := s.sorts[field]
+	if compare == nil {
+		return
+	}
+	slices.SortStableFunc(items, func(a, b T) int {
+		if desc {
+			return compare(b, a)
+		}
+		return compare(a, b)
+	})
+}
+
+// apply searches, sorts and pages items, returning the page and how many
+// items matched.
+func (s listSpec[T]) apply(items []T, q listQuery) ([]T, int) {
+	matched := make([]T, 0, len(items))
+	for _, item := range items {
+		if s.matches(item, q.Search) {
+			matched = append(matched, item)
+		}
+	}
+	s.sort(matched, q)
+
+	start := min(q.Offset, len(matched))
+	end := min(start+q.Limit, len(matched))
+	return matched[start:end], len(matched)
+}
+
+// decode reads the JSON array in r into the page of items q asks for, and
+// returns how many items matched. Without sort or search the items around
+// the page are skipped undecoded; sorting keeps every match until the end.
+func (s listSpec[T]) decode(r io.Reader, q listQuery, items *[]T) (int, error) {
+	list := make([]T, 0, q.Limit)
+	matched := 0
+	err := decodeArray(r, func(dec *json.Decoder) error {
+		if q.Sort == "" && q.Search == "" && (matched < q.Offset || len(list) >= q.Limit) {
+			matched++
+			var skip struct{}
+			return dec.Decode(&skip)
+		}
+
+		var item T
+		if err := dec.Decode(&item); err != nil {
+			return err
+		}
+		if !s.matches(item, q.Search) {
+			return nil
+		}
+		matched++
+		if q.Sort != "" || (matched > q.Offset && len(list) < q.Limit) {
+			list = append(list, item)
+		}
+		return nil
+	})
+	if q.Sort != "" {
+		list, _ = s.apply(list, listQuery{Offset: q.Offset, Limit: q.Limit, Sort: q.Sort})
+	}
+	*items = list
+	return matched, err
+}
+
+// writeList writes a page of a list, with the number of matching items in
+// X-Total-Count.
+func writeList[T any](w http.ResponseWriter, items []T, total int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	json.NewEncoder(w).Encode(items)
+}
+
+// byString and byNumber build sort comparisons from a field getter.
+func byString[T any](field func(T) string) func(a, b T) int {
+	return func(a, b T) int { return cmp.Compare(strings.ToLower(field(a)), strings.ToLower(field(b))) }
+}
+
+func byNumber[T any, N cmp.Ordered](field func(T) N) func(a, b T) int {
+	return func(a, b T) int { return cmp.Compare(field(a), field(b)) }
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor systemd passes sockets on.
+const listenFDsStart = 3
+
+// listen opens the listener of -listen: a TCP address, unix:path for a Unix
+// socket, or systemd for the socket systemd socket activation passed.
+func listen(addr string) (net.Listener, error) {
+	switch {
+	case addr == "systemd":
+		return systemdListener()
+	case strings.HasPrefix(addr, "unix:"):
+		return unixListener(strings.TrimPrefix(addr, "unix:"))
+	default:
+		return net.Listen("tcp", addr)
+	}
+}
+
+// unixListener listens on a Unix socket readable and writable by the
+// owner and group, replacing a socket left behind by an earlier run.
+// Closing the listener removes it.
+func unixListener(path string) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o660); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// systemdListener takes the first socket passed by systemd, following
+// sd_listen_fds(3).
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("-listen systemd needs the process to be started by a systemd .socket unit")
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, errors.New("systemd passed no sockets")
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(listenFDsStart, "systemd socket")
+	defer file.Close()
+	ln, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use the socket passed by systemd: %w", err)
+	}
+	return ln, nil
+}
+
+// listenPort is the TCP port of -listen, which the tunnel forwards to.
+func (a *App) listenPort() string {
+	_, port, _ := net.SplitHostPort(a.config.Listen)
+	return port
+}
+
+// listenURL is where the server can be reached, for the startup message.
+func listenURL(ln net.Listener) string {
+	switch addr := ln.Addr().(type) {
+	case *net.TCPAddr:
+		if addr.IP.IsUnspecified() {
+			return "http://localhost:" + strconv.Itoa(addr.Port)
+		}
+		return "http://" + addr.String()
+	default:
+		return addr.Network() + ":" + addr.String()
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Log levels of -log-level, least severe first.
+const (
+	levelDebug int32 = iota - 1
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = map[string]int32{"debug": levelDebug, "info": levelInfo, "warn": levelWarn, "error": levelError}
+
+// logLevel is the least severe level logged. Config reloads change it
+// while the server runs.
+var logLevel atomic.Int32
+
+// parseLogLevel checks a -log-level value.
+func parseLogLevel(name string) (int32, error) {
+	level, ok := logLevelNames[name]
+	if !ok {
+		return 0, fmt.Errorf("invalid -log-level %q, expected debug, info, warn or error", name)
+	}
+	return level, nil
+}
+
+// setLogLevel logs from the level of name on.
+func setLogLevel(name string) error {
+	level, err := parseLogLevel(name)
+	if err != nil {
+		return err
+	}
+	logLevel.Store(level)
+	return nil
+}
+
+// logf logs at level unless -log-level leaves it out.
+func logf(level int32, format string, args ...interface{}) {
+	if level >= logLevel.Load() {
+		log.Output(3, fmt.Sprintf(format, args...))
+	}
+}
+
+func debugf(format string, args ...interface{}) { logf(levelDebug, format, args...) }
+func infof(format string, args ...interface{})  { logf(levelInfo, format, args...) }
+func warnf(format string, args ...interface{})  { logf(levelWarn, format, args...) }
+func errorf(format string, args ...interface{}) { logf(levelError, format, args...) }
+
+// setupLogging sends the log to the -log-output sinks: stderr, the local
+// syslog, a remote one as syslog+udp://host:port or syslog+tcp://host:port,
+// or a file rotated by size and age.
+func setupLogging(c *Config) error {
+	if err := setLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+	var sinks []io.Writer
+	onlySyslog := true
+	for _, output := range c.LogOutput {
+		switch {
+		case output == "stderr":
+			sinks = append(sinks, os.Stderr)
+			onlySyslog = false
+		case output == "syslog":
+			w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "grapi")
+			if err != nil {
+				return fmt.Errorf("failed to connect to syslog: %w", err)
+			}
+			sinks = append(sinks, w)
+		case strings.HasPrefix(output, "syslog+"):
+			network, addr, _ := strings.Cut(strings.TrimPrefix(output, "syslog+"), "://")
+			w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, "grapi")
+			if err != nil {
+				return fmt.Errorf("failed to connect to syslog at %s: %w", addr, err)
+			}
+			sinks = append(sinks, w)
+		default:
+			w, err := openRotatingFile(output, c.LogMaxSize<<20, c.LogMaxAge, c.LogMaxBackups, c.LogCompress)
+			if err != nil {
+				return err
+			}
+			sinks = append(sinks, w)
+			onlySyslog = false
+		}
+	}
+	if len(sinks) == 0 {
+		return nil
+	}
+	if onlySyslog {
+		// syslog stamps the time itself
+		log.SetFlags(0)
+	}
+	log.SetOutput(io.MultiWriter(sinks...))
+	return nil
+}
+
+// rotatingFile is a log file moved aside once it grows past maxSize bytes
+// or gets older than maxAge, keeping maxBackups old files, gzipped when
+// compress is set.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int, compress bool) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups, compress: compress}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	f.file, f.size, f.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	full := f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize
+	old := f.maxAge > 0 && f.size > 0 && time.Since(f.opened) >= f.maxAge
+	if full || old {
+		if err := f.rotate(); err != nil {
+			// Keep logging to stderr rather than losing the line
+			fmt.Fprintf(os.Stderr, "Failed to rotate %s: %v\n", f.path, err)
+		}
+	}
+	if f.file == nil {
+		return os.Stderr.Write(p)
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file aside and starts a new one. The caller holds mu.
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+	backup := f.path + "." + time.Now().Format("20060102-150405")
+	if err := os.Rename(f.path, backup); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	go func() {
+		if f.compress {
+			if err := gzipFile(backup); err != nil {
+				errorf("Failed to compress %s: %v", backup, err)
+			}
+		}
+		f.prune()
+	}()
+	return nil
+}
+
+// prune removes the oldest backups beyond maxBackups.
+func (f *rotatingFile) prune() {
+	if f.maxBackups <= 0 {
+		return
+	}
+	backups, _ := filepath.Glob(f.path + ".*")
+	// The timestamps sort in the order the files were rotated
+	sort.Strings(backups)
+	for len(backups) > f.maxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
+
+// gzipFile replaces path with path.gz.
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
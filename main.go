@@ -2,225 +2,410 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"os"
+	"os/signal"
+	"path"
 	"strings"
+	"syscall"
 	"time"
 )
 
+// shutdownTimeout bounds draining requests and restoring instance settings
+// on exit.
+const shutdownTimeout = 10 * time.Second
+
 //go:embed templates/*
 var templates embed.FS
 
 //go:embed static/*
 var staticFiles embed.FS
 
-type SettingsRequest struct {
-	IDInstance       string `json:"idInstance"`
-	APITokenInstance string `json:"apiTokenInstance"`
+// assetFS returns the embedded files, or the working directory in dev mode so
+// edits to templates and static files show up on the next request without a
+// rebuild. Templates are parsed per request, so no watcher is needed.
+func (a *App) assetFS(embedded embed.FS) fs.FS {
+	if a.config.Dev {
+		return os.DirFS(".")
+	}
+	return embedded
 }
 
-type SettingsResponse struct {
-	URL      string      `json:"url"`
-	Response interface{} `json:"response"`
-	Status   int         `json:"status"`
-	Time     string      `json:"time"`
+// noCache stops browsers from holding on to static files in dev mode.
+func noCache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
 }
 
-func main() {
-	// Set up routes
-	http.HandleFunc("/", homeHandler)
-	http.HandleFunc("/api/get-settings", settingsHandler)
-	http.HandleFunc("/api/get-state", stateHandler)
-	http.HandleFunc("/api/send-message", sendMessageHandler)
-	http.HandleFunc("/api/send-file", sendFileHandler)
-	http.Handle("/static/", http.FileServer(http.FS(staticFiles)))
-
-	// Start server
-	fmt.Println("Server running on http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+// SettingsRequest is the body of the endpoints that only name an instance:
+// /api/get-settings, /api/get-state and /api/instance-overview.
+type SettingsRequest struct {
+	IDInstance       string `json:"idInstance" api:"required"`
+	APITokenInstance string `json:"apiTokenInstance" api:"required"`
+	UpstreamOverrides
 }
 
-func homeHandler(w http.ResponseWriter, r *http.Request) {
-	tmpl, err := template.ParseFS(templates, "templates/index.html")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// formBool decodes both JSON booleans and the string values htmx sends for
+// checkboxes ("on", "true").
+type formBool bool
+
+func (b *formBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true", "on", "1":
+		*b = true
+	case "false", "off", "0", "", "null":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean value %s", data)
 	}
-	tmpl.Execute(w, nil)
+	return nil
 }
 
-func makeAPIRequest(url string) (map[string]interface{}, int, error) {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:        10,
-			IdleConnTimeout:     30 * time.Second,
-			DisableCompression:  false,
-			TLSHandshakeTimeout: 5 * time.Second,
-		},
+func main() {
+	c := loadConfig()
+	if c.Version {
+		fmt.Println(currentBuild())
+		return
+	}
+	if err := setupLogging(c); err != nil {
+		log.Fatal(err)
+	}
+	infof("Starting %s", currentBuild())
+	if c.SealSecrets {
+		if c.SecretsFile == "" {
+			log.Fatal("-seal-secrets needs -secrets-file")
+		}
+		if err := sealSecretsFile(c.SecretsFile, os.Stdin); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Instances sealed into %s\n", c.SecretsFile)
+		return
+	}
+	if c.Check {
+		if !assembleApp(c).runCheck(os.Stdout) {
+			os.Exit(1)
+		}
+		return
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	app, err := newApp(c)
 	if err != nil {
-		return nil, 0, fmt.Errorf("request creation failed: %w", err)
+		log.Fatal(err)
 	}
+	defer app.close()
 
-	req.Header = http.Header{
-		"Accept":          {"application/json"},
-		"Content-Type":    {"application/json"},
-		"Accept-Language": {"en-US"},
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := app.start(ctx); err != nil {
+		log.Fatal(err)
 	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, 0, fmt.Errorf("request execution failed: %w", err)
+	app.routes()
+	if err := app.serve(ctx); err != nil {
+		log.Fatal(err)
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			log.Printf("Failed to close response body: %v", err)
-		}
-	}()
+}
 
-	if resp.StatusCode >= 400 {
-		errorBody, _ := io.ReadAll(resp.Body)
-		return nil, resp.StatusCode, fmt.Errorf("api error: %s", string(errorBody))
+// routes registers the pages and endpoints of the server on its mux.
+func (a *App) routes() {
+	a.warnMiddleware()
+	a.handle(Route{Pattern: "/", Role: roleViewer, Handler: a.homeHandler})
+	a.handle(Route{Pattern: "GET /login", Handler: a.loginPageHandler})
+	a.handle(Route{Pattern: "POST /login", Handler: a.loginHandler})
+	a.handle(Route{Pattern: "POST /logout", Handler: a.logoutHandler})
+	a.handle(Route{Pattern: "GET /login/oauth", Handler: a.oauthStartHandler})
+	a.handle(Route{Pattern: "GET /login/oauth/callback", Handler: a.oauthCallbackHandler})
+	a.handle(Route{Pattern: "/stats", Role: roleViewer, Handler: a.statsPageHandler})
+	a.handle(Route{Pattern: "POST /result/{action}", Handler: a.resultPageHandler})
+	a.handle(Route{Pattern: "/api/get-settings", Role: roleViewer, Stats: true, Passthrough: true, Handler: a.settingsHandler})
+	a.handle(Route{Pattern: "/api/get-state", Role: roleViewer, Stats: true, Passthrough: true, Handler: a.stateHandler})
+	a.handle(Route{Pattern: "POST /api/instance-overview", Role: roleViewer, Stats: true, Handler: a.instanceOverviewHandler})
+	a.handle(Route{Pattern: "/api/send-message", Role: roleSender, Stats: true, Passthrough: true, InFlight: inFlightSends, Handler: a.sendMessageHandler})
+	a.handle(Route{Pattern: "POST /api/preview-message", Role: roleViewer, Handler: previewMessageHandler})
+	a.handle(Route{Pattern: "/api/send-file", Role: roleSender, Stats: true, Passthrough: true, InFlight: inFlightSends, Handler: a.sendFileHandler})
+	a.handle(Route{Pattern: "/api/send-file-upload", Role: roleSender, Stats: true, Passthrough: true, InFlight: inFlightUploads, Handler: a.sendFileUploadHandler})
+	a.handle(Route{Pattern: "/api/send-voice", Role: roleSender, Stats: true, Passthrough: true, InFlight: inFlightUploads, Handler: a.sendVoiceHandler})
+	a.handle(Route{Pattern: "/api/read-chat", Role: roleSender, Stats: true, Passthrough: true, Handler: a.readChatHandler})
+	a.handle(Route{Pattern: "/api/send-typing", Role: roleSender, Stats: true, Passthrough: true, Handler: a.sendTypingHandler})
+	a.handle(Route{Pattern: "POST /api/chat-history", Role: roleViewer, Stats: true, Handler: a.chatHistoryHandler})
+	a.handle(Route{Pattern: "POST /api/contacts", Role: roleViewer, Stats: true, Handler: a.contactsHandler})
+	a.handle(Route{Pattern: "POST /api/check-whatsapp/bulk", Role: roleViewer, Stats: true, Handler: a.bulkCheckHandler})
+	a.handle(Route{Pattern: "POST /api/group-invite-link", Role: roleViewer, Stats: true, Handler: a.groupInviteLinkHandler})
+	a.handle(Route{Pattern: "/api/send-reaction", Role: roleSender, Stats: true, Passthrough: true, InFlight: inFlightSends, Handler: a.sendReactionHandler})
+	a.handle(Route{Pattern: "/api/set-profile-name", Role: roleAdmin, Stats: true, Passthrough: true, Handler: a.setProfileNameHandler})
+	a.handle(Route{Pattern: "/api/set-profile-picture", Role: roleAdmin, Stats: true, Passthrough: true, InFlight: inFlightUploads, Handler: a.setProfilePictureHandler})
+	a.handle(Route{Pattern: "/api/upload-progress", Role: roleSender, Handler: a.uploadProgressHandler})
+	a.handle(Route{Pattern: "/api/raw", Role: roleViewer, Stats: true, Passthrough: true, InFlight: inFlightSends, Handler: a.rawHandler})
+	a.handle(Route{Pattern: "GET /api/methods", Role: roleViewer, Handler: methodsHandler})
+	a.handle(Route{Pattern: "GET /api/schema", Role: roleViewer, Handler: schemasHandler})
+	a.handle(Route{Pattern: "GET /api/schema/{endpoint...}", Role: roleViewer, Handler: schemaHandler})
+	a.handle(Route{Pattern: "GET /api/history", Role: roleViewer, Handler: a.historyHandler})
+	a.handle(Route{Pattern: "GET /api/history/diff", Role: roleViewer, Handler: a.historyDiffHandler})
+	a.handle(Route{Pattern: "GET /api/history/{id}", Role: roleViewer, Handler: a.historyEntryHandler})
+	a.handle(Route{Pattern: "DELETE /api/history/{id}", Role: roleAdmin, Handler: a.deleteHistoryHandler})
+	a.handle(Route{Pattern: "GET /api/export", Role: roleViewer, Handler: a.exportHandler})
+	a.handle(Route{Pattern: "POST /api/import", Role: roleAdmin, InFlight: inFlightUploads, Handler: a.importHandler})
+	a.handle(Route{Pattern: "/api/files", Role: roleSender, Stats: true, InFlight: inFlightUploads, Handler: a.uploadFileHandler})
+	a.handle(Route{Pattern: "GET /files/{id}/{name}", Handler: a.serveFileHandler})
+	a.handle(Route{Pattern: "GET /api/attachments", Role: roleViewer, Handler: a.attachmentsHandler})
+	a.handle(Route{Pattern: "GET /api/attachments/{idInstance}/{idMessage}", Role: roleViewer, Handler: a.attachmentHandler})
+	a.handle(Route{Pattern: "GET /api/chats", Role: roleViewer, Handler: a.chatsHandler})
+	a.handle(Route{Pattern: "GET /api/search", Role: roleViewer, Handler: a.searchHandler})
+	a.handle(Route{Pattern: "GET /api/chats/{chatId}/export", Role: roleViewer, Handler: a.chatExportHandler})
+	a.handle(Route{Pattern: "PUT /api/chats/{idInstance}/{chatId}/labels", Role: roleSender, Handler: a.setChatLabelsHandler})
+	a.handle(Route{Pattern: "PUT /api/chats/{idInstance}/{chatId}/labels/{label}", Role: roleSender, Handler: a.addChatLabelHandler})
+	a.handle(Route{Pattern: "DELETE /api/chats/{idInstance}/{chatId}/labels/{label}", Role: roleSender, Handler: a.removeChatLabelHandler})
+	a.handle(Route{Pattern: "GET /api/chats/{idInstance}/{chatId}/notes", Role: roleViewer, Handler: a.chatNotesHandler})
+	a.handle(Route{Pattern: "PATCH /api/chats/{idInstance}/{chatId}/notes", Role: roleSender, Handler: a.updateChatNotesHandler})
+	a.handle(Route{Pattern: "DELETE /api/chats/{idInstance}/{chatId}/notes", Role: roleSender, Handler: a.deleteChatNotesHandler})
+	a.handle(Route{Pattern: "GET /api/media/{id}/thumb", Role: roleViewer, Handler: a.mediaThumbHandler})
+	a.handle(Route{Pattern: "/api/stats", Role: roleViewer, Handler: a.statsHandler})
+	a.handle(Route{Pattern: "GET /api/stats/volume", Role: roleViewer, Handler: a.volumeHandler})
+	a.handle(Route{Pattern: "GET /graphql", Role: roleViewer, Feature: featureGraphQL, Handler: a.graphqlHandler})
+	a.handle(Route{Pattern: "POST /graphql", Role: roleViewer, Feature: featureGraphQL, Handler: a.graphqlHandler})
+	a.handle(Route{Pattern: "GET /graphql/schema", Role: roleViewer, Feature: featureGraphQL, Handler: a.graphqlSchemaHandler})
+	a.handle(Route{Pattern: "GET /api/version", Role: roleViewer, Handler: versionHandler})
+	a.handle(Route{Pattern: "GET /api/jobs", Role: roleViewer, Handler: a.jobsHandler})
+	a.handle(Route{Pattern: "POST /api/jobs/{name}/run", Role: roleAdmin, Handler: a.runJobHandler})
+	a.handle(Route{Pattern: "GET /api/slow-requests", Role: roleViewer, Handler: a.slowRequestsHandler})
+	a.handle(Route{Pattern: "GET /metrics", Role: roleViewer, Handler: a.metricsHandler})
+	a.handle(Route{Pattern: "/webhook", Stats: true, Handler: a.webhookHandler})
+	a.handle(Route{Pattern: "GET /api/webhooks", Role: roleViewer, Handler: a.webhooksHandler})
+	a.handle(Route{Pattern: "GET /api/webhooks/stream", Role: roleViewer, Handler: a.webhookStreamHandler})
+	a.handle(Route{Pattern: "GET /api/webhooks/anomalies", Role: roleViewer, Handler: a.webhookAnomaliesHandler})
+	a.handle(Route{Pattern: "GET /api/webhooks/schema", Role: roleViewer, Handler: webhookSchemaHandler})
+	a.handle(Route{Pattern: "POST /api/webhook-test", Role: roleSender, Stats: true, Handler: a.webhookTestHandler})
+	a.handle(Route{Pattern: "GET /api/polls/{idMessage}/results", Role: roleViewer, Feature: featurePolls, Handler: a.pollResultsHandler})
+	a.handle(Route{Pattern: "GET /api/polls/{idMessage}/stream", Role: roleViewer, Feature: featurePolls, Handler: a.pollStreamHandler})
+	a.handle(Route{Pattern: "GET /api/instances", Role: roleViewer, Handler: a.instanceStatesHandler})
+	a.handle(Route{Pattern: "GET /api/instances/stream", Role: roleViewer, Handler: a.instanceStreamHandler})
+	a.handle(Route{Pattern: "GET /api/instances/drift/stream", Role: roleViewer, Handler: a.driftStreamHandler})
+	a.handle(Route{Pattern: "GET /api/instances/{idInstance}/drift", Role: roleViewer, Handler: a.driftHandler})
+	a.handle(Route{Pattern: "PUT /api/instances/{idInstance}/desired-settings", Role: roleAdmin, Handler: a.setDesiredSettingsHandler})
+	a.handle(Route{Pattern: "DELETE /api/instances/{idInstance}/desired-settings", Role: roleAdmin, Handler: a.deleteDesiredSettingsHandler})
+	a.handle(Route{Pattern: "GET /api/inbox", Role: roleViewer, Handler: a.inboxHandler})
+	a.handle(Route{Pattern: "PATCH /api/inbox/{idInstance}/{chatId}", Role: roleSender, Handler: a.updateConversationHandler})
+	a.handle(Route{Pattern: "GET /api/optouts", Role: roleViewer, Handler: a.optOutsHandler})
+	a.handle(Route{Pattern: "POST /api/optouts", Role: roleSender, Handler: a.addOptOutHandler})
+	a.handle(Route{Pattern: "DELETE /api/optouts/{phoneNumber}", Role: roleAdmin, Handler: a.removeOptOutHandler})
+	a.handle(Route{Pattern: "GET /api/audit", Role: roleAdmin, Handler: a.auditHandler})
+	a.handle(Route{Pattern: "GET /api/audit/export", Role: roleAdmin, Handler: a.auditExportHandler})
+	a.handle(Route{Pattern: "GET /api/schedule", Role: roleViewer, Feature: featureSchedule, Handler: a.scheduleHandler})
+	a.handle(Route{Pattern: "GET /api/schedule/calendar.ics", Role: roleViewer, Feature: featureSchedule, Handler: a.calendarHandler})
+	a.handle(Route{Pattern: "DELETE /api/schedule/{id}", Role: roleAdmin, Feature: featureSchedule, Handler: a.cancelScheduledHandler})
+	a.handle(Route{Pattern: "GET /api/batches/{id}/analytics", Role: roleViewer, Feature: featureBroadcast, Handler: a.batchAnalyticsHandler})
+	a.handle(Route{Pattern: "GET /api/batches/{id}/links", Role: roleViewer, Feature: featureBroadcast, Handler: a.batchLinksHandler})
+	a.handle(Route{Pattern: "GET /api/dlq", Role: roleViewer, Handler: a.deadLettersHandler})
+	a.handle(Route{Pattern: "DELETE /api/dlq", Role: roleAdmin, Handler: a.deadLettersPurgeHandler})
+	a.handle(Route{Pattern: "POST /api/dlq/{id}/retry", Role: roleSender, Handler: a.deadLetterRetryHandler})
+	a.handle(Route{Pattern: "DELETE /api/dlq/{id}", Role: roleAdmin, Handler: a.deadLetterDeleteHandler})
+	a.handle(Route{Pattern: "GET /api/features", Role: roleViewer, Handler: a.featuresHandler})
+	a.handle(Route{Pattern: "PUT /api/features/{name}", Role: roleAdmin, Handler: a.setFeatureHandler})
+	a.handle(Route{Pattern: "GET /api/canned-replies", Role: roleViewer, Handler: a.cannedRepliesHandler})
+	a.handle(Route{Pattern: "PUT /api/canned-replies/{shortcut}", Role: roleSender, Handler: a.saveCannedReplyHandler})
+	a.handle(Route{Pattern: "DELETE /api/canned-replies/{shortcut}", Role: roleSender, Handler: a.deleteCannedReplyHandler})
+	a.handle(Route{Pattern: "POST /api/canned-replies/{shortcut}/send", Role: roleSender, Stats: true, Passthrough: true, InFlight: inFlightSends, Handler: a.sendCannedReplyHandler})
+	a.handle(Route{Pattern: "GET /api/settings-presets", Role: roleViewer, Handler: a.presetsHandler})
+	a.handle(Route{Pattern: "PUT /api/settings-presets/{name}", Role: roleAdmin, Handler: a.savePresetHandler})
+	a.handle(Route{Pattern: "DELETE /api/settings-presets/{name}", Role: roleAdmin, Handler: a.deletePresetHandler})
+	a.handle(Route{Pattern: "POST /api/settings-presets/{name}/apply", Role: roleAdmin, Stats: true, Handler: a.applyPresetHandler})
+	a.handle(Route{Pattern: "GET /api/admin/instances", Role: roleAdmin, Handler: a.adminInstancesHandler})
+	a.handle(Route{Pattern: "POST /api/admin/instances", Role: roleAdmin, Handler: a.createInstanceHandler})
+	a.handle(Route{Pattern: "PUT /api/admin/instances/{idInstance}", Role: roleAdmin, Handler: a.updateInstanceHandler})
+	a.handle(Route{Pattern: "DELETE /api/admin/instances/{idInstance}", Role: roleAdmin, Handler: a.deleteInstanceHandler})
+	a.handle(Route{Pattern: "POST /api/admin/instances/{idInstance}/test", Role: roleAdmin, Handler: a.testInstanceHandler})
+	a.handle(Route{Pattern: "GET /api/trash", Role: roleAdmin, Handler: a.trashHandler})
+	a.handle(Route{Pattern: "POST /api/trash/{id}/restore", Role: roleAdmin, Handler: a.restoreTrashHandler})
+	a.handle(Route{Pattern: "DELETE /api/trash/{id}", Role: roleAdmin, Handler: a.purgeTrashHandler})
+	a.handle(Route{Pattern: "POST /api/admin/prune", Role: roleAdmin, Handler: a.pruneHandler})
+	a.handle(Route{Pattern: "GET /api/admin/backup", Role: roleAdmin, Handler: a.backupHandler})
+	a.handle(Route{Pattern: "POST /api/admin/restore", Role: roleAdmin, InFlight: inFlightUploads, Handler: a.restoreHandler})
+	if a.config.Pprof {
+		a.handle(Route{Pattern: "GET /debug/pprof/profile", Role: roleAdmin, Handler: cpuProfileHandler})
+		a.handle(Route{Pattern: "GET /debug/pprof/{name}", Role: roleAdmin, Handler: profileHandler})
+	}
+	static := http.FileServer(http.FS(a.assetFS(staticFiles)))
+	if a.config.Dev {
+		infof("Dev mode: serving templates and static files from disk")
+		static = noCache(static)
+	}
+	a.mux.Handle("/static/", static)
+}
+
+// newAPITransport builds the GREEN-API transport from the -upstream-*
+// settings. Bulk sends go to a handful of hosts, so the idle pool per host
+// is kept well above Go's default of 2 to avoid new TLS handshakes.
+func newAPITransport(c *Config) *http.Transport {
+	transport := &http.Transport{
+		MaxIdleConns:        c.MaxIdleConns,
+		MaxIdleConnsPerHost: c.MaxIdlePerHost,
+		MaxConnsPerHost:     c.MaxConnsPerHost,
+		IdleConnTimeout:     c.IdleConnTimeout,
+		TLSHandshakeTimeout: 5 * time.Second,
+		ForceAttemptHTTP2:   c.HTTP2,
+	}
+	if !c.HTTP2 {
+		// A non-nil empty map is how net/http is told not to negotiate h2
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
+
+func (a *App) makeAPIRequest(ctx context.Context, method, url string) (map[string]interface{}, int, error) {
+	body, statusCode, err := a.doAPIRequest(ctx, method, http.MethodGet, url, "", nil)
+	if err != nil {
+		return nil, statusCode, err
 	}
 
 	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, resp.StatusCode, fmt.Errorf("json decode failed: %w", err)
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, statusCode, fmt.Errorf("json decode failed: %w", err)
 	}
 
-	return result, resp.StatusCode, nil
+	return result, statusCode, nil
 }
 
-func settingsHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) settingsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 		return
 	}
 
 	var req SettingsRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid_request", err.Error())
 		return
 	}
 
-	// Construct the API URL
-	apiUrl := fmt.Sprintf("https://1103.api.green-api.com/waInstance%s/getSettings/%s",
-		url.PathEscape(req.IDInstance),
-		url.PathEscape(req.APITokenInstance))
-
-	// In a real app, you would make the actual GET request here
-	// For this example, we'll simulate a response
-	apiResponse, statusCode, err := makeAPIRequest(apiUrl)
+	ctx, err := withOverrides(r.Context(), req.UpstreamOverrides)
 	if err != nil {
-		log.Printf("API request failed after retries: %v", err)
-		http.Error(w, "Failed to communicate with WhatsApp API", http.StatusBadGateway)
+		writeError(w, r, http.StatusBadRequest, "blocked_override", err.Error())
 		return
 	}
+	ctx, rs := newResponder(ctx)
 
-	// Check for API-specific errors
-	if statusCode != http.StatusOK {
-		if apiError, ok := apiResponse["error"]; ok {
-			http.Error(w, fmt.Sprintf("WhatsApp API error: %v", apiError), statusCode)
-			return
-		}
-	}
+	// Construct the API URL
+	apiUrl := a.methodURL("getSettings", req.IDInstance, req.APITokenInstance)
 
-	response := SettingsResponse{
-		URL:      apiUrl,
-		Response: apiResponse,
-		Status:   200,
-		Time:     time.Now().Format(time.RFC3339),
+	apiResponse, statusCode, err := a.makeAPIRequest(ctx, "getSettings", apiUrl)
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	rs.respond(w, APIResponse{
+		URL: apiUrl,
+		RequestBody: map[string]string{
+			"idInstance":       req.IDInstance,
+			"apiTokenInstance": "••••••••", // Mask sensitive data
+		},
+		Response:   apiResponse,
+		StatusCode: statusCode,
+		Snippets:   snippetsFor(ctx, UpstreamCall{Verb: http.MethodGet, URL: apiUrl}),
+	})
 }
 
-func stateHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) stateHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 		return
 	}
 
 	// Parse JSON body
-	var requestBody struct {
-		IDInstance       string `json:"idInstance"`
-		APITokenInstance string `json:"apiTokenInstance"`
-	}
+	var requestBody SettingsRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	ctx, err := withOverrides(r.Context(), requestBody.UpstreamOverrides)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "blocked_override", err.Error())
 		return
 	}
+	ctx, rs := newResponder(ctx)
 
 	// Construct the API URL for getStateInstance
-	apiUrl := fmt.Sprintf("https://api.green-api.com/waInstance%s/getStateInstance/%s",
-		url.PathEscape(requestBody.IDInstance),
-		url.PathEscape(requestBody.APITokenInstance))
+	apiUrl := a.methodURL("getStateInstance", requestBody.IDInstance, requestBody.APITokenInstance)
 
 	// Make the actual HTTP request
-	startTime := time.Now()
-	apiResponse, statusCode, err := makeAPIRequest(apiUrl)
+	apiResponse, statusCode, err := a.makeAPIRequest(ctx, "getStateInstance", apiUrl)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("API request failed: %v", err), http.StatusBadGateway)
+		writeUpstreamError(w, r, err)
 		return
 	}
 
-	// Prepare our response
-	response := map[string]interface{}{
-		"url": apiUrl,
-		"requestBody": map[string]string{
+	rs.respond(w, APIResponse{
+		URL: apiUrl,
+		RequestBody: map[string]string{
 			"idInstance":       requestBody.IDInstance,
 			"apiTokenInstance": "••••••••", // Mask sensitive data
 		},
-		"response":    apiResponse,
-		"statusCode":  statusCode,
-		"processedAt": time.Now().Format(time.RFC3339),
-		"requestTime": time.Since(startTime).String(),
-	}
+		Response:   apiResponse,
+		StatusCode: statusCode,
+		Snippets:   snippetsFor(ctx, UpstreamCall{Verb: http.MethodGet, URL: apiUrl}),
+	})
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+// SendMessageRequest is the body of /api/send-message.
+type SendMessageRequest struct {
+	IDInstance       string    `json:"idInstance" api:"required"`
+	APITokenInstance string    `json:"apiTokenInstance" api:"required"`
+	PhoneNumber      string    `json:"phoneNumber"`
+	PhoneNumbers     phoneList `json:"phoneNumbers"`
+	MessageText      string    `json:"messageText"`
+	DryRun           formBool  `json:"dryRun"`
+	UpstreamOverrides
 }
 
-func sendMessageHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) sendMessageHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 		return
 	}
 
 	// Parse JSON body
-	var requestBody struct {
-		IDInstance       string `json:"idInstance"`
-		APITokenInstance string `json:"apiTokenInstance"`
-		PhoneNumber      string `json:"phoneNumber"`
-		MessageText      string `json:"messageText"`
-	}
+	var requestBody SendMessageRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
 		return
 	}
 
 	// Validate phone number (simple validation)
-	if len(requestBody.PhoneNumber) < 11 {
-		http.Error(w, "Phone number too short", http.StatusBadRequest)
+	if len(requestBody.PhoneNumbers) > 0 {
+		if !a.validateRecipients(w, r, requestBody.PhoneNumbers) {
+			return
+		}
+	} else if len(requestBody.PhoneNumber) < 11 {
+		writeError(w, r, http.StatusBadRequest, "invalid_phone_number", "Phone number too short")
+		return
+	} else if !a.checkOptOut(w, r, requestBody.PhoneNumber) {
+		return
+	}
+
+	ctx, err := withOverrides(r.Context(), requestBody.UpstreamOverrides)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "blocked_override", err.Error())
 		return
 	}
+	ctx, rs := newResponder(ctx)
 
 	// Construct the API URL
-	apiUrl := fmt.Sprintf("https://api.green-api.com/waInstance%s/sendMessage/%s",
-		url.PathEscape(requestBody.IDInstance),
-		url.PathEscape(requestBody.APITokenInstance))
+	apiUrl := a.methodURL("sendMessage", requestBody.IDInstance, requestBody.APITokenInstance)
 
 	// Prepare request payload
 	payload := map[string]interface{}{
@@ -228,154 +413,464 @@ func sendMessageHandler(w http.ResponseWriter, r *http.Request) {
 		"message": requestBody.MessageText,
 	}
 
-	// Make the API request
-	startTime := time.Now()
-	apiResponse, statusCode, err := makeAPIRequestWithPayload(apiUrl, payload)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("API request failed: %v", err), http.StatusBadGateway)
+	echo := map[string]interface{}{
+		"phoneNumber":      requestBody.PhoneNumber,
+		"message":          requestBody.MessageText,
+		"idInstance":       requestBody.IDInstance,
+		"apiTokenInstance": "••••••••", // Mask sensitive data
+	}
+
+	// Broadcasts fan out one call per recipient
+	if len(requestBody.PhoneNumbers) > 0 {
+		text := requestBody.MessageText
+		var links []ShortLink
+		// Dry runs keep the long links rather than create short ones
+		if a.shortenerEnabled() && !bool(requestBody.DryRun) {
+			if text, links, err = a.shortener.rewrite(ctx, text); err != nil {
+				writeErrorf(w, r, http.StatusBadGateway, "shortener_error", "Failed to shorten links: %v", err)
+				return
+			}
+		}
+		b := &Broadcast{
+			app:        a,
+			IDInstance: requestBody.IDInstance,
+			Method:     "sendMessage",
+			URL:        apiUrl,
+			Phones:     requestBody.PhoneNumbers,
+			Content:    text,
+			PayloadFor: func(chatId string) map[string]interface{} {
+				return map[string]interface{}{"chatId": chatId, "message": text}
+			},
+			Links: links,
+		}
+		b.respond(ctx, w, r, rs, echo, bool(requestBody.DryRun))
 		return
 	}
 
-	// Prepare our response
-	response := map[string]interface{}{
-		"url": apiUrl,
-		"requestBody": map[string]interface{}{
-			"phoneNumber":      requestBody.PhoneNumber,
-			"message":          requestBody.MessageText,
-			"idInstance":       requestBody.IDInstance,
-			"apiTokenInstance": "••••••••", // Mask sensitive data
-		},
-		"response":    apiResponse,
-		"statusCode":  statusCode,
-		"processedAt": time.Now().Format(time.RFC3339),
-		"requestTime": time.Since(startTime).String(),
+	// Dry runs stop here and show what would be sent
+	if requestBody.DryRun {
+		rs.respond(w, APIResponse{
+			URL:         apiUrl,
+			RequestBody: echo,
+			Payload:     payload,
+			DryRun:      true,
+			Snippets:    snippetsFor(ctx, jsonCall(apiUrl, payload)),
+		})
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
+	if a.queueOffline(w, r, rs, ScheduledSend{
+		IDInstance:  requestBody.IDInstance,
+		PhoneNumber: requestBody.PhoneNumber,
+		Method:      "sendMessage",
+		URL:         apiUrl,
+		Payload:     payload,
+		Content:     requestBody.MessageText,
+	}, echo) {
+		return
+	}
 
-func makeAPIRequestWithPayload(url string, payload interface{}) (map[string]interface{}, int, error) {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	// Keep messages to the same chat in order
+	unlock, err := a.chatLocks.lock(ctx, chatKey(requestBody.IDInstance, requestBody.PhoneNumber+"@c.us"))
+	if err != nil {
+		writeChatBusy(w, r)
+		return
+	}
+	defer unlock()
+
+	// Make the API request
+	apiResponse, statusCode, err := a.makeAPIRequestWithPayload(ctx, "sendMessage", apiUrl, payload)
+	a.audit.record(actorOf(r), OutboundMessage{
+		Method:  "sendMessage",
+		URL:     apiUrl,
+		ChatID:  requestBody.PhoneNumber + "@c.us",
+		Content: requestBody.MessageText,
+	}, statusCode, apiResponse, err)
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
 	}
 
+	rs.respond(w, APIResponse{
+		URL:         apiUrl,
+		RequestBody: echo,
+		Response:    apiResponse,
+		StatusCode:  statusCode,
+		Snippets:    snippetsFor(ctx, jsonCall(apiUrl, payload)),
+	})
+}
+
+func (a *App) makeAPIRequestWithPayload(ctx context.Context, method, url string, payload interface{}) (map[string]interface{}, int, error) {
 	// Marshal payload to JSON
 	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonPayload))
+	return a.makeAPIRequestWithBody(ctx, method, url, "application/json", bytes.NewBuffer(jsonPayload))
+}
+
+func (a *App) makeAPIRequestWithBody(ctx context.Context, method, url, contentType string, requestBody io.Reader) (map[string]interface{}, int, error) {
+	body, statusCode, err := a.doAPIRequest(ctx, method, http.MethodPost, url, contentType, requestBody)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+		return nil, statusCode, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, statusCode, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+
+	return result, statusCode, nil
+}
+
+// doAPIRequest performs a GREEN-API call and returns the raw response body.
+// Transient failures of GET calls are retried within the method's budget.
+func (a *App) doAPIRequest(ctx context.Context, method, verb, url, contentType string, requestBody io.Reader) ([]byte, int, error) {
+	return a.callAPI(ctx, method, verb, url, contentType, requestBody, nil)
+}
+
+// streamAPIRequest performs a GREEN-API call whose response may be large.
+// A successful body is handed to decode as it arrives rather than read into
+// memory first; history keeps only what -history-max-body allows of it.
+// decode may run again on a retry and must start over each time.
+func (a *App) streamAPIRequest(ctx context.Context, method, verb, url, contentType string, requestBody io.Reader, decode func(io.Reader) error) (int, error) {
+	_, statusCode, err := a.callAPI(ctx, method, verb, url, contentType, requestBody, decode)
+	return statusCode, err
+}
+
+func (a *App) callAPI(ctx context.Context, method, verb, url, contentType string, requestBody io.Reader, decode func(io.Reader) error) (body []byte, statusCode int, err error) {
+	idInstance := instanceID(url)
+	if err := a.checkInstanceWorkspace(ctx, idInstance); err != nil {
+		return nil, 0, err
+	}
+	workspace := workspaceFromContext(ctx)
+	if workspace == "" {
+		workspace = a.instanceWorkspace(idInstance)
+	}
+
+	breaker := a.upstream.of(idInstance)
+	allowed, probe := breaker.allow()
+	if !allowed {
+		return nil, 0, errUpstreamDown
+	}
+
+	budget := a.liveConfig().timeoutFor(method)
+	request, known := peekBody(requestBody)
+	retries := 0
+	size := 0
+	startTime := time.Now()
+	defer func() {
+		duration := time.Since(startTime)
+		breaker.record(probe, err)
+		a.stats.recordUpstream(idInstance, method, statusCode, err, duration)
+		entry := a.newHistoryEntry(workspace, method, verb, url, contentType, request, known, statusCode, body, err, duration)
+		if size > len(body) {
+			// Only the start of a streamed body was kept
+			entry.ResponseSize = size
+			entry.ResponseTruncated = true
+		}
+		a.slowRequests.record(entry, idInstance, duration, budget, retries)
+		debugf("%s %s of instance %s: status %d in %s", verb, method, idInstance, statusCode, duration.Round(time.Millisecond))
+		if err := a.history.add(entry); err != nil {
+			errorf("Failed to record %s in history: %v", method, err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+
+	for attempt := 0; ; attempt++ {
+		body, size, statusCode, err = a.sendAPIRequest(ctx, method, verb, url, contentType, requestBody, budget, decode)
+		wait := retryBackoff << attempt
+		if retryAfter := retryAfterOf(err); retryAfter > 0 {
+			// Queued sends of the instance wait as asked too
+			wait = retryAfter
+			a.sendQueue.hold(idInstance, time.Now().Add(wait))
+			a.checkQueue.hold(idInstance, time.Now().Add(wait))
+		}
+		if attempt >= a.liveConfig().Retries || !retryable(verb, requestBody != nil, err) {
+			return body, statusCode, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			// The budget ends before GREEN-API would take the call
+			return body, statusCode, err
+		}
+
+		warnf("Retrying %s in %s after error: %v", method, wait, err)
+		select {
+		case <-ctx.Done():
+			return body, statusCode, err
+		case <-time.After(wait):
+		}
+		retries++
+		countRetry(ctx)
+	}
+}
+
+// sendAPIRequest makes a single attempt of a GREEN-API call. It returns the
+// body, or what was kept of it when decode streamed it, and the body's size.
+func (a *App) sendAPIRequest(ctx context.Context, method, verb, url, contentType string, requestBody io.Reader, budget time.Duration, decode func(io.Reader) error) ([]byte, int, int, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { a.stats.recordConn(info.Reused) },
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), verb, url, requestBody)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
 	req.Header.Set("Accept", "application/json")
+	applyOverrides(req)
 
-	resp, err := client.Do(req)
+	resp, err := a.client.Do(req)
 	if err != nil {
-		return nil, 0, fmt.Errorf("request failed: %w", err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, 0, 0, &TimeoutError{Method: method, Budget: budget}
+		}
+		return nil, 0, 0, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
+	recordQuota(ctx, parseQuota(resp.Header, time.Now()))
+
+	if decode != nil && resp.StatusCode < 400 {
+		tap := newBodyTap(resp.Body, a.config.HistoryMaxBody)
+		err := decode(tap)
+		if err == nil {
+			// Count the rest and leave the connection reusable
+			_, err = io.Copy(io.Discard, tap)
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return tap.kept, tap.size, resp.StatusCode, &TimeoutError{Method: method, Budget: budget}
+		}
+		if err != nil {
+			return tap.kept, tap.size, resp.StatusCode, fmt.Errorf("failed to decode response: %w", err)
+		}
+		return tap.kept, tap.size, resp.StatusCode, nil
+	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, resp.StatusCode, fmt.Errorf("failed to read response: %w", err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, 0, resp.StatusCode, &TimeoutError{Method: method, Budget: budget}
+		}
+		return nil, 0, resp.StatusCode, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, resp.StatusCode, fmt.Errorf("failed to parse JSON: %w", err)
+	capturePassthrough(ctx, resp, body)
+
+	if resp.StatusCode >= 400 {
+		return body, len(body), resp.StatusCode, &UpstreamError{
+			Method:     method,
+			Status:     resp.StatusCode,
+			Body:       string(body),
+			RetryAfter: retryAfter(resp.Header, time.Now()),
+		}
 	}
 
-	return result, resp.StatusCode, nil
+	return body, len(body), resp.StatusCode, nil
 }
 
-func getFilename(url string) string {
-	// Remove query parameters and fragments
-	cleanURL := strings.Split(url, "?")[0]
-	cleanURL = strings.Split(cleanURL, "#")[0]
-
-	// Split by slashes
-	parts := strings.Split(cleanURL, "/")
+func jsonCall(apiUrl string, payload interface{}) UpstreamCall {
+	body, _ := json.Marshal(payload)
+	return UpstreamCall{Verb: http.MethodPost, URL: apiUrl, ContentType: "application/json", Body: body}
+}
 
-	// Get last non-empty part
-	for i := len(parts) - 1; i >= 0; i-- {
-		if parts[i] != "" {
-			return parts[i]
-		}
+// getFilename is the name of the file a URL points at: the last segment
+// of its path, unescaped. It is empty when the URL doesn't parse or names
+// no file, like a bare host.
+func getFilename(fileUrl string) string {
+	u, err := url.Parse(fileUrl)
+	if err != nil {
+		return ""
 	}
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
 
-	return ""
+// SendFileRequest is the body of /api/send-file.
+type SendFileRequest struct {
+	IDInstance       string    `json:"idInstance" api:"required"`
+	APITokenInstance string    `json:"apiTokenInstance" api:"required"`
+	PhoneNumber      string    `json:"phoneNumber"`
+	PhoneNumbers     phoneList `json:"phoneNumbers"`
+	FileUrl          string    `json:"fileUrl"`
+	FileID           string    `json:"fileId"`
+	Caption          string    `json:"caption"`
+	ValidateMedia    formBool  `json:"validateMedia"`
+	DryRun           formBool  `json:"dryRun"`
+	UpstreamOverrides
 }
 
-func sendFileHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) sendFileHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 		return
 	}
 
 	// Parse JSON body
-	var requestBody struct {
-		IDInstance       string `json:"idInstance"`
-		APITokenInstance string `json:"apiTokenInstance"`
-		PhoneNumber      string `json:"phoneNumber"`
-		FileUrl          string `json:"fileUrl"`
-	}
+	var requestBody SendFileRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
 		return
 	}
 
+	// A file uploaded to /api/files can be sent by its ID
+	fileName := getFilename(requestBody.FileUrl)
+	if requestBody.FileUrl == "" && requestBody.FileID != "" {
+		hosted, ok := a.fileHost.lookup(requestBody.FileID)
+		if !ok {
+			writeError(w, r, http.StatusNotFound, "file_not_found", "File not found or expired")
+			return
+		}
+		requestBody.FileUrl, fileName = hosted.URL, hosted.Name
+	}
+
 	// Validate inputs
 	if requestBody.FileUrl == "" {
-		http.Error(w, "File URL is required", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid_file_url", "File URL is required")
 		return
 	}
 
 	if _, err := url.ParseRequestURI(requestBody.FileUrl); err != nil {
-		http.Error(w, "Invalid file URL", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid_file_url", "Invalid file URL")
+		return
+	}
+	if fileName == "" {
+		writeError(w, r, http.StatusBadRequest, "invalid_file_url", "File URL must end in a file name")
+		return
+	}
+
+	var caption *captionTemplate
+	if requestBody.Caption != "" {
+		var err error
+		if caption, err = parseCaption(requestBody.Caption); err != nil {
+			writeErrorf(w, r, http.StatusBadRequest, "invalid_caption", "Invalid caption: %v", err)
+			return
+		}
+	}
+
+	if !a.validateRecipients(w, r, requestBody.PhoneNumbers) {
 		return
 	}
+	if len(requestBody.PhoneNumbers) == 0 && !a.checkOptOut(w, r, requestBody.PhoneNumber) {
+		return
+	}
+
+	// Optionally probe the file before handing it to GREEN-API
+	if requestBody.ValidateMedia {
+		if err := probeMedia(r.Context(), requestBody.FileUrl); err != nil {
+			var mediaErr *MediaValidationError
+			if errors.As(err, &mediaErr) {
+				writeErrorf(w, r, http.StatusUnprocessableEntity, mediaErr.Code, mediaErr.Message, mediaErr.Args...)
+				return
+			}
+			writeError(w, r, http.StatusUnprocessableEntity, "invalid_media", err.Error())
+			return
+		}
+	}
+
+	ctx, err := withOverrides(r.Context(), requestBody.UpstreamOverrides)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "blocked_override", err.Error())
+		return
+	}
+	ctx, rs := newResponder(ctx)
 
 	// Construct the API URL
-	apiUrl := fmt.Sprintf("https://api.green-api.com/waInstance%s/sendFileByUrl/%s",
-		url.PathEscape(requestBody.IDInstance),
-		url.PathEscape(requestBody.APITokenInstance))
+	apiUrl := a.methodURL("sendFileByUrl", requestBody.IDInstance, requestBody.APITokenInstance)
 
 	// Prepare request payload
-	payload := map[string]interface{}{
+	payload := caption.addTo(map[string]interface{}{
 		"chatId":   fmt.Sprintf("%s@c.us", requestBody.PhoneNumber),
 		"urlFile":  requestBody.FileUrl,
-		"fileName": getFilename(requestBody.FileUrl),
+		"fileName": fileName,
+	})
+
+	echo := map[string]interface{}{
+		"phoneNumber":      requestBody.PhoneNumber,
+		"fileUrl":          requestBody.FileUrl,
+		"caption":          requestBody.Caption,
+		"idInstance":       requestBody.IDInstance,
+		"apiTokenInstance": "••••••••", // Mask sensitive data
+	}
+
+	// Broadcasts fan out one call per recipient
+	if len(requestBody.PhoneNumbers) > 0 {
+		b := &Broadcast{
+			app:        a,
+			IDInstance: requestBody.IDInstance,
+			Method:     "sendFileByUrl",
+			URL:        apiUrl,
+			Phones:     requestBody.PhoneNumbers,
+			Content:    fileContent(requestBody.FileUrl, requestBody.Caption),
+			PayloadFor: func(chatId string) map[string]interface{} {
+				return caption.addTo(map[string]interface{}{
+					"chatId":   chatId,
+					"urlFile":  requestBody.FileUrl,
+					"fileName": fileName,
+				})
+			},
+		}
+		b.respond(ctx, w, r, rs, echo, bool(requestBody.DryRun))
+		return
 	}
 
-	// Make the API request
-	startTime := time.Now()
-	apiResponse, statusCode, err := makeAPIRequestWithPayload(apiUrl, payload)
+	// Dry runs stop here and show what would be sent
+	if requestBody.DryRun {
+		rs.respond(w, APIResponse{
+			URL:         apiUrl,
+			RequestBody: echo,
+			Payload:     payload,
+			DryRun:      true,
+			Snippets:    snippetsFor(ctx, jsonCall(apiUrl, payload)),
+		})
+		return
+	}
+
+	if a.queueOffline(w, r, rs, ScheduledSend{
+		IDInstance:  requestBody.IDInstance,
+		PhoneNumber: requestBody.PhoneNumber,
+		Method:      "sendFileByUrl",
+		URL:         apiUrl,
+		Payload:     payload,
+		Content:     fileContent(requestBody.FileUrl, requestBody.Caption),
+	}, echo) {
+		return
+	}
+
+	// Keep messages to the same chat in order
+	unlock, err := a.chatLocks.lock(ctx, chatKey(requestBody.IDInstance, requestBody.PhoneNumber+"@c.us"))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("API request failed: %v", err), http.StatusBadGateway)
+		writeChatBusy(w, r)
 		return
 	}
+	defer unlock()
 
-	// Prepare our response
-	response := map[string]interface{}{
-		"url": apiUrl,
-		"requestBody": map[string]interface{}{
-			"phoneNumber":      requestBody.PhoneNumber,
-			"fileUrl":          requestBody.FileUrl,
-			"idInstance":       requestBody.IDInstance,
-			"apiTokenInstance": "••••••••", // Mask sensitive data
-		},
-		"response":    apiResponse,
-		"statusCode":  statusCode,
-		"processedAt": time.Now().Format(time.RFC3339),
-		"requestTime": time.Since(startTime).String(),
+	// Make the API request
+	apiResponse, statusCode, err := a.makeAPIRequestWithPayload(ctx, "sendFileByUrl", apiUrl, payload)
+	a.audit.record(actorOf(r), OutboundMessage{
+		Method:  "sendFileByUrl",
+		URL:     apiUrl,
+		ChatID:  requestBody.PhoneNumber + "@c.us",
+		Content: fileContent(requestBody.FileUrl, requestBody.Caption),
+	}, statusCode, apiResponse, err)
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	rs.respond(w, APIResponse{
+		URL:         apiUrl,
+		RequestBody: echo,
+		Response:    apiResponse,
+		StatusCode:  statusCode,
+		Snippets:    snippetsFor(ctx, jsonCall(apiUrl, payload)),
+	})
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGetFilename(t *testing.T) {
+	cases := []struct {
+		fileUrl string
+		want    string
+	}{
+		{"https://example.com/files/report.pdf", "report.pdf"},
+		// The query and fragment aren't part of the name
+		{"https://example.com/files/report.pdf?token=abc&v=2", "report.pdf"},
+		{"https://example.com/files/report.pdf#page=2", "report.pdf"},
+		{"https://example.com/files/photo.jpg?name=other.png", "photo.jpg"},
+		// Escapes are decoded
+		{"https://example.com/files/annual%20report.pdf", "annual report.pdf"},
+		{"https://example.com/files/dir/", "dir"},
+		{"https://example.com/", ""},
+		{"https://example.com", ""},
+		{"", ""},
+		// Unparsable URLs have no name
+		{"https://example.com/%zz.pdf", ""},
+		{"http://[::1", ""},
+	}
+	for _, tc := range cases {
+		if got := getFilename(tc.fileUrl); got != tc.want {
+			t.Errorf("getFilename(%q) = %q, want %q", tc.fileUrl, got, tc.want)
+		}
+	}
+}
+
+func FuzzGetFilename(f *testing.F) {
+	for _, seed := range []string{
+		"https://example.com/files/report.pdf",
+		"https://example.com/files/report.pdf?token=abc#frag",
+		"https://example.com/files/annual%20report.pdf",
+		"https://example.com/",
+		"http://[::1",
+		"/relative/path/file.txt",
+		"file.txt",
+		"..",
+		"https://example.com/%zz",
+		"",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, fileUrl string) {
+		name := getFilename(fileUrl)
+		if strings.Contains(name, "/") {
+			t.Errorf("getFilename(%q) = %q contains a slash", fileUrl, name)
+		}
+		if name == "." || name == "/" {
+			t.Errorf("getFilename(%q) = %q", fileUrl, name)
+		}
+		if name == "" {
+			return
+		}
+		// The name is the last segment of the path, never the query
+		u, err := url.Parse(fileUrl)
+		if err != nil {
+			t.Fatalf("getFilename(%q) = %q from an unparsable URL", fileUrl, name)
+		}
+		if !strings.HasSuffix(strings.TrimRight(u.Path, "/"), name) {
+			t.Errorf("getFilename(%q) = %q isn't the end of path %q", fileUrl, name, u.Path)
+		}
+	})
+}
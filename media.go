@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxMediaSize is the GREEN-API limit for files sent by URL.
+const maxMediaSize = 100 << 20
+
+const mediaProbeTimeout = 10 * time.Second
+
+// supportedMediaTypes lists the content type prefixes WhatsApp accepts as
+// images, video, audio or documents.
+var supportedMediaTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"application/",
+	"text/plain",
+	"text/csv",
+}
+
+// MediaValidationError explains why a file URL was rejected before sending.
+// Message is a format string for Args so it can be translated.
+type MediaValidationError struct {
+	Code    string
+	Message string
+	Args    []interface{}
+}
+
+func (e *MediaValidationError) Error() string {
+	return fmt.Sprintf(e.Message, e.Args...)
+}
+
+// probeMedia issues a HEAD request to the file URL and checks its size and
+// content type against GREEN-API and WhatsApp limits. Servers that do not
+// report a header are given the benefit of the doubt.
+func probeMedia(ctx context.Context, fileUrl string) error {
+	ctx, cancel := context.WithTimeout(ctx, mediaProbeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, fileUrl, nil)
+	if err != nil {
+		return &MediaValidationError{Code: "invalid_file_url", Message: "Invalid file URL"}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return &MediaValidationError{Code: "file_unreachable", Message: "File URL is not reachable: %v", Args: []interface{}{err}}
+	}
+	resp.Body.Close()
+
+	// Some hosts do not implement HEAD, let GREEN-API decide in that case
+	if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+		return nil
+	}
+
+	if resp.StatusCode >= 400 {
+		return &MediaValidationError{Code: "file_unreachable", Message: "File URL returned status %d", Args: []interface{}{resp.StatusCode}}
+	}
+
+	if resp.ContentLength > maxMediaSize {
+		return &MediaValidationError{
+			Code:    "file_too_large",
+			Message: "File is %d MB, GREEN-API accepts files up to %d MB",
+			Args:    []interface{}{resp.ContentLength >> 20, maxMediaSize >> 20},
+		}
+	}
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || !isSupportedMediaType(mediaType) {
+			if mediaType == "text/html" {
+				return &MediaValidationError{Code: "unsupported_media_type", Message: "File URL points to a web page, not a file"}
+			}
+			return &MediaValidationError{
+				Code:    "unsupported_media_type",
+				Message: "Content type %q is not supported by WhatsApp",
+				Args:    []interface{}{contentType},
+			}
+		}
+	}
+
+	return nil
+}
+
+func isSupportedMediaType(mediaType string) bool {
+	for _, supported := range supportedMediaTypes {
+		if strings.HasPrefix(mediaType, supported) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"strings"
+)
+
+// Normalized forms of the message types other than text. Each is set on a
+// Notification only for its own type, next to the raw Body.
+type (
+	SharedLocation struct {
+		Name      string  `json:"name,omitempty"`
+		Address   string  `json:"address,omitempty"`
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+		Live      bool    `json:"live,omitempty"`
+	}
+	SharedContact struct {
+		DisplayName string   `json:"displayName"`
+		Phones      []string `json:"phones,omitempty"`
+		VCard       string   `json:"vcard"`
+	}
+	MessageFile struct {
+		DownloadURL string `json:"downloadUrl"`
+		FileName    string `json:"fileName,omitempty"`
+		MimeType    string `json:"mimeType,omitempty"`
+		Caption     string `json:"caption,omitempty"`
+	}
+	// ButtonReply is a tap on a button or list row of an earlier message,
+	// the one StanzaID names.
+	ButtonReply struct {
+		StanzaID string `json:"stanzaId"`
+		ID       string `json:"id,omitempty"`
+		Text     string `json:"text"`
+	}
+	// QuotedMessage is the message a reply quotes.
+	QuotedMessage struct {
+		IDMessage   string `json:"idMessage"`
+		Participant string `json:"participant,omitempty"`
+		TypeMessage string `json:"typeMessage"`
+		Text        string `json:"text,omitempty"`
+	}
+	LinkPreview struct {
+		Title       string `json:"title,omitempty"`
+		Description string `json:"description,omitempty"`
+	}
+)
+
+// messageData is the messageData of a message webhook. Only the part
+// matching typeMessage is filled in.
+type messageData struct {
+	TypeMessage     string `json:"typeMessage"`
+	TextMessageData struct {
+		TextMessage string `json:"textMessage"`
+	} `json:"textMessageData"`
+	ExtendedTextMessageData struct {
+		Text        string `json:"text"`
+		Description string `json:"description"`
+		Title       string `json:"title"`
+		StanzaID    string `json:"stanzaId"`
+	} `json:"extendedTextMessageData"`
+	QuotedMessage *struct {
+		StanzaID     string `json:"stanzaId"`
+		Participant  string `json:"participant"`
+		TypeMessage  string `json:"typeMessage"`
+		TextMessage  string `json:"textMessage"`
+		Caption      string `json:"caption"`
+		NameLocation string `json:"nameLocation"`
+		DisplayName  string `json:"displayName"`
+		SelectedText string `json:"selectedButtonText"`
+		Text         string `json:"text"`
+	} `json:"quotedMessage"`
+	LocationMessageData struct {
+		NameLocation string  `json:"nameLocation"`
+		Address      string  `json:"address"`
+		Latitude     float64 `json:"latitude"`
+		Longitude    float64 `json:"longitude"`
+	} `json:"locationMessageData"`
+	ContactMessageData struct {
+		DisplayName string `json:"displayName"`
+		VCard       string `json:"vcard"`
+	} `json:"contactMessageData"`
+	MessageData struct {
+		Contacts []struct {
+			DisplayName string `json:"displayName"`
+			VCard       string `json:"vcard"`
+		} `json:"contacts"`
+	} `json:"messageData"`
+	FileMessageData struct {
+		DownloadURL string `json:"downloadUrl"`
+		Caption     string `json:"caption"`
+		FileName    string `json:"fileName"`
+		MimeType    string `json:"mimeType"`
+	} `json:"fileMessageData"`
+	ButtonsResponseMessage struct {
+		StanzaID           string `json:"stanzaId"`
+		SelectedButtonID   string `json:"selectedButtonId"`
+		SelectedButtonText string `json:"selectedButtonText"`
+	} `json:"buttonsResponseMessage"`
+	TemplateButtonReplyMessage struct {
+		StanzaID            string `json:"stanzaId"`
+		SelectedID          string `json:"selectedId"`
+		SelectedDisplayText string `json:"selectedDisplayText"`
+	} `json:"templateButtonReplyMessage"`
+	ListResponseMessage struct {
+		StanzaID          string `json:"stanzaId"`
+		Title             string `json:"title"`
+		SingleSelectReply struct {
+			SelectedRowID string `json:"selectedRowId"`
+		} `json:"singleSelectReply"`
+	} `json:"listResponseMessage"`
+	PollMessageData *pollMessageData `json:"pollMessageData"`
+}
+
+// normalize fills the typed fields of notification for the message type,
+// and its Text with whatever text the message shows.
+func (data messageData) normalize(notification *Notification) {
+	extended := data.ExtendedTextMessageData
+	switch data.TypeMessage {
+	case "textMessage":
+		notification.Text = data.TextMessageData.TextMessage
+	case "extendedTextMessage":
+		notification.Text = extended.Text
+		if extended.Title != "" || extended.Description != "" {
+			notification.Link = &LinkPreview{Title: extended.Title, Description: extended.Description}
+		}
+	case "quotedMessage":
+		notification.Text = extended.Text
+		if quoted := data.QuotedMessage; quoted != nil {
+			notification.Quoted = &QuotedMessage{
+				IDMessage:   quoted.StanzaID,
+				Participant: quoted.Participant,
+				TypeMessage: quoted.TypeMessage,
+				Text:        cmp.Or(quoted.TextMessage, quoted.Text, quoted.Caption, quoted.NameLocation, quoted.DisplayName, quoted.SelectedText),
+			}
+		}
+	case "locationMessage", "liveLocationMessage":
+		location := data.LocationMessageData
+		notification.Text = cmp.Or(location.NameLocation, location.Address)
+		notification.Location = &SharedLocation{
+			Name:      location.NameLocation,
+			Address:   location.Address,
+			Latitude:  location.Latitude,
+			Longitude: location.Longitude,
+			Live:      data.TypeMessage == "liveLocationMessage",
+		}
+	case "contactMessage":
+		contact := data.ContactMessageData
+		notification.Text = contact.DisplayName
+		notification.Contacts = []SharedContact{vcardContact(contact.DisplayName, contact.VCard)}
+	case "contactsArrayMessage":
+		names := make([]string, 0, len(data.MessageData.Contacts))
+		for _, contact := range data.MessageData.Contacts {
+			notification.Contacts = append(notification.Contacts, vcardContact(contact.DisplayName, contact.VCard))
+			names = append(names, contact.DisplayName)
+		}
+		notification.Text = strings.Join(names, ", ")
+	case "imageMessage", "videoMessage", "documentMessage", "audioMessage", "stickerMessage":
+		file := data.FileMessageData
+		notification.Text = file.Caption
+		notification.File = &MessageFile{
+			DownloadURL: file.DownloadURL,
+			FileName:    file.FileName,
+			MimeType:    file.MimeType,
+			Caption:     file.Caption,
+		}
+	case "buttonsResponseMessage":
+		reply := data.ButtonsResponseMessage
+		notification.Text = reply.SelectedButtonText
+		notification.Reply = &ButtonReply{StanzaID: reply.StanzaID, ID: reply.SelectedButtonID, Text: reply.SelectedButtonText}
+	case "templateButtonsReplyMessage":
+		reply := data.TemplateButtonReplyMessage
+		notification.Text = reply.SelectedDisplayText
+		notification.Reply = &ButtonReply{StanzaID: reply.StanzaID, ID: reply.SelectedID, Text: reply.SelectedDisplayText}
+	case "listResponseMessage":
+		reply := data.ListResponseMessage
+		notification.Text = reply.Title
+		notification.Reply = &ButtonReply{StanzaID: reply.StanzaID, ID: reply.SingleSelectReply.SelectedRowID, Text: reply.Title}
+	case "pollMessage":
+		if data.PollMessageData != nil {
+			notification.Text = data.PollMessageData.Name
+		}
+	}
+}
+
+// vcardContact takes the phone numbers out of a shared contact's vCard.
+func vcardContact(displayName, vcard string) SharedContact {
+	contact := SharedContact{DisplayName: displayName, VCard: vcard}
+	scanner := bufio.NewScanner(strings.NewReader(vcard))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// e.g. TEL;type=CELL;waid=79001234567:+7 900 123-45-67
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.HasPrefix(strings.ToUpper(name), "TEL") {
+			continue
+		}
+		if _, waid, ok := strings.Cut(name, "waid="); ok {
+			value, _, _ = strings.Cut(waid, ";")
+		}
+		contact.Phones = append(contact.Phones, value)
+	}
+	return contact
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// GREEN-API hosts. Settings are read from the 1103 host and uploads go to
+// the media host; everything else uses the main one. -upstream-url sends
+// all of them to one base instead.
+const (
+	apiBase      = "https://api.green-api.com"
+	settingsBase = "https://1103.api.green-api.com"
+	mediaBase    = "https://media.green-api.com"
+)
+
+// APIMethod describes how a GREEN-API method is called. Handlers build
+// their URLs from it, and the raw request builder takes its verb, role and
+// body checks from it, so supporting a new method takes an entry in
+// apiMethods and a payload struct.
+type APIMethod struct {
+	Name string
+	Verb string
+	Base string
+	// PathParams name the values that follow the token in the URL.
+	PathParams []string
+	// Payload is the type of the JSON body, nil for methods without one.
+	Payload reflect.Type
+	// Form methods take a multipart upload and only have their own
+	// endpoints.
+	Form    bool
+	Role    Role
+	Audited bool
+}
+
+// Payloads of the GREEN-API methods. Fields tagged api:"required" must be
+// set; GREEN-API ignores fields it doesn't know, so others may be added.
+type (
+	SendMessagePayload struct {
+		ChatID          string `json:"chatId" api:"required"`
+		Message         string `json:"message" api:"required"`
+		QuotedMessageID string `json:"quotedMessageId,omitempty"`
+		LinkPreview     *bool  `json:"linkPreview,omitempty"`
+	}
+	SendFileByURLPayload struct {
+		ChatID          string `json:"chatId" api:"required"`
+		URLFile         string `json:"urlFile" api:"required"`
+		FileName        string `json:"fileName" api:"required"`
+		Caption         string `json:"caption,omitempty"`
+		QuotedMessageID string `json:"quotedMessageId,omitempty"`
+	}
+	SendPollPayload struct {
+		ChatID  string `json:"chatId" api:"required"`
+		Message string `json:"message" api:"required"`
+		Options []struct {
+			OptionName string `json:"optionName"`
+		} `json:"options" api:"required"`
+		MultipleAnswers bool `json:"multipleAnswers,omitempty"`
+	}
+	SendLocationPayload struct {
+		ChatID       string  `json:"chatId" api:"required"`
+		NameLocation string  `json:"nameLocation,omitempty"`
+		Address      string  `json:"address,omitempty"`
+		Latitude     float64 `json:"latitude" api:"required"`
+		Longitude    float64 `json:"longitude" api:"required"`
+	}
+	SendContactPayload struct {
+		ChatID  string `json:"chatId" api:"required"`
+		Contact struct {
+			PhoneContact int64  `json:"phoneContact"`
+			FirstName    string `json:"firstName,omitempty"`
+			LastName     string `json:"lastName,omitempty"`
+			Company      string `json:"company,omitempty"`
+		} `json:"contact" api:"required"`
+	}
+	ForwardMessagesPayload struct {
+		ChatID     string   `json:"chatId" api:"required"`
+		ChatIDFrom string   `json:"chatIdFrom" api:"required"`
+		Messages   []string `json:"messages" api:"required"`
+	}
+	SendReactionPayload struct {
+		ChatID    string `json:"chatId" api:"required"`
+		IDMessage string `json:"idMessage" api:"required"`
+		Reaction  string `json:"reaction"`
+	}
+	ReadChatPayload struct {
+		ChatID    string `json:"chatId" api:"required"`
+		IDMessage string `json:"idMessage,omitempty"`
+	}
+	SendTypingPayload struct {
+		ChatID     string `json:"chatId" api:"required"`
+		TypingTime int    `json:"typingTime,omitempty"`
+		TypingType string `json:"typingType,omitempty"`
+	}
+	GetChatHistoryPayload struct {
+		ChatID string `json:"chatId" api:"required"`
+		Count  int    `json:"count,omitempty"`
+	}
+	DownloadFilePayload struct {
+		ChatID    string `json:"chatId" api:"required"`
+		IDMessage string `json:"idMessage" api:"required"`
+	}
+	CheckWhatsappPayload struct {
+		PhoneNumber int64 `json:"phoneNumber" api:"required"`
+	}
+	GetGroupDataPayload struct {
+		GroupID string `json:"groupId" api:"required"`
+	}
+	SetProfileNamePayload struct {
+		Name string `json:"name" api:"required"`
+	}
+	// SetSettingsPayload holds only the settings being changed.
+	SetSettingsPayload map[string]interface{}
+)
+
+func payloadOf[T any]() reflect.Type {
+	return reflect.TypeFor[T]()
+}
+
+var apiMethods = map[string]APIMethod{
+	"getSettings":          {Verb: http.MethodGet, Base: settingsBase, Role: roleViewer},
+	"getStateInstance":     {Verb: http.MethodGet, Role: roleViewer},
+	"getWaSettings":        {Verb: http.MethodGet, Role: roleViewer},
+	"getContacts":          {Verb: http.MethodGet, Role: roleViewer},
+	"getChatHistory":       {Verb: http.MethodPost, Payload: payloadOf[GetChatHistoryPayload](), Role: roleViewer},
+	"lastIncomingMessages": {Verb: http.MethodGet, Role: roleViewer},
+	"lastOutgoingMessages": {Verb: http.MethodGet, Role: roleViewer},
+	"checkWhatsapp":        {Verb: http.MethodPost, Payload: payloadOf[CheckWhatsappPayload](), Role: roleViewer},
+	"getGroupData":         {Verb: http.MethodPost, Payload: payloadOf[GetGroupDataPayload](), Role: roleViewer},
+	"downloadFile":         {Verb: http.MethodPost, Payload: payloadOf[DownloadFilePayload](), Role: roleViewer},
+	"sendMessage":          {Verb: http.MethodPost, Payload: payloadOf[SendMessagePayload](), Role: roleSender, Audited: true},
+	"sendFileByUrl":        {Verb: http.MethodPost, Payload: payloadOf[SendFileByURLPayload](), Role: roleSender, Audited: true},
+	"sendFileByUpload":     {Verb: http.MethodPost, Base: mediaBase, Form: true, Role: roleSender, Audited: true},
+	"sendPoll":             {Verb: http.MethodPost, Payload: payloadOf[SendPollPayload](), Role: roleSender, Audited: true},
+	"sendLocation":         {Verb: http.MethodPost, Payload: payloadOf[SendLocationPayload](), Role: roleSender, Audited: true},
+	"sendContact":          {Verb: http.MethodPost, Payload: payloadOf[SendContactPayload](), Role: roleSender, Audited: true},
+	"forwardMessages":      {Verb: http.MethodPost, Payload: payloadOf[ForwardMessagesPayload](), Role: roleSender, Audited: true},
+	"sendReaction":         {Verb: http.MethodPost, Payload: payloadOf[SendReactionPayload](), Role: roleSender, Audited: true},
+	"readChat":             {Verb: http.MethodPost, Payload: payloadOf[ReadChatPayload](), Role: roleSender},
+	"sendTyping":           {Verb: http.MethodPost, Payload: payloadOf[SendTypingPayload](), Role: roleSender},
+	"setSettings":          {Verb: http.MethodPost, Payload: payloadOf[SetSettingsPayload](), Role: roleAdmin},
+	"setProfileName":       {Verb: http.MethodPost, Payload: payloadOf[SetProfileNamePayload](), Role: roleAdmin},
+	"setProfilePicture":    {Verb: http.MethodPost, Form: true, Role: roleAdmin},
+	"receiveNotification":  {Verb: http.MethodGet, Role: roleAdmin},
+	"deleteNotification":   {Verb: http.MethodDelete, PathParams: []string{"receiptId"}, Role: roleAdmin},
+	"logout":               {Verb: http.MethodGet, Role: roleAdmin},
+	"reboot":               {Verb: http.MethodGet, Role: roleAdmin},
+}
+
+func init() {
+	for name, method := range apiMethods {
+		method.Name = name
+		if method.Base == "" {
+			method.Base = apiBase
+		}
+		apiMethods[name] = method
+	}
+}
+
+// url is the address of the method for an instance on base.
+func (m APIMethod) url(base, idInstance, apiTokenInstance string, params ...string) string {
+	apiUrl := fmt.Sprintf("%s/waInstance%s/%s/%s", base,
+		url.PathEscape(idInstance),
+		m.Name,
+		url.PathEscape(apiTokenInstance))
+	for _, param := range params {
+		apiUrl += "/" + url.PathEscape(param)
+	}
+	return apiUrl
+}
+
+// upstreamBase is where calls meant for a GREEN-API host go.
+func (a *App) upstreamBase(base string) string {
+	if a.config.UpstreamURL != "" {
+		return a.config.UpstreamURL
+	}
+	return base
+}
+
+// methodURL is the address of a catalogued method for an instance. Names
+// are constants in the handlers, so an unknown one is a bug.
+func (a *App) methodURL(name, idInstance, apiTokenInstance string, params ...string) string {
+	method, ok := apiMethods[name]
+	if !ok {
+		panic("GREEN-API method missing from apiMethods: " + name)
+	}
+	return method.url(a.upstreamBase(method.Base), idInstance, apiTokenInstance, params...)
+}
+
+// checkPayload decodes body into the method's payload type and reports the
+// first problem: a value of the wrong type or a missing required field.
+func (m APIMethod) checkPayload(body []byte) error {
+	if m.Payload == nil {
+		if len(body) > 0 {
+			return fmt.Errorf("%s takes no body", m.Name)
+		}
+		return nil
+	}
+	if len(body) == 0 {
+		body = []byte("{}")
+	}
+
+	payload := reflect.New(m.Payload)
+	if err := json.Unmarshal(body, payload.Interface()); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return fmt.Errorf("%s must be a %s", typeErr.Field, jsonType(typeErr.Type))
+		}
+		return fmt.Errorf("body must be a JSON object")
+	}
+	if missing := missingFields(payload.Elem()); len(missing) > 0 {
+		return fmt.Errorf("%s needs %s", m.Name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// missingFields lists the JSON names of required fields left empty.
+func missingFields(v reflect.Value) []string {
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	var missing []string
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Tag.Get("api") == "required" && v.Field(i).IsZero() {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// MethodInfo is a catalogued method as listed by /api/methods.
+type MethodInfo struct {
+	Name       string         `json:"name"`
+	HTTPMethod string         `json:"httpMethod"`
+	PathParams []string       `json:"pathParams,omitempty"`
+	Fields     []PayloadField `json:"fields,omitempty"`
+	Upload     bool           `json:"upload,omitempty"`
+	Role       string         `json:"role"`
+}
+
+// PayloadField is a top-level field of a method's JSON body.
+type PayloadField struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Required bool   `json:"required,omitempty"`
+}
+
+func (m APIMethod) info() MethodInfo {
+	info := MethodInfo{Name: m.Name, HTTPMethod: m.Verb, PathParams: m.PathParams, Upload: m.Form, Role: m.Role.String()}
+	if m.Payload == nil || m.Payload.Kind() != reflect.Struct {
+		return info
+	}
+	for i := 0; i < m.Payload.NumField(); i++ {
+		field := m.Payload.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		info.Fields = append(info.Fields, PayloadField{
+			Name:     name,
+			Type:     jsonType(field.Type),
+			Required: field.Tag.Get("api") == "required",
+		})
+	}
+	return info
+}
+
+// jsonType names the JSON type a Go type is encoded as.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonType(t.Elem())
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		return "number"
+	case reflect.Slice:
+		return "array"
+	}
+	return "object"
+}
+
+// methodsHandler lists the catalogued methods for the raw request builder.
+func methodsHandler(w http.ResponseWriter, r *http.Request) {
+	list := make([]MethodInfo, 0, len(apiMethods))
+	for _, method := range apiMethods {
+		list = append(list, method.info())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
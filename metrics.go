@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// labelEscaper escapes Prometheus label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetric writes the header of a metric family in the Prometheus text
+// format.
+func writeMetric(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// writeSample writes one sample, labels given as name, value pairs.
+func writeSample(w io.Writer, name string, value float64, labels ...string) {
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+	if len(pairs) > 0 {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(pairs, ","), strconv.FormatFloat(value, 'g', -1, 64))
+	} else {
+		fmt.Fprintf(w, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+	}
+}
+
+// metricsHandler exposes the stats counters for Prometheus to scrape.
+// Counters reset on restart, which Prometheus' rate() copes with.
+func (a *App) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot := a.stats.snapshot(workspaceOf(r))
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	routes := slices.Sorted(maps.Keys(snapshot.Endpoints))
+	writeMetric(w, "grapi_requests_total", "counter", "Requests served, by route.")
+	for _, route := range routes {
+		writeSample(w, "grapi_requests_total", float64(snapshot.Endpoints[route].Requests), "route", route)
+	}
+	writeMetric(w, "grapi_request_errors_total", "counter", "Requests answered with a 4xx or 5xx status, by route.")
+	for _, route := range routes {
+		writeSample(w, "grapi_request_errors_total", float64(snapshot.Endpoints[route].Errors), "route", route)
+	}
+
+	methods := slices.Sorted(maps.Keys(snapshot.Methods))
+	writeMetric(w, "grapi_upstream_calls_total", "counter", "GREEN-API calls, by method.")
+	for _, method := range methods {
+		writeSample(w, "grapi_upstream_calls_total", float64(snapshot.Methods[method].Calls), "method", method)
+	}
+	writeMetric(w, "grapi_upstream_call_errors_total", "counter", "Failed GREEN-API calls, by method.")
+	for _, method := range methods {
+		writeSample(w, "grapi_upstream_call_errors_total", float64(snapshot.Methods[method].Errors), "method", method)
+	}
+
+	writeMetric(w, "grapi_upstream_latency_seconds", "histogram", "GREEN-API call latency, by method.")
+	for _, method := range methods {
+		m := snapshot.Methods[method]
+		cumulative := 0
+		for i, bucket := range m.Latency {
+			cumulative += bucket.Count
+			le := "+Inf"
+			if i < len(latencyBuckets) {
+				le = strconv.FormatFloat(latencyBuckets[i].Seconds(), 'g', -1, 64)
+			}
+			writeSample(w, "grapi_upstream_latency_seconds_bucket", float64(cumulative), "method", method, "le", le)
+		}
+		writeSample(w, "grapi_upstream_latency_seconds_sum", m.TotalLatency.Seconds(), "method", method)
+		writeSample(w, "grapi_upstream_latency_seconds_count", float64(m.Calls), "method", method)
+	}
+
+	writeMetric(w, "grapi_upstream_errors_total", "counter", "GREEN-API calls answered with 401, 429, 466 or 5xx, by instance and status.")
+	for _, idInstance := range slices.Sorted(maps.Keys(snapshot.Instances)) {
+		totals := snapshot.Instances[idInstance].Totals
+		for _, class := range slices.Sorted(maps.Keys(totals)) {
+			writeSample(w, "grapi_upstream_errors_total", float64(totals[class]), "instance", idInstance, "status", class)
+		}
+	}
+
+	writeMetric(w, "grapi_upstream_connections_total", "counter", "Connections GREEN-API calls were sent on, by whether an idle one was reused.")
+	writeSample(w, "grapi_upstream_connections_total", float64(snapshot.Connections.Opened), "reused", "false")
+	writeSample(w, "grapi_upstream_connections_total", float64(snapshot.Connections.Reused), "reused", "true")
+
+	writeMetric(w, "grapi_upstream_breaker_open", "gauge", "Whether GREEN-API calls of an instance are paused after failing in a row.")
+	breakers := slices.DeleteFunc(a.upstream.instances.values(), func(breaker *Breaker) bool {
+		return !canSee(workspaceOf(r), a.instanceWorkspace(breaker.idInstance))
+	})
+	slices.SortFunc(breakers, func(x, y *Breaker) int { return strings.Compare(x.idInstance, y.idInstance) })
+	for _, breaker := range breakers {
+		breakerOpen := 0.0
+		if breaker.isOpen() {
+			breakerOpen = 1
+		}
+		writeSample(w, "grapi_upstream_breaker_open", breakerOpen, "instance", breaker.idInstance)
+	}
+
+	writeMetric(w, "grapi_webhook_anomalies_total", "counter", "Received webhooks that didn't match their schema, by typeWebhook.")
+	totals := a.anomalies.totals()
+	for _, typeWebhook := range slices.Sorted(maps.Keys(totals)) {
+		writeSample(w, "grapi_webhook_anomalies_total", float64(totals[typeWebhook]), "type", typeWebhook)
+	}
+
+	running, rejected := a.inFlight.counts()
+	writeMetric(w, "grapi_in_flight_requests", "gauge", "Requests running, by -max-in-flight class.")
+	for _, class := range inFlightClasses {
+		writeSample(w, "grapi_in_flight_requests", float64(running[class]), "class", class)
+	}
+	writeMetric(w, "grapi_in_flight_rejected_total", "counter", "Requests turned away with 503 because their class was full, by class.")
+	for _, class := range inFlightClasses {
+		writeSample(w, "grapi_in_flight_rejected_total", float64(rejected[class]), "class", class)
+	}
+
+	writeMetric(w, "grapi_active_streams", "gauge", "Open server-sent event streams.")
+	writeSample(w, "grapi_active_streams", float64(snapshot.ActiveStreams))
+}
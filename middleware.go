@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Route is a handler and what its middleware needs to know about it.
+type Route struct {
+	Pattern string
+	// Role is the least role allowed to call the route, 0 for public ones.
+	Role    Role
+	Feature string
+	// Stats counts the route's responses in /api/stats under its path.
+	Stats       bool
+	Passthrough bool
+	// InFlight is the class whose -max-in-flight limit the route counts
+	// against, empty for none.
+	InFlight string
+	Handler  http.HandlerFunc
+}
+
+// path is the route's pattern without its method.
+func (rt Route) path() string {
+	if _, path, ok := strings.Cut(rt.Pattern, " "); ok {
+		return path
+	}
+	return rt.Pattern
+}
+
+// Routes fall into groups by path, and each group runs through the
+// middleware stages -middleware switches on for it.
+const (
+	groupAPI     = "api"
+	groupPages   = "pages"
+	groupWebhook = "webhook"
+)
+
+func routeGroup(path string) string {
+	switch {
+	case path == "/webhook":
+		return groupWebhook
+	case strings.HasPrefix(path, "/api/"), path == "/metrics", strings.HasPrefix(path, "/debug/"), strings.HasPrefix(path, "/graphql"):
+		return groupAPI
+	}
+	return groupPages
+}
+
+// middleware wraps the handler of a route of the app.
+type middleware func(a *App, rt Route, next http.HandlerFunc) http.HandlerFunc
+
+// middlewareStages run in this order, outermost first, whatever order
+// -middleware lists them in. The route's in-flight limit, feature check
+// and passthrough always run inside them.
+var middlewareStages = []string{"recover", "log", "cors", "ratelimit", "metrics", "auth"}
+
+var middlewares = map[string]middleware{
+	"recover":   (*App).withRecovery,
+	"log":       (*App).withRequestLog,
+	"cors":      (*App).withCORS,
+	"ratelimit": (*App).withRateLimit,
+	"metrics": func(a *App, rt Route, next http.HandlerFunc) http.HandlerFunc {
+		if !rt.Stats {
+			return next
+		}
+		return a.withStats(rt.path(), next)
+	},
+	"auth": func(a *App, rt Route, next http.HandlerFunc) http.HandlerFunc {
+		if rt.Role == 0 {
+			return next
+		}
+		return a.requireRole(rt.Role, next)
+	},
+}
+
+// middlewareChains maps route groups to their middleware stages, set as
+// group=stage+stage pairs, e.g. api=recover+log+metrics+auth,webhook=metrics.
+// A group given replaces its default stages.
+type middlewareChains map[string][]string
+
+func defaultMiddleware() middlewareChains {
+	return middlewareChains{
+		groupAPI:     {"metrics", "auth"},
+		groupPages:   {"auth"},
+		groupWebhook: {"metrics"},
+	}
+}
+
+func (c middlewareChains) String() string {
+	pairs := make([]string, 0, len(c))
+	for group, stages := range c {
+		pairs = append(pairs, group+"="+strings.Join(stages, "+"))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (c middlewareChains) Set(value string) error {
+	for _, pair := range splitList(value) {
+		group, list, ok := strings.Cut(pair, "=")
+		group = strings.TrimSpace(group)
+		if !ok || (group != groupAPI && group != groupPages && group != groupWebhook) {
+			return fmt.Errorf("invalid middleware %q, expected api, pages or webhook=stage+stage", pair)
+		}
+		var stages []string
+		for _, stage := range strings.Split(list, "+") {
+			stage = strings.TrimSpace(stage)
+			if stage == "" {
+				continue
+			}
+			if middlewares[stage] == nil {
+				return fmt.Errorf("unknown middleware %q, expected one of %s", stage, strings.Join(middlewareStages, ", "))
+			}
+			stages = append(stages, stage)
+		}
+		c[group] = stages
+	}
+	return nil
+}
+
+// handle registers a route on the app's mux, wrapped in the middleware of
+// its group.
+func (a *App) handle(rt Route) {
+	next := rt.Handler
+	if rt.Passthrough {
+		next = withPassthrough(next)
+	}
+	if rt.Feature != "" {
+		next = a.requireFeature(rt.Feature, next)
+	}
+	if rt.InFlight != "" {
+		next = a.withInFlightLimit(rt.InFlight, next)
+	}
+	stages := a.config.Middleware[routeGroup(rt.path())]
+	for i := len(middlewareStages) - 1; i >= 0; i-- {
+		if slices.Contains(stages, middlewareStages[i]) {
+			next = middlewares[middlewareStages[i]](a, rt, next)
+		}
+	}
+	a.mux.HandleFunc(rt.Pattern, next)
+}
+
+// warnMiddleware logs the protections switched off by -middleware.
+func (a *App) warnMiddleware() {
+	for _, group := range []string{groupAPI, groupPages} {
+		if a.authEnabled() && !slices.Contains(a.config.Middleware[group], "auth") {
+			warnf("Warning: auth is off for the %s routes", group)
+		}
+	}
+}
+
+// withRecovery turns a panicking handler into a 500 instead of a dropped
+// connection, and logs the stack.
+func (a *App) withRecovery(rt Route, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				errorf("Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+				writeError(w, r, http.StatusInternalServerError, "internal_error", "Internal server error")
+			}
+		}()
+		next(w, r)
+	}
+}
+
+// withRequestLog logs each request with its client, status and duration.
+func (a *App) withRequestLog(rt Route, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		infof("%s %s %s %d %s", r.RemoteAddr, r.Method, r.URL.Path, rec.status, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// withCORS lets pages on -cors-origins call the route from a browser.
+// Preflights are answered by corsPreflight, since most routes only match
+// their own method.
+func (a *App) withCORS(rt Route, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a.setCORSHeaders(w, r)
+		next(w, r)
+	}
+}
+
+// setCORSHeaders allows the request's origin when it is listed, reporting
+// whether it was.
+func (a *App) setCORSHeaders(w http.ResponseWriter, r *http.Request) bool {
+	origin, allowed := r.Header.Get("Origin"), a.liveConfig().CORSOrigins
+	if origin == "" || !(slices.Contains(allowed, origin) || slices.Contains(allowed, "*")) {
+		return false
+	}
+	w.Header().Add("Vary", "Origin")
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count, X-Request-Id")
+	return true
+}
+
+// corsPreflight answers the OPTIONS preflights of browsers for groups with
+// the cors stage, and hands every other request to next.
+func (a *App) corsPreflight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" ||
+			!slices.Contains(a.config.Middleware[routeGroup(r.URL.Path)], "cors") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if a.setCORSHeaders(w, r) {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-API-Key")
+			w.Header().Set("Access-Control-Max-Age", "600")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// maxRateClients bounds the clients the rate limiter remembers; idle ones
+// are dropped beyond it.
+const maxRateClients = 10000
+
+// rateBucket is a token bucket of one client.
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter allows each client -rate-limit requests a second on average,
+// in bursts of up to -rate-burst. Clients are told apart by IP address.
+type RateLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*rateBucket
+	// config returns the live config.
+	config func() *Config
+}
+
+func newRateLimiter() *RateLimiter {
+	return &RateLimiter{clients: make(map[string]*rateBucket)}
+}
+
+// allow takes a token of client, or reports how long until one is there.
+func (l *RateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	live := l.config()
+	limit, burst := live.RateLimit, float64(max(live.RateBurst, 1))
+	if limit <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.clients[client]
+	if !ok {
+		if len(l.clients) >= maxRateClients {
+			l.dropIdle(now, burst/limit)
+		}
+		bucket = &rateBucket{tokens: burst, last: now}
+		l.clients[client] = bucket
+	}
+	bucket.tokens = min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*limit)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / limit * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// dropIdle forgets clients whose bucket has refilled, which takes refill
+// seconds. The caller holds mu.
+func (l *RateLimiter) dropIdle(now time.Time, refill float64) {
+	for client, bucket := range l.clients {
+		if now.Sub(bucket.last).Seconds() >= refill {
+			delete(l.clients, client)
+		}
+	}
+}
+
+func (a *App) withRateLimit(rt Route, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		if ok, wait := a.rateLimiter.allow(client, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			writeError(w, r, http.StatusTooManyRequests, "rate_limited", "Too many requests, slow down")
+			return
+		}
+		next(w, r)
+	}
+}
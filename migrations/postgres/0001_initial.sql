@@ -0,0 +1,35 @@
+-- History, browser sessions and scheduled sends. IF NOT EXISTS adopts
+-- databases created before migrations were tracked.
+
+CREATE TABLE IF NOT EXISTS history (
+	id BIGSERIAL PRIMARY KEY,
+	time BIGINT NOT NULL,
+	workspace TEXT NOT NULL,
+	method TEXT NOT NULL,
+	http_method TEXT NOT NULL,
+	url TEXT NOT NULL,
+	status INTEGER NOT NULL,
+	duration TEXT NOT NULL,
+	error TEXT NOT NULL,
+	response TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	token_hash TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	role TEXT NOT NULL,
+	workspace TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	expires_at BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS scheduled_sends (
+	id BIGSERIAL PRIMARY KEY,
+	status TEXT NOT NULL,
+	send_at BIGINT NOT NULL,
+	url TEXT NOT NULL,
+	content TEXT NOT NULL,
+	data TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS scheduled_sends_due ON scheduled_sends (status, send_at);
@@ -0,0 +1,7 @@
+-- Request bodies and the size and truncation of both bodies.
+
+ALTER TABLE history ADD COLUMN request TEXT NOT NULL DEFAULT '';
+ALTER TABLE history ADD COLUMN request_size INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE history ADD COLUMN request_truncated BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE history ADD COLUMN response_size INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE history ADD COLUMN response_truncated BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,8 @@
+-- Files of incoming messages saved to -media-dir, by message ID.
+
+CREATE TABLE attachments (
+	id_message TEXT PRIMARY KEY,
+	downloaded_at BIGINT NOT NULL,
+	path TEXT NOT NULL,
+	data TEXT NOT NULL
+);
@@ -0,0 +1,7 @@
+-- Instances added through /api/admin/instances, by idInstance.
+
+CREATE TABLE managed_instances (
+	id_instance TEXT PRIMARY KEY,
+	created_at BIGINT NOT NULL,
+	data TEXT NOT NULL
+);
@@ -0,0 +1,6 @@
+-- Named sets of instance settings, applied with setSettings.
+
+CREATE TABLE settings_presets (
+	name TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
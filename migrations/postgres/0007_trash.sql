@@ -0,0 +1,10 @@
+-- Things deleted through the API, kept for -trash-ttl to be restored.
+
+CREATE TABLE trash (
+	id BIGSERIAL PRIMARY KEY,
+	workspace TEXT NOT NULL,
+	deleted_at BIGINT NOT NULL,
+	data TEXT NOT NULL
+);
+
+CREATE INDEX trash_deleted_at ON trash (deleted_at);
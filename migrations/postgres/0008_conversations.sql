@@ -0,0 +1,8 @@
+-- Inbox conversations, one per chat of an instance.
+
+CREATE TABLE conversations (
+	id_instance TEXT NOT NULL,
+	chat_id TEXT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (id_instance, chat_id)
+);
@@ -0,0 +1,6 @@
+-- Canned replies by shortcut.
+
+CREATE TABLE canned_replies (
+	shortcut TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
@@ -0,0 +1,8 @@
+-- Labels of chats, one row per labelled chat of an instance.
+
+CREATE TABLE chat_labels (
+	id_instance TEXT NOT NULL,
+	chat_id TEXT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (id_instance, chat_id)
+);
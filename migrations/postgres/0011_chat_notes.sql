@@ -0,0 +1,8 @@
+-- Notes and metadata of chats, one row per annotated chat of an instance.
+
+CREATE TABLE chat_notes (
+	id_instance TEXT NOT NULL,
+	chat_id TEXT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (id_instance, chat_id)
+);
@@ -0,0 +1,16 @@
+-- Text of received messages, indexed for /api/search.
+
+CREATE TABLE messages (
+	id BIGSERIAL PRIMARY KEY,
+	time BIGINT NOT NULL,
+	workspace TEXT NOT NULL,
+	id_instance TEXT NOT NULL,
+	chat_id TEXT NOT NULL,
+	id_message TEXT NOT NULL,
+	type_webhook TEXT NOT NULL,
+	text TEXT NOT NULL,
+	search TSVECTOR GENERATED ALWAYS AS (to_tsvector('simple', text)) STORED
+);
+
+CREATE INDEX messages_time ON messages (time);
+CREATE INDEX messages_search ON messages USING GIN (search);
@@ -0,0 +1,11 @@
+-- Saved files by instance and message ID, as message IDs are only unique
+-- within an instance. The instance of existing rows is taken from their
+-- JSON.
+
+ALTER TABLE attachments ADD COLUMN id_instance TEXT NOT NULL DEFAULT '';
+
+UPDATE attachments SET id_instance = COALESCE(data::json->>'idInstance', '');
+
+ALTER TABLE attachments DROP CONSTRAINT attachments_pkey;
+
+ALTER TABLE attachments ADD PRIMARY KEY (id_instance, id_message);
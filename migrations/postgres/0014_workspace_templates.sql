@@ -0,0 +1,14 @@
+-- Presets and canned replies by workspace, so teams sharing a deployment
+-- keep their own. Existing rows belong to no workspace.
+
+ALTER TABLE settings_presets ADD COLUMN workspace TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE settings_presets DROP CONSTRAINT settings_presets_pkey;
+
+ALTER TABLE settings_presets ADD PRIMARY KEY (workspace, name);
+
+ALTER TABLE canned_replies ADD COLUMN workspace TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE canned_replies DROP CONSTRAINT canned_replies_pkey;
+
+ALTER TABLE canned_replies ADD PRIMARY KEY (workspace, shortcut);
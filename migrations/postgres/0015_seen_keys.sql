@@ -0,0 +1,11 @@
+-- Keys of webhooks and signatures already seen, kept until expires_at so
+-- redeliveries are dropped across restarts.
+
+CREATE TABLE seen_keys (
+	kind TEXT NOT NULL,
+	seen_key TEXT NOT NULL,
+	expires_at BIGINT NOT NULL,
+	PRIMARY KEY (kind, seen_key)
+);
+
+CREATE INDEX seen_keys_expires_at ON seen_keys (expires_at);
@@ -0,0 +1,6 @@
+-- Settings instances should have, checked for drift.
+
+CREATE TABLE desired_settings (
+	id_instance TEXT PRIMARY KEY,
+	data TEXT NOT NULL
+);
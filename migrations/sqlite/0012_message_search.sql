@@ -0,0 +1,25 @@
+-- Text of received messages, indexed with FTS4 for /api/search. FTS4 rather
+-- than FTS5 because go-sqlite3 only builds FTS5 with the sqlite_fts5 tag.
+
+CREATE TABLE messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	time BIGINT NOT NULL,
+	workspace TEXT NOT NULL,
+	id_instance TEXT NOT NULL,
+	chat_id TEXT NOT NULL,
+	id_message TEXT NOT NULL,
+	type_webhook TEXT NOT NULL,
+	text TEXT NOT NULL
+);
+
+CREATE INDEX messages_time ON messages (time);
+
+CREATE VIRTUAL TABLE messages_fts USING fts4(content="messages", text, tokenize=unicode61);
+
+CREATE TRIGGER messages_fts_insert AFTER INSERT ON messages BEGIN
+	INSERT INTO messages_fts (docid, text) VALUES (new.id, new.text);
+END;
+
+CREATE TRIGGER messages_fts_delete BEFORE DELETE ON messages BEGIN
+	DELETE FROM messages_fts WHERE docid = old.id;
+END;
@@ -0,0 +1,19 @@
+-- Saved files by instance and message ID, as message IDs are only unique
+-- within an instance. SQLite can't change a primary key, so the table is
+-- rebuilt; the instance of existing rows is taken from their JSON.
+
+CREATE TABLE attachments_by_instance (
+	id_instance TEXT NOT NULL,
+	id_message TEXT NOT NULL,
+	downloaded_at BIGINT NOT NULL,
+	path TEXT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (id_instance, id_message)
+);
+
+INSERT INTO attachments_by_instance (id_instance, id_message, downloaded_at, path, data)
+	SELECT COALESCE(json_extract(data, '$.idInstance'), ''), id_message, downloaded_at, path, data FROM attachments;
+
+DROP TABLE attachments;
+
+ALTER TABLE attachments_by_instance RENAME TO attachments;
@@ -0,0 +1,29 @@
+-- Presets and canned replies by workspace, so teams sharing a deployment
+-- keep their own. SQLite can't change a primary key, so both tables are
+-- rebuilt; existing rows belong to no workspace.
+
+CREATE TABLE settings_presets_by_workspace (
+	workspace TEXT NOT NULL,
+	name TEXT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (workspace, name)
+);
+
+INSERT INTO settings_presets_by_workspace (workspace, name, data) SELECT '', name, data FROM settings_presets;
+
+DROP TABLE settings_presets;
+
+ALTER TABLE settings_presets_by_workspace RENAME TO settings_presets;
+
+CREATE TABLE canned_replies_by_workspace (
+	workspace TEXT NOT NULL,
+	shortcut TEXT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (workspace, shortcut)
+);
+
+INSERT INTO canned_replies_by_workspace (workspace, shortcut, data) SELECT '', shortcut, data FROM canned_replies;
+
+DROP TABLE canned_replies;
+
+ALTER TABLE canned_replies_by_workspace RENAME TO canned_replies;
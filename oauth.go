@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oauthStateCookie = "grapi_oauth_state"
+	oauthTimeout     = 10 * time.Second
+	// oauthLoginTTL is how long a user has to finish signing in at the
+	// provider.
+	oauthLoginTTL = 10 * time.Minute
+)
+
+// oauthProvider holds the endpoints of an OAuth2 login provider.
+type oauthProvider struct {
+	Name        string
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+	// EmailsURL lists the user's addresses with their verification state,
+	// for providers whose user info doesn't say.
+	EmailsURL string
+	Scopes    []string
+}
+
+// GitHub speaks plain OAuth2 rather than OIDC, so its endpoints are fixed.
+var githubProvider = oauthProvider{
+	Name:        "GitHub",
+	AuthURL:     "https://github.com/login/oauth/authorize",
+	TokenURL:    "https://github.com/login/oauth/access_token",
+	UserInfoURL: "https://api.github.com/user",
+	EmailsURL:   "https://api.github.com/user/emails",
+	Scopes:      []string{"read:user", "user:email"},
+}
+
+const googleIssuer = "https://accounts.google.com"
+
+var oauthClient = &http.Client{Timeout: oauthTimeout}
+
+// oauthIdentity is who signed in at the provider. Email is only set when the
+// provider has verified it.
+type oauthIdentity struct {
+	Login string
+	Email string
+}
+
+// oauthLogin is a sign-in waiting for the provider to redirect back.
+type oauthLogin struct {
+	Next      string
+	ExpiresAt time.Time
+}
+
+// oauthLogins are the sign-ins waiting for the provider, by state.
+type oauthLogins struct {
+	sync.Mutex
+	pending map[string]oauthLogin
+}
+
+// setupOAuth resolves -oauth-provider: github, google or the issuer URL of
+// any OIDC provider, whose endpoints are discovered.
+func (a *App) setupOAuth(ctx context.Context) error {
+	switch provider := a.config.OAuthProvider; provider {
+	case "":
+		return nil
+	case "github":
+		a.oauth = &githubProvider
+		return nil
+	case "google":
+		return a.discoverOIDC(ctx, googleIssuer, "Google")
+	default:
+		issuer, err := url.Parse(provider)
+		if err != nil || issuer.Host == "" {
+			return fmt.Errorf("invalid -oauth-provider %q, expected github, google or an issuer URL", provider)
+		}
+		return a.discoverOIDC(ctx, strings.TrimSuffix(provider, "/"), issuer.Host)
+	}
+}
+
+func (a *App) discoverOIDC(ctx context.Context, issuer, name string) error {
+	var discovery struct {
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	}
+	if err := oauthGet(ctx, issuer+"/.well-known/openid-configuration", "", &discovery); err != nil {
+		return fmt.Errorf("OIDC discovery for %s failed: %w", issuer, err)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.UserInfoEndpoint == "" {
+		return fmt.Errorf("OIDC discovery for %s is missing endpoints", issuer)
+	}
+
+	a.oauth = &oauthProvider{
+		Name:        name,
+		AuthURL:     discovery.AuthorizationEndpoint,
+		TokenURL:    discovery.TokenEndpoint,
+		UserInfoURL: discovery.UserInfoEndpoint,
+		Scopes:      []string{"openid", "email", "profile"},
+	}
+	return nil
+}
+
+// oauthGet fetches a JSON document, with the access token when there is one.
+func oauthGet(ctx context.Context, target, token string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", target, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// exchange trades an authorization code for an access token, as the
+// client of c.
+func (p *oauthProvider) exchange(ctx context.Context, c *Config, code, redirectURL string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {c.OAuthClientID},
+		"client_secret": {c.OAuthClientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid token response (%s): %w", resp.Status, err)
+	}
+	if token.Error != "" {
+		return "", fmt.Errorf("%s: %s", token.Error, token.ErrorDescription)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("token response (%s) has no access token", resp.Status)
+	}
+	return token.AccessToken, nil
+}
+
+// identity looks up who the access token belongs to.
+func (p *oauthProvider) identity(ctx context.Context, token string) (oauthIdentity, error) {
+	var info struct {
+		Login             string `json:"login"`
+		PreferredUsername string `json:"preferred_username"`
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+	}
+	if err := oauthGet(ctx, p.UserInfoURL, token, &info); err != nil {
+		return oauthIdentity{}, err
+	}
+
+	identity := oauthIdentity{Login: info.Login}
+	if identity.Login == "" {
+		identity.Login = info.PreferredUsername
+	}
+	if info.EmailVerified {
+		identity.Email = info.Email
+	}
+
+	if p.EmailsURL != "" {
+		var emails []struct {
+			Email    string `json:"email"`
+			Primary  bool   `json:"primary"`
+			Verified bool   `json:"verified"`
+		}
+		if err := oauthGet(ctx, p.EmailsURL, token, &emails); err != nil {
+			return oauthIdentity{}, err
+		}
+		for _, email := range emails {
+			if email.Primary && email.Verified {
+				identity.Email = email.Email
+			}
+		}
+	}
+	return identity, nil
+}
+
+// name is how the user shows up in the UI and the audit log.
+func (i oauthIdentity) name() string {
+	if i.Email != "" {
+		return i.Email
+	}
+	return i.Login
+}
+
+// allowed reports whether the user is listed in -oauth-allowed-users, by
+// login or email, or their verified email is in -oauth-allowed-domains.
+func (i oauthIdentity) allowed(c *Config) bool {
+	for _, user := range c.OAuthAllowedUsers {
+		if (i.Login != "" && strings.EqualFold(user, i.Login)) || (i.Email != "" && strings.EqualFold(user, i.Email)) {
+			return true
+		}
+	}
+	if _, domain, ok := strings.Cut(i.Email, "@"); ok {
+		for _, allowed := range c.OAuthAllowedDomains {
+			if strings.EqualFold(allowed, domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (a *App) oauthRedirectURL(r *http.Request) string {
+	return a.requestBaseURL(r) + "/login/oauth/callback"
+}
+
+// oauthStartHandler sends the browser to the provider's consent screen.
+func (a *App) oauthStartHandler(w http.ResponseWriter, r *http.Request) {
+	if a.oauth == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	id := make([]byte, 24)
+	rand.Read(id)
+	state := base64.RawURLEncoding.EncodeToString(id)
+
+	now := time.Now()
+	a.oauthLogins.Lock()
+	for pendingState, login := range a.oauthLogins.pending {
+		if now.After(login.ExpiresAt) {
+			delete(a.oauthLogins.pending, pendingState)
+		}
+	}
+	a.oauthLogins.pending[state] = oauthLogin{Next: safeNext(r.URL.Query().Get("next")), ExpiresAt: now.Add(oauthLoginTTL)}
+	a.oauthLogins.Unlock()
+
+	// Bind the state to this browser so a callback link can't be replayed
+	// in another one
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     a.appPath("/login/oauth"),
+		MaxAge:   int(oauthLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   a.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {a.config.OAuthClientID},
+		"redirect_uri":  {a.oauthRedirectURL(r)},
+		"scope":         {strings.Join(a.oauth.Scopes, " ")},
+		"state":         {state},
+	}
+	http.Redirect(w, r, a.oauth.AuthURL+"?"+query.Encode(), http.StatusSeeOther)
+}
+
+// oauthCallbackHandler finishes a provider sign-in and starts a session for
+// allowed users. Their role and workspace come from -oauth-role and
+// -oauth-workspace.
+func (a *App) oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if a.oauth == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	state := query.Get("state")
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		a.renderPage(w, r, http.StatusBadRequest, "login.html", a.loginPage("/", "Sign-in expired, please try again"))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: a.appPath("/login/oauth"), MaxAge: -1})
+
+	a.oauthLogins.Lock()
+	login, ok := a.oauthLogins.pending[state]
+	delete(a.oauthLogins.pending, state)
+	a.oauthLogins.Unlock()
+	if !ok || time.Now().After(login.ExpiresAt) {
+		a.renderPage(w, r, http.StatusBadRequest, "login.html", a.loginPage("/", "Sign-in expired, please try again"))
+		return
+	}
+
+	if reason := query.Get("error"); reason != "" {
+		errorf("%s sign-in failed: %s %s", a.oauth.Name, reason, query.Get("error_description"))
+		a.renderPage(w, r, http.StatusUnauthorized, "login.html", a.loginPage(login.Next, "Sign-in failed"))
+		return
+	}
+
+	token, err := a.oauth.exchange(r.Context(), a.config, query.Get("code"), a.oauthRedirectURL(r))
+	if err != nil {
+		errorf("%s token exchange failed: %v", a.oauth.Name, err)
+		a.renderPage(w, r, http.StatusBadGateway, "login.html", a.loginPage(login.Next, "Sign-in failed"))
+		return
+	}
+	identity, err := a.oauth.identity(r.Context(), token)
+	if err != nil {
+		errorf("%s user lookup failed: %v", a.oauth.Name, err)
+		a.renderPage(w, r, http.StatusBadGateway, "login.html", a.loginPage(login.Next, "Sign-in failed"))
+		return
+	}
+	if !identity.allowed(a.config) {
+		warnf("%s sign-in refused for %s", a.oauth.Name, identity.name())
+		a.renderPage(w, r, http.StatusForbidden, "login.html", a.loginPage(login.Next, "This account is not allowed to sign in"))
+		return
+	}
+
+	if !a.startSession(w, r, Principal{Name: identity.name(), Role: a.config.OAuthRole, Workspace: a.config.OAuthWorkspace}, false) {
+		return
+	}
+	http.Redirect(w, r, a.appPath(login.Next), http.StatusSeeOther)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// OptOut is a number that must never be messaged.
+type OptOut struct {
+	PhoneNumber string    `json:"phoneNumber"`
+	Reason      string    `json:"reason"`
+	Source      string    `json:"source"`
+	AddedAt     time.Time `json:"addedAt"`
+}
+
+var errOptedOut = errors.New("recipient opted out")
+
+// Opt-out sources.
+const (
+	optOutManual  = "manual"
+	optOutKeyword = "keyword"
+)
+
+// OptOutList holds opted-out numbers, saved to a JSON file when one is
+// configured so they survive restarts.
+type OptOutList struct {
+	mu      sync.Mutex
+	entries map[string]OptOut
+	path    string
+}
+
+func newOptOutList() *OptOutList {
+	return &OptOutList{entries: make(map[string]OptOut)}
+}
+
+// load reads the list from path and keeps saving changes there. A missing
+// file starts an empty list.
+func (l *OptOutList) load(path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.path = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var entries []OptOut
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("invalid opt-out file %s: %w", path, err)
+	}
+	for _, entry := range entries {
+		l.entries[entry.PhoneNumber] = entry
+	}
+	return nil
+}
+
+// save writes the list atomically. The caller holds mu.
+func (l *OptOutList) save() {
+	if l.path == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(l.sorted(), "", "  ")
+	if err != nil {
+		errorf("Failed to encode opt-out list: %v", err)
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), ".optouts-*")
+	if err != nil {
+		errorf("Failed to save opt-out list: %v", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), l.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		errorf("Failed to save opt-out list: %v", err)
+	}
+}
+
+// sorted lists the entries by phone number. The caller holds mu.
+func (l *OptOutList) sorted() []OptOut {
+	list := make([]OptOut, 0, len(l.entries))
+	for _, entry := range l.entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].PhoneNumber < list[j].PhoneNumber })
+	return list
+}
+
+// add opts the number out, reporting whether it wasn't already. An
+// existing entry keeps its original reason.
+func (l *OptOutList) add(entry OptOut) (OptOut, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if existing, ok := l.entries[entry.PhoneNumber]; ok {
+		return existing, false
+	}
+	entry.AddedAt = time.Now()
+	l.entries[entry.PhoneNumber] = entry
+	l.save()
+	return entry, true
+}
+
+func (l *OptOutList) remove(phone string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.entries[phone]; !ok {
+		return false
+	}
+	delete(l.entries, phone)
+	l.save()
+	return true
+}
+
+// restore adds imported entries, first dropping all others when replace is
+// set. Imported entries keep their reason and when they were added.
+func (l *OptOutList) restore(entries []OptOut, replace bool) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if replace {
+		clear(l.entries)
+	}
+	for _, entry := range entries {
+		l.entries[entry.PhoneNumber] = entry
+	}
+	l.save()
+	return len(entries)
+}
+
+func (l *OptOutList) get(phone string) (OptOut, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[phone]
+	return entry, ok
+}
+
+func (l *OptOutList) list() []OptOut {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sorted()
+}
+
+// optOutError reports why a number may not be messaged, or nil if it may.
+func (a *App) optOutError(r *http.Request, phone string) *ErrorBody {
+	entry, ok := a.optOuts.get(phone)
+	if !ok {
+		return nil
+	}
+	return &ErrorBody{
+		Code:    "recipient_opted_out",
+		Message: translatef(negotiateLanguage(r), "%s opted out: %s", phone, entry.Reason),
+		Status:  http.StatusConflict,
+	}
+}
+
+// checkOptOut writes a 409 and returns false when the number opted out.
+func (a *App) checkOptOut(w http.ResponseWriter, r *http.Request, phone string) bool {
+	if body := a.optOutError(r, phone); body != nil {
+		writeErrorBody(w, *body)
+		return false
+	}
+	return true
+}
+
+// isStopKeyword reports whether a reply asks not to be messaged again.
+func (a *App) isStopKeyword(text string) bool {
+	text = strings.TrimSpace(text)
+	for _, keyword := range a.liveConfig().StopKeywords {
+		if strings.EqualFold(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// stopKeywordActor is who the audit log shows opting out senders of stop
+// keywords and confirming it.
+var stopKeywordActor = AuditActor{User: "stop-keyword"}
+
+// optOutOnStop runs the stop keyword pipeline on an incoming message: the
+// sender of a stop keyword is added to the opt-out list, the opt-out is
+// recorded in the audit log, and -stop-reply confirms it to the sender.
+// A sender already opted out is left alone.
+func (a *App) optOutOnStop(notification Notification) {
+	if notification.TypeWebhook != "incomingMessageReceived" || !a.isStopKeyword(notification.Text) {
+		return
+	}
+	phone, ok := strings.CutSuffix(notification.ChatID, "@c.us")
+	if !ok {
+		return
+	}
+
+	keyword := strings.TrimSpace(notification.Text)
+	_, added := a.optOuts.add(OptOut{
+		PhoneNumber: phone,
+		Reason:      fmt.Sprintf("replied %q", keyword),
+		Source:      optOutKeyword,
+	})
+	if !added {
+		return
+	}
+	infof("%s opted out by replying %q", phone, keyword)
+	idInstance := strconv.FormatInt(notification.IDInstance, 10)
+	a.audit.event(stopKeywordActor, "optOut", idInstance, notification.ChatID, keyword)
+	go a.confirmOptOut(idInstance, phone, keyword)
+}
+
+// confirmOptOut sends the -stop-reply to a number that just opted out,
+// through the instance the stop keyword came to. It goes out even though
+// the number is on the opt-out list.
+func (a *App) confirmOptOut(idInstance, phone, keyword string) {
+	text := a.liveConfig().StopReply
+	if text == "" {
+		return
+	}
+	instance, ok := a.lookupInstance(idInstance)
+	if !ok {
+		warnf("Not confirming the opt-out of %s: no credentials for instance %s", phone, idInstance)
+		return
+	}
+
+	tmpl, err := template.New("stop-reply").Parse(text)
+	if err != nil {
+		errorf("Invalid -stop-reply: %v", err)
+		return
+	}
+	var message strings.Builder
+	if err := tmpl.Execute(&message, map[string]string{"Phone": phone, "Keyword": keyword}); err != nil {
+		errorf("Failed to render -stop-reply for %s: %v", phone, err)
+		return
+	}
+
+	apiUrl := a.methodURL("sendMessage", instance.IDInstance, instance.APITokenInstance)
+	payload := map[string]interface{}{"chatId": phone + "@c.us", "message": message.String()}
+	response, statusCode, err := a.makeAPIRequestWithPayload(context.Background(), "sendMessage", apiUrl, payload)
+	a.audit.record(stopKeywordActor, OutboundMessage{Method: "sendMessage", URL: apiUrl, ChatID: phone + "@c.us", Content: message.String()}, statusCode, response, err)
+	if err != nil {
+		errorf("Failed to confirm the opt-out of %s: %v", phone, err)
+	}
+}
+
+func (a *App) optOutsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.optOuts.list())
+}
+
+func (a *App) addOptOutHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		PhoneNumber string `json:"phoneNumber"`
+		Reason      string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	phone := strings.TrimSpace(requestBody.PhoneNumber)
+	if len(phone) < 11 {
+		writeError(w, r, http.StatusBadRequest, "invalid_phone_number", "Phone number too short")
+		return
+	}
+	reason := strings.TrimSpace(requestBody.Reason)
+	if reason == "" {
+		reason = "added manually"
+	}
+
+	entry, _ := a.optOuts.add(OptOut{PhoneNumber: phone, Reason: reason, Source: optOutManual})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+func (a *App) removeOptOutHandler(w http.ResponseWriter, r *http.Request) {
+	phone := r.PathValue("phoneNumber")
+	if !a.optOuts.remove(phone) {
+		writeErrorf(w, r, http.StatusNotFound, "opt_out_not_found", "%s is not on the opt-out list", phone)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// blockedHeaders may not be overridden in advanced mode because they would
+// bypass authentication or break the proxied request framing.
+var blockedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Host":                true,
+	"Content-Length":      true,
+	"Transfer-Encoding":   true,
+	"Connection":          true,
+	"X-Forwarded-For":     true,
+	"X-Forwarded-Host":    true,
+	"X-Real-Ip":           true,
+}
+
+// blockedQueryParams may not be set in advanced mode since credentials are
+// passed in the URL path only.
+var blockedQueryParams = map[string]bool{
+	"idInstance":       true,
+	"apiTokenInstance": true,
+}
+
+// UpstreamOverrides are extra headers and query parameters attached to the
+// GREEN-API call in advanced mode.
+type UpstreamOverrides struct {
+	ExtraHeaders stringMap `json:"extraHeaders,omitempty"`
+	ExtraQuery   stringMap `json:"extraQuery,omitempty"`
+}
+
+// stringMap decodes a JSON object or a string holding one, as sent by
+// json-enc for textarea fields.
+type stringMap map[string]string
+
+func (m *stringMap) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err == nil {
+		if strings.TrimSpace(raw) == "" {
+			*m = nil
+			return nil
+		}
+		data = []byte(raw)
+	}
+
+	var values map[string]string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("expected an object of string values: %w", err)
+	}
+	*m = values
+	return nil
+}
+
+type overridesKey struct{}
+
+// withOverrides validates the overrides and attaches them to the context used
+// for the upstream call.
+func withOverrides(ctx context.Context, overrides UpstreamOverrides) (context.Context, error) {
+	for name := range overrides.ExtraHeaders {
+		if blockedHeaders[http.CanonicalHeaderKey(name)] {
+			return nil, fmt.Errorf("header %s cannot be overridden", name)
+		}
+	}
+	for name := range overrides.ExtraQuery {
+		if blockedQueryParams[name] {
+			return nil, fmt.Errorf("query parameter %s cannot be overridden", name)
+		}
+	}
+
+	if len(overrides.ExtraHeaders) == 0 && len(overrides.ExtraQuery) == 0 {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, overridesKey{}, overrides), nil
+}
+
+// formOverrides reads advanced mode fields from a multipart upload form.
+func formOverrides(fields map[string]string) (UpstreamOverrides, error) {
+	var overrides UpstreamOverrides
+	for name, target := range map[string]*stringMap{
+		"extraHeaders": &overrides.ExtraHeaders,
+		"extraQuery":   &overrides.ExtraQuery,
+	} {
+		if fields[name] == "" {
+			continue
+		}
+		encoded, _ := json.Marshal(fields[name])
+		if err := target.UnmarshalJSON(encoded); err != nil {
+			return overrides, fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return overrides, nil
+}
+
+// applyOverrides adds the context's extra headers and query parameters to
+// the outgoing GREEN-API request.
+func applyOverrides(req *http.Request) {
+	overrides, ok := req.Context().Value(overridesKey{}).(UpstreamOverrides)
+	if !ok {
+		return
+	}
+
+	for name, value := range overrides.ExtraHeaders {
+		req.Header.Set(name, value)
+	}
+
+	if len(overrides.ExtraQuery) > 0 {
+		query := req.URL.Query()
+		for name, value := range overrides.ExtraQuery {
+			query.Set(name, value)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+}
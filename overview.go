@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// InstanceSettings is the part of getSettings the status panel shows.
+type InstanceSettings struct {
+	Wid                           string `json:"wid"`
+	CountryInstance               string `json:"countryInstance,omitempty"`
+	TypeAccount                   string `json:"typeAccount,omitempty"`
+	WebhookURL                    string `json:"webhookUrl"`
+	WebhookURLToken               string `json:"webhookUrlToken,omitempty"`
+	DelaySendMessagesMilliseconds int    `json:"delaySendMessagesMilliseconds"`
+	MarkIncomingMessagesReaded    string `json:"markIncomingMessagesReaded,omitempty"`
+	OutgoingWebhook               string `json:"outgoingWebhook,omitempty"`
+	IncomingWebhook               string `json:"incomingWebhook,omitempty"`
+	StateWebhook                  string `json:"stateWebhook,omitempty"`
+}
+
+// WaSettings is the WhatsApp account behind an instance, from getWaSettings.
+type WaSettings struct {
+	Avatar        string `json:"avatar"`
+	Phone         string `json:"phone"`
+	StateInstance string `json:"stateInstance"`
+	DeviceID      string `json:"deviceId"`
+}
+
+// InstanceOverview merges getSettings, getStateInstance and getWaSettings.
+type InstanceOverview struct {
+	IDInstance    string           `json:"idInstance"`
+	StateInstance string           `json:"stateInstance"`
+	Settings      InstanceSettings `json:"settings"`
+	Account       WaSettings       `json:"account"`
+}
+
+// fetchJSON makes a GET GREEN-API call and decodes the response into v.
+func (a *App) fetchJSON(ctx context.Context, method, apiUrl string, v interface{}) error {
+	body, _, err := a.doAPIRequest(ctx, method, http.MethodGet, apiUrl, "", nil)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("json decode failed: %w", err)
+	}
+	return nil
+}
+
+// instanceOverviewHandler fetches the three calls the status panel needs at
+// once. The first failure cancels the other calls and is returned as is.
+func (a *App) instanceOverviewHandler(w http.ResponseWriter, r *http.Request) {
+	var req SettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	ctx, err := withOverrides(r.Context(), req.UpstreamOverrides)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "blocked_override", err.Error())
+		return
+	}
+	ctx, rs := newResponder(ctx)
+
+	settingsUrl := a.methodURL("getSettings", req.IDInstance, req.APITokenInstance)
+	stateUrl := a.methodURL("getStateInstance", req.IDInstance, req.APITokenInstance)
+	waSettingsUrl := a.methodURL("getWaSettings", req.IDInstance, req.APITokenInstance)
+
+	overview := InstanceOverview{IDInstance: req.IDInstance}
+	var state struct {
+		StateInstance string `json:"stateInstance"`
+	}
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return a.fetchJSON(gctx, "getSettings", settingsUrl, &overview.Settings)
+	})
+	g.Go(func() error {
+		return a.fetchJSON(gctx, "getStateInstance", stateUrl, &state)
+	})
+	g.Go(func() error {
+		return a.fetchJSON(gctx, "getWaSettings", waSettingsUrl, &overview.Account)
+	})
+	if err := g.Wait(); err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+
+	overview.StateInstance = state.StateInstance
+	if overview.Settings.WebhookURLToken != "" {
+		overview.Settings.WebhookURLToken = redactedToken // Mask sensitive data
+	}
+
+	rs.respond(w, APIResponse{
+		URL: stateUrl,
+		RequestBody: map[string]string{
+			"idInstance":       req.IDInstance,
+			"apiTokenInstance": "••••••••", // Mask sensitive data
+		},
+		Response:   overview,
+		StatusCode: http.StatusOK,
+	})
+}
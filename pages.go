@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"slices"
+)
+
+// recentHistorySize is how many upstream calls the home page lists.
+const recentHistorySize = 10
+
+// HomePage is the view model of templates/index.html.
+type HomePage struct {
+	Instances     []string
+	RecentHistory []HistoryEntry
+	Methods       []string
+	Features      Features
+	User          string
+}
+
+// Features tells the page which optional capabilities this server has.
+type Features struct {
+	VoiceTranscoding bool
+	MaxUploadSizeMB  int64
+}
+
+// ResultPage is the view model of templates/result.html, used when the form
+// is submitted without JavaScript.
+type ResultPage struct {
+	Title      string
+	StatusCode int
+	Response   string
+	Error      *ErrorBody
+}
+
+// LoginPage is the view model of templates/login.html.
+type LoginPage struct {
+	Next    string
+	Error   string
+	APIKeys bool
+	OAuth   string
+}
+
+// resultActions map form buttons to the API endpoints they call.
+var resultActions = map[string]struct {
+	title    string
+	route    string
+	role     Role
+	inFlight string
+	handler  func(*App, http.ResponseWriter, *http.Request)
+}{
+	"get-settings":      {"Get Settings", "/api/get-settings", roleViewer, "", (*App).settingsHandler},
+	"get-state":         {"Get State Instance", "/api/get-state", roleViewer, "", (*App).stateHandler},
+	"instance-overview": {"Instance Overview", "/api/instance-overview", roleViewer, "", (*App).instanceOverviewHandler},
+	"check-whatsapp":    {"Check WhatsApp", "/api/check-whatsapp/bulk", roleViewer, "", (*App).bulkCheckHandler},
+	"send-message":      {"Send Message", "/api/send-message", roleSender, inFlightSends, (*App).sendMessageHandler},
+	"send-file":         {"Send File", "/api/send-file", roleSender, inFlightSends, (*App).sendFileHandler},
+	"read-chat":         {"Mark as Read", "/api/read-chat", roleSender, "", (*App).readChatHandler},
+	"send-typing":       {"Send Typing", "/api/send-typing", roleSender, "", (*App).sendTypingHandler},
+	"send-reaction":     {"Send Reaction", "/api/send-reaction", roleSender, inFlightSends, (*App).sendReactionHandler},
+	"raw":               {"Send Raw Request", "/api/raw", roleViewer, inFlightSends, (*App).rawHandler},
+}
+
+func (a *App) homeHandler(w http.ResponseWriter, r *http.Request) {
+	_, ffmpegErr := exec.LookPath("ffmpeg")
+	principal, _ := principalOf(r)
+
+	// The page still works when history can't be read, just without it
+	instances, err := a.historyInstances(workspaceOf(r))
+	if err != nil {
+		errorf("Failed to read history: %v", err)
+	}
+	recent, _ := a.history.list(workspaceOf(r), recentHistorySize)
+
+	a.renderPage(w, r, http.StatusOK, "index.html", HomePage{
+		Instances:     instances,
+		RecentHistory: recent,
+		Methods:       slices.Sorted(maps.Keys(apiMethods)),
+		Features: Features{
+			VoiceTranscoding: ffmpegErr == nil,
+			MaxUploadSizeMB:  a.config.MaxUploadSize >> 20,
+		},
+		User: principal.Name,
+	})
+}
+
+// resultPageHandler runs a form submission through the matching API
+// endpoint and renders its response as a page.
+func (a *App) resultPageHandler(w http.ResponseWriter, r *http.Request) {
+	action, ok := resultActions[r.PathValue("action")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Send the fields the same way json-enc does, as a flat object of strings
+	fields := make(map[string]string)
+	for name := range r.PostForm {
+		fields[name] = r.PostForm.Get(name)
+	}
+	body, _ := json.Marshal(fields)
+
+	apiRequest := r.Clone(r.Context())
+	apiRequest.Body = io.NopCloser(bytes.NewReader(body))
+	apiRequest.ContentLength = int64(len(body))
+	apiRequest.Header.Set("Content-Type", "application/json")
+
+	// The form counts against the in-flight limit of the route it stands for
+	handler := func(w http.ResponseWriter, r *http.Request) { action.handler(a, w, r) }
+	if action.inFlight != "" {
+		handler = a.withInFlightLimit(action.inFlight, handler)
+	}
+	recorder := httptest.NewRecorder()
+	a.withStats(action.route, a.requireRole(action.role, handler))(recorder, apiRequest)
+
+	page := ResultPage{
+		Title:      action.title,
+		StatusCode: recorder.Code,
+	}
+	var envelope ErrorResponse
+	if recorder.Code >= 400 && json.Unmarshal(recorder.Body.Bytes(), &envelope) == nil {
+		page.Error = &envelope.Error
+	} else {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, recorder.Body.Bytes(), "", "  "); err != nil {
+			indented.Reset()
+			indented.Write(recorder.Body.Bytes())
+		}
+		page.Response = indented.String()
+	}
+
+	a.renderPage(w, r, recorder.Code, "result.html", page)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// hopHeaders describe the upstream connection rather than the response and
+// are not copied in raw mode.
+var hopHeaders = map[string]bool{
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Transfer-Encoding": true,
+	"Content-Length":    true,
+	"Trailer":           true,
+	"Upgrade":           true,
+}
+
+// upstreamCapture holds the last GREEN-API response made while handling a
+// raw mode request.
+type upstreamCapture struct {
+	mu       sync.Mutex
+	captured bool
+	header   http.Header
+	status   int
+	body     []byte
+}
+
+type passthroughKey struct{}
+
+// capturePassthrough stores the upstream response if the request asked for
+// raw mode.
+func capturePassthrough(ctx context.Context, resp *http.Response, body []byte) {
+	capture, ok := ctx.Value(passthroughKey{}).(*upstreamCapture)
+	if !ok {
+		return
+	}
+
+	capture.mu.Lock()
+	defer capture.mu.Unlock()
+	capture.captured = true
+	capture.header = resp.Header.Clone()
+	capture.status = resp.StatusCode
+	capture.body = body
+}
+
+// withPassthrough serves ?raw=true requests with the GREEN-API status,
+// headers and body verbatim instead of the usual envelope. Requests that are
+// rejected before reaching GREEN-API get the normal error response.
+func withPassthrough(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isChecked(r.URL.Query().Get("raw")) {
+			next(w, r)
+			return
+		}
+
+		capture := &upstreamCapture{}
+		ctx := context.WithValue(r.Context(), passthroughKey{}, capture)
+		recorder := httptest.NewRecorder()
+		next(recorder, r.WithContext(ctx))
+
+		capture.mu.Lock()
+		defer capture.mu.Unlock()
+
+		if !capture.captured {
+			for name, values := range recorder.Header() {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(recorder.Code)
+			w.Write(recorder.Body.Bytes())
+			return
+		}
+
+		for name, values := range capture.header {
+			if !hopHeaders[name] {
+				w.Header()[name] = values
+			}
+		}
+		w.WriteHeader(capture.status)
+		w.Write(capture.body)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxStoredPolls bounds the poll store; the least recently updated poll is
+// dropped first.
+const maxStoredPolls = 500
+
+// PollOption is one answer of a poll and who picked it.
+type PollOption struct {
+	Name   string   `json:"name"`
+	Voters []string `json:"voters"`
+	Votes  int      `json:"votes"`
+}
+
+// PollResults are the latest known votes of a poll.
+type PollResults struct {
+	IDMessage       string       `json:"idMessage"`
+	ChatID          string       `json:"chatId"`
+	Name            string       `json:"name"`
+	MultipleAnswers bool         `json:"multipleAnswers"`
+	Options         []PollOption `json:"options"`
+	TotalVoters     int          `json:"totalVoters"`
+	UpdatedAt       time.Time    `json:"updatedAt"`
+}
+
+// pollMessageData is the poll part of pollMessage and pollUpdateMessage
+// notifications. Updates carry every option's full voter list, so the
+// latest update replaces the previous one.
+type pollMessageData struct {
+	StanzaID        string `json:"stanzaId"`
+	Name            string `json:"name"`
+	MultipleAnswers bool   `json:"multipleAnswers"`
+	Options         []struct {
+		OptionName string `json:"optionName"`
+	} `json:"options"`
+	Votes []struct {
+		OptionName   string   `json:"optionName"`
+		OptionVoters []string `json:"optionVoters"`
+	} `json:"votes"`
+}
+
+// results converts the notification data of a poll into its results.
+func (data pollMessageData) results(idMessage, chatID string, at time.Time) PollResults {
+	poll := PollResults{
+		IDMessage:       idMessage,
+		ChatID:          chatID,
+		Name:            data.Name,
+		MultipleAnswers: data.MultipleAnswers,
+		UpdatedAt:       at,
+	}
+
+	options := make([]PollOption, 0, len(data.Options))
+	for _, option := range data.Options {
+		options = append(options, PollOption{Name: option.OptionName, Voters: []string{}})
+	}
+
+	votes := make([]PollOption, 0, len(data.Votes))
+	voters := make(map[string]bool)
+	for _, vote := range data.Votes {
+		optionVoters := vote.OptionVoters
+		if optionVoters == nil {
+			optionVoters = []string{}
+		}
+		for _, voter := range optionVoters {
+			voters[voter] = true
+		}
+		votes = append(votes, PollOption{
+			Name:   vote.OptionName,
+			Voters: optionVoters,
+			Votes:  len(optionVoters),
+		})
+	}
+	poll.Options = mergeOptions(options, votes)
+	poll.TotalVoters = len(voters)
+	return poll
+}
+
+// PollStore keeps the latest results of every poll seen on /webhook.
+type PollStore struct {
+	mu     sync.Mutex
+	polls  map[string]PollResults
+	events *EventHub
+}
+
+func newPollStore(events *EventHub) *PollStore {
+	return &PollStore{polls: make(map[string]PollResults), events: events}
+}
+
+// pollTopic is the event hub topic updates of one poll are published on.
+func pollTopic(idMessage string) string {
+	return "polls/" + idMessage
+}
+
+// record stores a poll's results and publishes them to its subscribers.
+// Options known from the poll itself are kept when an update omits them.
+func (s *PollStore) record(poll PollResults) {
+	s.mu.Lock()
+	if previous, ok := s.polls[poll.IDMessage]; ok {
+		if poll.Name == "" {
+			poll.Name = previous.Name
+		}
+		if poll.ChatID == "" {
+			poll.ChatID = previous.ChatID
+		}
+		poll.Options = mergeOptions(previous.Options, poll.Options)
+	} else if len(s.polls) >= maxStoredPolls {
+		s.evictOldest()
+	}
+	s.polls[poll.IDMessage] = poll
+	s.mu.Unlock()
+
+	s.events.publish(pollTopic(poll.IDMessage), poll)
+}
+
+func (s *PollStore) get(idMessage string) (PollResults, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	poll, ok := s.polls[idMessage]
+	return poll, ok
+}
+
+// evictOldest drops the least recently updated poll. The caller holds mu.
+func (s *PollStore) evictOldest() {
+	oldest := ""
+	for id, poll := range s.polls {
+		if oldest == "" || poll.UpdatedAt.Before(s.polls[oldest].UpdatedAt) {
+			oldest = id
+		}
+	}
+	delete(s.polls, oldest)
+}
+
+// mergeOptions lists the options of previous followed by any new ones in
+// current. Votes come from current only, since updates carry the full state.
+func mergeOptions(previous, current []PollOption) []PollOption {
+	byName := make(map[string]PollOption, len(current))
+	for _, option := range current {
+		byName[option.Name] = option
+	}
+
+	merged := make([]PollOption, 0, len(previous)+len(current))
+	for _, option := range previous {
+		if latest, ok := byName[option.Name]; ok {
+			merged = append(merged, latest)
+			delete(byName, option.Name)
+		} else {
+			merged = append(merged, PollOption{Name: option.Name, Voters: []string{}})
+		}
+	}
+	for _, option := range current {
+		if _, ok := byName[option.Name]; ok {
+			merged = append(merged, option)
+		}
+	}
+	return merged
+}
+
+func (a *App) pollResultsHandler(w http.ResponseWriter, r *http.Request) {
+	poll, ok := a.polls.get(r.PathValue("idMessage"))
+	if !ok {
+		writeErrorf(w, r, http.StatusNotFound, "poll_not_found", "Poll %s not found", r.PathValue("idMessage"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(poll)
+}
+
+func (a *App) pollStreamHandler(w http.ResponseWriter, r *http.Request) {
+	a.serveEvents(w, r, pollTopic(r.PathValue("idMessage")))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/pprof"
+	"strconv"
+	"time"
+)
+
+// maxCPUProfile caps how long a CPU profile may run.
+const maxCPUProfile = 2 * time.Minute
+
+// Profiles are served with runtime/pprof rather than net/http/pprof, whose
+// import would register them on the default mux without a role check.
+// Both work with go tool pprof, e.g.
+//
+//	go tool pprof -http :0 -H 'Authorization: Bearer KEY' http://localhost:8080/debug/pprof/profile?seconds=30
+
+// profileHandler writes a runtime profile: heap, allocs, goroutine, block,
+// mutex or threadcreate. ?debug=1 gives the text form.
+func profileHandler(w http.ResponseWriter, r *http.Request) {
+	profile := pprof.Lookup(r.PathValue("name"))
+	if profile == nil {
+		writeError(w, r, http.StatusNotFound, "not_found", "Unknown profile")
+		return
+	}
+	debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+	if debug > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", profile.Name()))
+	}
+	profile.WriteTo(w, debug)
+}
+
+// cpuProfileHandler records a CPU profile for ?seconds (default 30), e.g.
+// while a broadcast runs against the instance.
+func cpuProfileHandler(w http.ResponseWriter, r *http.Request) {
+	duration := 30 * time.Second
+	if value := r.URL.Query().Get("seconds"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > maxCPUProfile {
+			writeErrorf(w, r, http.StatusBadRequest, "invalid_request", "Seconds must be 1 to %d", int(maxCPUProfile.Seconds()))
+			return
+		}
+		duration = time.Duration(seconds) * time.Second
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+	if err := pprof.StartCPUProfile(w); err != nil {
+		// Only one CPU profile runs at a time
+		w.Header().Del("Content-Disposition")
+		writeError(w, r, http.StatusConflict, "profile_running", "A CPU profile is already being recorded")
+		return
+	}
+	select {
+	case <-time.After(duration):
+	case <-r.Context().Done():
+	}
+	pprof.StopCPUProfile()
+}
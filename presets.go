@@ -0,0 +1,279 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SettingsPreset is a named set of instance settings applied together with
+// setSettings. Settings it leaves out are not touched. Presets belong to
+// the workspace they were saved in, so names only need to be unique within
+// one.
+type SettingsPreset struct {
+	Name      string                 `json:"name"`
+	Workspace string                 `json:"workspace,omitempty"`
+	Settings  map[string]interface{} `json:"settings"`
+	BuiltIn   bool                   `json:"builtIn,omitempty"`
+	UpdatedAt time.Time              `json:"updatedAt,omitzero"`
+}
+
+// builtinPresets are always there. A stored preset of the same name takes
+// their place, and deleting it brings them back.
+var builtinPresets = map[string]map[string]interface{}{
+	"webhooks-on": {
+		"incomingWebhook":           "yes",
+		"outgoingWebhook":           "yes",
+		"outgoingMessageWebhook":    "yes",
+		"outgoingAPIMessageWebhook": "yes",
+		"stateWebhook":              "yes",
+		"deviceWebhook":             "yes",
+		"pollMessageWebhook":        "yes",
+		"incomingCallWebhook":       "yes",
+	},
+	"webhooks-off": {
+		"incomingWebhook":           "no",
+		"outgoingWebhook":           "no",
+		"outgoingMessageWebhook":    "no",
+		"outgoingAPIMessageWebhook": "no",
+		"stateWebhook":              "no",
+		"deviceWebhook":             "no",
+		"pollMessageWebhook":        "no",
+		"incomingCallWebhook":       "no",
+	},
+	// silent-mode stays out of sight of contacts: no read receipts and no
+	// online status.
+	"silent-mode": {
+		"markIncomingMessagesReaded":        "no",
+		"markIncomingMessagesReadedOnReply": "no",
+		"keepOnlineStatus":                  "no",
+	},
+}
+
+var presetNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// PresetStore keeps settings presets by workspace and name.
+type PresetStore interface {
+	list() ([]SettingsPreset, error)
+	get(workspace, name string) (SettingsPreset, bool, error)
+	put(preset SettingsPreset) error
+	delete(workspace, name string) (bool, error)
+}
+
+// lookupPreset finds a stored preset of the workspace or a built-in one.
+func (a *App) lookupPreset(workspace, name string) (SettingsPreset, bool, error) {
+	preset, ok, err := a.presets.get(workspace, name)
+	if err != nil || ok {
+		return preset, ok, err
+	}
+	if settings, ok := builtinPresets[name]; ok {
+		return SettingsPreset{Name: name, Settings: settings, BuiltIn: true}, true, nil
+	}
+	return SettingsPreset{}, false, nil
+}
+
+// presetsHandler lists the presets of the workspaces the caller can see,
+// and the built-in ones not replaced in the caller's own.
+func (a *App) presetsHandler(w http.ResponseWriter, r *http.Request) {
+	stored, err := a.presets.list()
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	workspace, owner := workspaceOf(r), ownerWorkspace(r)
+	list := slices.DeleteFunc(stored, func(p SettingsPreset) bool { return !canSee(workspace, p.Workspace) })
+	for _, name := range slices.Sorted(maps.Keys(builtinPresets)) {
+		if !slices.ContainsFunc(list, func(p SettingsPreset) bool { return p.Name == name && p.Workspace == owner }) {
+			list = append(list, SettingsPreset{Name: name, Settings: builtinPresets[name], BuiltIn: true})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// savePresetHandler stores a preset from a JSON object of settings.
+func (a *App) savePresetHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !presetNamePattern.MatchString(name) {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Preset names are lowercase letters, digits, - and _")
+		return
+	}
+	var settings map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil || len(settings) == 0 {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "A preset needs a JSON object of settings")
+		return
+	}
+	for key, value := range settings {
+		switch value.(type) {
+		case string, float64, bool:
+		default:
+			writeErrorf(w, r, http.StatusBadRequest, "invalid_request", "Setting %s must be a string, number or boolean", key)
+			return
+		}
+	}
+
+	preset := SettingsPreset{Name: name, Workspace: ownerWorkspace(r), Settings: settings, UpdatedAt: time.Now()}
+	if err := a.presets.put(preset); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	infof("Settings preset %s saved by %s", name, actorName(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preset)
+}
+
+// deletePresetHandler moves a stored preset to the trash. Built-in presets
+// can't be deleted.
+func (a *App) deletePresetHandler(w http.ResponseWriter, r *http.Request) {
+	name, workspace := r.PathValue("name"), ownerWorkspace(r)
+	preset, ok, err := a.presets.get(workspace, name)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if !ok {
+		writeErrorf(w, r, http.StatusNotFound, "not_found", "Preset %s not found", name)
+		return
+	}
+	err = a.moveToTrash(r, trashPreset, name, workspace, preset, func() error {
+		_, err := a.presets.delete(workspace, name)
+		return err
+	})
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	infof("Settings preset %s deleted by %s", name, actorName(r))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyPresetHandler reads the instance's settings, sends the preset with
+// setSettings and reports which settings it changed. With dryRun only the
+// changes are reported.
+func (a *App) applyPresetHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		SettingsRequest
+		DryRun formBool `json:"dryRun"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	preset, ok, err := a.lookupPreset(ownerWorkspace(r), r.PathValue("name"))
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if !ok {
+		writeErrorf(w, r, http.StatusNotFound, "not_found", "Preset %s not found", r.PathValue("name"))
+		return
+	}
+
+	ctx, err := withOverrides(r.Context(), requestBody.UpstreamOverrides)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "blocked_override", err.Error())
+		return
+	}
+	ctx, rs := newResponder(ctx)
+
+	current, _, err := a.makeAPIRequest(ctx, "getSettings", a.methodURL("getSettings", requestBody.IDInstance, requestBody.APITokenInstance))
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+	before := make(map[string]interface{}, len(preset.Settings))
+	for key := range preset.Settings {
+		if value, ok := current[key]; ok {
+			before[key] = value
+		}
+	}
+	changes := diffJSON("$", before, preset.Settings)
+	if changes == nil {
+		changes = []DiffChange{}
+	}
+
+	apiUrl := a.methodURL("setSettings", requestBody.IDInstance, requestBody.APITokenInstance)
+	response := APIResponse{
+		URL: apiUrl,
+		RequestBody: map[string]string{
+			"idInstance":       requestBody.IDInstance,
+			"apiTokenInstance": "••••••••",
+			"preset":           preset.Name,
+		},
+		Payload:  preset.Settings,
+		Changes:  changes,
+		Snippets: snippetsFor(ctx, jsonCall(apiUrl, preset.Settings)),
+	}
+	if requestBody.DryRun {
+		response.DryRun = true
+		rs.respond(w, response)
+		return
+	}
+
+	apiResponse, statusCode, err := a.makeAPIRequestWithPayload(ctx, "setSettings", apiUrl, preset.Settings)
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+	response.Response = apiResponse
+	response.StatusCode = statusCode
+	rs.respond(w, response)
+}
+
+// memoryPresets keeps presets in a map by workspaceKey, so they are
+// forgotten on restart.
+type memoryPresets struct {
+	mu      sync.Mutex
+	presets map[string]SettingsPreset
+}
+
+func newMemoryPresets() *memoryPresets {
+	return &memoryPresets{presets: make(map[string]SettingsPreset)}
+}
+
+func (s *memoryPresets) list() ([]SettingsPreset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]SettingsPreset, 0, len(s.presets))
+	for _, preset := range s.presets {
+		list = append(list, preset)
+	}
+	slices.SortFunc(list, func(a, b SettingsPreset) int {
+		return cmp.Or(strings.Compare(a.Name, b.Name), strings.Compare(a.Workspace, b.Workspace))
+	})
+	return list, nil
+}
+
+func (s *memoryPresets) get(workspace, name string) (SettingsPreset, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	preset, ok := s.presets[workspaceKey(workspace, name)]
+	return preset, ok, nil
+}
+
+func (s *memoryPresets) put(preset SettingsPreset) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.presets[workspaceKey(preset.Workspace, preset.Name)] = preset
+	return nil
+}
+
+func (s *memoryPresets) delete(workspace, name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := workspaceKey(workspace, name)
+	_, ok := s.presets[key]
+	delete(s.presets, key)
+	return ok, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf16"
+)
+
+// maxMessageLength is the longest text sendMessage accepts.
+const maxMessageLength = 20000
+
+// linkPattern finds the links WhatsApp turns into previews.
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"]+[^\s<>".,;:!?)\]'}]`)
+
+// gsm7Basic and gsm7Extended are the characters of the GSM 03.38 alphabet;
+// extended ones take two of the 160 places of a segment.
+const (
+	gsm7Basic    = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	gsm7Extended = "^{}\\[~]|€\f"
+)
+
+// PreviewMessageRequest is the body of /api/preview-message.
+type PreviewMessageRequest struct {
+	MessageText string `json:"messageText" api:"required"`
+	// PhoneNumber fills in {{.Phone}} and {{.ChatID}}; a sample number is
+	// used without it.
+	PhoneNumber string `json:"phoneNumber"`
+	// Variables are filled in by name, e.g. {{.name}}, next to Phone,
+	// ChatID and Date, which they take the place of.
+	Variables stringMap `json:"variables"`
+}
+
+// MessagePreview is a message text as it would be sent, with what a
+// campaign wants to proof before sending it.
+type MessagePreview struct {
+	Text   string `json:"text"`
+	Length int    `json:"length"`
+	// TooLong is set when sendMessage would reject the text.
+	TooLong bool     `json:"tooLong,omitempty"`
+	Links   []string `json:"links"`
+	Emoji   int      `json:"emoji"`
+	// Encoding and Segments are what the text would take as SMS, GSM-7
+	// or UCS-2, for campaigns that fall back to it.
+	Encoding string `json:"encoding"`
+	Segments int    `json:"segments"`
+}
+
+// renderMessage renders text as a template of variables and the recipient's
+// Phone, ChatID and Date. A name it doesn't know is an error, so a typo
+// isn't sent out as "<no value>".
+func renderMessage(text, phoneNumber string, variables map[string]string) (string, error) {
+	tmpl, err := template.New("message").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	if phoneNumber == "" {
+		phoneNumber = "79001234567"
+	}
+	fields := map[string]string{
+		"Phone":  phoneNumber,
+		"ChatID": phoneNumber + "@c.us",
+		"Date":   time.Now().Format(time.DateOnly),
+	}
+	maps.Copy(fields, variables)
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, fields); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func previewMessage(text string) MessagePreview {
+	length := len([]rune(text))
+	links := linkPattern.FindAllString(text, -1)
+	if links == nil {
+		links = []string{}
+	}
+	encoding, segments := smsSegments(text)
+	return MessagePreview{
+		Text:     text,
+		Length:   length,
+		TooLong:  length > maxMessageLength,
+		Links:    links,
+		Emoji:    countEmoji(text),
+		Encoding: encoding,
+		Segments: segments,
+	}
+}
+
+// smsSegments estimates how many SMS parts text takes: 160 GSM-7
+// characters or 70 UCS-2 ones fit in one, 153 or 67 in each part of a
+// longer one.
+func smsSegments(text string) (string, int) {
+	septets := 0
+	for _, c := range text {
+		switch {
+		case strings.ContainsRune(gsm7Basic, c):
+			septets++
+		case strings.ContainsRune(gsm7Extended, c):
+			septets += 2
+		default:
+			return "UCS-2", segmentsOf(len(utf16.Encode([]rune(text))), 70, 67)
+		}
+	}
+	return "GSM-7", segmentsOf(septets, 160, 153)
+}
+
+func segmentsOf(units, single, part int) int {
+	switch {
+	case units == 0:
+		return 0
+	case units <= single:
+		return 1
+	default:
+		return (units + part - 1) / part
+	}
+}
+
+// countEmoji counts the emoji a reader sees: a sequence joined with ZWJ, a
+// flag or an emoji with a skin tone counts once.
+func countEmoji(text string) int {
+	count := 0
+	var previous rune
+	flagHalf := false
+	for _, c := range text {
+		switch {
+		case c >= 0x1F1E6 && c <= 0x1F1FF:
+			// Regional indicators pair up into flags
+			if !flagHalf {
+				count++
+			}
+			flagHalf = !flagHalf
+		case isEmoji(c) && previous != 0x200D:
+			count++
+			flagHalf = false
+		default:
+			flagHalf = false
+		}
+		previous = c
+	}
+	return count
+}
+
+func isEmoji(c rune) bool {
+	switch {
+	case c >= 0x1F3FB && c <= 0x1F3FF:
+		// Skin tone modifiers belong to the emoji before them
+		return false
+	case c >= 0x1F000 && c <= 0x1FAFF,
+		c >= 0x2600 && c <= 0x27BF,
+		c >= 0x2B00 && c <= 0x2BFF,
+		c >= 0x23E9 && c <= 0x23FA, c == 0x231A, c == 0x231B,
+		c == 0x00A9, c == 0x00AE, c == 0x203C, c == 0x2049, c == 0x2122, c == 0x2139, c == 0x3030, c == 0x303D:
+		return true
+	}
+	return false
+}
+
+// previewMessageHandler renders a message for a recipient without sending
+// it, so a campaign can be proofed first.
+func previewMessageHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody PreviewMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	if requestBody.MessageText == "" {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Message text is required")
+		return
+	}
+
+	text, err := renderMessage(requestBody.MessageText, requestBody.PhoneNumber, requestBody.Variables)
+	if err != nil {
+		writeErrorf(w, r, http.StatusBadRequest, "invalid_template", "Invalid message template: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(previewMessage(text))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// maxProfilePictureSize caps avatar uploads; WhatsApp downsizes them anyway.
+const maxProfilePictureSize = 5 << 20
+
+// maxProfileNameLength is the longest display name WhatsApp accepts.
+const maxProfileNameLength = 25
+
+// SetProfileNameRequest is the body of /api/set-profile-name.
+type SetProfileNameRequest struct {
+	IDInstance       string `json:"idInstance" api:"required"`
+	APITokenInstance string `json:"apiTokenInstance" api:"required"`
+	Name             string `json:"name" api:"required"`
+	UpstreamOverrides
+}
+
+func (a *App) setProfileNameHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	var requestBody SetProfileNameRequest
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	// Validate inputs
+	name := strings.TrimSpace(requestBody.Name)
+	if name == "" || len([]rune(name)) > maxProfileNameLength {
+		writeErrorf(w, r, http.StatusBadRequest, "invalid_profile_name", "Profile name must be 1 to %d characters", maxProfileNameLength)
+		return
+	}
+
+	ctx, err := withOverrides(r.Context(), requestBody.UpstreamOverrides)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "blocked_override", err.Error())
+		return
+	}
+	ctx, rs := newResponder(ctx)
+
+	apiUrl := a.methodURL("setProfileName", requestBody.IDInstance, requestBody.APITokenInstance)
+	payload := map[string]interface{}{"name": name}
+
+	apiResponse, statusCode, err := a.makeAPIRequestWithPayload(ctx, "setProfileName", apiUrl, payload)
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+
+	rs.respond(w, APIResponse{
+		URL: apiUrl,
+		RequestBody: map[string]interface{}{
+			"name":             name,
+			"idInstance":       requestBody.IDInstance,
+			"apiTokenInstance": "••••••••", // Mask sensitive data
+		},
+		Response:   apiResponse,
+		StatusCode: statusCode,
+		Snippets:   snippetsFor(ctx, jsonCall(apiUrl, payload)),
+	})
+}
+
+func (a *App) setProfilePictureHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxProfilePictureSize)
+
+	fields, filePart, err := readUploadForm(r)
+	if err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+
+	// Validate inputs
+	fileName := filePart.FileName()
+	if !isImageFile(fileName) {
+		writeError(w, r, http.StatusBadRequest, "unsupported_media_type", "Profile picture must be a JPEG, PNG or GIF image")
+		return
+	}
+
+	overrides, err := formOverrides(fields)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	ctx, err := withOverrides(r.Context(), overrides)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "blocked_override", err.Error())
+		return
+	}
+	ctx, rs := newResponder(ctx)
+
+	// Avatars are small, so the form is built in memory
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	var size int64
+	fileWriter, err := form.CreateFormFile("file", fileName)
+	if err == nil {
+		size, err = io.Copy(fileWriter, filePart)
+	}
+	if err != nil {
+		writeUploadError(w, r, &uploadReadError{err: err})
+		return
+	}
+	form.Close()
+
+	apiUrl := a.methodURL("setProfilePicture", fields["idInstance"], fields["apiTokenInstance"])
+
+	apiResponse, statusCode, err := a.makeAPIRequestWithBody(ctx, "setProfilePicture", apiUrl, form.FormDataContentType(), &body)
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+
+	rs.respond(w, APIResponse{
+		URL: apiUrl,
+		RequestBody: map[string]interface{}{
+			"fileName":         fileName,
+			"fileSize":         size,
+			"idInstance":       fields["idInstance"],
+			"apiTokenInstance": "••••••••", // Mask sensitive data
+		},
+		Response:   apiResponse,
+		StatusCode: statusCode,
+		Snippets: snippetsFor(ctx, UpstreamCall{
+			Verb:     http.MethodPost,
+			URL:      apiUrl,
+			FileName: fileName,
+		}),
+	})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// proxyList is the -trusted-proxies networks, whose X-Forwarded-* headers
+// are believed.
+type proxyList []*net.IPNet
+
+func (l *proxyList) String() string {
+	nets := make([]string, len(*l))
+	for i, n := range *l {
+		nets[i] = n.String()
+	}
+	return strings.Join(nets, ",")
+}
+
+func (l *proxyList) Set(value string) error {
+	for _, entry := range splitList(value) {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		*l = append(*l, n)
+	}
+	return nil
+}
+
+func (l proxyList) contains(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && slices.ContainsFunc(l, func(n *net.IPNet) bool { return n.Contains(ip) })
+}
+
+// appPath is the path of a page or endpoint as the browser sees it, under
+// -base-path.
+func (a *App) appPath(path string) string {
+	return a.config.BasePath + path
+}
+
+// requestScheme is the scheme the client used, which a trusted proxy
+// reports in X-Forwarded-Proto.
+func requestScheme(r *http.Request) string {
+	switch {
+	case r.URL.Scheme != "":
+		return r.URL.Scheme
+	case r.TLS != nil:
+		return "https"
+	default:
+		return "http"
+	}
+}
+
+// withProxy adapts requests that come through a reverse proxy. From a
+// -trusted-proxies address the X-Forwarded-For client replaces RemoteAddr,
+// so logs, audit records and rate limits see the real caller, and
+// X-Forwarded-Proto and X-Forwarded-Host make generated URLs absolute the
+// way the client reached the proxy. Paths under -base-path are served with
+// the prefix removed; paths without it are served as they are, for proxies
+// that strip it themselves.
+func (a *App) withProxy(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.liveConfig().TrustedProxies.contains(r.RemoteAddr) {
+			r = a.forwardedRequest(r)
+		}
+		if base := a.config.BasePath; base != "" {
+			switch {
+			case r.URL.Path == base:
+				target := base + "/"
+				if r.URL.RawQuery != "" {
+					target += "?" + r.URL.RawQuery
+				}
+				http.Redirect(w, r, target, http.StatusMovedPermanently)
+				return
+			case strings.HasPrefix(r.URL.Path, base+"/"):
+				r = withURL(r)
+				r.URL.Path = strings.TrimPrefix(r.URL.Path, base)
+				r.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, base)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedRequest applies the X-Forwarded-* headers of a trusted proxy.
+// The client is the last X-Forwarded-For address that isn't a trusted
+// proxy itself, since anything before it could be made up by the client.
+func (a *App) forwardedRequest(r *http.Request) *http.Request {
+	r = withURL(r)
+	proxies := a.liveConfig().TrustedProxies
+	forwardedFor := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwardedFor[i])
+		if net.ParseIP(addr) == nil {
+			break
+		}
+		r.RemoteAddr = addr
+		if !proxies.contains(addr) {
+			break
+		}
+	}
+	if proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ","); proto == "http" || proto == "https" {
+		r.URL.Scheme = proto
+	}
+	if host, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Host"), ","); host != "" {
+		r.Host = strings.TrimSpace(host)
+	}
+	return r
+}
+
+// withURL shallow-copies a request with its own URL to change.
+func withURL(r *http.Request) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	u := *r.URL
+	r2.URL = &u
+	return r2
+}
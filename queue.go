@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SendQueue paces calls per instance so a burst leaves at most one every
+// interval, in the order the slots were requested.
+type SendQueue struct {
+	mu       sync.Mutex
+	next     map[string]time.Time
+	interval func() time.Duration
+}
+
+func newSendQueue(interval func() time.Duration) *SendQueue {
+	return &SendQueue{next: make(map[string]time.Time), interval: interval}
+}
+
+// wait reserves the next send slot of the instance and blocks until it
+// starts. A cancelled context gives up the wait but not the slot.
+func (q *SendQueue) wait(ctx context.Context, idInstance string) error {
+	q.mu.Lock()
+	now := time.Now()
+	slot := q.next[idInstance]
+	if slot.Before(now) {
+		slot = now
+	}
+	q.next[idInstance] = slot.Add(q.interval())
+	q.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(slot))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// hold keeps the instance's slots from starting before until, as asked by
+// a Retry-After of GREEN-API. Slots already handed out keep their time.
+func (q *SendQueue) hold(idInstance string, until time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.next[idInstance].Before(until) {
+		q.next[idInstance] = until
+	}
+}
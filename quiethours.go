@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// recipientZone marks a quiet window evaluated in the recipient's time zone.
+const recipientZone = "recipient"
+
+// countryZones guesses a recipient's time zone from the phone number's
+// country code. Countries spanning several zones use their capital's.
+var countryZones = map[string]string{
+	"1":   "America/New_York",
+	"7":   "Europe/Moscow",
+	"20":  "Africa/Cairo",
+	"33":  "Europe/Paris",
+	"34":  "Europe/Madrid",
+	"39":  "Europe/Rome",
+	"44":  "Europe/London",
+	"49":  "Europe/Berlin",
+	"55":  "America/Sao_Paulo",
+	"77":  "Asia/Almaty",
+	"86":  "Asia/Shanghai",
+	"90":  "Europe/Istanbul",
+	"91":  "Asia/Kolkata",
+	"374": "Asia/Yerevan",
+	"375": "Europe/Minsk",
+	"380": "Europe/Kyiv",
+	"971": "Asia/Dubai",
+	"994": "Asia/Baku",
+	"995": "Asia/Tbilisi",
+	"996": "Asia/Bishkek",
+	"998": "Asia/Tashkent",
+}
+
+// QuietWindow is a daily period in which queued sends are held back.
+// Start and End are minutes after midnight; a window with End before Start
+// spans midnight.
+type QuietWindow struct {
+	Start, End int
+	// Zone is an IANA zone name, recipientZone, or empty for server time.
+	Zone     string
+	location *time.Location
+}
+
+// quietHours maps an idInstance to its window; the "" key applies to
+// instances without their own.
+type quietHours map[string]QuietWindow
+
+// windowFor returns the window of the instance, if any.
+func (q quietHours) windowFor(idInstance string) (QuietWindow, bool) {
+	if window, ok := q[idInstance]; ok {
+		return window, true
+	}
+	window, ok := q[""]
+	return window, ok
+}
+
+// sendAt returns when a message to phone may be sent at now: now itself
+// outside quiet hours, otherwise the moment the window ends.
+func (q quietHours) sendAt(idInstance, phone string, now time.Time) time.Time {
+	window, ok := q.windowFor(idInstance)
+	if !ok {
+		return now
+	}
+
+	local := now.In(window.locationFor(phone))
+	minute := local.Hour()*60 + local.Minute()
+	quiet := window.Start <= minute && minute < window.End
+	if window.End < window.Start {
+		quiet = minute >= window.Start || minute < window.End
+	}
+	if !quiet {
+		return now
+	}
+
+	opens := time.Date(local.Year(), local.Month(), local.Day(), window.End/60, window.End%60, 0, 0, local.Location())
+	if !opens.After(local) {
+		opens = opens.AddDate(0, 0, 1)
+	}
+	return opens
+}
+
+func (w QuietWindow) locationFor(phone string) *time.Location {
+	if w.Zone != recipientZone {
+		return w.location
+	}
+	// Longest matching country code wins, so 77 beats 7
+	for length := 3; length > 0; length-- {
+		if len(phone) < length {
+			continue
+		}
+		if name, ok := countryZones[phone[:length]]; ok {
+			if location, err := time.LoadLocation(name); err == nil {
+				return location
+			}
+		}
+	}
+	return time.Local
+}
+
+func (q quietHours) String() string {
+	entries := make([]string, 0, len(q))
+	for id, window := range q {
+		entry := fmt.Sprintf("%02d:%02d-%02d:%02d", window.Start/60, window.Start%60, window.End/60, window.End%60)
+		if window.Zone != "" {
+			entry += "@" + window.Zone
+		}
+		if id != "" {
+			entry = id + "=" + entry
+		}
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+// Set parses comma-separated [idInstance=]HH:MM-HH:MM[@zone] windows.
+func (q quietHours) Set(value string) error {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id := ""
+		if before, after, ok := strings.Cut(entry, "="); ok {
+			id, entry = before, after
+		}
+		span, zone, _ := strings.Cut(entry, "@")
+		rawStart, rawEnd, ok := strings.Cut(span, "-")
+		if !ok {
+			return fmt.Errorf("invalid quiet hours %q, expected HH:MM-HH:MM[@zone]", entry)
+		}
+
+		window := QuietWindow{Zone: zone, location: time.Local}
+		var err error
+		if window.Start, err = parseClock(rawStart); err != nil {
+			return err
+		}
+		if window.End, err = parseClock(rawEnd); err != nil {
+			return err
+		}
+		if window.Start == window.End {
+			return fmt.Errorf("quiet hours %q are empty", entry)
+		}
+		if zone != "" && zone != recipientZone {
+			if window.location, err = time.LoadLocation(zone); err != nil {
+				return fmt.Errorf("invalid time zone %q: %w", zone, err)
+			}
+		}
+		q[id] = window
+	}
+	return nil
+}
+
+// parseClock converts HH:MM to minutes after midnight.
+func parseClock(value string) (int, error) {
+	clock, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return clock.Hour()*60 + clock.Minute(), nil
+}
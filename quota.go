@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// UpstreamQuota is what GREEN-API told of its rate limit on the last call
+// made for a request, from the Retry-After and RateLimit headers of its
+// answer. Fields it didn't send are left out.
+type UpstreamQuota struct {
+	Limit     *int       `json:"limit,omitempty"`
+	Remaining *int       `json:"remaining,omitempty"`
+	Reset     *time.Time `json:"reset,omitempty"`
+	// RetryAfter is how long GREEN-API asked to wait before calling again.
+	RetryAfter string `json:"retryAfter,omitempty"`
+}
+
+// quotaHeaders are the prefixes of the headers a rate limit is read from,
+// the common X-RateLimit- and the standard draft's RateLimit-.
+var quotaHeaders = []string{"X-RateLimit-", "RateLimit-"}
+
+// parseQuota reads the rate limit headers of an answer, nil without any.
+func parseQuota(header http.Header, now time.Time) *UpstreamQuota {
+	quota := &UpstreamQuota{}
+	found := false
+	for _, prefix := range quotaHeaders {
+		if n, err := strconv.Atoi(header.Get(prefix + "Limit")); err == nil && quota.Limit == nil {
+			quota.Limit, found = &n, true
+		}
+		if n, err := strconv.Atoi(header.Get(prefix + "Remaining")); err == nil && quota.Remaining == nil {
+			quota.Remaining, found = &n, true
+		}
+		if n, err := strconv.ParseInt(header.Get(prefix+"Reset"), 10, 64); err == nil && quota.Reset == nil {
+			// Seconds from now, or a Unix time when that can't be meant
+			reset := now.Add(time.Duration(n) * time.Second)
+			if n > 1e9 {
+				reset = time.Unix(n, 0)
+			}
+			quota.Reset, found = &reset, true
+		}
+	}
+	if wait := retryAfter(header, now); wait > 0 {
+		quota.RetryAfter, found = wait.String(), true
+	}
+	if !found {
+		return nil
+	}
+	return quota
+}
+
+// retryAfter reads a Retry-After header given in seconds or as a date, 0
+// without one.
+func retryAfter(header http.Header, now time.Time) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
+
+// retryAfterOf is the wait GREEN-API asked for when it turned a call away,
+// 0 when err isn't such an answer.
+func retryAfterOf(err error) time.Duration {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.RetryAfter
+	}
+	return 0
+}
+
+type quotaKey struct{}
+
+// recordQuota keeps the rate limit GREEN-API reported for the request's
+// envelope, if any.
+func recordQuota(ctx context.Context, quota *UpstreamQuota) {
+	if last, ok := ctx.Value(quotaKey{}).(*atomic.Pointer[UpstreamQuota]); ok && quota != nil {
+		last.Store(quota)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package main
+
+// raceEnabled reports whether the race detector is on, which makes
+// allocation counts meaningless.
+const raceEnabled = true
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// methodNamePattern guards the raw builder against path injection through
+// the method name.
+var methodNamePattern = regexp.MustCompile(`^[A-Za-z]+$`)
+
+// rawVerbs are the HTTP verbs GREEN-API methods use.
+var rawVerbs = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodPost:   true,
+	http.MethodDelete: true,
+}
+
+// jsonBody decodes an arbitrary JSON value, or a string holding one as sent
+// by json-enc for textarea fields.
+type jsonBody json.RawMessage
+
+func (b *jsonBody) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err == nil {
+		if strings.TrimSpace(raw) == "" {
+			*b = nil
+			return nil
+		}
+		if !json.Valid([]byte(raw)) {
+			return fmt.Errorf("body is not valid JSON")
+		}
+		data = []byte(raw)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+type RawRequest struct {
+	IDInstance       string   `json:"idInstance" api:"required"`
+	APITokenInstance string   `json:"apiTokenInstance" api:"required"`
+	Method           string   `json:"method" api:"required"`
+	HTTPMethod       string   `json:"httpMethod"`
+	PathParams       []string `json:"pathParams"`
+	Body             jsonBody `json:"body"`
+	UpstreamOverrides
+}
+
+// rawRecipient is the phone number of the personal chat a raw call's body
+// names in chatId, empty for a group or no chat.
+func rawRecipient(body jsonBody) string {
+	var target struct {
+		ChatID string `json:"chatId"`
+	}
+	json.Unmarshal(body, &target)
+	phone, _ := strings.CutSuffix(target.ChatID, "@c.us")
+	if phone == target.ChatID {
+		return ""
+	}
+	return phone
+}
+
+func (a *App) rawHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	var requestBody RawRequest
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	// Validate inputs
+	if !methodNamePattern.MatchString(requestBody.Method) {
+		writeError(w, r, http.StatusBadRequest, "invalid_method", "Method name must contain letters only")
+		return
+	}
+
+	// Raw calls reach every GREEN-API method, so the method decides the role
+	if _, ok := a.authorize(w, r, rawMethodRole(requestBody.Method)); !ok {
+		return
+	}
+
+	// Methods outside the catalog are sent as given, to the main host
+	method, known := apiMethods[requestBody.Method]
+	if !known {
+		method = APIMethod{Name: requestBody.Method, Base: apiBase}
+	}
+
+	verb := strings.ToUpper(requestBody.HTTPMethod)
+	if verb == "" {
+		verb = method.Verb
+	}
+	if verb == "" {
+		verb = http.MethodGet
+		if len(requestBody.Body) > 0 {
+			verb = http.MethodPost
+		}
+	}
+	if !rawVerbs[verb] {
+		writeError(w, r, http.StatusBadRequest, "invalid_http_method", "HTTP method must be GET, POST or DELETE")
+		return
+	}
+	if known {
+		if !checkRawMethod(w, r, method, verb, requestBody) {
+			return
+		}
+	}
+
+	// Sends through the builder honor opt-outs like the send endpoints
+	if audited(requestBody.Method) && !a.checkOptOut(w, r, rawRecipient(requestBody.Body)) {
+		return
+	}
+
+	ctx, err := withOverrides(r.Context(), requestBody.UpstreamOverrides)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "blocked_override", err.Error())
+		return
+	}
+	ctx, rs := newResponder(ctx)
+
+	// Construct the API URL
+	apiUrl := method.url(a.upstreamBase(method.Base), requestBody.IDInstance, requestBody.APITokenInstance, requestBody.PathParams...)
+
+	var payload io.Reader
+	contentType := ""
+	if verb == http.MethodGet {
+		requestBody.Body = nil
+	}
+	if len(requestBody.Body) > 0 {
+		payload = bytes.NewReader(requestBody.Body)
+		contentType = "application/json"
+	}
+
+	// Sends keep to the order of the other sends to their chat
+	var target struct {
+		ChatID string `json:"chatId"`
+	}
+	json.Unmarshal(requestBody.Body, &target)
+	if audited(requestBody.Method) && target.ChatID != "" {
+		unlock, err := a.chatLocks.lock(ctx, chatKey(requestBody.IDInstance, target.ChatID))
+		if err != nil {
+			writeChatBusy(w, r)
+			return
+		}
+		defer unlock()
+	}
+
+	// Make the API request
+	body, statusCode, err := a.doAPIRequest(ctx, requestBody.Method, verb, apiUrl, contentType, payload)
+	if audited(requestBody.Method) {
+		var response map[string]interface{}
+		json.Unmarshal(body, &response)
+		a.audit.record(actorOf(r), OutboundMessage{
+			Method:  requestBody.Method,
+			URL:     apiUrl,
+			ChatID:  target.ChatID,
+			Content: string(requestBody.Body),
+		}, statusCode, response, err)
+	}
+	if err != nil {
+		writeUpstreamError(w, r, err)
+		return
+	}
+
+	// GREEN-API methods answer with objects, arrays or nothing at all
+	var apiResponse interface{}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &apiResponse); err != nil {
+			apiResponse = string(body)
+		}
+	}
+
+	rs.respond(w, APIResponse{
+		URL: apiUrl,
+		RequestBody: map[string]interface{}{
+			"method":           requestBody.Method,
+			"httpMethod":       verb,
+			"pathParams":       requestBody.PathParams,
+			"body":             json.RawMessage(requestBody.Body),
+			"idInstance":       requestBody.IDInstance,
+			"apiTokenInstance": "••••••••", // Mask sensitive data
+		},
+		Response:   apiResponse,
+		StatusCode: statusCode,
+		Snippets: snippetsFor(ctx, UpstreamCall{
+			Verb:        verb,
+			URL:         apiUrl,
+			ContentType: contentType,
+			Body:        requestBody.Body,
+		}),
+	})
+}
+
+// checkRawMethod checks a raw call of a catalogued method against the
+// catalog before it is sent.
+func checkRawMethod(w http.ResponseWriter, r *http.Request, method APIMethod, verb string, request RawRequest) bool {
+	if method.Form {
+		writeErrorf(w, r, http.StatusBadRequest, "invalid_method", "%s takes a file upload, use its own form", method.Name)
+		return false
+	}
+	if verb != method.Verb {
+		writeErrorf(w, r, http.StatusBadRequest, "invalid_http_method", "%s is called with %s", method.Name, method.Verb)
+		return false
+	}
+	if len(request.PathParams) != len(method.PathParams) {
+		writeErrorf(w, r, http.StatusBadRequest, "invalid_path_params", "%s takes %d path parameters", method.Name, len(method.PathParams))
+		return false
+	}
+	// GET bodies are dropped anyway
+	if verb == http.MethodGet {
+		return true
+	}
+	if err := method.checkPayload(request.Body); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", err.Error())
+		return false
+	}
+	return true
+}
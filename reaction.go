@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Reaction is an emoji reaction decoded from an incoming or outgoing
+// message notification.
+type Reaction struct {
+	ChatID    string `json:"chatId"`
+	Sender    string `json:"sender,omitempty"`
+	IDMessage string `json:"idMessage"`
+	Target    string `json:"targetIdMessage"`
+	Emoji     string `json:"emoji"`
+	Removed   bool   `json:"removed,omitempty"`
+}
+
+// SendReactionRequest is the body of /api/send-reaction.
+type SendReactionRequest struct {
+	chatRequest
+	IDMessage string `json:"idMessage"`
+	Reaction  string `json:"reaction"`
+}
+
+// sendReactionHandler reacts to a message with an emoji, or removes the
+// reaction when the emoji is empty.
+func (a *App) sendReactionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	var requestBody SendReactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	// Validate inputs
+	if len(requestBody.PhoneNumber) < 11 {
+		writeError(w, r, http.StatusBadRequest, "invalid_phone_number", "Phone number too short")
+		return
+	}
+	idMessage := strings.TrimSpace(requestBody.IDMessage)
+	if idMessage == "" {
+		writeError(w, r, http.StatusBadRequest, "missing_message_id", "Message ID is required")
+		return
+	}
+	reaction := strings.TrimSpace(requestBody.Reaction)
+	if !a.checkOptOut(w, r, requestBody.PhoneNumber) {
+		return
+	}
+
+	payload := map[string]interface{}{
+		"chatId":    fmt.Sprintf("%s@c.us", requestBody.PhoneNumber),
+		"idMessage": idMessage,
+		"reaction":  reaction,
+	}
+
+	a.callChatMethod(w, r, "sendReaction", requestBody.chatRequest, payload, map[string]interface{}{
+		"idMessage": idMessage,
+		"reaction":  reaction,
+	})
+}
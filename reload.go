@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// configPollInterval is how often -config is checked for changes.
+const configPollInterval = 2 * time.Second
+
+// applyConfigFile sets the flags listed in path, one name=value per line,
+// except those already given on the command line. Blank lines and lines
+// starting with # are skipped.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		name = strings.TrimLeft(strings.TrimSpace(name), "-")
+		if !ok || name == "" {
+			return fmt.Errorf("%s:%d: expected name=value", path, line)
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%s:%d: unknown flag %s", path, line, name)
+		}
+		if explicit[name] || name == "config" {
+			continue
+		}
+		if err := fs.Set(name, strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// reloadConfig parses the command line and -config again and applies the
+// settings that can change at run time: instances, timeouts and retries,
+// the circuit breaker and offline queue, the slow call threshold,
+// forwarding and email, broadcast and bulk check limits, the link
+// shortener, quiet hours, stop keywords and reply, label rules, API keys,
+// rate and in-flight limits, CORS origins, trusted proxies, the log level
+// and feature flags. Anything else, such as -middleware, needs a restart.
+func (a *App) reloadConfig() error {
+	fresh := defaultConfig()
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fresh.register(fs)
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		return err
+	}
+	if err := applyConfigFile(fs, fresh.ConfigFile); err != nil {
+		return err
+	}
+	if err := fresh.resolve(); err != nil {
+		return err
+	}
+	if err := a.withSecrets(fresh); err != nil {
+		return err
+	}
+	// Routes left open or shut by a reload would go unnoticed, unlike the
+	// warning logged at startup
+	if freshAuth := len(fresh.APIKeys) > 0 || a.config.OAuthProvider != ""; freshAuth != a.authEnabled() {
+		return errors.New("adding the first -api-keys or removing the last needs a restart")
+	}
+	if err := a.featureFlags.configure(fresh.Features); err != nil {
+		return err
+	}
+
+	a.updateConfig(func(c *Config) {
+		c.DefaultTimeout = fresh.DefaultTimeout
+		c.Timeouts = fresh.Timeouts
+		c.Retries = fresh.Retries
+		c.BreakerThreshold = fresh.BreakerThreshold
+		c.BreakerCooldown = fresh.BreakerCooldown
+		c.OfflineQueue = fresh.OfflineQueue
+		c.SlowThreshold = fresh.SlowThreshold
+		c.ForwardTo = fresh.ForwardTo
+		c.ForwardSecret = fresh.ForwardSecret
+		c.SMTPAddr = fresh.SMTPAddr
+		c.SMTPUser = fresh.SMTPUser
+		c.SMTPPassword = fresh.SMTPPassword
+		c.SMTPFrom = fresh.SMTPFrom
+		c.EmailEvents = fresh.EmailEvents
+		c.EmailSubject = fresh.EmailSubject
+		c.EmailBody = fresh.EmailBody
+		c.EmailRate = fresh.EmailRate
+		c.SendInterval = fresh.SendInterval
+		c.MaxRecipients = fresh.MaxRecipients
+		c.Shortener = fresh.Shortener
+		c.BitlyToken = fresh.BitlyToken
+		c.CheckInterval = fresh.CheckInterval
+		c.CheckConcurrency = fresh.CheckConcurrency
+		c.MaxCheckNumbers = fresh.MaxCheckNumbers
+		c.QuietHours = fresh.QuietHours
+		c.StopKeywords = fresh.StopKeywords
+		c.StopReply = fresh.StopReply
+		c.LabelRules = fresh.LabelRules
+		c.APIKeys = fresh.APIKeys
+		c.RateLimit = fresh.RateLimit
+		c.RateBurst = fresh.RateBurst
+		c.InFlight = fresh.InFlight
+		c.CORSOrigins = fresh.CORSOrigins
+		c.TrustedProxies = fresh.TrustedProxies
+		c.LogLevel = fresh.LogLevel
+	})
+	setLogLevel(fresh.LogLevel)
+	if a.vaultEnabled() {
+		a.vault.setStatic(fresh.Instances)
+	} else {
+		a.setInstances(fresh.Instances)
+	}
+	return nil
+}
+
+// watchConfig reloads the config on SIGHUP and whenever -config changes,
+// until the context ends. A config that fails to load is logged and the
+// running one kept.
+func (a *App) watchConfig(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	modified := a.configModTime()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			infof("SIGHUP: reloading config")
+		case <-ticker.C:
+			current := a.configModTime()
+			if current.Equal(modified) {
+				continue
+			}
+			modified = current
+			infof("%s changed: reloading config", a.config.ConfigFile)
+		}
+
+		if err := a.reloadConfig(); err != nil {
+			errorf("Config reload failed, keeping the current one: %v", err)
+			continue
+		}
+		infof("Config reloaded: %d instances, %d forward targets", len(a.configuredInstances()), len(a.liveConfig().ForwardTo))
+	}
+}
+
+// configModTime is when -config last changed, zero without one.
+func (a *App) configModTime() time.Time {
+	if a.config.ConfigFile == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(a.config.ConfigFile)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// retryBackoff is the delay before the first retry, doubled on each attempt.
+const retryBackoff = 250 * time.Millisecond
+
+// APIResponse is the envelope returned by every endpoint that calls GREEN-API.
+type APIResponse struct {
+	URL           string            `json:"url"`
+	RequestBody   interface{}       `json:"requestBody,omitempty"`
+	Payload       interface{}       `json:"payload,omitempty"`
+	DryRun        bool              `json:"dryRun,omitempty"`
+	Response      interface{}       `json:"response"`
+	StatusCode    int               `json:"statusCode"`
+	ProcessedAt   string            `json:"processedAt"`
+	RequestTime   string            `json:"requestTime"`
+	Retries       int               `json:"retries"`
+	Snippets      map[string]string `json:"snippets,omitempty"`
+	Batch         *BatchResult      `json:"batch,omitempty"`
+	MediaID       string            `json:"mediaId,omitempty"`
+	ThumbnailURL  string            `json:"thumbnailUrl,omitempty"`
+	Transcoded    bool              `json:"transcoded,omitempty"`
+	TranscodeNote string            `json:"transcodeNote,omitempty"`
+	Changes       []DiffChange      `json:"changes,omitempty"`
+	Quota         *UpstreamQuota    `json:"quota,omitempty"`
+	Version       string            `json:"version"`
+}
+
+// responder measures a request from the moment it is created, counts the
+// retries its GREEN-API calls perform and keeps the last rate limit they
+// were told of.
+type responder struct {
+	start   time.Time
+	retries *atomic.Int32
+	quota   *atomic.Pointer[UpstreamQuota]
+}
+
+type retriesKey struct{}
+
+func newResponder(ctx context.Context) (context.Context, *responder) {
+	rs := &responder{start: time.Now(), retries: &atomic.Int32{}, quota: &atomic.Pointer[UpstreamQuota]{}}
+	ctx = context.WithValue(ctx, quotaKey{}, rs.quota)
+	return context.WithValue(ctx, retriesKey{}, rs.retries), rs
+}
+
+// respond fills in the timing and retry fields and writes the envelope.
+func (rs *responder) respond(w http.ResponseWriter, response APIResponse) {
+	rs.respondStatus(w, http.StatusOK, response)
+}
+
+// respondStatus is respond with a status other than 200.
+func (rs *responder) respondStatus(w http.ResponseWriter, status int, response APIResponse) {
+	response.ProcessedAt = time.Now().Format(time.RFC3339)
+	response.RequestTime = time.Since(rs.start).String()
+	response.Retries = int(rs.retries.Load())
+	if response.Quota == nil {
+		response.Quota = rs.quota.Load()
+	}
+	response.Version = currentBuild().Version
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// countRetry records a retry against the request's responder, if any.
+func countRetry(ctx context.Context) {
+	if retries, ok := ctx.Value(retriesKey{}).(*atomic.Int32); ok {
+		retries.Add(1)
+	}
+}
+
+// retryable reports whether a failed GREEN-API call is safe and worth
+// repeating. Only GET requests without a body are retried; exhausted time
+// budgets are not.
+func retryable(verb string, hasBody bool, err error) bool {
+	if err == nil || verb != http.MethodGet || hasBody {
+		return false
+	}
+
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return false
+	}
+
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.Status == http.StatusTooManyRequests || upstreamErr.Status >= 500
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// PruneResult counts what one pruning pass dropped.
+type PruneResult struct {
+	History    int `json:"history"`
+	Webhooks   int `json:"webhooks"`
+	Thumbnails int `json:"thumbnails"`
+	Trash      int `json:"trash"`
+	Messages   int `json:"messages"`
+}
+
+func (p PruneResult) total() int {
+	return p.History + p.Webhooks + p.Thumbnails + p.Trash + p.Messages
+}
+
+// retentionCutoff is the oldest time kept under maxAge, zero when age isn't
+// limited.
+func retentionCutoff(now time.Time, maxAge time.Duration) time.Time {
+	if maxAge <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-maxAge)
+}
+
+// pruneAll applies the retention settings to history, received webhooks,
+// cached thumbnails, the trash and the message search index.
+func (a *App) pruneAll(now time.Time) (PruneResult, error) {
+	var result PruneResult
+	result.Webhooks = a.notifications.prune(retentionCutoff(now, a.config.WebhookMaxAge), a.config.WebhookHistorySize)
+	result.Thumbnails = a.thumbnails.prune(retentionCutoff(now, a.config.ThumbnailMaxAge), a.config.ThumbnailCacheSize)
+
+	var err error
+	result.History, err = a.history.prune(retentionCutoff(now, a.config.HistoryMaxAge), a.config.HistorySize)
+	if err != nil {
+		return result, err
+	}
+	result.Trash, err = a.trash.prune(now.Add(-a.config.TrashTTL))
+	if err != nil {
+		return result, err
+	}
+	result.Messages, err = a.messageIndex.prune(retentionCutoff(now, a.config.SearchMaxAge))
+	return result, err
+}
+
+// pruneJob is the prune job, by default run every -prune-interval.
+func (a *App) pruneJob(ctx context.Context) error {
+	result, err := a.pruneAll(time.Now())
+	if result.total() > 0 {
+		infof("Pruned %d history entries, %d webhooks, %d thumbnails, %d trash items and %d indexed messages", result.History, result.Webhooks, result.Thumbnails, result.Trash, result.Messages)
+	}
+	return err
+}
+
+// pruneHandler prunes right away rather than waiting for the prune job.
+func (a *App) pruneHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := a.pruneAll(time.Now())
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
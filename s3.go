@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MediaBlobs keeps the bytes of the files the media downloader saves: in
+// -media-dir, or in the S3 bucket of -s3-bucket.
+type MediaBlobs interface {
+	// put stores the file at path as name and removes path.
+	put(ctx context.Context, name, path, contentType string) error
+	open(ctx context.Context, name string) (io.ReadCloser, error)
+	// link is a presigned URL the file can be downloaded from directly,
+	// or "" when the proxy serves it itself.
+	link(attachment Attachment) string
+}
+
+// mediaBlobs picks where saved files go, or nil when they aren't saved.
+func (a *App) mediaBlobs() MediaBlobs {
+	switch {
+	case a.config.S3Bucket != "":
+		return &s3Blobs{
+			client:     a.client,
+			endpoint:   a.config.S3Endpoint,
+			bucket:     a.config.S3Bucket,
+			region:     a.config.S3Region,
+			accessKey:  a.config.S3AccessKey,
+			secretKey:  a.config.S3SecretKey,
+			presignTTL: a.config.S3PresignTTL,
+		}
+	case a.config.MediaDir != "":
+		return dirBlobs(a.config.MediaDir)
+	}
+	return nil
+}
+
+// dirBlobs keeps saved files in a local directory, served by the proxy.
+type dirBlobs string
+
+func (d dirBlobs) put(ctx context.Context, name, path, contentType string) error {
+	return os.Rename(path, filepath.Join(string(d), name))
+}
+
+func (d dirBlobs) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), name))
+}
+
+func (d dirBlobs) link(Attachment) string {
+	return ""
+}
+
+// s3Blobs keeps saved files in an S3 bucket, e.g. of MinIO, addressed by
+// path so any endpoint works without bucket DNS names. Downloads are
+// redirected to presigned URLs, so the files don't pass through the proxy.
+type s3Blobs struct {
+	client     *http.Client
+	endpoint   string
+	bucket     string
+	region     string
+	accessKey  string
+	secretKey  string
+	presignTTL time.Duration
+}
+
+// s3UnsignedPayload leaves request bodies out of the signature, so a file
+// needn't be read twice to upload it.
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+func (s *s3Blobs) objectURL(name string) *url.URL {
+	u, _ := url.Parse(s.endpoint + "/" + url.PathEscape(s.bucket) + "/" + url.PathEscape(name))
+	return u
+}
+
+func (s *s3Blobs) put(ctx context.Context, name, path, contentType string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(name).String(), file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Blobs) open(ctx context.Context, name string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(name).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// do signs and sends a request, failing on any status but 200.
+func (s *s3Blobs) do(req *http.Request) (*http.Response, error) {
+	s.sign(req, time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("S3 %s returned %s %s", req.Method, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+func (s *s3Blobs) link(attachment Attachment) string {
+	u := s.objectURL(attachment.Path)
+	q := url.Values{"response-content-disposition": {fmt.Sprintf("attachment; filename=%q", attachment.FileName)}}
+	if attachment.MimeType != "" {
+		q.Set("response-content-type", attachment.MimeType)
+	}
+	u.RawQuery = q.Encode()
+	s.presign(u, time.Now())
+	return u.String()
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req.
+func (s *s3Blobs) sign(req *http.Request, now time.Time) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\nx-amz-content-sha256:" + s3UnsignedPayload + "\nx-amz-date:" + stamp + "\n"
+	scope, signature := s.signature(req.Method, req.URL, canonicalHeaders, signedHeaders, stamp)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// presign adds the query string of a presigned GET of u, valid for
+// -s3-presign-ttl.
+func (s *s3Blobs) presign(u *url.URL, now time.Time) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	q := u.Query()
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", s.accessKey+"/"+s.scope(stamp))
+	q.Set("X-Amz-Date", stamp)
+	q.Set("X-Amz-Expires", strconv.Itoa(int(s.presignTTL/time.Second)))
+	q.Set("X-Amz-SignedHeaders", "host")
+	u.RawQuery = s3Query(q)
+
+	_, signature := s.signature(http.MethodGet, u, "host:"+u.Host+"\n", "host", stamp)
+	u.RawQuery += "&X-Amz-Signature=" + signature
+}
+
+func (s *s3Blobs) scope(stamp string) string {
+	return stamp[:8] + "/" + s.region + "/s3/aws4_request"
+}
+
+// signature signs the canonical form of a request, returning its
+// credential scope and the hex signature.
+func (s *s3Blobs) signature(method string, u *url.URL, canonicalHeaders, signedHeaders, stamp string) (string, string) {
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		s3Query(u.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		s3UnsignedPayload,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := s.scope(stamp)
+	stringToSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{stamp[:8], s.region, "s3", "aws4_request", stringToSign} {
+		key = hmacSHA256(key, part)
+	}
+	return scope, hex.EncodeToString(key)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Query is the canonical query string SigV4 signs: sorted by key, with
+// spaces encoded as %20 rather than +.
+func s3Query(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for key := range q {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, key := range keys {
+		for _, value := range q[key] {
+			if b.Len() > 0 {
+				b.WriteByte('&')
+			}
+			b.WriteString(url.QueryEscape(key) + "=" + strings.ReplaceAll(url.QueryEscape(value), "+", "%20"))
+		}
+	}
+	return b.String()
+}
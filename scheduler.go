@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Scheduled send states.
+const (
+	scheduledPending   = "pending"
+	scheduledSending   = "sending"
+	scheduledSent      = "sent"
+	scheduledFailed    = "failed"
+	scheduledCancelled = "cancelled"
+)
+
+// maxFinishedSends is how many sent, failed or cancelled sends are kept
+// for review.
+const maxFinishedSends = 200
+
+// ScheduledSend is a GREEN-API send held back until SendAt.
+type ScheduledSend struct {
+	ID          int64                  `json:"id"`
+	IDInstance  string                 `json:"idInstance"`
+	PhoneNumber string                 `json:"phoneNumber"`
+	Method      string                 `json:"method"`
+	URL         string                 `json:"-"`
+	Payload     map[string]interface{} `json:"payload"`
+	Content     string                 `json:"-"`
+	Actor       AuditActor             `json:"actor"`
+	Reason      string                 `json:"reason"`
+	CreatedAt   time.Time              `json:"createdAt"`
+	SendAt      time.Time              `json:"sendAt"`
+	Status      string                 `json:"status"`
+	SentAt      *time.Time             `json:"sentAt,omitempty"`
+	Response    map[string]interface{} `json:"response,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+}
+
+// scheduledView is how a scheduled send is listed, with the token masked.
+type scheduledView struct {
+	ScheduledSend
+	URL string `json:"url"`
+}
+
+// ScheduleStore keeps scheduled sends. Sends get their ID, creation time and
+// pending status when added.
+type ScheduleStore interface {
+	add(send ScheduledSend) (ScheduledSend, error)
+	get(id int64) (ScheduledSend, bool, error)
+	// due returns the pending sends whose time has come, oldest first.
+	due(now time.Time) ([]ScheduledSend, error)
+	// nextDue is when the earliest pending send is due, false without one.
+	nextDue() (time.Time, bool, error)
+	// claim moves a pending send to sending, false when it no longer is
+	// pending, so a send shared between servers goes out once.
+	claim(id int64) (bool, error)
+	// finish stores a claimed send with its outcome, or as pending again to
+	// hand it back, maybe for a later SendAt, and drops the oldest finished sends beyond
+	// maxFinishedSends.
+	finish(send ScheduledSend) error
+	// cancel stops a pending send, false when it no longer is pending.
+	cancel(id int64) (ScheduledSend, bool, error)
+	list() ([]ScheduledSend, error)
+	pending() (int, error)
+}
+
+// Scheduler dispatches the sends in its store when they are due.
+type Scheduler struct {
+	app   *App
+	store ScheduleStore
+	wake  chan struct{}
+}
+
+func newScheduler(a *App) *Scheduler {
+	return &Scheduler{app: a, store: newMemorySchedule(), wake: make(chan struct{}, 1)}
+}
+
+func (s *Scheduler) add(send ScheduledSend) (ScheduledSend, error) {
+	send, err := s.store.add(send)
+	if err != nil {
+		return ScheduledSend{}, err
+	}
+	s.wakeUp()
+	return send, nil
+}
+
+// wakeUp has the scheduler look for due sends now.
+func (s *Scheduler) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run dispatches due sends until the context ends. With memory storage,
+// sends still pending on shutdown are lost.
+func (s *Scheduler) run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if _, memory := s.store.(*memorySchedule); memory {
+				if pending, _ := s.store.pending(); pending > 0 {
+					warnf("Dropping %d scheduled sends on shutdown", pending)
+				}
+			}
+			return
+		case <-timer.C:
+		case <-s.wake:
+		}
+
+		if err := s.dispatchDue(ctx); err != nil {
+			errorf("Failed to read scheduled sends: %v", err)
+		}
+		timer.Reset(s.untilNext())
+	}
+}
+
+// dispatchDue sends the pending sends whose time has come. It is also the
+// scheduled-sends job, which picks up sends added by other servers sharing
+// the storage. With -offline-queue, sends wait while the circuit breaker
+// of their instance keeps GREEN-API calls paused.
+func (s *Scheduler) dispatchDue(ctx context.Context) error {
+	due, err := s.store.due(time.Now())
+	for _, send := range due {
+		if s.holding(send.IDInstance) {
+			continue
+		}
+		s.dispatch(ctx, send)
+	}
+	return err
+}
+
+// holding reports whether due sends of an instance are held back for
+// GREEN-API to answer again.
+func (s *Scheduler) holding(idInstance string) bool {
+	return s.app.liveConfig().OfflineQueue && !s.app.upstream.of(idInstance).ready()
+}
+
+// untilNext is the wait before the earliest pending send, at most an hour.
+// While sends are held and one is already due, it is the wait before the
+// first circuit breaker lets a call through.
+func (s *Scheduler) untilNext() time.Duration {
+	wait := time.Hour
+	next, ok, err := s.store.nextDue()
+	if err != nil {
+		errorf("Failed to read scheduled sends: %v", err)
+	}
+	if ok {
+		until := time.Until(next)
+		if until <= 0 && s.app.liveConfig().OfflineQueue {
+			for i, breaker := range s.app.upstream.held() {
+				if retry := breaker.retryIn(); i == 0 || retry < until {
+					until = retry
+				}
+			}
+		}
+		if until < wait {
+			wait = max(until, 0)
+		}
+	}
+	return wait
+}
+
+// dispatch sends through the instance's send queue, rechecking the opt-out
+// list since it may have changed while the send was held. A send that
+// fails goes to the dead letter queue.
+func (s *Scheduler) dispatch(ctx context.Context, send ScheduledSend) {
+	claimed, err := s.store.claim(send.ID)
+	if err != nil {
+		errorf("Failed to claim scheduled send %d: %v", send.ID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	var response map[string]interface{}
+	if _, optedOut := s.app.optOuts.get(send.PhoneNumber); optedOut {
+		err = errOptedOut
+	} else if err = s.app.sendQueue.wait(ctx, send.IDInstance); err == nil {
+		var unlock func()
+		if unlock, err = s.app.chatLocks.lock(ctx, chatKey(send.IDInstance, send.PhoneNumber+"@c.us")); err == nil {
+			var statusCode int
+			response, statusCode, err = s.app.makeAPIRequestWithPayload(ctx, send.Method, send.URL, send.Payload)
+			unlock()
+			s.app.audit.record(send.Actor, OutboundMessage{
+				Method:  send.Method,
+				URL:     send.URL,
+				ChatID:  send.PhoneNumber + "@c.us",
+				Content: send.Content,
+			}, statusCode, response, err)
+		}
+	}
+
+	if wait := retryAfterOf(err); wait > 0 && ctx.Err() == nil {
+		// GREEN-API turned the send away: try again when it asked to
+		send.SendAt = time.Now().Add(wait)
+		send.Reason = "rate limited"
+		warnf("Scheduled %s to %s rate limited, retrying at %s", send.Method, send.PhoneNumber, send.SendAt.Format(time.RFC3339))
+		if err := s.store.finish(send); err != nil {
+			errorf("Failed to release scheduled send %d: %v", send.ID, err)
+		}
+		return
+	}
+	if ctx.Err() != nil || s.app.liveConfig().OfflineQueue && (errors.Is(err, errUpstreamDown) || unreachable(err)) {
+		// Shutting down, or GREEN-API is down: hand the send back for the
+		// next run
+		if err := s.store.finish(send); err != nil {
+			errorf("Failed to release scheduled send %d: %v", send.ID, err)
+		}
+		return
+	}
+
+	now := time.Now()
+	send.SentAt = &now
+	send.Response = response
+	send.Status = scheduledSent
+	if err != nil {
+		send.Status = scheduledFailed
+		send.Error = err.Error()
+		errorf("Scheduled %s to %s failed: %v", send.Method, send.PhoneNumber, err)
+		// An opted-out number would only fail again
+		if !errors.Is(err, errOptedOut) {
+			s.app.deadSend(send, err)
+		}
+	}
+	if err := s.store.finish(send); err != nil {
+		errorf("Failed to record scheduled send %d: %v", send.ID, err)
+	}
+}
+
+// cancel stops a pending send of an instance workspace may see.
+func (s *Scheduler) cancel(workspace string, id int64) (ScheduledSend, bool, error) {
+	send, ok, err := s.store.get(id)
+	if err != nil || !ok || !canSee(workspace, s.app.instanceWorkspace(send.IDInstance)) {
+		return ScheduledSend{}, false, err
+	}
+	return s.store.cancel(id)
+}
+
+// list returns the sends of instances workspace may see, the next one due
+// first.
+func (s *Scheduler) list(workspace string) ([]scheduledView, error) {
+	sends, err := s.store.list()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]scheduledView, 0, len(sends))
+	for _, send := range sends {
+		if canSee(workspace, s.app.instanceWorkspace(send.IDInstance)) {
+			list = append(list, scheduledView{ScheduledSend: send, URL: maskToken(send.URL)})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].SendAt.Equal(list[j].SendAt) {
+			return list[i].SendAt.Before(list[j].SendAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+// memorySchedule keeps scheduled sends in a map, so pending ones end with
+// the process.
+type memorySchedule struct {
+	mu     sync.Mutex
+	sends  map[int64]*ScheduledSend
+	nextID int64
+}
+
+func newMemorySchedule() *memorySchedule {
+	return &memorySchedule{sends: make(map[int64]*ScheduledSend), nextID: 1}
+}
+
+func (s *memorySchedule) add(send ScheduledSend) (ScheduledSend, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	send.ID = s.nextID
+	s.nextID++
+	send.CreatedAt = time.Now()
+	send.Status = scheduledPending
+	s.sends[send.ID] = &send
+	return send, nil
+}
+
+func (s *memorySchedule) get(id int64) (ScheduledSend, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	send, ok := s.sends[id]
+	if !ok {
+		return ScheduledSend{}, false, nil
+	}
+	return *send, true, nil
+}
+
+func (s *memorySchedule) due(now time.Time) ([]ScheduledSend, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []ScheduledSend
+	for _, send := range s.sends {
+		if send.Status == scheduledPending && !send.SendAt.After(now) {
+			due = append(due, *send)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].ID < due[j].ID })
+	return due, nil
+}
+
+func (s *memorySchedule) nextDue() (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	found := false
+	for _, send := range s.sends {
+		if send.Status == scheduledPending && (!found || send.SendAt.Before(next)) {
+			next, found = send.SendAt, true
+		}
+	}
+	return next, found, nil
+}
+
+func (s *memorySchedule) claim(id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	send, ok := s.sends[id]
+	if !ok || send.Status != scheduledPending {
+		return false, nil
+	}
+	send.Status = scheduledSending
+	return true, nil
+}
+
+func (s *memorySchedule) finish(send ScheduledSend) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sends[send.ID]; ok {
+		s.sends[send.ID] = &send
+	}
+	s.pruneFinished()
+	return nil
+}
+
+// pruneFinished drops the oldest finished sends beyond maxFinishedSends.
+// The caller holds mu.
+func (s *memorySchedule) pruneFinished() {
+	var finished []int64
+	for id, send := range s.sends {
+		if send.Status != scheduledPending && send.Status != scheduledSending {
+			finished = append(finished, id)
+		}
+	}
+	if len(finished) <= maxFinishedSends {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i] < finished[j] })
+	for _, id := range finished[:len(finished)-maxFinishedSends] {
+		delete(s.sends, id)
+	}
+}
+
+func (s *memorySchedule) cancel(id int64) (ScheduledSend, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	send, ok := s.sends[id]
+	if !ok || send.Status != scheduledPending {
+		return ScheduledSend{}, false, nil
+	}
+	send.Status = scheduledCancelled
+	s.pruneFinished()
+	return *send, true, nil
+}
+
+func (s *memorySchedule) list() ([]ScheduledSend, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]ScheduledSend, 0, len(s.sends))
+	for _, send := range s.sends {
+		list = append(list, *send)
+	}
+	return list, nil
+}
+
+func (s *memorySchedule) pending() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, send := range s.sends {
+		if send.Status == scheduledPending {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (a *App) scheduleHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := a.scheduler.list(workspaceOf(r))
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (a *App) cancelScheduledHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Scheduled send id must be a number")
+		return
+	}
+
+	send, ok, err := a.scheduler.cancel(workspaceOf(r), id)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if !ok {
+		writeErrorf(w, r, http.StatusNotFound, "scheduled_send_not_found", "No pending scheduled send %d", id)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scheduledView{ScheduledSend: send, URL: maskToken(send.URL)})
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// jsonSchemaDialect is the JSON Schema version /api/schema describes
+// bodies in.
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// EndpointSchema ties a JSON endpoint to the Go types of its request body
+// and of the response field of its APIResponse envelope.
+type EndpointSchema struct {
+	Request reflect.Type
+	// Response is nil where the GREEN-API answer is passed on as it is.
+	Response reflect.Type
+}
+
+// endpointSchemas are the endpoints /api/schema describes, by their path
+// under /api/. Upload endpoints take multipart forms and are left out.
+var endpointSchemas = map[string]EndpointSchema{
+	"get-settings":        {Request: reflect.TypeFor[SettingsRequest]()},
+	"get-state":           {Request: reflect.TypeFor[SettingsRequest]()},
+	"instance-overview":   {Request: reflect.TypeFor[SettingsRequest](), Response: reflect.TypeFor[InstanceOverview]()},
+	"send-message":        {Request: reflect.TypeFor[SendMessageRequest]()},
+	"send-file":           {Request: reflect.TypeFor[SendFileRequest]()},
+	"read-chat":           {Request: reflect.TypeFor[ReadChatRequest]()},
+	"send-typing":         {Request: reflect.TypeFor[SendTypingRequest]()},
+	"send-reaction":       {Request: reflect.TypeFor[SendReactionRequest]()},
+	"chat-history":        {Request: reflect.TypeFor[ChatHistoryRequest](), Response: reflect.TypeFor[ChatHistoryPage]()},
+	"contacts":            {Request: reflect.TypeFor[ContactsRequest](), Response: reflect.TypeFor[ContactsPage]()},
+	"check-whatsapp/bulk": {Request: reflect.TypeFor[BulkCheckRequest](), Response: reflect.TypeFor[BulkCheckResult]()},
+	"group-invite-link":   {Request: reflect.TypeFor[GroupInviteLinkRequest](), Response: reflect.TypeFor[GroupInvite]()},
+	"set-profile-name":    {Request: reflect.TypeFor[SetProfileNameRequest]()},
+	"raw":                 {Request: reflect.TypeFor[RawRequest]()},
+}
+
+// schemaFor maps types that decode from more than their Go kind suggests,
+// like the form-friendly types accepting strings, to their schema.
+var schemaFor = map[reflect.Type]map[string]interface{}{
+	reflect.TypeFor[formBool](): {"type": []string{"boolean", "string"}},
+	reflect.TypeFor[phoneList](): {"oneOf": []interface{}{
+		map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		map[string]interface{}{"type": "string", "description": "numbers separated by commas, spaces or newlines"},
+	}},
+	reflect.TypeFor[stringMap](): {"oneOf": []interface{}{
+		map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "string"}},
+		map[string]interface{}{"type": "string", "description": "a JSON object"},
+	}},
+	reflect.TypeFor[jsonBody]():        {"description": "any JSON value, or a string holding one"},
+	reflect.TypeFor[json.RawMessage](): {},
+	reflect.TypeFor[time.Time]():       {"type": "string", "format": "date-time"},
+}
+
+// jsonSchema describes how t is encoded in JSON. Fields tagged
+// api:"required" are listed as required; embedded structs contribute
+// their fields as encoding/json does.
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	if schema, ok := schemaFor[t]; ok {
+		return schema
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonSchema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		var required []string
+		addFields(t, properties, &required)
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	// interface{} holds anything
+	return map[string]interface{}{}
+}
+
+// addFields adds the JSON fields of struct t to properties.
+func addFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addFields(field.Type, properties, required)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = jsonSchema(field.Type)
+		if field.Tag.Get("api") == "required" {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// envelopeSchema is the APIResponse envelope with its response field
+// described by response, or left open for GREEN-API answers.
+func envelopeSchema(response reflect.Type) map[string]interface{} {
+	schema := jsonSchema(reflect.TypeFor[APIResponse]())
+	properties := schema["properties"].(map[string]interface{})
+	if response != nil {
+		properties["response"] = jsonSchema(response)
+	} else {
+		properties["response"] = map[string]interface{}{"description": "the GREEN-API answer as it came"}
+	}
+	return schema
+}
+
+// schemaDocument is what /api/schema/{endpoint} returns: a schema each for
+// the request body and the response.
+type schemaDocument struct {
+	Endpoint string                 `json:"endpoint"`
+	Request  map[string]interface{} `json:"request"`
+	Response map[string]interface{} `json:"response"`
+}
+
+func (s EndpointSchema) document(endpoint string) schemaDocument {
+	request := jsonSchema(s.Request)
+	request["$schema"] = jsonSchemaDialect
+	request["title"] = "/api/" + endpoint + " request"
+	response := envelopeSchema(s.Response)
+	response["$schema"] = jsonSchemaDialect
+	response["title"] = "/api/" + endpoint + " response"
+	return schemaDocument{Endpoint: endpoint, Request: request, Response: response}
+}
+
+// schemasHandler lists the endpoints with a schema.
+func schemasHandler(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(endpointSchemas))
+	for name := range endpointSchemas {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(names)
+}
+
+// schemaHandler returns the JSON schemas of an endpoint's request body and
+// response, so tools can check a payload before sending it.
+func schemaHandler(w http.ResponseWriter, r *http.Request) {
+	endpoint := strings.Trim(r.PathValue("endpoint"), "/")
+	schema, ok := endpointSchemas[endpoint]
+	if !ok {
+		writeErrorf(w, r, http.StatusNotFound, "not_found", "No schema for /api/%s", endpoint)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schema.document(endpoint))
+}
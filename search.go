@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/binary"
+	"html"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Search terms are marked in snippets with snippetStart and snippetEnd,
+// and the rest of the text is HTML-escaped, so a snippet can be shown as
+// HTML. The database indexes mark terms with the private use characters
+// indexStart and indexEnd instead, which markSnippet turns into the tags
+// once the text is escaped.
+const (
+	snippetStart = "<b>"
+	snippetEnd   = "</b>"
+	indexStart   = "\uE000"
+	indexEnd     = "\uE001"
+	// snippetRunes is about how much of a message a snippet shows.
+	snippetRunes = 120
+)
+
+// SearchHit is a message found by /api/search.
+type SearchHit struct {
+	IDInstance  string    `json:"idInstance"`
+	ChatID      string    `json:"chatId"`
+	IDMessage   string    `json:"idMessage,omitempty"`
+	TypeWebhook string    `json:"typeWebhook"`
+	Time        time.Time `json:"time"`
+	// Snippet is the HTML-escaped part of the text around the terms, which
+	// are marked with <b></b>.
+	Snippet string `json:"snippet"`
+	// Rank is how well the message matches, higher is better. It compares
+	// the hits of one search only.
+	Rank float64 `json:"rank"`
+}
+
+// SearchQuery is what /api/search looks for. Every term must be in a
+// message; a term ending in * matches words starting with it.
+type SearchQuery struct {
+	Terms      []string
+	Workspace  string
+	IDInstance string
+	ChatID     string
+	Offset     int
+	Limit      int
+}
+
+// MessageIndex keeps the messages of chats to find them by their text,
+// best matches first, and count them over time.
+type MessageIndex interface {
+	add(notification Notification) error
+	search(query SearchQuery) ([]SearchHit, int, error)
+	volume(query VolumeQuery) ([]VolumeCount, error)
+	prune(before time.Time) (int, error)
+}
+
+// isMessage reports whether a notification is a message sent or received
+// in a chat, which the message index keeps. Messages without text, such as
+// files, are counted but can't be found.
+func isMessage(notification Notification) bool {
+	return notification.Reaction == nil && notification.ChatID != "" &&
+		slices.Contains(transcriptWebhooks, notification.TypeWebhook)
+}
+
+// indexMessage adds a received webhook to the message index if it is a
+// message.
+func (a *App) indexMessage(notification Notification) {
+	if !isMessage(notification) {
+		return
+	}
+	if err := a.messageIndex.add(notification); err != nil {
+		errorf("Failed to index message %s: %v", notification.IDMessage, err)
+	}
+}
+
+// searchTerms splits a search into its terms, dropping the quotes and
+// operators of the FTS query syntax so any text can be searched for.
+func searchTerms(text string) []string {
+	var terms []string
+	for _, field := range strings.Fields(text) {
+		prefix := strings.HasSuffix(field, "*")
+		field = strings.Map(func(c rune) rune {
+			if strings.ContainsRune(`"*()^:`, c) {
+				return -1
+			}
+			return c
+		}, field)
+		field = strings.TrimLeft(field, "-+")
+		if field == "" {
+			continue
+		}
+		if prefix {
+			field += "*"
+		}
+		terms = append(terms, field)
+	}
+	return terms
+}
+
+// searchSpec only checks the paging of /api/search, whose hits come ranked.
+var searchSpec = listSpec[SearchHit]{}
+
+// searchHandler finds messages of every chat by their text. ?q= is the
+// search, ?idInstance= and ?chatId= narrow it to an instance or chat.
+func (a *App) searchHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
+	// q is the search itself rather than a filter of its hits
+	terms := searchTerms(q.Search)
+	q.Search = ""
+	if !searchSpec.check(w, r, &q, defaultPageSize) {
+		return
+	}
+	if len(terms) == 0 {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Query parameter q is required")
+		return
+	}
+	chatID := r.URL.Query().Get("chatId")
+	if chatID != "" && !strings.Contains(chatID, "@") {
+		chatID += "@c.us"
+	}
+
+	hits, total, err := a.messageIndex.search(SearchQuery{
+		Terms:      terms,
+		Workspace:  workspaceOf(r),
+		IDInstance: r.URL.Query().Get("idInstance"),
+		ChatID:     chatID,
+		Offset:     q.Offset,
+		Limit:      q.Limit,
+	})
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	writeList(w, hits, total)
+}
+
+// ftsRank scores an FTS4 match from matchinfo(..., 'pcx'): every hit of a
+// term in the message counts by how rare the term is in all messages.
+func ftsRank(matchinfo []byte) float64 {
+	ints := make([]uint32, len(matchinfo)/4)
+	for i := range ints {
+		ints[i] = binary.NativeEndian.Uint32(matchinfo[i*4:])
+	}
+	if len(ints) < 2 {
+		return 0
+	}
+	phrases, columns := int(ints[0]), int(ints[1])
+	score := 0.0
+	for i := range phrases * columns {
+		at := 2 + i*3
+		if at+1 >= len(ints) {
+			break
+		}
+		if hitsHere, hitsAll := ints[at], ints[at+1]; hitsAll > 0 {
+			score += float64(hitsHere) / float64(hitsAll)
+		}
+	}
+	return score
+}
+
+// memoryMessageIndex searches the webhooks kept in memory, so it adds
+// nothing of its own and forgets what -webhook-history-size drops.
+type memoryMessageIndex struct {
+	notifications *NotificationStore
+}
+
+func (memoryMessageIndex) add(Notification) error { return nil }
+
+func (memoryMessageIndex) prune(time.Time) (int, error) { return 0, nil }
+
+func (m memoryMessageIndex) search(query SearchQuery) ([]SearchHit, int, error) {
+	hits := []SearchHit{}
+	for _, notification := range m.notifications.list(query.Workspace) {
+		idInstance := strconv.FormatInt(notification.IDInstance, 10)
+		if !isMessage(notification) || notification.Text == "" ||
+			(query.IDInstance != "" && idInstance != query.IDInstance) ||
+			(query.ChatID != "" && notification.ChatID != query.ChatID) {
+			continue
+		}
+		rank, ok := matchTerms(notification.Text, query.Terms)
+		if !ok {
+			continue
+		}
+		hits = append(hits, SearchHit{
+			IDInstance:  idInstance,
+			ChatID:      notification.ChatID,
+			IDMessage:   notification.IDMessage,
+			TypeWebhook: notification.TypeWebhook,
+			Time:        notification.ReceivedAt,
+			Snippet:     snippet(notification.Text, query.Terms),
+			Rank:        rank,
+		})
+	}
+	// Newest first among equal ranks, as notifications are listed
+	slices.SortStableFunc(hits, func(a, b SearchHit) int {
+		switch {
+		case a.Rank > b.Rank:
+			return -1
+		case a.Rank < b.Rank:
+			return 1
+		}
+		return 0
+	})
+
+	total := len(hits)
+	start := min(query.Offset, total)
+	end := min(start+query.Limit, total)
+	return hits[start:end], total, nil
+}
+
+// searchTerm is a term as the memory index looks for it.
+type searchTerm struct {
+	word   []rune
+	prefix bool
+}
+
+func lowerTerms(terms []string) []searchTerm {
+	list := make([]searchTerm, 0, len(terms))
+	for _, term := range terms {
+		word, prefix := strings.CutSuffix(term, "*")
+		list = append(list, searchTerm{word: []rune(strings.ToLower(word)), prefix: prefix})
+	}
+	return list
+}
+
+func isWordRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// termAt is the length of the longest word at i of text a term matches, 0
+// without one. Like the database indexes, terms match whole words, or
+// their start when they end in *.
+func termAt(text []rune, i int, terms []searchTerm) int {
+	if i > 0 && isWordRune(text[i-1]) {
+		return 0
+	}
+	longest := 0
+	for _, term := range terms {
+		end := i + len(term.word)
+		if len(term.word) == 0 || end > len(text) || !slices.Equal(text[i:end], term.word) {
+			continue
+		}
+		for term.prefix && end < len(text) && isWordRune(text[end]) {
+			end++
+		}
+		if end < len(text) && isWordRune(text[end]) {
+			continue
+		}
+		longest = max(longest, end-i)
+	}
+	return longest
+}
+
+// matchTerms reports whether text has every term, ignoring case, and ranks
+// it by how many of its words they match.
+func matchTerms(text string, terms []string) (float64, bool) {
+	lower := []rune(strings.ToLower(text))
+	hits, words := 0, 0
+	for _, term := range lowerTerms(terms) {
+		n := 0
+		for i := range lower {
+			if termAt(lower, i, []searchTerm{term}) > 0 {
+				n++
+			}
+		}
+		if n == 0 {
+			return 0, false
+		}
+		hits += n
+	}
+	for i, c := range lower {
+		if isWordRune(c) && (i == 0 || !isWordRune(lower[i-1])) {
+			words++
+		}
+	}
+	return float64(hits) / float64(words), true
+}
+
+// markSnippet escapes a snippet of a database index and marks its terms
+// like snippet does. Markers can only open and close a tag in turn, so
+// stray ones in a message never leave one unbalanced.
+func markSnippet(indexed string) string {
+	var b strings.Builder
+	open := false
+	for _, c := range html.EscapeString(indexed) {
+		switch {
+		case string(c) == indexStart && !open:
+			b.WriteString(snippetStart)
+			open = true
+		case string(c) == indexEnd && open:
+			b.WriteString(snippetEnd)
+			open = false
+		case string(c) == indexStart, string(c) == indexEnd:
+		default:
+			b.WriteRune(c)
+		}
+	}
+	if open {
+		b.WriteString(snippetEnd)
+	}
+	return b.String()
+}
+
+// snippet cuts the part of text around the first term and marks the terms
+// in it, like the snippets of the database indexes.
+func snippet(text string, terms []string) string {
+	runes := []rune(text)
+	// strings.ToLower maps rune by rune, so lower lines up with runes
+	lower := []rune(strings.ToLower(text))
+	lowered := lowerTerms(terms)
+
+	first := 0
+	for i := range lower {
+		if termAt(lower, i, lowered) > 0 {
+			first = i
+			break
+		}
+	}
+	start := max(first-snippetRunes/3, 0)
+	end := min(start+snippetRunes, len(runes))
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	plain := start
+	for i := start; i < end; {
+		n := min(termAt(lower, i, lowered), end-i)
+		if n == 0 {
+			i++
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[plain:i])))
+		b.WriteString(snippetStart + html.EscapeString(string(runes[i:i+n])) + snippetEnd)
+		i += n
+		plain = i
+	}
+	b.WriteString(html.EscapeString(string(runes[plain:end])))
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// keyringService is the service name instance tokens are stored under in the
+// OS keyring, with the idInstance as the account.
+const keyringService = "grapi"
+
+// secretsIterations is the PBKDF2 work factor for the secrets file.
+const secretsIterations = 600000
+
+// secretsFile is the on-disk form of -secrets-file. The plaintext is the
+// instance list in -instances format, one idInstance:apiTokenInstance per
+// line, sealed with AES-256-GCM under a key derived from the passphrase.
+type secretsFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+var errWrongPassphrase = errors.New("wrong passphrase or corrupted secrets file")
+
+func secretsKey(passphrase string, salt []byte, iterations int) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+}
+
+func sealSecrets(plaintext []byte, passphrase string) (secretsFile, error) {
+	sealed := secretsFile{Version: 1, KDF: "pbkdf2-sha256", Iterations: secretsIterations, Salt: make([]byte, 16)}
+	rand.Read(sealed.Salt)
+
+	key, err := secretsKey(passphrase, sealed.Salt, sealed.Iterations)
+	if err != nil {
+		return sealed, err
+	}
+	aead, err := newSecretsAEAD(key)
+	if err != nil {
+		return sealed, err
+	}
+	sealed.Nonce = make([]byte, aead.NonceSize())
+	rand.Read(sealed.Nonce)
+	sealed.Ciphertext = aead.Seal(nil, sealed.Nonce, plaintext, nil)
+	return sealed, nil
+}
+
+func (f secretsFile) open(passphrase string) ([]byte, error) {
+	if f.Version != 1 || f.KDF != "pbkdf2-sha256" {
+		return nil, fmt.Errorf("unsupported secrets file version %d (%s)", f.Version, f.KDF)
+	}
+	key, err := secretsKey(passphrase, f.Salt, f.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newSecretsAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Nonce) != aead.NonceSize() {
+		return nil, errWrongPassphrase
+	}
+	plaintext, err := aead.Open(nil, f.Nonce, f.Ciphertext, nil)
+	if err != nil {
+		return nil, errWrongPassphrase
+	}
+	return plaintext, nil
+}
+
+func newSecretsAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadSecrets reads the instances stored in the encrypted secrets file.
+func loadSecrets(path string) (instanceList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sealed secretsFile
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return nil, fmt.Errorf("invalid secrets file %s: %w", path, err)
+	}
+
+	passphrase, err := secretsPassphrase("Passphrase for " + path + ": ")
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := sealed.open(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	var instances instanceList
+	err = instances.Set(strings.Join(strings.Fields(string(plaintext)), ","))
+	return instances, err
+}
+
+// sealSecretsFile encrypts the idInstance:apiTokenInstance lines read from
+// in into path.
+func sealSecretsFile(path string, in io.Reader) error {
+	var instances instanceList
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if err := instances.Set(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(instances) == 0 {
+		return errors.New("no instances on stdin, expected one idInstance:apiTokenInstance per line")
+	}
+
+	lines := make([]string, len(instances))
+	for i, instance := range instances {
+		if instance.APITokenInstance == "" {
+			return fmt.Errorf("instance %s has no token", instance.IDInstance)
+		}
+		lines[i] = instance.IDInstance + ":" + instance.APITokenInstance
+		if instance.Workspace != "" {
+			lines[i] = instance.Workspace + "/" + lines[i]
+		}
+	}
+
+	passphrase, err := secretsPassphrase("New passphrase for " + path + ": ")
+	if err != nil {
+		return err
+	}
+	if passphrase == "" {
+		return errors.New("the passphrase must not be empty")
+	}
+	sealed, err := sealSecrets([]byte(strings.Join(lines, "\n")+"\n"), passphrase)
+	if err != nil {
+		return err
+	}
+	data, _ := json.MarshalIndent(sealed, "", "  ")
+
+	// Write through a temp file so a failed write never truncates the old one
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".secrets-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// secretsPassphrase reads the passphrase from $GREENAPI_SECRETS_PASSPHRASE,
+// or prompts for it on the terminal.
+func secretsPassphrase(prompt string) (string, error) {
+	if passphrase, ok := os.LookupEnv("GREENAPI_SECRETS_PASSPHRASE"); ok {
+		return passphrase, nil
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", errors.New("no terminal to prompt for the passphrase, set GREENAPI_SECRETS_PASSPHRASE")
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, prompt)
+	echoOff := exec.Command("stty", "-echo")
+	echoOff.Stdin = tty
+	if echoOff.Run() == nil {
+		defer func() {
+			echoOn := exec.Command("stty", "echo")
+			echoOn.Stdin = tty
+			echoOn.Run()
+			fmt.Fprintln(tty)
+		}()
+	}
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// keyringToken looks up an instance token in the OS keyring: the Secret
+// Service through secret-tool on Linux, the login keychain on macOS.
+func keyringToken(idInstance string) (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd":
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", idInstance)
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", idInstance, "-w")
+	default:
+		return "", fmt.Errorf("the OS keyring is not supported on %s", runtime.GOOS)
+	}
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("no keyring entry for instance %s (service %s): %w", idInstance, keyringService, err)
+	}
+	token := strings.TrimSpace(string(output))
+	if token == "" {
+		return "", fmt.Errorf("empty keyring entry for instance %s", idInstance)
+	}
+	return token, nil
+}
+
+// resolveSecrets unlocks -secrets-file and completes config.Instances.
+func (a *App) resolveSecrets() error {
+	if a.config.SecretsFile != "" {
+		var err error
+		if a.sealedInstances, err = loadSecrets(a.config.SecretsFile); err != nil {
+			return fmt.Errorf("failed to load %s: %w", a.config.SecretsFile, err)
+		}
+	}
+	return a.withSecrets(a.config)
+}
+
+// withSecrets adds the sealed instances to c and looks up the tokens of
+// instances listed without one in the OS keyring.
+func (a *App) withSecrets(c *Config) error {
+	instances := append(slices.Clone(c.Instances), a.sealedInstances...)
+	for i, instance := range instances {
+		if instance.APITokenInstance != "" {
+			continue
+		}
+		if !c.Keyring {
+			return fmt.Errorf("instance %s has no token, use idInstance:apiTokenInstance or -keyring", instance.IDInstance)
+		}
+		token, err := keyringToken(instance.IDInstance)
+		if err != nil {
+			return err
+		}
+		instances[i].APITokenInstance = token
+	}
+	c.Instances = instances
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+)
+
+// registeredSettings are the instance settings pointed at this server while
+// it runs.
+var registeredSettings = []string{"webhookUrl", "webhookUrlToken", "incomingWebhook", "outgoingWebhook"}
+
+// WebhookRegistrar points configured instances at this server's /webhook
+// and remembers their previous settings so they can be restored.
+type WebhookRegistrar struct {
+	app      *App
+	previous map[string]map[string]interface{}
+}
+
+func newWebhookRegistrar(a *App) *WebhookRegistrar {
+	return &WebhookRegistrar{app: a, previous: make(map[string]map[string]interface{})}
+}
+
+func (a *App) instanceURL(instance Instance, method string) string {
+	return a.methodURL(method, instance.IDInstance, instance.APITokenInstance)
+}
+
+// register saves each instance's webhook settings and replaces them with
+// webhookUrl. Instances that fail are logged and skipped.
+func (wr *WebhookRegistrar) register(ctx context.Context, webhookUrl string) {
+	for _, instance := range wr.app.configuredInstances() {
+		current, _, err := wr.app.makeAPIRequest(ctx, "getSettings", wr.app.instanceURL(instance, "getSettings"))
+		if err != nil {
+			errorf("Failed to read settings of instance %s: %v", instance.IDInstance, err)
+			continue
+		}
+
+		previous := make(map[string]interface{})
+		for _, name := range registeredSettings {
+			if value, ok := current[name]; ok {
+				previous[name] = value
+			}
+		}
+
+		settings := map[string]interface{}{
+			"webhookUrl":      webhookUrl,
+			"webhookUrlToken": wr.app.config.WebhookToken,
+			"incomingWebhook": "yes",
+			"outgoingWebhook": "yes",
+		}
+		if _, _, err := wr.app.makeAPIRequestWithPayload(ctx, "setSettings", wr.app.instanceURL(instance, "setSettings"), settings); err != nil {
+			errorf("Failed to register webhook for instance %s: %v", instance.IDInstance, err)
+			continue
+		}
+
+		wr.previous[instance.IDInstance] = previous
+		infof("Instance %s now sends webhooks to %s", instance.IDInstance, webhookUrl)
+	}
+}
+
+// restore puts back the settings replaced by register.
+func (wr *WebhookRegistrar) restore(ctx context.Context) {
+	for _, instance := range wr.app.configuredInstances() {
+		previous, ok := wr.previous[instance.IDInstance]
+		if !ok {
+			continue
+		}
+		if _, _, err := wr.app.makeAPIRequestWithPayload(ctx, "setSettings", wr.app.instanceURL(instance, "setSettings"), previous); err != nil {
+			errorf("Failed to restore webhook settings of instance %s: %v", instance.IDInstance, err)
+			continue
+		}
+		delete(wr.previous, instance.IDInstance)
+		infof("Restored webhook settings of instance %s", instance.IDInstance)
+	}
+}
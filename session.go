@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// sessionCookie is the cookie carrying the session id.
+const sessionCookie = "grapi_session"
+
+// Session is a signed-in browser.
+type Session struct {
+	Principal Principal
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// SessionStore keeps sessions server-side; the cookie only holds the token.
+type SessionStore interface {
+	create(principal Principal, ttl time.Duration) (string, Session, error)
+	// get returns an unexpired session.
+	get(token string) (Session, bool, error)
+	delete(token string) error
+	removeExpired() error
+}
+
+// newSessionToken returns a random session token.
+func newSessionToken() string {
+	id := make([]byte, 32)
+	rand.Read(id)
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+// runSessionJanitor drops expired sessions every interval.
+func (a *App) runSessionJanitor(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := a.sessions.removeExpired(); err != nil {
+			errorf("Failed to remove expired sessions: %v", err)
+		}
+	}
+}
+
+// memorySessions keeps sessions in a map, so they end with the process.
+type memorySessions struct {
+	sessions *SyncMap[string, Session]
+}
+
+func newMemorySessions() *memorySessions {
+	return &memorySessions{sessions: newSyncMap[string, Session]()}
+}
+
+func (s *memorySessions) create(principal Principal, ttl time.Duration) (string, Session, error) {
+	token := newSessionToken()
+	now := time.Now()
+	session := Session{Principal: principal, CreatedAt: now, ExpiresAt: now.Add(ttl)}
+	s.sessions.setTTL(token, session, ttl)
+	return token, session, nil
+}
+
+func (s *memorySessions) get(token string) (Session, bool, error) {
+	session, ok := s.sessions.get(token)
+	if !ok || time.Now().After(session.ExpiresAt) {
+		// A -session-ttl of 0 gives sessions the map keeps for good
+		return Session{}, false, nil
+	}
+	return session, true, nil
+}
+
+func (s *memorySessions) delete(token string) error {
+	s.sessions.delete(token)
+	return nil
+}
+
+func (s *memorySessions) removeExpired() error {
+	s.sessions.removeExpired(time.Now())
+	return nil
+}
+
+// sessionPrincipal returns the caller signed in through the session cookie.
+func (a *App) sessionPrincipal(r *http.Request) (Principal, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return Principal{}, false
+	}
+	session, ok, err := a.sessions.get(cookie.Value)
+	if err != nil {
+		errorf("Failed to read session: %v", err)
+		return Principal{}, false
+	}
+	return session.Principal, ok
+}
+
+// secureCookies reports whether cookies should be limited to HTTPS.
+func (a *App) secureCookies(r *http.Request) bool {
+	return requestScheme(r) == "https" || strings.HasPrefix(a.config.PublicURL, "https://")
+}
+
+// wantsPage reports whether an unauthenticated request comes from a browser
+// navigating to a page, which is sent to the login form instead of a 401.
+func wantsPage(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// safeNext keeps post-login redirects on this server.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func (a *App) loginPageHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.authEnabled() {
+		http.Redirect(w, r, a.appPath("/"), http.StatusSeeOther)
+		return
+	}
+	a.renderPage(w, r, http.StatusOK, "login.html", a.loginPage(safeNext(r.URL.Query().Get("next")), ""))
+}
+
+// loginPage offers the sign-in methods that are configured.
+func (a *App) loginPage(next, message string) LoginPage {
+	page := LoginPage{Next: next, Error: message, APIKeys: len(a.liveConfig().APIKeys) > 0}
+	if a.oauth != nil {
+		page.OAuth = a.oauth.Name
+	}
+	return page
+}
+
+// loginHandler exchanges an API key for a session cookie.
+func (a *App) loginHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.authEnabled() {
+		http.Redirect(w, r, a.appPath("/"), http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	next := safeNext(r.PostForm.Get("next"))
+
+	principal, ok := a.keyPrincipal(r.PostForm.Get("key"))
+	if !ok {
+		a.renderPage(w, r, http.StatusUnauthorized, "login.html", a.loginPage(next, "Invalid API key"))
+		return
+	}
+
+	if !a.startSession(w, r, principal, isChecked(r.PostForm.Get("remember"))) {
+		return
+	}
+	http.Redirect(w, r, a.appPath(next), http.StatusSeeOther)
+}
+
+// startSession signs the browser in as principal. With remember set the
+// cookie outlives the browser for -remember-ttl, otherwise it is a browser
+// session cookie valid for -session-ttl. It reports false after answering
+// with an error when the session can't be stored.
+func (a *App) startSession(w http.ResponseWriter, r *http.Request, principal Principal, remember bool) bool {
+	ttl := a.config.SessionTTL
+	if remember {
+		ttl = a.config.RememberTTL
+	}
+	token, session, err := a.sessions.create(principal, ttl)
+	if err != nil {
+		errorf("Failed to create session: %v", err)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return false
+	}
+
+	cookie := &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     a.appPath("/"),
+		HttpOnly: true,
+		Secure:   a.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if remember {
+		cookie.Expires = session.ExpiresAt
+	}
+	http.SetCookie(w, cookie)
+	return true
+}
+
+func (a *App) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		if err := a.sessions.delete(cookie.Value); err != nil {
+			errorf("Failed to delete session: %v", err)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     a.appPath("/"),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   a.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, a.appPath("/login"), http.StatusSeeOther)
+}
+
+// loginRedirect sends a browser to the login form, returning afterwards.
+func (a *App) loginRedirect(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, a.appPath("/login")+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+}
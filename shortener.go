@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const shortenerTimeout = 10 * time.Second
+
+// bitlyAPI is the Bitly API used by -shortener bitly.
+const bitlyAPI = "https://api-ssl.bitly.com/v4"
+
+// ShortLink is a link of a broadcast message rewritten by -shortener, kept
+// with the batch so clicks can be put down to it.
+type ShortLink struct {
+	LongURL  string `json:"longUrl"`
+	ShortURL string `json:"shortUrl"`
+	// Clicks is counted by Bitly; a self-hosted shortener keeps its own
+	// count.
+	Clicks *int `json:"clicks,omitempty"`
+}
+
+// Shortener rewrites the links of broadcast messages through Bitly or a
+// self-hosted shortener, so each batch gets links of its own to count
+// clicks on.
+type Shortener struct {
+	client *http.Client
+	// config returns the live config.
+	config func() *Config
+}
+
+func (a *App) shortenerEnabled() bool {
+	return a.liveConfig().Shortener != ""
+}
+
+// rewrite replaces every link of text with a short one, shortening each
+// distinct link once.
+func (s *Shortener) rewrite(ctx context.Context, text string) (string, []ShortLink, error) {
+	var links []ShortLink
+	short := make(map[string]string)
+	for _, link := range linkPattern.FindAllString(text, -1) {
+		if _, ok := short[link]; ok {
+			continue
+		}
+		longURL := link
+		if !strings.Contains(link, "://") {
+			longURL = "https://" + link
+		}
+		shortURL, err := s.shorten(ctx, longURL)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %w", longURL, err)
+		}
+		short[link] = shortURL
+		links = append(links, ShortLink{LongURL: longURL, ShortURL: shortURL})
+	}
+	text = linkPattern.ReplaceAllStringFunc(text, func(link string) string { return short[link] })
+	return text, links, nil
+}
+
+func (s *Shortener) shorten(ctx context.Context, longURL string) (string, error) {
+	live := s.config()
+	if live.Shortener == "bitly" {
+		var link struct {
+			Link string `json:"link"`
+		}
+		err := s.call(ctx, http.MethodPost, bitlyAPI+"/shorten", map[string]string{"long_url": longURL}, &link)
+		return link.Link, err
+	}
+
+	var link struct {
+		ShortURL string `json:"shortUrl"`
+	}
+	if err := s.call(ctx, http.MethodPost, live.Shortener, map[string]string{"url": longURL}, &link); err != nil {
+		return "", err
+	}
+	if link.ShortURL == "" {
+		return "", fmt.Errorf("no shortUrl in the shortener's answer")
+	}
+	return link.ShortURL, nil
+}
+
+// clicks returns how often Bitly has seen a short link followed, nil with
+// a self-hosted shortener.
+func (s *Shortener) clicks(ctx context.Context, shortURL string) (*int, error) {
+	if s.config().Shortener != "bitly" {
+		return nil, nil
+	}
+	parsed, err := url.Parse(shortURL)
+	if err != nil {
+		return nil, err
+	}
+	var summary struct {
+		TotalClicks int `json:"total_clicks"`
+	}
+	target := bitlyAPI + "/bitlinks/" + parsed.Host + parsed.Path + "/clicks/summary?unit=month&units=-1"
+	if err := s.call(ctx, http.MethodGet, target, nil, &summary); err != nil {
+		return nil, err
+	}
+	return &summary.TotalClicks, nil
+}
+
+// call sends a JSON request to the shortener, with the Bitly token when
+// it goes to Bitly, and decodes the answer into out.
+func (s *Shortener) call(ctx context.Context, verb, target string, body, out interface{}) error {
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, verb, target, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if strings.HasPrefix(target, bitlyAPI) {
+		req.Header.Set("Authorization", "Bearer "+s.config().BitlyToken)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("shortener returned %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid shortener response: %w", err)
+	}
+	return nil
+}
+
+// BatchLinks are the short links a broadcast was sent with.
+type BatchLinks struct {
+	ID    int64       `json:"id"`
+	Links []ShortLink `json:"links"`
+}
+
+// batchLinksHandler lists the short links of a broadcast, with their
+// clicks when Bitly counts them.
+func (a *App) batchLinksHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid batch ID")
+		return
+	}
+	idInstance, links, ok := a.batches.links(id)
+	if !ok || !canSee(workspaceOf(r), a.instanceWorkspace(idInstance)) {
+		writeErrorf(w, r, http.StatusNotFound, "not_found", "Batch %d not found", id)
+		return
+	}
+
+	for i := range links {
+		clicks, err := a.shortener.clicks(r.Context(), links[i].ShortURL)
+		if err != nil {
+			errorf("Failed to get clicks of %s: %v", links[i].ShortURL, err)
+			continue
+		}
+		links[i].Clicks = clicks
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BatchLinks{ID: id, Links: links})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxSlowRequests is how many slow upstream calls are kept for review.
+const maxSlowRequests = 100
+
+// SlowRequest is an upstream call that took longer than -slow-threshold,
+// with the token masked like in history.
+type SlowRequest struct {
+	HistoryEntry
+	IDInstance string `json:"idInstance,omitempty"`
+	Budget     string `json:"budget"`
+	Threshold  string `json:"threshold"`
+	Retries    int    `json:"retries"`
+}
+
+// SlowLog keeps the most recent slow upstream calls in memory.
+type SlowLog struct {
+	mu       sync.Mutex
+	requests []SlowRequest
+	nextID   int64
+	// config returns the live config.
+	config func() *Config
+}
+
+// record logs and keeps the call if it exceeded the threshold.
+func (s *SlowLog) record(entry HistoryEntry, idInstance string, duration, budget time.Duration, retries int) {
+	threshold := s.config().SlowThreshold
+	if threshold <= 0 || duration <= threshold {
+		return
+	}
+
+	detail := ""
+	if entry.Error != "" {
+		detail = ": " + entry.Error
+	}
+	warnf("Slow %s %s took %s (threshold %s, budget %s, status %d, %d retries)%s",
+		entry.HTTPMethod, entry.URL, entry.Duration, threshold, budget, entry.Status, retries, detail)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.ID = s.nextID
+	s.nextID++
+	if len(s.requests) >= maxSlowRequests {
+		s.requests = s.requests[1:]
+	}
+	s.requests = append(s.requests, SlowRequest{
+		HistoryEntry: entry,
+		IDInstance:   idInstance,
+		Budget:       budget.String(),
+		Threshold:    threshold.String(),
+		Retries:      retries,
+	})
+}
+
+// list returns the slow calls workspace may see, newest first.
+func (s *SlowLog) list(workspace string) []SlowRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]SlowRequest, 0, len(s.requests))
+	for i := len(s.requests) - 1; i >= 0; i-- {
+		if canSee(workspace, s.requests[i].Workspace) {
+			list = append(list, s.requests[i])
+		}
+	}
+	return list
+}
+
+func (a *App) slowRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.slowRequests.list(workspaceOf(r)))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// UpstreamCall describes a GREEN-API request so it can be reproduced
+// outside the tool.
+type UpstreamCall struct {
+	Verb        string
+	URL         string
+	ContentType string
+	Body        []byte
+	FormFields  map[string]string
+	FileName    string
+}
+
+// snippetsFor renders curl, Go and Python code reproducing the upstream
+// call, including any advanced mode headers and query parameters.
+func snippetsFor(ctx context.Context, call UpstreamCall) map[string]string {
+	headers := map[string]string{}
+	if call.ContentType != "" && call.FileName == "" {
+		headers["Content-Type"] = call.ContentType
+	}
+
+	if overrides, ok := ctx.Value(overridesKey{}).(UpstreamOverrides); ok {
+		for name, value := range overrides.ExtraHeaders {
+			headers[name] = value
+		}
+		if len(overrides.ExtraQuery) > 0 {
+			if parsed, err := url.Parse(call.URL); err == nil {
+				query := parsed.Query()
+				for name, value := range overrides.ExtraQuery {
+					query.Set(name, value)
+				}
+				parsed.RawQuery = query.Encode()
+				call.URL = parsed.String()
+			}
+		}
+	}
+
+	return map[string]string{
+		"curl":   curlSnippet(call, headers),
+		"go":     goSnippet(call, headers),
+		"python": pythonSnippet(call, headers),
+	}
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func curlSnippet(call UpstreamCall, headers map[string]string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "curl -X %s %s", call.Verb, shellQuote(call.URL))
+	for _, name := range sortedKeys(headers) {
+		fmt.Fprintf(&b, " \\\n  -H %s", shellQuote(name+": "+headers[name]))
+	}
+	for _, name := range sortedKeys(call.FormFields) {
+		fmt.Fprintf(&b, " \\\n  -F %s", shellQuote(name+"="+call.FormFields[name]))
+	}
+	if call.FileName != "" {
+		fmt.Fprintf(&b, " \\\n  -F %s", shellQuote("file=@"+call.FileName))
+	}
+	if len(call.Body) > 0 {
+		fmt.Fprintf(&b, " \\\n  -d %s", shellQuote(string(call.Body)))
+	}
+	return b.String()
+}
+
+func goSnippet(call UpstreamCall, headers map[string]string) string {
+	imports := []string{"fmt", "io", "net/http"}
+	switch {
+	case call.FileName != "":
+		imports = append(imports, "bytes", "mime/multipart", "os")
+	case len(call.Body) > 0:
+		imports = append(imports, "strings")
+	}
+	sort.Strings(imports)
+
+	var b strings.Builder
+	b.WriteString("package main\n\nimport (\n")
+	for _, name := range imports {
+		fmt.Fprintf(&b, "\t%s\n", strconv.Quote(name))
+	}
+	b.WriteString(")\n\nfunc main() {\n")
+
+	bodyExpr := "nil"
+	switch {
+	case call.FileName != "":
+		b.WriteString("\tvar body bytes.Buffer\n\tform := multipart.NewWriter(&body)\n")
+		for _, name := range sortedKeys(call.FormFields) {
+			fmt.Fprintf(&b, "\tform.WriteField(%s, %s)\n", strconv.Quote(name), strconv.Quote(call.FormFields[name]))
+		}
+		fmt.Fprintf(&b, "\n\tfile, err := os.Open(%s)\n\tif err != nil {\n\t\tpanic(err)\n\t}\n\tdefer file.Close()\n", strconv.Quote(call.FileName))
+		fmt.Fprintf(&b, "\tpart, _ := form.CreateFormFile(\"file\", %s)\n\tio.Copy(part, file)\n\tform.Close()\n\n", strconv.Quote(call.FileName))
+		bodyExpr = "&body"
+	case len(call.Body) > 0:
+		fmt.Fprintf(&b, "\tbody := strings.NewReader(%s)\n", strconv.Quote(string(call.Body)))
+		bodyExpr = "body"
+	}
+
+	fmt.Fprintf(&b, "\treq, err := http.NewRequest(%s, %s, %s)\n", strconv.Quote(call.Verb), strconv.Quote(call.URL), bodyExpr)
+	b.WriteString("\tif err != nil {\n\t\tpanic(err)\n\t}\n")
+	if call.FileName != "" {
+		b.WriteString("\treq.Header.Set(\"Content-Type\", form.FormDataContentType())\n")
+	}
+	for _, name := range sortedKeys(headers) {
+		fmt.Fprintf(&b, "\treq.Header.Set(%s, %s)\n", strconv.Quote(name), strconv.Quote(headers[name]))
+	}
+
+	b.WriteString("\n\tresp, err := http.DefaultClient.Do(req)\n\tif err != nil {\n\t\tpanic(err)\n\t}\n\tdefer resp.Body.Close()\n\n")
+	b.WriteString("\tdata, _ := io.ReadAll(resp.Body)\n\tfmt.Println(resp.Status, string(data))\n}\n")
+	return b.String()
+}
+
+func pythonDict(values map[string]string) string {
+	pairs := make([]string, 0, len(values))
+	for _, name := range sortedKeys(values) {
+		pairs = append(pairs, fmt.Sprintf("%s: %s", strconv.Quote(name), strconv.Quote(values[name])))
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+func pythonSnippet(call UpstreamCall, headers map[string]string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "import requests\n\nresponse = requests.request(\n    %s,\n    %s,\n", strconv.Quote(call.Verb), strconv.Quote(call.URL))
+	if len(headers) > 0 {
+		fmt.Fprintf(&b, "    headers=%s,\n", pythonDict(headers))
+	}
+	if len(call.FormFields) > 0 {
+		fmt.Fprintf(&b, "    data=%s,\n", pythonDict(call.FormFields))
+	}
+	if call.FileName != "" {
+		fmt.Fprintf(&b, "    files={\"file\": open(%s, \"rb\")},\n", strconv.Quote(call.FileName))
+	}
+	if len(call.Body) > 0 {
+		fmt.Fprintf(&b, "    data=%s,\n", strconv.Quote(string(call.Body)))
+	}
+	b.WriteString(")\nprint(response.status_code, response.text)\n")
+	return b.String()
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Stats keeps in-memory counters for the dashboard. They reset on restart.
+// GREEN-API calls are counted per workspace of the instance they were
+// made for, so a workspace sees only its own.
+type Stats struct {
+	mu            sync.Mutex
+	startedAt     time.Time
+	endpoints     map[string]*endpointStats
+	workspaces    map[string]*workspaceStats
+	instances     map[string]*instanceErrors
+	activeStreams int
+	connsOpened   int
+	connsReused   int
+	// instanceWorkspace returns the workspace of an instance.
+	instanceWorkspace func(idInstance string) string
+}
+
+type endpointStats struct {
+	Requests int `json:"requests"`
+	Errors   int `json:"errors"`
+}
+
+// workspaceStats counts the GREEN-API calls of the instances of a
+// workspace.
+type workspaceStats struct {
+	methods       map[string]*methodStats
+	messagesDay   string
+	messagesToday int
+}
+
+type methodStats struct {
+	calls        int
+	successes    int
+	errors       int
+	totalLatency time.Duration
+	// latency counts calls by latencyBuckets, the last one for slower calls.
+	latency []int
+}
+
+// latencyBuckets are the upper bounds upstream latency is counted in.
+var latencyBuckets = []time.Duration{
+	50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second, 30 * time.Second, time.Minute,
+}
+
+// errorHistory is how far back per-instance error counts are kept by hour.
+const errorHistory = 24 * time.Hour
+
+// instanceErrors counts an instance's failed calls by errorClass, in total
+// and per hour.
+type instanceErrors struct {
+	totals map[string]int
+	hourly map[time.Time]map[string]int
+}
+
+// errorClass groups the upstream statuses worth watching per instance:
+// 401 for lost authorization, 429 and 466 for rate limits and quota, 5xx
+// for outages. Other statuses aren't tracked.
+func errorClass(status int) string {
+	switch {
+	case status == http.StatusUnauthorized, status == http.StatusTooManyRequests, status == 466:
+		return strconv.Itoa(status)
+	case status >= 500:
+		return "5xx"
+	}
+	return ""
+}
+
+type StatsResponse struct {
+	StartedAt     string                    `json:"startedAt"`
+	Uptime        string                    `json:"uptime"`
+	Endpoints     map[string]endpointStats  `json:"endpoints"`
+	Methods       map[string]MethodSnapshot `json:"methods"`
+	Instances     map[string]InstanceErrors `json:"instanceErrors"`
+	MessagesToday int                       `json:"messagesToday"`
+	ActiveStreams int                       `json:"activeStreams"`
+	Connections   ConnectionStats           `json:"upstreamConnections"`
+}
+
+// ConnectionStats counts the connections GREEN-API calls were sent on.
+// Many opened ones under load mean the idle pool is too small.
+type ConnectionStats struct {
+	Opened int `json:"opened"`
+	Reused int `json:"reused"`
+}
+
+type MethodSnapshot struct {
+	Calls          int     `json:"calls"`
+	Successes      int     `json:"successes"`
+	Errors         int     `json:"errors"`
+	SuccessRate    float64 `json:"successRate"`
+	AverageLatency string  `json:"averageLatency"`
+	// Latency counts calls per bucket, not cumulatively.
+	Latency      []LatencyBucket `json:"latency"`
+	TotalLatency time.Duration   `json:"-"`
+}
+
+// LatencyBucket counts calls that took longer than the previous bucket's
+// bound and at most LE, "+Inf" for the slowest.
+type LatencyBucket struct {
+	LE    string `json:"le"`
+	Count int    `json:"count"`
+}
+
+// InstanceErrors is how often an instance's calls failed, by errorClass.
+// Hourly covers the last 24 hours, oldest first, leaving out hours without
+// errors.
+type InstanceErrors struct {
+	Totals map[string]int `json:"totals"`
+	Hourly []HourlyErrors `json:"hourly"`
+}
+
+type HourlyErrors struct {
+	Hour   time.Time      `json:"hour"`
+	Counts map[string]int `json:"counts"`
+}
+
+func newStats() *Stats {
+	return &Stats{
+		startedAt:  time.Now(),
+		endpoints:  make(map[string]*endpointStats),
+		workspaces: make(map[string]*workspaceStats),
+		instances:  make(map[string]*instanceErrors),
+	}
+}
+
+func (s *Stats) recordRequest(route string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.endpoints[route]
+	if !ok {
+		e = &endpointStats{}
+		s.endpoints[route] = e
+	}
+	e.Requests++
+	if status >= 400 {
+		e.Errors++
+	}
+}
+
+func (s *Stats) recordUpstream(idInstance, method string, status int, err error, latency time.Duration) {
+	workspace := s.instanceWorkspace(idInstance)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if class := errorClass(status); class != "" && idInstance != "" {
+		s.recordInstanceError(idInstance, class, time.Now())
+	}
+
+	ws, ok := s.workspaces[workspace]
+	if !ok {
+		ws = &workspaceStats{methods: make(map[string]*methodStats)}
+		s.workspaces[workspace] = ws
+	}
+	m, ok := ws.methods[method]
+	if !ok {
+		m = &methodStats{latency: make([]int, len(latencyBuckets)+1)}
+		ws.methods[method] = m
+	}
+	m.calls++
+	m.totalLatency += latency
+	m.latency[latencyBucket(latency)]++
+	if err != nil || status >= 400 {
+		m.errors++
+		return
+	}
+	m.successes++
+
+	if method == "sendMessage" || method == "sendFileByUrl" || method == "sendFileByUpload" {
+		today := time.Now().Format(time.DateOnly)
+		if ws.messagesDay != today {
+			ws.messagesDay = today
+			ws.messagesToday = 0
+		}
+		ws.messagesToday++
+	}
+}
+
+// latencyBucket is the index of the latency bucket latency falls into.
+func latencyBucket(latency time.Duration) int {
+	for i, bound := range latencyBuckets {
+		if latency <= bound {
+			return i
+		}
+	}
+	return len(latencyBuckets)
+}
+
+// recordInstanceError counts a failed call, dropping hours older than
+// errorHistory. The caller holds mu.
+func (s *Stats) recordInstanceError(idInstance, class string, now time.Time) {
+	e, ok := s.instances[idInstance]
+	if !ok {
+		e = &instanceErrors{totals: make(map[string]int), hourly: make(map[time.Time]map[string]int)}
+		s.instances[idInstance] = e
+	}
+	e.totals[class]++
+
+	hour := now.Truncate(time.Hour)
+	if e.hourly[hour] == nil {
+		e.hourly[hour] = make(map[string]int)
+	}
+	e.hourly[hour][class]++
+	for start := range e.hourly {
+		if now.Sub(start) >= errorHistory+time.Hour {
+			delete(e.hourly, start)
+		}
+	}
+}
+
+// recordConn counts the connection an upstream call got.
+func (s *Stats) recordConn(reused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reused {
+		s.connsReused++
+	} else {
+		s.connsOpened++
+	}
+}
+
+func (s *Stats) streamOpened() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.activeStreams++
+}
+
+func (s *Stats) streamClosed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.activeStreams--
+}
+
+// snapshot returns the counters workspace may see: the GREEN-API calls and
+// instance errors of its instances. Request and connection counters cover
+// every workspace, so only callers outside one get them.
+func (s *Stats) snapshot(workspace string) StatsResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	response := StatsResponse{
+		StartedAt: s.startedAt.Format(time.RFC3339),
+		Uptime:    time.Since(s.startedAt).Round(time.Second).String(),
+		Endpoints: make(map[string]endpointStats),
+		Methods:   make(map[string]MethodSnapshot),
+		Instances: make(map[string]InstanceErrors),
+	}
+
+	if workspace == "" {
+		for route, e := range s.endpoints {
+			response.Endpoints[route] = *e
+		}
+		response.ActiveStreams = s.activeStreams
+		response.Connections = ConnectionStats{Opened: s.connsOpened, Reused: s.connsReused}
+	}
+
+	today := time.Now().Format(time.DateOnly)
+	for owner, ws := range s.workspaces {
+		if !canSee(workspace, owner) {
+			continue
+		}
+		for method, m := range ws.methods {
+			snapshot, ok := response.Methods[method]
+			if !ok {
+				snapshot.Latency = make([]LatencyBucket, len(m.latency))
+				for i := range snapshot.Latency {
+					snapshot.Latency[i].LE = "+Inf"
+					if i < len(latencyBuckets) {
+						snapshot.Latency[i].LE = latencyBuckets[i].String()
+					}
+				}
+			}
+			snapshot.Calls += m.calls
+			snapshot.Successes += m.successes
+			snapshot.Errors += m.errors
+			snapshot.TotalLatency += m.totalLatency
+			for i, count := range m.latency {
+				snapshot.Latency[i].Count += count
+			}
+			response.Methods[method] = snapshot
+		}
+		if ws.messagesDay == today {
+			response.MessagesToday += ws.messagesToday
+		}
+	}
+	for method, snapshot := range response.Methods {
+		if snapshot.Calls > 0 {
+			snapshot.SuccessRate = float64(snapshot.Successes) / float64(snapshot.Calls)
+			snapshot.AverageLatency = (snapshot.TotalLatency / time.Duration(snapshot.Calls)).Round(time.Millisecond).String()
+		}
+		response.Methods[method] = snapshot
+	}
+
+	now := time.Now()
+	for idInstance, e := range s.instances {
+		if !canSee(workspace, s.instanceWorkspace(idInstance)) {
+			continue
+		}
+		snapshot := InstanceErrors{Totals: maps.Clone(e.totals), Hourly: []HourlyErrors{}}
+		for hour, counts := range e.hourly {
+			if now.Sub(hour) < errorHistory+time.Hour {
+				snapshot.Hourly = append(snapshot.Hourly, HourlyErrors{Hour: hour, Counts: maps.Clone(counts)})
+			}
+		}
+		sort.Slice(snapshot.Hourly, func(i, j int) bool { return snapshot.Hourly[i].Hour.Before(snapshot.Hourly[j].Hour) })
+		response.Instances[idInstance] = snapshot
+	}
+
+	return response
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (a *App) withStats(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		a.stats.recordRequest(route, rec.status)
+	}
+}
+
+func (a *App) statsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.stats.snapshot(workspaceOf(r)))
+}
+
+func (a *App) statsPageHandler(w http.ResponseWriter, r *http.Request) {
+	a.renderPage(w, r, http.StatusOK, "stats.html", nil)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openStorage moves the stores of history, sessions, scheduled sends, saved
+// attachments, managed instances, settings presets, desired settings, inbox
+// conversations, chat labels and notes, canned replies, the message search
+// index, the trash and the keys of seen webhooks and signatures to the
+// database of -storage: sqlite:path is a SQLite file and a postgres:// URL a
+// Postgres database several servers can share. With memory they stay in the
+// process as assembleApp built them.
+func (a *App) openStorage(spec string) error {
+	var dialect sqlDialect
+	var dsn string
+	switch {
+	case spec == "" || spec == "memory":
+		return nil
+	case strings.HasPrefix(spec, "sqlite:"):
+		path := strings.TrimPrefix(spec, "sqlite:")
+		if path == "" {
+			return fmt.Errorf("invalid -storage %q, expected sqlite:path", spec)
+		}
+		dialect, dsn = sqliteDialect, "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL"
+	case strings.HasPrefix(spec, "postgres://"), strings.HasPrefix(spec, "postgresql://"):
+		dialect, dsn = postgresDialect, spec
+	default:
+		return fmt.Errorf("invalid -storage %q, expected memory, sqlite:path or a postgres:// URL", spec)
+	}
+
+	db, err := openSQL(dialect, dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open %s storage: %w", dialect.name, err)
+	}
+	a.db = db
+	a.history = &sqlHistory{db: db, limit: a.config.HistorySize}
+	a.sessions = &sqlSessions{db: db}
+	a.scheduler.store = &sqlSchedule{db: db}
+	a.mediaDownloader.store = &sqlAttachments{db: db}
+	a.instanceProfiles.store = &sqlInstances{db: db}
+	a.presets = &sqlPresets{db: db}
+	a.drift.store = &sqlDesiredSettings{db: db}
+	a.inbox.store = &sqlConversations{db: db}
+	a.labeler.store = &sqlLabels{db: db}
+	a.chatNotes.store = &sqlChatNotes{db: db}
+	a.messageIndex = &sqlMessageIndex{db: db, instanceWorkspace: a.instanceWorkspace}
+	a.cannedReplies = &sqlCannedReplies{db: db}
+	a.trash = &sqlTrash{db: db}
+	a.seenNotifications.store = &sqlSeen{db: db, kind: "notification"}
+	a.seenSignatures.store = &sqlSeen{db: db, kind: "signature"}
+	return nil
+}
+
+// closeStorage closes the storage database, if there is one.
+func (a *App) closeStorage() error {
+	if a.db == nil {
+		return nil
+	}
+	return a.db.db.Close()
+}
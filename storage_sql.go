@@ -0,0 +1,1096 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqlDialect covers the differences between the supported databases.
+type sqlDialect struct {
+	name   string
+	driver string
+	// dir holds the dialect's migrations.
+	dir string
+	// numbered placeholders ($1, $2) instead of ?.
+	numbered bool
+}
+
+var (
+	sqliteDialect   = sqlDialect{name: "SQLite", driver: "sqlite3-grapi", dir: "sqlite"}
+	postgresDialect = sqlDialect{name: "Postgres", driver: "pgx", dir: "postgres", numbered: true}
+)
+
+// sqlite3-grapi is go-sqlite3 with the functions our queries call.
+func init() {
+	sql.Register("sqlite3-grapi", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("fts_rank", ftsRank, true)
+		},
+	})
+}
+
+// sqlDB is a storage database. Queries are written with ? placeholders and
+// rewritten for the dialect. Times are stored as Unix nanoseconds.
+type sqlDB struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func openSQL(dialect sqlDialect, dsn string) (*sqlDB, error) {
+	db, err := sql.Open(dialect.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == sqliteDialect {
+		// SQLite allows one writer at a time
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &sqlDB{db: db, dialect: dialect}
+	if err := s.migrate(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// query rewrites the ? placeholders of a query for the dialect.
+func (s *sqlDB) query(query string) string {
+	if !s.dialect.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// sqlRunner is a database or a transaction.
+type sqlRunner interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// sqlHistory keeps the last limit upstream calls in the history table.
+type sqlHistory struct {
+	db    *sqlDB
+	limit int
+}
+
+const historyColumns = "id, time, workspace, method, http_method, url, status, duration, error, " +
+	"request, request_size, request_truncated, response, response_size, response_truncated"
+
+func (h *sqlHistory) insert(run sqlRunner, entry HistoryEntry) error {
+	_, err := run.Exec(h.db.query(`INSERT INTO history (`+strings.TrimPrefix(historyColumns, "id, ")+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		entry.Time.UnixNano(), entry.Workspace, entry.Method, entry.HTTPMethod, entry.URL,
+		entry.Status, entry.Duration, entry.Error,
+		string(entry.Request), entry.RequestSize, entry.RequestTruncated,
+		string(entry.Response), entry.ResponseSize, entry.ResponseTruncated)
+	return err
+}
+
+// trim drops entries beyond the newest limit.
+func (h *sqlHistory) trim(run sqlRunner) error {
+	_, err := run.Exec(h.db.query(`DELETE FROM history WHERE id <= (SELECT id FROM history ORDER BY id DESC LIMIT 1 OFFSET ?)`), max(h.limit, 1))
+	return err
+}
+
+func (h *sqlHistory) add(entry HistoryEntry) error {
+	if err := h.insert(h.db.db, entry); err != nil {
+		return err
+	}
+	return h.trim(h.db.db)
+}
+
+func scanHistoryEntry(row interface{ Scan(...interface{}) error }) (HistoryEntry, error) {
+	var entry HistoryEntry
+	var nanos int64
+	var request, response string
+	err := row.Scan(&entry.ID, &nanos, &entry.Workspace, &entry.Method, &entry.HTTPMethod, &entry.URL,
+		&entry.Status, &entry.Duration, &entry.Error,
+		&request, &entry.RequestSize, &entry.RequestTruncated,
+		&response, &entry.ResponseSize, &entry.ResponseTruncated)
+	entry.Time = time.Unix(0, nanos)
+	if request != "" {
+		entry.Request = json.RawMessage(request)
+	}
+	if response != "" {
+		entry.Response = json.RawMessage(response)
+	}
+	return entry, err
+}
+
+func (h *sqlHistory) get(id int64) (HistoryEntry, bool, error) {
+	row := h.db.db.QueryRow(h.db.query(`SELECT `+historyColumns+` FROM history WHERE id = ?`), id)
+	entry, err := scanHistoryEntry(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return HistoryEntry{}, false, nil
+	}
+	return entry, err == nil, err
+}
+
+func (h *sqlHistory) list(workspace string, limit int) ([]HistoryEntry, error) {
+	query := `SELECT ` + historyColumns + ` FROM history`
+	var args []interface{}
+	if workspace != "" {
+		query += ` WHERE workspace = ?`
+		args = append(args, workspace)
+	}
+	query += ` ORDER BY id DESC`
+	if limit > 0 {
+		query += ` LIMIT ` + strconv.Itoa(limit)
+	}
+
+	rows, err := h.db.db.Query(h.db.query(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []HistoryEntry{}
+	for rows.Next() {
+		entry, err := scanHistoryEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (h *sqlHistory) restore(workspace string, entries []HistoryEntry, replace bool) (int, error) {
+	tx, err := h.db.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if replace {
+		query, args := `DELETE FROM history`, []interface{}{}
+		if workspace != "" {
+			query, args = query+` WHERE workspace = ?`, append(args, workspace)
+		}
+		if _, err := tx.Exec(h.db.query(query), args...); err != nil {
+			return 0, err
+		}
+	}
+	for _, entry := range entries {
+		if err := h.insert(tx, importedEntry(workspace, entry)); err != nil {
+			return 0, err
+		}
+	}
+	if err := h.trim(tx); err != nil {
+		return 0, err
+	}
+	return len(entries), tx.Commit()
+}
+
+func (h *sqlHistory) prune(before time.Time, keep int) (int, error) {
+	pruned := 0
+	if !before.IsZero() {
+		result, err := h.db.db.Exec(h.db.query(`DELETE FROM history WHERE time < ?`), before.UnixNano())
+		if err != nil {
+			return 0, err
+		}
+		n, _ := result.RowsAffected()
+		pruned += int(n)
+	}
+	if keep > 0 {
+		result, err := h.db.db.Exec(h.db.query(`DELETE FROM history WHERE id <= (SELECT id FROM history ORDER BY id DESC LIMIT 1 OFFSET ?)`), keep)
+		if err != nil {
+			return pruned, err
+		}
+		n, _ := result.RowsAffected()
+		pruned += int(n)
+	}
+	return pruned, nil
+}
+
+func (h *sqlHistory) delete(id int64) (bool, error) {
+	result, err := h.db.db.Exec(h.db.query(`DELETE FROM history WHERE id = ?`), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// sqlSessions keeps sessions in the sessions table, under a hash of the
+// token so a leaked database doesn't sign anyone in.
+type sqlSessions struct {
+	db *sqlDB
+}
+
+func sessionTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *sqlSessions) create(principal Principal, ttl time.Duration) (string, Session, error) {
+	token := newSessionToken()
+	now := time.Now()
+	session := Session{Principal: principal, CreatedAt: now, ExpiresAt: now.Add(ttl)}
+
+	_, err := s.db.db.Exec(s.db.query(`INSERT INTO sessions (token_hash, name, role, workspace, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`),
+		sessionTokenHash(token), principal.Name, principal.Role.String(), principal.Workspace,
+		session.CreatedAt.UnixNano(), session.ExpiresAt.UnixNano())
+	if err != nil {
+		return "", Session{}, err
+	}
+	return token, session, nil
+}
+
+func (s *sqlSessions) get(token string) (Session, bool, error) {
+	var session Session
+	var role string
+	var created, expires int64
+	err := s.db.db.QueryRow(s.db.query(`SELECT name, role, workspace, created_at, expires_at FROM sessions
+		WHERE token_hash = ? AND expires_at > ?`), sessionTokenHash(token), time.Now().UnixNano()).
+		Scan(&session.Principal.Name, &role, &session.Principal.Workspace, &created, &expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, false, nil
+	}
+	if err != nil {
+		return Session{}, false, err
+	}
+	// Sessions of a role that no longer exists are dropped
+	if session.Principal.Role, err = parseRole(role); err != nil {
+		return Session{}, false, nil
+	}
+	session.CreatedAt = time.Unix(0, created)
+	session.ExpiresAt = time.Unix(0, expires)
+	return session, true, nil
+}
+
+func (s *sqlSessions) delete(token string) error {
+	_, err := s.db.db.Exec(s.db.query(`DELETE FROM sessions WHERE token_hash = ?`), sessionTokenHash(token))
+	return err
+}
+
+func (s *sqlSessions) removeExpired() error {
+	_, err := s.db.db.Exec(s.db.query(`DELETE FROM sessions WHERE expires_at <= ?`), time.Now().UnixNano())
+	return err
+}
+
+// sqlSeen keeps the keys of a SeenSet in the seen_keys table, under kind so
+// several sets can share it.
+type sqlSeen struct {
+	db   *sqlDB
+	kind string
+}
+
+// add inserts the key, or takes over an expired row of it, in one
+// statement, so of servers sharing a database only one sees a key first.
+func (s *sqlSeen) add(key string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	result, err := s.db.db.Exec(s.db.query(`INSERT INTO seen_keys (kind, seen_key, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (kind, seen_key) DO UPDATE SET expires_at = excluded.expires_at WHERE seen_keys.expires_at <= ?`),
+		s.kind, key, now.Add(ttl).UnixNano(), now.UnixNano())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (s *sqlSeen) removeExpired() error {
+	_, err := s.db.db.Exec(s.db.query(`DELETE FROM seen_keys WHERE kind = ? AND expires_at <= ?`), s.kind, time.Now().UnixNano())
+	return err
+}
+
+// sqlSchedule keeps scheduled sends in the scheduled_sends table. Status and
+// due time have their own columns for the scheduler's queries; URL and
+// content, which the API never shows, too. The rest is stored as JSON.
+type sqlSchedule struct {
+	db *sqlDB
+}
+
+const scheduledColumns = "id, status, send_at, url, content, data"
+
+// finishedSends matches the states a send ends in.
+const finishedSends = `status NOT IN ('` + scheduledPending + `', '` + scheduledSending + `')`
+
+func scanScheduledSend(row interface{ Scan(...interface{}) error }) (ScheduledSend, error) {
+	var send ScheduledSend
+	var id, sendAt int64
+	var status, sendURL, content, data string
+	if err := row.Scan(&id, &status, &sendAt, &sendURL, &content, &data); err != nil {
+		return ScheduledSend{}, err
+	}
+	if err := json.Unmarshal([]byte(data), &send); err != nil {
+		return ScheduledSend{}, err
+	}
+	send.ID = id
+	send.Status = status
+	send.SendAt = time.Unix(0, sendAt)
+	send.URL = sendURL
+	send.Content = content
+	return send, nil
+}
+
+func (s *sqlSchedule) add(send ScheduledSend) (ScheduledSend, error) {
+	send.CreatedAt = time.Now()
+	send.Status = scheduledPending
+	data, err := json.Marshal(send)
+	if err != nil {
+		return ScheduledSend{}, err
+	}
+
+	err = s.db.db.QueryRow(s.db.query(`INSERT INTO scheduled_sends (status, send_at, url, content, data)
+		VALUES (?, ?, ?, ?, ?) RETURNING id`),
+		send.Status, send.SendAt.UnixNano(), send.URL, send.Content, string(data)).Scan(&send.ID)
+	if err != nil {
+		return ScheduledSend{}, err
+	}
+	return send, nil
+}
+
+func (s *sqlSchedule) get(id int64) (ScheduledSend, bool, error) {
+	row := s.db.db.QueryRow(s.db.query(`SELECT `+scheduledColumns+` FROM scheduled_sends WHERE id = ?`), id)
+	send, err := scanScheduledSend(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ScheduledSend{}, false, nil
+	}
+	return send, err == nil, err
+}
+
+func (s *sqlSchedule) selectSends(query string, args ...interface{}) ([]ScheduledSend, error) {
+	rows, err := s.db.db.Query(s.db.query(`SELECT `+scheduledColumns+` FROM scheduled_sends`+query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sends []ScheduledSend
+	for rows.Next() {
+		send, err := scanScheduledSend(rows)
+		if err != nil {
+			return nil, err
+		}
+		sends = append(sends, send)
+	}
+	return sends, rows.Err()
+}
+
+func (s *sqlSchedule) due(now time.Time) ([]ScheduledSend, error) {
+	return s.selectSends(` WHERE status = ? AND send_at <= ? ORDER BY id`, scheduledPending, now.UnixNano())
+}
+
+func (s *sqlSchedule) nextDue() (time.Time, bool, error) {
+	var next sql.NullInt64
+	err := s.db.db.QueryRow(s.db.query(`SELECT MIN(send_at) FROM scheduled_sends WHERE status = ?`), scheduledPending).Scan(&next)
+	if err != nil || !next.Valid {
+		return time.Time{}, false, err
+	}
+	return time.Unix(0, next.Int64), true, nil
+}
+
+func (s *sqlSchedule) claim(id int64) (bool, error) {
+	result, err := s.db.db.Exec(s.db.query(`UPDATE scheduled_sends SET status = ? WHERE id = ? AND status = ?`),
+		scheduledSending, id, scheduledPending)
+	if err != nil {
+		return false, err
+	}
+	claimed, err := result.RowsAffected()
+	return claimed == 1, err
+}
+
+// pruneFinished drops the oldest finished sends beyond maxFinishedSends.
+func (s *sqlSchedule) pruneFinished() error {
+	_, err := s.db.db.Exec(s.db.query(`DELETE FROM scheduled_sends WHERE `+finishedSends+` AND id <= (
+		SELECT id FROM scheduled_sends WHERE `+finishedSends+` ORDER BY id DESC LIMIT 1 OFFSET ?)`), maxFinishedSends)
+	return err
+}
+
+func (s *sqlSchedule) finish(send ScheduledSend) error {
+	data, err := json.Marshal(send)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.db.Exec(s.db.query(`UPDATE scheduled_sends SET status = ?, send_at = ?, data = ? WHERE id = ?`),
+		send.Status, send.SendAt.UnixNano(), string(data), send.ID)
+	if err != nil {
+		return err
+	}
+	return s.pruneFinished()
+}
+
+func (s *sqlSchedule) cancel(id int64) (ScheduledSend, bool, error) {
+	result, err := s.db.db.Exec(s.db.query(`UPDATE scheduled_sends SET status = ? WHERE id = ? AND status = ?`),
+		scheduledCancelled, id, scheduledPending)
+	if err != nil {
+		return ScheduledSend{}, false, err
+	}
+	if cancelled, err := result.RowsAffected(); err != nil || cancelled != 1 {
+		return ScheduledSend{}, false, err
+	}
+
+	send, ok, err := s.get(id)
+	if err != nil || !ok {
+		return ScheduledSend{}, false, err
+	}
+	return send, true, s.pruneFinished()
+}
+
+func (s *sqlSchedule) list() ([]ScheduledSend, error) {
+	return s.selectSends(``)
+}
+
+func (s *sqlSchedule) pending() (int, error) {
+	var count int
+	err := s.db.db.QueryRow(s.db.query(`SELECT COUNT(*) FROM scheduled_sends WHERE status = ?`), scheduledPending).Scan(&count)
+	return count, err
+}
+
+// sqlAttachments keeps the metadata of saved files in the attachments
+// table. The instance, message ID and path have columns, the rest is stored
+// as JSON.
+type sqlAttachments struct {
+	db *sqlDB
+}
+
+func scanAttachment(row interface{ Scan(...interface{}) error }) (Attachment, error) {
+	var attachment Attachment
+	var path, data string
+	if err := row.Scan(&path, &data); err != nil {
+		return Attachment{}, err
+	}
+	if err := json.Unmarshal([]byte(data), &attachment); err != nil {
+		return Attachment{}, err
+	}
+	attachment.Path = path
+	return attachment, nil
+}
+
+func (s *sqlAttachments) add(attachment Attachment) error {
+	data, err := json.Marshal(attachment)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.db.Exec(s.db.query(`INSERT INTO attachments (id_instance, id_message, downloaded_at, path, data) VALUES (?, ?, ?, ?, ?)`),
+		attachment.IDInstance, attachment.IDMessage, attachment.DownloadedAt.UnixNano(), attachment.Path, string(data))
+	return err
+}
+
+func (s *sqlAttachments) get(idInstance, idMessage string) (Attachment, bool, error) {
+	row := s.db.db.QueryRow(s.db.query(`SELECT path, data FROM attachments WHERE id_instance = ? AND id_message = ?`), idInstance, idMessage)
+	attachment, err := scanAttachment(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Attachment{}, false, nil
+	}
+	return attachment, err == nil, err
+}
+
+func (s *sqlAttachments) list() ([]Attachment, error) {
+	rows, err := s.db.db.Query(`SELECT path, data FROM attachments ORDER BY downloaded_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Attachment
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, attachment)
+	}
+	return list, rows.Err()
+}
+
+// sqlInstances keeps managed instances in the managed_instances table as
+// JSON.
+type sqlInstances struct {
+	db *sqlDB
+}
+
+func (s *sqlInstances) list() ([]ManagedInstance, error) {
+	rows, err := s.db.db.Query(`SELECT data FROM managed_instances ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []ManagedInstance
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var instance ManagedInstance
+		if err := json.Unmarshal([]byte(data), &instance); err != nil {
+			return nil, err
+		}
+		list = append(list, instance)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlInstances) put(instance ManagedInstance) error {
+	data, err := json.Marshal(instance)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.db.Exec(s.db.query(`INSERT INTO managed_instances (id_instance, created_at, data) VALUES (?, ?, ?)
+		ON CONFLICT (id_instance) DO UPDATE SET data = excluded.data`),
+		instance.IDInstance, instance.CreatedAt.UnixNano(), string(data))
+	return err
+}
+
+func (s *sqlInstances) delete(idInstance string) (bool, error) {
+	result, err := s.db.db.Exec(s.db.query(`DELETE FROM managed_instances WHERE id_instance = ?`), idInstance)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// sqlPresets keeps settings presets in the settings_presets table as JSON,
+// by workspace and name.
+type sqlPresets struct {
+	db *sqlDB
+}
+
+func scanPreset(row interface{ Scan(...interface{}) error }) (SettingsPreset, error) {
+	var data string
+	if err := row.Scan(&data); err != nil {
+		return SettingsPreset{}, err
+	}
+	var preset SettingsPreset
+	err := json.Unmarshal([]byte(data), &preset)
+	return preset, err
+}
+
+func (s *sqlPresets) list() ([]SettingsPreset, error) {
+	rows, err := s.db.db.Query(`SELECT data FROM settings_presets ORDER BY name, workspace`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []SettingsPreset
+	for rows.Next() {
+		preset, err := scanPreset(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, preset)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlPresets) get(workspace, name string) (SettingsPreset, bool, error) {
+	preset, err := scanPreset(s.db.db.QueryRow(s.db.query(`SELECT data FROM settings_presets WHERE workspace = ? AND name = ?`), workspace, name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return SettingsPreset{}, false, nil
+	}
+	return preset, err == nil, err
+}
+
+func (s *sqlPresets) put(preset SettingsPreset) error {
+	data, err := json.Marshal(preset)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.db.Exec(s.db.query(`INSERT INTO settings_presets (workspace, name, data) VALUES (?, ?, ?)
+		ON CONFLICT (workspace, name) DO UPDATE SET data = excluded.data`), preset.Workspace, preset.Name, string(data))
+	return err
+}
+
+func (s *sqlPresets) delete(workspace, name string) (bool, error) {
+	result, err := s.db.db.Exec(s.db.query(`DELETE FROM settings_presets WHERE workspace = ? AND name = ?`), workspace, name)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// sqlDesiredSettings keeps desired settings in the desired_settings table
+// as JSON.
+type sqlDesiredSettings struct {
+	db *sqlDB
+}
+
+func (s *sqlDesiredSettings) list() ([]DesiredSettings, error) {
+	rows, err := s.db.db.Query(`SELECT data FROM desired_settings ORDER BY id_instance`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []DesiredSettings
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var desired DesiredSettings
+		if err := json.Unmarshal([]byte(data), &desired); err != nil {
+			return nil, err
+		}
+		list = append(list, desired)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlDesiredSettings) get(idInstance string) (DesiredSettings, bool, error) {
+	var data string
+	err := s.db.db.QueryRow(s.db.query(`SELECT data FROM desired_settings WHERE id_instance = ?`), idInstance).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DesiredSettings{}, false, nil
+	}
+	if err != nil {
+		return DesiredSettings{}, false, err
+	}
+	var desired DesiredSettings
+	err = json.Unmarshal([]byte(data), &desired)
+	return desired, err == nil, err
+}
+
+func (s *sqlDesiredSettings) put(desired DesiredSettings) error {
+	data, err := json.Marshal(desired)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.db.Exec(s.db.query(`INSERT INTO desired_settings (id_instance, data) VALUES (?, ?)
+		ON CONFLICT (id_instance) DO UPDATE SET data = excluded.data`), desired.IDInstance, string(data))
+	return err
+}
+
+func (s *sqlDesiredSettings) delete(idInstance string) (bool, error) {
+	result, err := s.db.db.Exec(s.db.query(`DELETE FROM desired_settings WHERE id_instance = ?`), idInstance)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// sqlTrash keeps deleted items in the trash table. The item is stored as
+// JSON, with columns for what it is looked up by.
+type sqlTrash struct {
+	db *sqlDB
+}
+
+func scanTrashItem(row interface{ Scan(...interface{}) error }) (TrashItem, error) {
+	var id int64
+	var data string
+	if err := row.Scan(&id, &data); err != nil {
+		return TrashItem{}, err
+	}
+	var item TrashItem
+	err := json.Unmarshal([]byte(data), &item)
+	item.ID = id
+	return item, err
+}
+
+func (t *sqlTrash) add(item TrashItem) (TrashItem, error) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return TrashItem{}, err
+	}
+	err = t.db.db.QueryRow(t.db.query(`INSERT INTO trash (workspace, deleted_at, data) VALUES (?, ?, ?) RETURNING id`),
+		item.Workspace, item.DeletedAt.UnixNano(), string(data)).Scan(&item.ID)
+	return item, err
+}
+
+func (t *sqlTrash) get(id int64) (TrashItem, bool, error) {
+	item, err := scanTrashItem(t.db.db.QueryRow(t.db.query(`SELECT id, data FROM trash WHERE id = ?`), id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return TrashItem{}, false, nil
+	}
+	return item, err == nil, err
+}
+
+func (t *sqlTrash) list(workspace string) ([]TrashItem, error) {
+	query := `SELECT id, data FROM trash`
+	var args []interface{}
+	if workspace != "" {
+		query += ` WHERE workspace = ?`
+		args = append(args, workspace)
+	}
+	rows, err := t.db.db.Query(t.db.query(query+` ORDER BY id DESC`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []TrashItem{}
+	for rows.Next() {
+		item, err := scanTrashItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+	return list, rows.Err()
+}
+
+func (t *sqlTrash) delete(id int64) (bool, error) {
+	result, err := t.db.db.Exec(t.db.query(`DELETE FROM trash WHERE id = ?`), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (t *sqlTrash) prune(before time.Time) (int, error) {
+	result, err := t.db.db.Exec(t.db.query(`DELETE FROM trash WHERE deleted_at < ?`), before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// sqlConversations keeps inbox conversations in the conversations table as
+// JSON.
+type sqlConversations struct {
+	db *sqlDB
+}
+
+func scanConversation(row interface{ Scan(...interface{}) error }) (Conversation, error) {
+	var data string
+	if err := row.Scan(&data); err != nil {
+		return Conversation{}, err
+	}
+	var conversation Conversation
+	err := json.Unmarshal([]byte(data), &conversation)
+	return conversation, err
+}
+
+func (s *sqlConversations) get(idInstance, chatId string) (Conversation, bool, error) {
+	conversation, err := scanConversation(s.db.db.QueryRow(s.db.query(`SELECT data FROM conversations WHERE id_instance = ? AND chat_id = ?`), idInstance, chatId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Conversation{}, false, nil
+	}
+	return conversation, err == nil, err
+}
+
+func (s *sqlConversations) put(conversation Conversation) error {
+	data, err := json.Marshal(conversation)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.db.Exec(s.db.query(`INSERT INTO conversations (id_instance, chat_id, data) VALUES (?, ?, ?)
+		ON CONFLICT (id_instance, chat_id) DO UPDATE SET data = excluded.data`), conversation.IDInstance, conversation.ChatID, string(data))
+	return err
+}
+
+func (s *sqlConversations) list() ([]Conversation, error) {
+	rows, err := s.db.db.Query(`SELECT data FROM conversations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Conversation
+	for rows.Next() {
+		conversation, err := scanConversation(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, conversation)
+	}
+	return list, rows.Err()
+}
+
+// sqlLabels keeps the labels of chats in the chat_labels table as JSON.
+type sqlLabels struct {
+	db *sqlDB
+}
+
+func scanChatLabels(row interface{ Scan(...interface{}) error }) (ChatLabels, error) {
+	var data string
+	if err := row.Scan(&data); err != nil {
+		return ChatLabels{}, err
+	}
+	var labels ChatLabels
+	err := json.Unmarshal([]byte(data), &labels)
+	return labels, err
+}
+
+func (s *sqlLabels) get(idInstance, chatId string) (ChatLabels, bool, error) {
+	labels, err := scanChatLabels(s.db.db.QueryRow(s.db.query(`SELECT data FROM chat_labels WHERE id_instance = ? AND chat_id = ?`), idInstance, chatId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ChatLabels{}, false, nil
+	}
+	return labels, err == nil, err
+}
+
+func (s *sqlLabels) put(labels ChatLabels) error {
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.db.Exec(s.db.query(`INSERT INTO chat_labels (id_instance, chat_id, data) VALUES (?, ?, ?)
+		ON CONFLICT (id_instance, chat_id) DO UPDATE SET data = excluded.data`), labels.IDInstance, labels.ChatID, string(data))
+	return err
+}
+
+func (s *sqlLabels) delete(idInstance, chatId string) error {
+	_, err := s.db.db.Exec(s.db.query(`DELETE FROM chat_labels WHERE id_instance = ? AND chat_id = ?`), idInstance, chatId)
+	return err
+}
+
+func (s *sqlLabels) list() ([]ChatLabels, error) {
+	rows, err := s.db.db.Query(`SELECT data FROM chat_labels`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []ChatLabels
+	for rows.Next() {
+		labels, err := scanChatLabels(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, labels)
+	}
+	return list, rows.Err()
+}
+
+// sqlChatNotes keeps the notes of chats in the chat_notes table as JSON.
+type sqlChatNotes struct {
+	db *sqlDB
+}
+
+func (s *sqlChatNotes) get(idInstance, chatId string) (ChatNotes, bool, error) {
+	var data string
+	err := s.db.db.QueryRow(s.db.query(`SELECT data FROM chat_notes WHERE id_instance = ? AND chat_id = ?`), idInstance, chatId).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ChatNotes{}, false, nil
+	}
+	if err != nil {
+		return ChatNotes{}, false, err
+	}
+	var notes ChatNotes
+	err = json.Unmarshal([]byte(data), &notes)
+	return notes, err == nil, err
+}
+
+func (s *sqlChatNotes) put(notes ChatNotes) error {
+	data, err := json.Marshal(notes)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.db.Exec(s.db.query(`INSERT INTO chat_notes (id_instance, chat_id, data) VALUES (?, ?, ?)
+		ON CONFLICT (id_instance, chat_id) DO UPDATE SET data = excluded.data`), notes.IDInstance, notes.ChatID, string(data))
+	return err
+}
+
+func (s *sqlChatNotes) delete(idInstance, chatId string) error {
+	_, err := s.db.db.Exec(s.db.query(`DELETE FROM chat_notes WHERE id_instance = ? AND chat_id = ?`), idInstance, chatId)
+	return err
+}
+
+// sqlMessageIndex keeps messages in the messages table, with an FTS4 index
+// of their text in SQLite and a tsvector one in Postgres.
+type sqlMessageIndex struct {
+	db *sqlDB
+	// instanceWorkspace returns the workspace of an instance.
+	instanceWorkspace func(idInstance string) string
+}
+
+func (m *sqlMessageIndex) add(notification Notification) error {
+	_, err := m.db.db.Exec(m.db.query(`INSERT INTO messages (time, workspace, id_instance, chat_id, id_message, type_webhook, text)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`),
+		notification.ReceivedAt.UnixNano(), m.instanceWorkspace(strconv.FormatInt(notification.IDInstance, 10)), strconv.FormatInt(notification.IDInstance, 10),
+		notification.ChatID, notification.IDMessage, notification.TypeWebhook, notification.Text)
+	return err
+}
+
+func (m *sqlMessageIndex) search(query SearchQuery) ([]SearchHit, int, error) {
+	// match, snippet and rank are the dialect's FTS; the filters are shared
+	var match, from, snippet, rank string
+	var terms interface{}
+	if m.db.dialect == postgresDialect {
+		var words []string
+		for _, term := range query.Terms {
+			word := strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(strings.TrimSuffix(term, "*"))
+			if strings.HasSuffix(term, "*") {
+				words = append(words, "'"+word+"':*")
+			} else {
+				words = append(words, "'"+word+"'")
+			}
+		}
+		terms = strings.Join(words, " & ")
+		from = `messages m, to_tsquery('simple', ?) query`
+		match = `m.search @@ query`
+		snippet = `ts_headline('simple', m.text, query, 'StartSel=` + indexStart + `, StopSel=` + indexEnd + `, MaxWords=20, MinWords=8')`
+		rank = `ts_rank(m.search, query)`
+	} else {
+		words := make([]string, len(query.Terms))
+		for i, term := range query.Terms {
+			words[i] = `"` + term + `"`
+		}
+		terms = strings.Join(words, " ")
+		from = `messages_fts JOIN messages m ON m.id = messages_fts.docid`
+		match = `messages_fts MATCH ?`
+		snippet = `snippet(messages_fts, '` + indexStart + `', '` + indexEnd + `', '…', -1, 20)`
+		rank = `fts_rank(matchinfo(messages_fts, 'pcx'))`
+	}
+
+	where := ` WHERE ` + match
+	args := []interface{}{terms}
+	for _, filter := range [][2]string{{"workspace", query.Workspace}, {"id_instance", query.IDInstance}, {"chat_id", query.ChatID}} {
+		if filter[1] != "" {
+			where += ` AND m.` + filter[0] + ` = ?`
+			args = append(args, filter[1])
+		}
+	}
+
+	var total int
+	if err := m.db.db.QueryRow(m.db.query(`SELECT COUNT(*) FROM `+from+where), args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := m.db.db.Query(m.db.query(`SELECT m.id_instance, m.chat_id, m.id_message, m.type_webhook, m.time, `+snippet+`, `+rank+`
+		FROM `+from+where+` ORDER BY 7 DESC, m.time DESC LIMIT ? OFFSET ?`), append(args, query.Limit, query.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	hits := []SearchHit{}
+	for rows.Next() {
+		var hit SearchHit
+		var nanos int64
+		if err := rows.Scan(&hit.IDInstance, &hit.ChatID, &hit.IDMessage, &hit.TypeWebhook, &nanos, &hit.Snippet, &hit.Rank); err != nil {
+			return nil, 0, err
+		}
+		hit.Time = time.Unix(0, nanos)
+		hit.Snippet = markSnippet(hit.Snippet)
+		hits = append(hits, hit)
+	}
+	return hits, total, rows.Err()
+}
+
+func (m *sqlMessageIndex) volume(query VolumeQuery) ([]VolumeCount, error) {
+	bucket := query.Bucket.Nanoseconds()
+	sqlQuery := `SELECT id_instance, type_webhook, time / ?, COUNT(*) FROM messages WHERE time >= ? AND time < ?`
+	args := []interface{}{bucket, query.From.UnixNano(), query.To.UnixNano()}
+	for _, filter := range [][2]string{{"workspace", query.Workspace}, {"id_instance", query.IDInstance}} {
+		if filter[1] != "" {
+			sqlQuery += ` AND ` + filter[0] + ` = ?`
+			args = append(args, filter[1])
+		}
+	}
+	rows, err := m.db.db.Query(m.db.query(sqlQuery+` GROUP BY 1, 2, 3`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []VolumeCount
+	for rows.Next() {
+		var count VolumeCount
+		var n int64
+		if err := rows.Scan(&count.IDInstance, &count.TypeWebhook, &n, &count.Count); err != nil {
+			return nil, err
+		}
+		count.Bucket = time.Unix(0, n*bucket).UTC()
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+func (m *sqlMessageIndex) prune(before time.Time) (int, error) {
+	if before.IsZero() {
+		return 0, nil
+	}
+	result, err := m.db.db.Exec(m.db.query(`DELETE FROM messages WHERE time < ?`), before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// sqlCannedReplies keeps canned replies in the canned_replies table as
+// JSON, by workspace and shortcut.
+type sqlCannedReplies struct {
+	db *sqlDB
+}
+
+func scanCannedReply(row interface{ Scan(...interface{}) error }) (CannedReply, error) {
+	var data string
+	if err := row.Scan(&data); err != nil {
+		return CannedReply{}, err
+	}
+	var reply CannedReply
+	err := json.Unmarshal([]byte(data), &reply)
+	return reply, err
+}
+
+func (s *sqlCannedReplies) list() ([]CannedReply, error) {
+	rows, err := s.db.db.Query(`SELECT data FROM canned_replies ORDER BY shortcut, workspace`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []CannedReply{}
+	for rows.Next() {
+		reply, err := scanCannedReply(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, reply)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlCannedReplies) get(workspace, shortcut string) (CannedReply, bool, error) {
+	reply, err := scanCannedReply(s.db.db.QueryRow(s.db.query(`SELECT data FROM canned_replies WHERE workspace = ? AND shortcut = ?`), workspace, shortcut))
+	if errors.Is(err, sql.ErrNoRows) {
+		return CannedReply{}, false, nil
+	}
+	return reply, err == nil, err
+}
+
+func (s *sqlCannedReplies) put(reply CannedReply) error {
+	data, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.db.Exec(s.db.query(`INSERT INTO canned_replies (workspace, shortcut, data) VALUES (?, ?, ?)
+		ON CONFLICT (workspace, shortcut) DO UPDATE SET data = excluded.data`), reply.Workspace, reply.Shortcut, string(data))
+	return err
+}
+
+func (s *sqlCannedReplies) delete(workspace, shortcut string) (bool, error) {
+	result, err := s.db.db.Exec(s.db.query(`DELETE FROM canned_replies WHERE workspace = ? AND shortcut = ?`), workspace, shortcut)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// syncShards is how many shards a SyncMap spreads its keys over, so the
+// webhook handler and the API don't all wait on one lock.
+const syncShards = 16
+
+// SyncMap is a map safe for concurrent use whose entries may expire. The
+// in-memory stores of subsystems keep their records in one rather than
+// pairing a mutex with a map of their own.
+//
+// Keys are spread over shards, each with its own lock. Expired entries
+// are never returned, and are dropped by removeExpired or runJanitor.
+type SyncMap[K comparable, V any] struct {
+	seed   maphash.Seed
+	shards [syncShards]syncShard[K, V]
+}
+
+type syncShard[K comparable, V any] struct {
+	mu      sync.Mutex
+	entries map[K]syncEntry[V]
+}
+
+// syncEntry is a value and when it expires, never when zero.
+type syncEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+func (e syncEntry[V]) live(now time.Time) bool {
+	return e.expires.IsZero() || now.Before(e.expires)
+}
+
+func newSyncMap[K comparable, V any]() *SyncMap[K, V] {
+	m := &SyncMap[K, V]{seed: maphash.MakeSeed()}
+	for i := range m.shards {
+		m.shards[i].entries = make(map[K]syncEntry[V])
+	}
+	return m
+}
+
+func (m *SyncMap[K, V]) shard(key K) *syncShard[K, V] {
+	return &m.shards[maphash.Comparable(m.seed, key)%syncShards]
+}
+
+// get returns the value of key unless it is missing or expired.
+func (m *SyncMap[K, V]) get(key K) (V, bool) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok || !entry.live(time.Now()) {
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// set stores the value of key for good.
+func (m *SyncMap[K, V]) set(key K, value V) {
+	m.setTTL(key, value, 0)
+}
+
+// setTTL stores the value of key for ttl, for good when it is 0.
+func (m *SyncMap[K, V]) setTTL(key K, value V, ttl time.Duration) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = newSyncEntry(value, ttl)
+}
+
+func newSyncEntry[V any](value V, ttl time.Duration) syncEntry[V] {
+	entry := syncEntry[V]{value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	return entry
+}
+
+// add stores the value of key for ttl unless it already has a live one,
+// and reports whether it did.
+func (m *SyncMap[K, V]) add(key K, value V, ttl time.Duration) bool {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok && entry.live(time.Now()) {
+		return false
+	}
+	s.entries[key] = newSyncEntry(value, ttl)
+	return true
+}
+
+// update replaces the value of key with what change makes of it, with no
+// other change of the key in between. change is told whether key had a
+// live value; it returns the new one and false to delete key instead.
+// The expiry of a live value is kept.
+func (m *SyncMap[K, V]) update(key K, change func(value V, ok bool) (V, bool)) V {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if ok && !entry.live(time.Now()) {
+		entry, ok = syncEntry[V]{}, false
+	}
+	value, keep := change(entry.value, ok)
+	if !keep {
+		delete(s.entries, key)
+		return value
+	}
+	entry.value = value
+	s.entries[key] = entry
+	return value
+}
+
+func (m *SyncMap[K, V]) delete(key K) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+}
+
+// values lists the live values, in no particular order.
+func (m *SyncMap[K, V]) values() []V {
+	now := time.Now()
+	list := []V{}
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.Lock()
+		for _, entry := range s.entries {
+			if entry.live(now) {
+				list = append(list, entry.value)
+			}
+		}
+		s.mu.Unlock()
+	}
+	return list
+}
+
+// removeExpired drops the entries expired by now and returns how many.
+func (m *SyncMap[K, V]) removeExpired(now time.Time) int {
+	removed := 0
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.Lock()
+		for key, entry := range s.entries {
+			if !entry.live(now) {
+				delete(s.entries, key)
+				removed++
+			}
+		}
+		s.mu.Unlock()
+	}
+	return removed
+}
+
+// runJanitor drops expired entries every interval, so keys that are never
+// read again don't pile up.
+func (m *SyncMap[K, V]) runJanitor(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		m.removeExpired(now)
+	}
+}
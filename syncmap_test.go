@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// workers is how many goroutines the concurrency tests run at once; run
+// them with -race.
+const workers = 16
+
+// parallel runs do(worker) on workers goroutines and waits for them all.
+func parallel(do func(worker int)) {
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			do(worker)
+		}(worker)
+	}
+	wg.Wait()
+}
+
+func TestSyncMapGetSetDelete(t *testing.T) {
+	m := newSyncMap[string, int]()
+	if _, ok := m.get("a"); ok {
+		t.Fatal("empty map has a")
+	}
+	m.set("a", 1)
+	if value, ok := m.get("a"); !ok || value != 1 {
+		t.Fatalf("get(a) = %d, %v, want 1, true", value, ok)
+	}
+	m.set("a", 2)
+	if value, _ := m.get("a"); value != 2 {
+		t.Fatalf("get(a) = %d after set, want 2", value)
+	}
+	m.delete("a")
+	if _, ok := m.get("a"); ok {
+		t.Fatal("a is still there after delete")
+	}
+}
+
+func TestSyncMapExpiry(t *testing.T) {
+	m := newSyncMap[string, int]()
+	m.setTTL("short", 1, 20*time.Millisecond)
+	m.setTTL("long", 2, time.Hour)
+	m.set("forever", 3)
+
+	if _, ok := m.get("short"); !ok {
+		t.Fatal("short expired at once")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := m.get("short"); ok {
+		t.Error("short is returned after it expired")
+	}
+	if got := sortedValues(m); fmt.Sprint(got) != "[2 3]" {
+		t.Errorf("values() = %v, want [2 3]", got)
+	}
+
+	// An expired key can be added again, a live one can't
+	if !m.add("short", 4, time.Hour) {
+		t.Error("add over an expired key failed")
+	}
+	if m.add("long", 5, time.Hour) {
+		t.Error("add over a live key succeeded")
+	}
+
+	m.setTTL("gone", 6, time.Millisecond)
+	if removed := m.removeExpired(time.Now().Add(time.Minute)); removed != 1 {
+		t.Errorf("removeExpired removed %d, want 1", removed)
+	}
+	if removed := m.removeExpired(time.Now().Add(2 * time.Hour)); removed != 2 {
+		t.Errorf("removeExpired removed %d, want short and long", removed)
+	}
+	if got := sortedValues(m); fmt.Sprint(got) != "[3]" {
+		t.Errorf("values() = %v, want [3]", got)
+	}
+}
+
+func TestSyncMapUpdateKeepsExpiry(t *testing.T) {
+	m := newSyncMap[string, int]()
+	m.setTTL("a", 1, 20*time.Millisecond)
+	m.update("a", func(value int, ok bool) (int, bool) { return value + 1, true })
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := m.get("a"); ok {
+		t.Error("update made an expiring key permanent")
+	}
+
+	// An expired value is not handed to change
+	got := m.update("a", func(value int, ok bool) (int, bool) {
+		if ok || value != 0 {
+			t.Errorf("change got %d, %v for an expired key", value, ok)
+		}
+		return 10, true
+	})
+	if got != 10 {
+		t.Errorf("update returned %d, want 10", got)
+	}
+
+	m.update("a", func(int, bool) (int, bool) { return 0, false })
+	if _, ok := m.get("a"); ok {
+		t.Error("update returning false didn't delete the key")
+	}
+}
+
+func TestSyncMapConcurrentUpdate(t *testing.T) {
+	const increments = 1000
+	m := newSyncMap[string, int]()
+	parallel(func(worker int) {
+		for i := 0; i < increments; i++ {
+			m.update("counter", func(value int, _ bool) (int, bool) { return value + 1, true })
+		}
+	})
+	if value, _ := m.get("counter"); value != workers*increments {
+		t.Errorf("counter = %d, want %d", value, workers*increments)
+	}
+}
+
+func TestSyncMapConcurrentAdd(t *testing.T) {
+	const keys = 100
+	m := newSyncMap[int, int]()
+	var won [keys]atomic.Int32
+	parallel(func(worker int) {
+		for key := 0; key < keys; key++ {
+			if m.add(key, worker, time.Hour) {
+				won[key].Add(1)
+			}
+		}
+	})
+	for key := range won {
+		if n := won[key].Load(); n != 1 {
+			t.Errorf("key %d was added %d times", key, n)
+		}
+	}
+}
+
+func TestSyncMapConcurrentAccess(t *testing.T) {
+	const keys = 64
+	m := newSyncMap[string, int]()
+	parallel(func(worker int) {
+		for i := 0; i < 500; i++ {
+			key := fmt.Sprint("key", (worker+i)%keys)
+			switch i % 6 {
+			case 0:
+				m.set(key, i)
+			case 1:
+				m.setTTL(key, i, time.Millisecond)
+			case 2:
+				m.get(key)
+			case 3:
+				m.delete(key)
+			case 4:
+				m.values()
+			case 5:
+				m.removeExpired(time.Now())
+			}
+		}
+	})
+
+	// Whatever survived is consistent: values lists no key that has gone
+	live := 0
+	for i := 0; i < keys; i++ {
+		if _, ok := m.get(fmt.Sprint("key", i)); ok {
+			live++
+		}
+	}
+	if n := len(m.values()); n > live {
+		t.Errorf("values() lists %d entries, %d keys are live", n, live)
+	}
+}
+
+func TestSeenSetConcurrent(t *testing.T) {
+	const keys = 200
+	seen := newSeenSet()
+	var first atomic.Int32
+	parallel(func(worker int) {
+		for i := 0; i < keys; i++ {
+			if seen.firstSeenFor(fmt.Sprint("incomingMessageReceived/", i), time.Minute) {
+				first.Add(1)
+			}
+		}
+	})
+	if n := first.Load(); n != keys {
+		t.Errorf("%d keys were seen first, want %d", n, keys)
+	}
+
+	// Without a window nothing is remembered
+	if !seen.firstSeenFor("incomingMessageReceived/0", 0) {
+		t.Error("a 0 ttl deduplicated a key")
+	}
+}
+
+func TestMemorySessionsConcurrent(t *testing.T) {
+	s := newMemorySessions()
+	tokens := make([]string, workers)
+	parallel(func(worker int) {
+		token, _, err := s.create(Principal{Name: fmt.Sprint("user", worker), Role: roleViewer}, time.Hour)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		tokens[worker] = token
+		for i := 0; i < 100; i++ {
+			session, ok, err := s.get(token)
+			if err != nil || !ok || session.Principal.Name != fmt.Sprint("user", worker) {
+				t.Errorf("get(%s) = %+v, %v, %v", token, session, ok, err)
+				return
+			}
+		}
+		if worker%2 == 0 {
+			s.delete(token)
+		}
+		s.removeExpired()
+	})
+	for worker, token := range tokens {
+		_, ok, _ := s.get(token)
+		if want := worker%2 != 0; ok != want {
+			t.Errorf("session of worker %d live = %v, want %v", worker, ok, want)
+		}
+	}
+
+	token, _, _ := s.create(Principal{Name: "brief"}, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if _, ok, _ := s.get(token); ok {
+		t.Error("an expired session is returned")
+	}
+}
+
+func TestMemoryLabelsConcurrent(t *testing.T) {
+	s := newMemoryLabels()
+	parallel(func(worker int) {
+		chatId := fmt.Sprintf("7900%07d@c.us", worker)
+		for i := 0; i < 100; i++ {
+			labels := ChatLabels{IDInstance: testInstance, ChatID: chatId, Labels: []string{fmt.Sprint("label", i)}}
+			if err := s.put(labels); err != nil {
+				t.Error(err)
+				return
+			}
+			got, ok, err := s.get(testInstance, chatId)
+			if err != nil || !ok || got.ChatID != chatId {
+				t.Errorf("get(%s) = %+v, %v, %v", chatId, got, ok, err)
+				return
+			}
+			s.list()
+		}
+		if worker%2 == 0 {
+			s.delete(testInstance, chatId)
+		}
+	})
+	list, _ := s.list()
+	if len(list) != workers/2 {
+		t.Errorf("list() has %d chats, want %d", len(list), workers/2)
+	}
+	for _, labels := range list {
+		if fmt.Sprint(labels.Labels) != "[label99]" {
+			t.Errorf("labels of %s = %v, want the last put", labels.ChatID, labels.Labels)
+		}
+	}
+}
+
+func sortedValues(m *SyncMap[string, int]) []int {
+	values := m.values()
+	sort.Ints(values)
+	return values
+}
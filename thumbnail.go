@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// thumbnailSize is the maximum width or height of a generated thumbnail.
+const thumbnailSize = 256
+
+// maxThumbnailSource caps how much of an image is kept in memory for
+// thumbnailing. Larger images are sent without a preview.
+const maxThumbnailSource = 20 << 20
+
+// ThumbnailCache keeps the most recent thumbnails in memory, evicting the
+// oldest beyond -thumbnail-cache-size.
+type ThumbnailCache struct {
+	mu    sync.Mutex
+	items map[string]cachedThumbnail
+	order []string
+	// size is how many thumbnails are kept.
+	size int
+}
+
+type cachedThumbnail struct {
+	data    []byte
+	addedAt time.Time
+}
+
+func newThumbnailCache() *ThumbnailCache {
+	return &ThumbnailCache{items: make(map[string]cachedThumbnail)}
+}
+
+func (c *ThumbnailCache) put(id string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.order) >= max(c.size, 1) {
+		delete(c.items, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.items[id] = cachedThumbnail{data: data, addedAt: time.Now()}
+	c.order = append(c.order, id)
+}
+
+func (c *ThumbnailCache) get(id string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.items[id]
+	return item.data, ok
+}
+
+// prune drops thumbnails cached before before, unless it is zero, and all
+// but the newest keep, unless it is 0.
+func (c *ThumbnailCache) prune(before time.Time, keep int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	drop := 0
+	for drop < len(c.order) && ((keep > 0 && len(c.order)-drop > keep) || c.items[c.order[drop]].addedAt.Before(before)) {
+		delete(c.items, c.order[drop])
+		drop++
+	}
+	c.order = c.order[drop:]
+	return drop
+}
+
+func newMediaID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func isImageFile(fileName string) bool {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return true
+	}
+	return false
+}
+
+// captureBuffer keeps a copy of streamed bytes up to a limit. Once the limit
+// is crossed the copy is dropped and further writes are discarded.
+type captureBuffer struct {
+	buf      bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (c *captureBuffer) Write(p []byte) (int, error) {
+	if c.overflow {
+		return len(p), nil
+	}
+	if c.buf.Len()+len(p) > c.limit {
+		c.overflow = true
+		c.buf = bytes.Buffer{}
+		return len(p), nil
+	}
+	return c.buf.Write(p)
+}
+
+// makeThumbnail decodes an image and encodes a downscaled JPEG preview.
+func makeThumbnail(src []byte) ([]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, scaleImage(img, thumbnailSize), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// scaleImage shrinks the image to fit within maxSize, averaging the source
+// pixels covered by each destination pixel. Small images are copied as is.
+func scaleImage(img image.Image, maxSize int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	dstWidth, dstHeight := width, height
+	if width > maxSize || height > maxSize {
+		dstWidth, dstHeight = maxSize, height*maxSize/width
+		if height > width {
+			dstWidth, dstHeight = width*maxSize/height, maxSize
+		}
+	}
+	dstWidth, dstHeight = max(dstWidth, 1), max(dstHeight, 1)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		y0 := bounds.Min.Y + y*height/dstHeight
+		y1 := max(bounds.Min.Y+(y+1)*height/dstHeight, y0+1)
+		for x := 0; x < dstWidth; x++ {
+			x0 := bounds.Min.X + x*width/dstWidth
+			x1 := max(bounds.Min.X+(x+1)*width/dstWidth, x0+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			// Flatten transparency onto white since JPEG has no alpha
+			white := 0xffff*n - a
+			offset := dst.PixOffset(x, y)
+			dst.Pix[offset] = uint8((r + white) / n >> 8)
+			dst.Pix[offset+1] = uint8((g + white) / n >> 8)
+			dst.Pix[offset+2] = uint8((b + white) / n >> 8)
+			dst.Pix[offset+3] = 0xff
+		}
+	}
+	return dst
+}
+
+func (a *App) mediaThumbHandler(w http.ResponseWriter, r *http.Request) {
+	data, ok := a.thumbnails.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "thumbnail_not_found", "Thumbnail not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Write(data)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// transcriptPDFTimeout bounds converting a transcript with wkhtmltopdf.
+const transcriptPDFTimeout = time.Minute
+
+// transcriptWebhooks are the notifications that make up a conversation.
+var transcriptWebhooks = []string{"incomingMessageReceived", "outgoingMessageReceived", "outgoingAPIMessageReceived"}
+
+// TranscriptMessage is one message of an exported chat.
+type TranscriptMessage struct {
+	Time     time.Time
+	Outgoing bool
+	Text     string
+	FileName string
+	// Thumbnail is a data URI of the saved image, if there is one.
+	Thumbnail template.URL
+}
+
+// TranscriptPage is the view model of templates/transcript.html.
+type TranscriptPage struct {
+	ChatID     string
+	ExportedAt time.Time
+	Messages   []TranscriptMessage
+}
+
+// transcriptText is what a message shows when it has no text of its own.
+func transcriptText(n Notification) string {
+	var caption, fileName, location, contact string
+	if n.File != nil {
+		caption, fileName = n.File.Caption, n.File.FileName
+	}
+	if n.Location != nil {
+		location = cmp.Or(n.Location.Name, n.Location.Address, fmt.Sprintf("%.6f, %.6f", n.Location.Latitude, n.Location.Longitude))
+	}
+	if len(n.Contacts) > 0 {
+		names := make([]string, len(n.Contacts))
+		for i, c := range n.Contacts {
+			names[i] = c.DisplayName
+		}
+		contact = strings.Join(names, ", ")
+	}
+	return cmp.Or(n.Text, caption, location, contact, fileName, "["+n.TypeMessage+"]")
+}
+
+// transcriptThumbnail previews the saved file of an incoming image.
+func (a *App) transcriptThumbnail(ctx context.Context, idInstance, idMessage string) template.URL {
+	attachment, ok, err := a.mediaDownloader.store.get(idInstance, idMessage)
+	if err != nil || !ok || a.mediaDownloader.blobs == nil {
+		return ""
+	}
+	file, err := a.mediaDownloader.blobs.open(ctx, attachment.Path)
+	if err != nil {
+		return ""
+	}
+	src, err := io.ReadAll(io.LimitReader(file, maxMediaSize))
+	file.Close()
+	if err != nil {
+		return ""
+	}
+	thumbnail, err := makeThumbnail(src)
+	if err != nil {
+		return ""
+	}
+	return template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(thumbnail))
+}
+
+// chatExportHandler renders the stored messages of a chat as a standalone
+// HTML page, or as PDF when wkhtmltopdf is installed. Only the webhooks
+// still kept in memory are there, see -webhook-history-size.
+func (a *App) chatExportHandler(w http.ResponseWriter, r *http.Request) {
+	chatID := r.PathValue("chatId")
+	if !strings.Contains(chatID, "@") {
+		chatID += "@c.us"
+	}
+	format := cmp.Or(r.URL.Query().Get("format"), "html")
+	if format != "html" && format != "pdf" {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "format must be html or pdf")
+		return
+	}
+	idInstance := r.URL.Query().Get("idInstance")
+
+	page := TranscriptPage{ChatID: chatID, ExportedAt: time.Now()}
+	stored := a.notifications.list(workspaceOf(r))
+	for _, n := range slices.Backward(stored) {
+		if n.ChatID != chatID || n.Reaction != nil || !slices.Contains(transcriptWebhooks, n.TypeWebhook) {
+			continue
+		}
+		if idInstance != "" && strconv.FormatInt(n.IDInstance, 10) != idInstance {
+			continue
+		}
+		message := TranscriptMessage{
+			Time:     n.ReceivedAt,
+			Outgoing: n.TypeWebhook != "incomingMessageReceived",
+			Text:     transcriptText(n),
+		}
+		if n.File != nil {
+			message.FileName = n.File.FileName
+			message.Thumbnail = a.transcriptThumbnail(r.Context(), strconv.FormatInt(n.IDInstance, 10), n.IDMessage)
+		}
+		page.Messages = append(page.Messages, message)
+	}
+	if len(page.Messages) == 0 {
+		writeErrorf(w, r, http.StatusNotFound, "not_found", "No stored messages for chat %s", chatID)
+		return
+	}
+
+	tmpl, err := a.parsePage(r, "transcript.html")
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "render_failed", err.Error())
+		return
+	}
+	var html bytes.Buffer
+	if err := tmpl.Execute(&html, page); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "render_failed", err.Error())
+		return
+	}
+
+	name := "chat-" + strings.Split(chatID, "@")[0]
+	if format == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".html"))
+		w.Write(html.Bytes())
+		return
+	}
+
+	wkhtmltopdf, err := exec.LookPath("wkhtmltopdf")
+	if err != nil {
+		writeError(w, r, http.StatusNotImplemented, "pdf_unavailable", "wkhtmltopdf not found, export as html instead")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), transcriptPDFTimeout)
+	defer cancel()
+
+	var pdf, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, wkhtmltopdf, "--quiet", "-", "-")
+	cmd.Stdin = &html
+	cmd.Stdout = &pdf
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		writeErrorf(w, r, http.StatusInternalServerError, "render_failed", "wkhtmltopdf failed: %s", cmp.Or(strings.TrimSpace(stderr.String()), err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".pdf"))
+	w.Write(pdf.Bytes())
+}
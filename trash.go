@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Kinds of deleted things the trash keeps.
+const (
+	trashHistory     = "history"
+	trashPreset      = "preset"
+	trashCannedReply = "cannedReply"
+)
+
+var errTrashConflict = errors.New("restoring would replace an existing item")
+
+// TrashItem is something deleted through the API, kept for -trash-ttl so a
+// mistaken cleanup can be undone.
+type TrashItem struct {
+	ID   int64  `json:"id"`
+	Kind string `json:"kind"`
+	// Key is the ID or name the item had.
+	Key       string          `json:"key"`
+	Workspace string          `json:"workspace,omitempty"`
+	DeletedAt time.Time       `json:"deletedAt"`
+	DeletedBy string          `json:"deletedBy,omitempty"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// TrashStore keeps deleted items. Items get their ID when added.
+type TrashStore interface {
+	add(item TrashItem) (TrashItem, error)
+	get(id int64) (TrashItem, bool, error)
+	// list returns the items workspace may see, most recently deleted first.
+	list(workspace string) ([]TrashItem, error)
+	delete(id int64) (bool, error)
+	// prune drops items deleted before before.
+	prune(before time.Time) (int, error)
+}
+
+// trashRestorers put a deleted item of each kind back.
+var trashRestorers = map[string]func(a *App, item TrashItem) error{
+	trashHistory: func(a *App, item TrashItem) error {
+		var entry HistoryEntry
+		if err := json.Unmarshal(item.Data, &entry); err != nil {
+			return err
+		}
+		_, err := a.history.restore(entry.Workspace, []HistoryEntry{entry}, false)
+		return err
+	},
+	trashPreset: func(a *App, item TrashItem) error {
+		var preset SettingsPreset
+		if err := json.Unmarshal(item.Data, &preset); err != nil {
+			return err
+		}
+		_, exists, err := a.presets.get(preset.Workspace, preset.Name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			// Restoring would replace the preset saved since
+			return errTrashConflict
+		}
+		return a.presets.put(preset)
+	},
+	trashCannedReply: func(a *App, item TrashItem) error {
+		var reply CannedReply
+		if err := json.Unmarshal(item.Data, &reply); err != nil {
+			return err
+		}
+		_, exists, err := a.cannedReplies.get(reply.Workspace, reply.Shortcut)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errTrashConflict
+		}
+		return a.cannedReplies.put(reply)
+	},
+}
+
+// moveToTrash keeps value in the trash and then deletes it with remove,
+// taking it out of the trash again when that fails.
+func (a *App) moveToTrash(r *http.Request, kind, key, workspace string, value interface{}, remove func() error) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	item, err := a.trash.add(TrashItem{
+		Kind:      kind,
+		Key:       key,
+		Workspace: workspace,
+		DeletedAt: time.Now(),
+		DeletedBy: actorName(r),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+	if err := remove(); err != nil {
+		a.trash.delete(item.ID)
+		return err
+	}
+	return nil
+}
+
+// trashSpec sorts the trash by time (most recent first by default), kind or
+// key and searches kinds, keys and who deleted them.
+var trashSpec = listSpec[TrashItem]{
+	sorts: map[string]func(a, b TrashItem) int{
+		"time": byNumber(func(item TrashItem) int64 { return item.ID }),
+		"kind": byString(func(item TrashItem) string { return item.Kind }),
+		"key":  byString(func(item TrashItem) string { return item.Key }),
+	},
+	text: func(item TrashItem) []string { return []string{item.Kind, item.Key, item.DeletedBy} },
+}
+
+func (a *App) trashHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseListQuery(w, r)
+	if !ok || !trashSpec.check(w, r, &q, defaultPageSize) {
+		return
+	}
+	items, err := a.trash.list(workspaceOf(r))
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		items = slices.DeleteFunc(items, func(item TrashItem) bool { return item.Kind != kind })
+	}
+	page, total := trashSpec.apply(items, q)
+	writeList(w, page, total)
+}
+
+// trashItem looks up the trash item of the request path, writing the error
+// when there is none the caller may see.
+func (a *App) trashItem(w http.ResponseWriter, r *http.Request) (TrashItem, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Trash item ID must be a number")
+		return TrashItem{}, false
+	}
+	item, ok, err := a.trash.get(id)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return TrashItem{}, false
+	}
+	if !ok || !canSee(workspaceOf(r), item.Workspace) {
+		writeErrorf(w, r, http.StatusNotFound, "not_found", "Trash item %d not found", id)
+		return TrashItem{}, false
+	}
+	return item, true
+}
+
+// restoreTrashHandler puts a deleted item back. Restored history entries get
+// a new ID.
+func (a *App) restoreTrashHandler(w http.ResponseWriter, r *http.Request) {
+	item, ok := a.trashItem(w, r)
+	if !ok {
+		return
+	}
+	restore, ok := trashRestorers[item.Kind]
+	if !ok {
+		writeErrorf(w, r, http.StatusNotFound, "not_found", "Trash item %d not found", item.ID)
+		return
+	}
+	if err := restore(a, item); err != nil {
+		if errors.Is(err, errTrashConflict) {
+			writeErrorf(w, r, http.StatusConflict, "trash_conflict", "%s %s exists again, delete it first", item.Kind, item.Key)
+			return
+		}
+		writeStorageError(w, r, err)
+		return
+	}
+	if _, err := a.trash.delete(item.ID); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	infof("Restored %s %s from the trash for %s", item.Kind, item.Key, actorName(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
+// purgeTrashHandler deletes an item for good.
+func (a *App) purgeTrashHandler(w http.ResponseWriter, r *http.Request) {
+	item, ok := a.trashItem(w, r)
+	if !ok {
+		return
+	}
+	if _, err := a.trash.delete(item.ID); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// memoryTrash keeps deleted items in a slice, oldest first, so they are
+// gone on restart.
+type memoryTrash struct {
+	mu     sync.Mutex
+	items  []TrashItem
+	nextID int64
+}
+
+func newMemoryTrash() *memoryTrash {
+	return &memoryTrash{nextID: 1}
+}
+
+func (t *memoryTrash) add(item TrashItem) (TrashItem, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	item.ID = t.nextID
+	t.nextID++
+	t.items = append(t.items, item)
+	return item, nil
+}
+
+func (t *memoryTrash) get(id int64) (TrashItem, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, item := range t.items {
+		if item.ID == id {
+			return item, true, nil
+		}
+	}
+	return TrashItem{}, false, nil
+}
+
+func (t *memoryTrash) list(workspace string) ([]TrashItem, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := make([]TrashItem, 0, len(t.items))
+	for i := len(t.items) - 1; i >= 0; i-- {
+		if canSee(workspace, t.items[i].Workspace) {
+			list = append(list, t.items[i])
+		}
+	}
+	return list, nil
+}
+
+func (t *memoryTrash) delete(id int64) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(t.items)
+	t.items = slices.DeleteFunc(t.items, func(item TrashItem) bool { return item.ID == id })
+	return len(t.items) < n, nil
+}
+
+func (t *memoryTrash) prune(before time.Time) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := len(t.items)
+	t.items = slices.DeleteFunc(t.items, func(item TrashItem) bool { return item.DeletedAt.Before(before) })
+	return n - len(t.items), nil
+}
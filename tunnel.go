@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ngrokAPI is the local ngrok agent API listing active tunnels.
+const ngrokAPI = "http://127.0.0.1:4040/api/tunnels"
+
+// tunnelStartTimeout bounds how long a started ngrok agent may take to
+// report its public URL.
+const tunnelStartTimeout = 15 * time.Second
+
+// openTunnel returns a public HTTPS URL forwarding to this server. It reuses
+// a running ngrok agent, or starts one when ngrok is installed. The returned
+// cleanup stops an agent started here.
+func (a *App) openTunnel(ctx context.Context) (string, func(), error) {
+	if publicUrl, err := a.ngrokPublicURL(ctx); err == nil {
+		return publicUrl, func() {}, nil
+	}
+
+	ngrok, err := exec.LookPath("ngrok")
+	if err != nil {
+		return "", nil, errors.New("no ngrok agent is running and ngrok is not installed")
+	}
+
+	cmd := exec.Command(ngrok, "http", a.listenPort(), "--log", "stdout")
+	if err := cmd.Start(); err != nil {
+		return "", nil, fmt.Errorf("failed to start ngrok: %w", err)
+	}
+	cleanup := func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+
+	deadline := time.Now().Add(tunnelStartTimeout)
+	for time.Now().Before(deadline) {
+		if publicUrl, err := a.ngrokPublicURL(ctx); err == nil {
+			return publicUrl, cleanup, nil
+		}
+		select {
+		case <-ctx.Done():
+			cleanup()
+			return "", nil, ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	cleanup()
+	return "", nil, errors.New("ngrok did not report a tunnel in time")
+}
+
+// ngrokPublicURL finds the HTTPS tunnel pointing at this server's port.
+func (a *App) ngrokPublicURL(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ngrokAPI, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		Tunnels []struct {
+			PublicURL string `json:"public_url"`
+			Proto     string `json:"proto"`
+			Config    struct {
+				Addr string `json:"addr"`
+			} `json:"config"`
+		} `json:"tunnels"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return "", fmt.Errorf("invalid ngrok response: %w", err)
+	}
+
+	for _, tunnel := range list.Tunnels {
+		if tunnel.Proto == "https" && strings.HasSuffix(tunnel.Config.Addr, ":"+a.listenPort()) {
+			return strings.TrimSuffix(tunnel.PublicURL, "/"), nil
+		}
+	}
+	return "", errors.New("no ngrok tunnel for this server")
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+)
+
+// UploadProgress is published on the upload's event topic while the file is
+// streamed to GREEN-API.
+type UploadProgress struct {
+	Uploaded int64 `json:"uploaded"`
+	Total    int64 `json:"total"`
+	Done     bool  `json:"done"`
+}
+
+// progressInterval is how many bytes are streamed between progress events.
+const progressInterval = 256 << 10
+
+// maxFieldSize caps the size of non-file form fields.
+const maxFieldSize = 64 << 10
+
+type progressReader struct {
+	reader   io.Reader
+	events   *EventHub
+	topic    string
+	total    int64
+	uploaded int64
+	reported int64
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	n, err := p.reader.Read(buf)
+	p.uploaded += int64(n)
+	if p.topic != "" && (p.uploaded-p.reported >= progressInterval || err == io.EOF) {
+		p.reported = p.uploaded
+		p.events.publish(p.topic, UploadProgress{Uploaded: p.uploaded, Total: p.total})
+	}
+	return n, err
+}
+
+func uploadTopic(uploadID string) string {
+	return "upload:" + uploadID
+}
+
+func (a *App) sendFileUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, a.config.MaxUploadSize)
+
+	fields, filePart, err := readUploadForm(r)
+	if err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+
+	// Validate inputs
+	if len(fields["phoneNumber"]) < 11 {
+		writeError(w, r, http.StatusBadRequest, "invalid_phone_number", "Phone number too short")
+		return
+	}
+	if !a.checkOptOut(w, r, fields["phoneNumber"]) {
+		return
+	}
+
+	overrides, err := formOverrides(fields)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	ctx, err := withOverrides(r.Context(), overrides)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "blocked_override", err.Error())
+		return
+	}
+	ctx, rs := newResponder(ctx)
+
+	// Keep messages to the same chat in order
+	unlock, err := a.chatLocks.lock(ctx, chatKey(fields["idInstance"], fields["phoneNumber"]+"@c.us"))
+	if err != nil {
+		writeChatBusy(w, r)
+		return
+	}
+	defer unlock()
+
+	upload := a.streamUpload
+	if isChecked(fields["dryRun"]) {
+		upload = a.dryRunUpload
+	}
+	result, err := upload(ctx, fields, filePart.FileName(), filePart, r.ContentLength)
+	if !isChecked(fields["dryRun"]) {
+		a.audit.record(actorOf(r), a.uploadMessage(fields, filePart.FileName()), result.StatusCode, result.Response, err)
+	}
+	if err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+
+	response := APIResponse{
+		URL: result.URL,
+		RequestBody: map[string]interface{}{
+			"phoneNumber":      fields["phoneNumber"],
+			"fileName":         filePart.FileName(),
+			"fileSize":         result.Size,
+			"caption":          fields["caption"],
+			"idInstance":       fields["idInstance"],
+			"apiTokenInstance": "••••••••", // Mask sensitive data
+		},
+		Payload:    result.Payload,
+		DryRun:     result.Payload != nil,
+		Response:   result.Response,
+		StatusCode: result.StatusCode,
+		Snippets:   result.Snippets,
+	}
+	if result.MediaID != "" {
+		response.MediaID = result.MediaID
+		response.ThumbnailURL = a.appPath(fmt.Sprintf("/api/media/%s/thumb", result.MediaID))
+	}
+
+	rs.respond(w, response)
+}
+
+// uploadReadError wraps failures reading the client's multipart body.
+type uploadReadError struct {
+	err error
+}
+
+func (e *uploadReadError) Error() string {
+	return fmt.Sprintf("failed to read upload: %v", e.err)
+}
+
+func (e *uploadReadError) Unwrap() error {
+	return e.err
+}
+
+// readUploadForm reads form fields up to the file part, which must come last
+// so it can be streamed without buffering.
+func readUploadForm(r *http.Request) (map[string]string, *multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, &uploadReadError{err: errors.New("expected multipart/form-data body")}
+	}
+
+	fields := make(map[string]string)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, nil, &uploadReadError{err: errors.New("file is required and must be the last form field")}
+		}
+		if err != nil {
+			return nil, nil, &uploadReadError{err: err}
+		}
+
+		if part.FormName() == "file" {
+			if part.FileName() == "" {
+				return nil, nil, &uploadReadError{err: errors.New("file name is required")}
+			}
+			return fields, part, nil
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxFieldSize))
+		if err != nil {
+			return nil, nil, &uploadReadError{err: err}
+		}
+		fields[part.FormName()] = string(value)
+	}
+}
+
+// UploadResult describes a file delivered to sendFileByUpload.
+type UploadResult struct {
+	URL        string
+	Response   map[string]interface{}
+	StatusCode int
+	Size       int64
+	MediaID    string
+	Snippets   map[string]string
+	Payload    map[string]interface{}
+}
+
+func (a *App) uploadURL(fields map[string]string) string {
+	return a.methodURL("sendFileByUpload", fields["idInstance"], fields["apiTokenInstance"])
+}
+
+// dryRunUpload reads the file to validate its size and reports the form
+// sendFileByUpload would receive without calling GREEN-API.
+func (a *App) dryRunUpload(ctx context.Context, fields map[string]string, fileName string, file io.Reader, total int64) (UploadResult, error) {
+	apiUrl := a.uploadURL(fields)
+
+	size, err := io.Copy(io.Discard, file)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return UploadResult{}, err
+		}
+		return UploadResult{}, &uploadReadError{err: err}
+	}
+
+	call := uploadCall(apiUrl, fields, fileName)
+	payload := make(map[string]interface{}, len(call.FormFields)+1)
+	for name, value := range call.FormFields {
+		payload[name] = value
+	}
+	payload["file"] = map[string]interface{}{"name": fileName, "size": size}
+
+	return UploadResult{
+		URL:      apiUrl,
+		Size:     size,
+		Snippets: snippetsFor(ctx, call),
+		Payload:  payload,
+	}, nil
+}
+
+// streamUpload pipes the file to sendFileByUpload as it is read, so large
+// media never has to fit in memory. Progress is published on the upload's
+// event topic when the form carries an uploadId.
+func (a *App) streamUpload(ctx context.Context, fields map[string]string, fileName string, file io.Reader, total int64) (UploadResult, error) {
+	// Construct the API URL
+	apiUrl := a.uploadURL(fields)
+
+	topic := ""
+	if fields["uploadId"] != "" {
+		topic = uploadTopic(fields["uploadId"])
+	}
+	// Keep a bounded copy of images for thumbnail generation
+	var capture *captureBuffer
+	if isImageFile(fileName) {
+		capture = &captureBuffer{limit: maxThumbnailSource}
+		file = io.TeeReader(file, capture)
+	}
+	progress := &progressReader{reader: file, events: a.events, topic: topic, total: total}
+
+	pipeReader, pipeWriter := io.Pipe()
+	form := multipart.NewWriter(pipeWriter)
+	copyErr := make(chan error, 1)
+	go func() {
+		err := writeUploadForm(form, fields, fileName, progress)
+		pipeWriter.CloseWithError(err)
+		copyErr <- err
+	}()
+
+	apiResponse, statusCode, err := a.makeAPIRequestWithBody(ctx, "sendFileByUpload", apiUrl, form.FormDataContentType(), pipeReader)
+	pipeReader.Close()
+	streamErr := <-copyErr
+
+	// A size cap violation causes the upstream failure, so report it first
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(streamErr, &maxBytesErr) {
+		return UploadResult{}, streamErr
+	}
+	if err != nil {
+		return UploadResult{}, err
+	}
+	if streamErr != nil {
+		return UploadResult{}, &uploadReadError{err: streamErr}
+	}
+
+	if topic != "" {
+		a.events.publish(topic, UploadProgress{Uploaded: progress.uploaded, Total: progress.uploaded, Done: true})
+	}
+
+	result := UploadResult{
+		URL:        apiUrl,
+		Response:   apiResponse,
+		StatusCode: statusCode,
+		Size:       progress.uploaded,
+		Snippets:   snippetsFor(ctx, uploadCall(apiUrl, fields, fileName)),
+	}
+	if capture != nil && !capture.overflow {
+		if thumbnail, err := makeThumbnail(capture.buf.Bytes()); err != nil {
+			errorf("Failed to generate thumbnail for %s: %v", fileName, err)
+		} else {
+			result.MediaID = newMediaID()
+			a.thumbnails.put(result.MediaID, thumbnail)
+		}
+	}
+
+	return result, nil
+}
+
+// isChecked reports whether a multipart form field holds a checkbox or
+// boolean "true" value.
+func isChecked(value string) bool {
+	switch value {
+	case "true", "on", "1":
+		return true
+	}
+	return false
+}
+
+// uploadMessage describes a sendFileByUpload call for the audit log.
+func (a *App) uploadMessage(fields map[string]string, fileName string) OutboundMessage {
+	return OutboundMessage{
+		Method:  "sendFileByUpload",
+		URL:     a.uploadURL(fields),
+		ChatID:  fields["phoneNumber"] + "@c.us",
+		Content: fileName + "\n" + fields["caption"],
+	}
+}
+
+// uploadCall describes a sendFileByUpload request for snippet generation.
+func uploadCall(apiUrl string, fields map[string]string, fileName string) UpstreamCall {
+	formFields := map[string]string{
+		"chatId":   fmt.Sprintf("%s@c.us", fields["phoneNumber"]),
+		"fileName": fileName,
+	}
+	if fields["caption"] != "" {
+		formFields["caption"] = fields["caption"]
+	}
+	return UpstreamCall{Verb: http.MethodPost, URL: apiUrl, FormFields: formFields, FileName: fileName}
+}
+
+func writeUploadForm(form *multipart.Writer, fields map[string]string, fileName string, file io.Reader) error {
+	if err := form.WriteField("chatId", fmt.Sprintf("%s@c.us", fields["phoneNumber"])); err != nil {
+		return err
+	}
+	if err := form.WriteField("fileName", fileName); err != nil {
+		return err
+	}
+	if fields["caption"] != "" {
+		if err := form.WriteField("caption", fields["caption"]); err != nil {
+			return err
+		}
+	}
+
+	fileWriter, err := form.CreateFormFile("file", fileName)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fileWriter, file); err != nil {
+		return err
+	}
+
+	return form.Close()
+}
+
+func writeUploadError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeErrorf(w, r, http.StatusRequestEntityTooLarge, "file_too_large",
+			"File exceeds the %d byte upload limit", maxBytesErr.Limit)
+		return
+	}
+
+	var readErr *uploadReadError
+	if errors.As(err, &readErr) {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", readErr.Error())
+		return
+	}
+
+	writeUpstreamError(w, r, err)
+}
+
+func (a *App) uploadProgressHandler(w http.ResponseWriter, r *http.Request) {
+	uploadID := r.URL.Query().Get("id")
+	if uploadID == "" {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Upload id is required")
+		return
+	}
+
+	a.serveEvents(w, r, uploadTopic(uploadID))
+}
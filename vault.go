@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const vaultTimeout = 10 * time.Second
+
+// VaultSource keeps instance credentials in sync with a Vault KV v2 secret
+// whose keys are [workspace/]idInstance and values their apiTokenInstance.
+type VaultSource struct {
+	app    *App
+	client *http.Client
+
+	mu sync.Mutex
+	// static are the instances configured by flags, env or the secrets
+	// file. Vault entries with the same idInstance take precedence.
+	static  []Instance
+	fetched []Instance
+}
+
+func (a *App) vaultEnabled() bool {
+	return a.config.VaultPath != ""
+}
+
+// secretURL is the KV v2 read endpoint of -vault-path under -vault-mount.
+func (v *VaultSource) secretURL() string {
+	return fmt.Sprintf("%s/v1/%s/data/%s",
+		strings.TrimSuffix(v.app.config.VaultAddr, "/"),
+		strings.Trim(v.app.config.VaultMount, "/"),
+		strings.Trim(v.app.config.VaultPath, "/"))
+}
+
+func (v *VaultSource) fetch(ctx context.Context) ([]Instance, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.secretURL(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.app.config.VaultToken)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var secret struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+		Errors []string `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("invalid Vault response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Vault returned %s %s", resp.Status, strings.Join(secret.Errors, "; "))
+	}
+
+	instances := make([]Instance, 0, len(secret.Data.Data))
+	for id, value := range secret.Data.Data {
+		token, ok := value.(string)
+		if !ok || token == "" {
+			return nil, fmt.Errorf("Vault entry %s is not a token string", id)
+		}
+		workspace, id := splitWorkspace(id)
+		instances = append(instances, Instance{Workspace: workspace, IDInstance: id, APITokenInstance: token})
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].IDInstance < instances[j].IDInstance })
+	return instances, nil
+}
+
+// refresh replaces the configured instances with the static ones plus what
+// Vault holds now.
+func (v *VaultSource) refresh(ctx context.Context) error {
+	fetched, err := v.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.fetched = fetched
+	v.apply()
+	return nil
+}
+
+// setStatic replaces the static instances, keeping what Vault returned last.
+func (v *VaultSource) setStatic(static []Instance) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.static = static
+	v.apply()
+}
+
+// apply publishes the merged instances. The caller holds mu.
+func (v *VaultSource) apply() {
+	merged := make([]Instance, 0, len(v.static)+len(v.fetched))
+	fromVault := make(map[string]bool, len(v.fetched))
+	for _, instance := range v.fetched {
+		fromVault[instance.IDInstance] = true
+	}
+	for _, instance := range v.static {
+		if !fromVault[instance.IDInstance] {
+			merged = append(merged, instance)
+		}
+	}
+	merged = append(merged, v.fetched...)
+
+	if previous := v.app.configuredInstances(); len(previous) != len(merged) {
+		infof("Vault: %d instances configured, was %d", len(merged), len(previous))
+	}
+	v.app.setInstances(merged)
+}
+
+// refreshJob is the vault job, by default run every -vault-refresh. A
+// failed refresh keeps the last known credentials.
+func (v *VaultSource) refreshJob(ctx context.Context) error {
+	if err := v.refresh(ctx); err != nil {
+		return fmt.Errorf("refresh of %s failed: %w", v.secretURL(), err)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// voiceTranscodeArgs convert any audio into the mono OGG/OPUS stream WhatsApp
+// renders as a voice note.
+var voiceTranscodeArgs = []string{"-vn", "-ac", "1", "-c:a", "libopus", "-b:a", "32k", "-application", "voip", "-f", "ogg"}
+
+func (a *App) sendVoiceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, a.config.MaxUploadSize)
+
+	fields, filePart, err := readUploadForm(r)
+	if err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+
+	// Validate inputs
+	if len(fields["phoneNumber"]) < 11 {
+		writeError(w, r, http.StatusBadRequest, "invalid_phone_number", "Phone number too short")
+		return
+	}
+	if !a.checkOptOut(w, r, fields["phoneNumber"]) {
+		return
+	}
+
+	overrides, err := formOverrides(fields)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	ctx, err := withOverrides(r.Context(), overrides)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "blocked_override", err.Error())
+		return
+	}
+	ctx, rs := newResponder(ctx)
+
+	fileName := filePart.FileName()
+	var voice io.Reader = filePart
+	total := r.ContentLength
+	transcoded := false
+	transcodeNote := ""
+
+	// Convert to OGG/OPUS when requested and ffmpeg is installed
+	if isChecked(fields["convert"]) {
+		ffmpeg, err := exec.LookPath("ffmpeg")
+		if err != nil {
+			transcodeNote = translate(negotiateLanguage(r), "ffmpeg not found, original file sent")
+		} else {
+			path, cleanup, err := transcodeVoice(r.Context(), ffmpeg, filePart)
+			if cleanup != nil {
+				defer cleanup()
+			}
+			if err != nil {
+				var readErr *uploadReadError
+				if errors.As(err, &readErr) {
+					writeUploadError(w, r, err)
+					return
+				}
+				writeError(w, r, http.StatusUnprocessableEntity, "transcode_failed", err.Error())
+				return
+			}
+
+			file, err := os.Open(path)
+			if err != nil {
+				writeError(w, r, http.StatusInternalServerError, "transcode_failed", err.Error())
+				return
+			}
+			defer file.Close()
+
+			if info, err := file.Stat(); err == nil {
+				total = info.Size()
+			}
+			voice = file
+			fileName = strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".ogg"
+			transcoded = true
+		}
+	}
+
+	// Keep messages to the same chat in order
+	unlock, err := a.chatLocks.lock(ctx, chatKey(fields["idInstance"], fields["phoneNumber"]+"@c.us"))
+	if err != nil {
+		writeChatBusy(w, r)
+		return
+	}
+	defer unlock()
+
+	upload := a.streamUpload
+	if isChecked(fields["dryRun"]) {
+		upload = a.dryRunUpload
+	}
+	result, err := upload(ctx, fields, fileName, voice, total)
+	if !isChecked(fields["dryRun"]) {
+		a.audit.record(actorOf(r), a.uploadMessage(fields, fileName), result.StatusCode, result.Response, err)
+	}
+	if err != nil {
+		writeUploadError(w, r, err)
+		return
+	}
+
+	rs.respond(w, APIResponse{
+		URL: result.URL,
+		RequestBody: map[string]interface{}{
+			"phoneNumber":      fields["phoneNumber"],
+			"fileName":         fileName,
+			"fileSize":         result.Size,
+			"idInstance":       fields["idInstance"],
+			"apiTokenInstance": "••••••••", // Mask sensitive data
+		},
+		Payload:       result.Payload,
+		DryRun:        result.Payload != nil,
+		Response:      result.Response,
+		StatusCode:    result.StatusCode,
+		Snippets:      result.Snippets,
+		Transcoded:    transcoded,
+		TranscodeNote: transcodeNote,
+	})
+}
+
+// transcodeVoice stores the upload in a temporary directory and converts it
+// with ffmpeg. The returned cleanup removes the directory.
+func transcodeVoice(ctx context.Context, ffmpeg string, input io.Reader) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "voice-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+
+	inputPath := filepath.Join(dir, "input")
+	inputFile, err := os.Create(inputPath)
+	if err != nil {
+		return "", cleanup, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	_, err = io.Copy(inputFile, input)
+	inputFile.Close()
+	if err != nil {
+		return "", cleanup, &uploadReadError{err: err}
+	}
+
+	outputPath := filepath.Join(dir, "voice.ogg")
+	args := append([]string{"-y", "-i", inputPath}, voiceTranscodeArgs...)
+	args = append(args, outputPath)
+
+	output, err := exec.CommandContext(ctx, ffmpeg, args...).CombinedOutput()
+	if err != nil {
+		return "", cleanup, fmt.Errorf("ffmpeg failed: %w: %s", err, lastLine(output))
+	}
+
+	return outputPath, cleanup, nil
+}
+
+func lastLine(output []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	return lines[len(lines)-1]
+}
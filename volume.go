@@ -0,0 +1,191 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// maxVolumeBuckets bounds the points of one series of /api/stats/volume.
+const maxVolumeBuckets = 2000
+
+// volumeGranularities are the bucket sizes of /api/stats/volume. Buckets
+// start on the hour or at midnight UTC.
+var volumeGranularities = map[string]time.Duration{
+	"hour": time.Hour,
+	"day":  24 * time.Hour,
+}
+
+// VolumeQuery is what /api/stats/volume counts: messages from From up to
+// To in buckets of Bucket.
+type VolumeQuery struct {
+	Workspace  string
+	IDInstance string
+	From       time.Time
+	To         time.Time
+	Bucket     time.Duration
+}
+
+// VolumeCount is how many messages of a typeWebhook an instance had in the
+// bucket starting at Bucket.
+type VolumeCount struct {
+	IDInstance  string
+	TypeWebhook string
+	Bucket      time.Time
+	Count       int
+}
+
+// MessageVolume is the answer of /api/stats/volume, a series per instance
+// and direction.
+type MessageVolume struct {
+	From        time.Time      `json:"from"`
+	To          time.Time      `json:"to"`
+	Granularity string         `json:"granularity"`
+	Series      []VolumeSeries `json:"series"`
+}
+
+// VolumeSeries counts the messages of an instance in one direction, with a
+// point for every bucket, empty ones included, so it can be charted as is.
+type VolumeSeries struct {
+	IDInstance string        `json:"idInstance"`
+	Direction  string        `json:"direction"`
+	Total      int           `json:"total"`
+	Points     []VolumePoint `json:"points"`
+}
+
+type VolumePoint struct {
+	Time  time.Time `json:"time"`
+	Count int       `json:"count"`
+}
+
+// messageDirection tells incoming messages from those sent from the phone
+// or through the API.
+func messageDirection(typeWebhook string) string {
+	if typeWebhook == "incomingMessageReceived" {
+		return "incoming"
+	}
+	return "outgoing"
+}
+
+// parseVolumeTime reads ?from= or ?to= as an RFC 3339 time or a date.
+func parseVolumeTime(value string, fallback time.Time) (time.Time, bool) {
+	if value == "" {
+		return fallback, true
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// volumeHandler counts the messages kept by the message index per hour or
+// day. ?granularity= is hour (the default) or day, ?from= and ?to= default
+// to the last day of hours or the last 30 days, and ?idInstance= keeps one
+// instance.
+func (a *App) volumeHandler(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	granularity := values.Get("granularity")
+	if granularity == "" {
+		granularity = "hour"
+	}
+	bucket, ok := volumeGranularities[granularity]
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Granularity must be hour or day")
+		return
+	}
+
+	now := time.Now().UTC()
+	to, ok := parseVolumeTime(values.Get("to"), now)
+	if !ok {
+		writeErrorf(w, r, http.StatusBadRequest, "invalid_request", "Query parameter %s must be an RFC 3339 time or a date", "to")
+		return
+	}
+	span := 24 * time.Hour
+	if granularity == "day" {
+		span = 30 * 24 * time.Hour
+	}
+	from, ok := parseVolumeTime(values.Get("from"), to.Add(-span))
+	if !ok {
+		writeErrorf(w, r, http.StatusBadRequest, "invalid_request", "Query parameter %s must be an RFC 3339 time or a date", "from")
+		return
+	}
+	// Whole buckets, the last one holding to
+	from = from.UTC().Truncate(bucket)
+	to = to.UTC().Truncate(bucket).Add(bucket)
+	if !from.Before(to) {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "from must be before to")
+		return
+	}
+	buckets := int(to.Sub(from) / bucket)
+	if buckets > maxVolumeBuckets {
+		writeErrorf(w, r, http.StatusBadRequest, "invalid_request", "The range spans %d buckets, at most %d are allowed", buckets, maxVolumeBuckets)
+		return
+	}
+
+	counts, err := a.messageIndex.volume(VolumeQuery{
+		Workspace:  workspaceOf(r),
+		IDInstance: values.Get("idInstance"),
+		From:       from,
+		To:         to,
+		Bucket:     bucket,
+	})
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	bySeries := make(map[[2]string]*VolumeSeries)
+	volume := MessageVolume{From: from, To: to, Granularity: granularity, Series: []VolumeSeries{}}
+	for _, count := range counts {
+		key := [2]string{count.IDInstance, messageDirection(count.TypeWebhook)}
+		series, ok := bySeries[key]
+		if !ok {
+			series = &VolumeSeries{IDInstance: key[0], Direction: key[1], Points: make([]VolumePoint, buckets)}
+			for i := range series.Points {
+				series.Points[i].Time = from.Add(time.Duration(i) * bucket)
+			}
+			bySeries[key] = series
+		}
+		i := int(count.Bucket.Sub(from) / bucket)
+		if i < 0 || i >= buckets {
+			continue
+		}
+		series.Points[i].Count += count.Count
+		series.Total += count.Count
+	}
+	for _, series := range bySeries {
+		volume.Series = append(volume.Series, *series)
+	}
+	slices.SortFunc(volume.Series, func(a, b VolumeSeries) int {
+		return cmp.Or(cmp.Compare(a.IDInstance, b.IDInstance), cmp.Compare(a.Direction, b.Direction))
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(volume)
+}
+
+// volume counts the messages of the webhooks kept in memory.
+func (m memoryMessageIndex) volume(query VolumeQuery) ([]VolumeCount, error) {
+	counts := make(map[VolumeCount]int)
+	for _, notification := range m.notifications.list(query.Workspace) {
+		idInstance := strconv.FormatInt(notification.IDInstance, 10)
+		if !isMessage(notification) || notification.ReceivedAt.Before(query.From) || !notification.ReceivedAt.Before(query.To) ||
+			(query.IDInstance != "" && idInstance != query.IDInstance) {
+			continue
+		}
+		key := VolumeCount{IDInstance: idInstance, TypeWebhook: notification.TypeWebhook, Bucket: notification.ReceivedAt.UTC().Truncate(query.Bucket)}
+		counts[key]++
+	}
+
+	list := make([]VolumeCount, 0, len(counts))
+	for key, count := range counts {
+		key.Count = count
+		list = append(list, key)
+	}
+	return list, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// instancesTopic is the event hub topic instance state changes are published on.
+const instancesTopic = "instances"
+
+const stateAuthorized = "authorized"
+
+// InstanceState is the last known state of a configured instance.
+type InstanceState struct {
+	IDInstance string    `json:"idInstance"`
+	State      string    `json:"state"`
+	Previous   string    `json:"previous,omitempty"`
+	ChangedAt  time.Time `json:"changedAt"`
+	CheckedAt  time.Time `json:"checkedAt"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// StateWatcher polls configured instances and reports state changes.
+type StateWatcher struct {
+	app    *App
+	mu     sync.Mutex
+	states map[string]InstanceState
+}
+
+func newStateWatcher(a *App) *StateWatcher {
+	return &StateWatcher{app: a, states: make(map[string]InstanceState)}
+}
+
+// checkAll polls every configured instance. It is the watch job, by
+// default run every -watch-interval.
+func (sw *StateWatcher) checkAll(ctx context.Context) error {
+	for _, instance := range sw.app.configuredInstances() {
+		sw.check(ctx, instance)
+	}
+	return nil
+}
+
+func (sw *StateWatcher) check(ctx context.Context, instance Instance) {
+	apiUrl := sw.app.methodURL("getStateInstance", instance.IDInstance, instance.APITokenInstance)
+
+	state := ""
+	checkErr := ""
+	response, _, err := sw.app.makeAPIRequest(ctx, "getStateInstance", apiUrl)
+	if err != nil {
+		checkErr = err.Error()
+	} else {
+		state, _ = response["stateInstance"].(string)
+	}
+
+	sw.mu.Lock()
+	current, known := sw.states[instance.IDInstance]
+	current.IDInstance = instance.IDInstance
+	current.CheckedAt = time.Now()
+	current.Error = checkErr
+	// A failed poll says nothing about the device, keep the last state
+	changed := err == nil && (!known || current.State != state)
+	if changed {
+		current.Previous = current.State
+		current.State = state
+		current.ChangedAt = current.CheckedAt
+	}
+	sw.states[instance.IDInstance] = current
+	sw.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	sw.app.publishScoped(instancesTopic, instance.Workspace, current)
+	if current.Previous == stateAuthorized {
+		warnf("Instance %s is no longer authorized: %s", instance.IDInstance, current.State)
+		sw.alert(current)
+	}
+}
+
+// alert forwards the state change to the configured forwarder targets.
+func (sw *StateWatcher) alert(state InstanceState) {
+	body, err := json.Marshal(map[string]interface{}{
+		"typeWebhook":   "instanceStateAlert",
+		"instanceData":  map[string]string{"idInstance": state.IDInstance},
+		"stateInstance": state.State,
+		"previousState": state.Previous,
+		"timestamp":     state.ChangedAt.Unix(),
+	})
+	if err != nil {
+		errorf("Failed to encode state alert: %v", err)
+		return
+	}
+	sw.app.forwarder.forward(Notification{
+		ReceivedAt:  state.ChangedAt,
+		TypeWebhook: "instanceStateAlert",
+		Body:        body,
+	})
+}
+
+// list returns the states of the instances workspace may see.
+func (sw *StateWatcher) list(workspace string) []InstanceState {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	instances := sw.app.configuredInstances()
+	list := make([]InstanceState, 0, len(instances))
+	for _, instance := range instances {
+		if !canSee(workspace, instance.Workspace) {
+			continue
+		}
+		if state, ok := sw.states[instance.IDInstance]; ok {
+			list = append(list, state)
+		} else {
+			list = append(list, InstanceState{IDInstance: instance.IDInstance})
+		}
+	}
+	return list
+}
+
+func (a *App) instanceStatesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.watcher.list(workspaceOf(r)))
+}
+
+func (a *App) instanceStreamHandler(w http.ResponseWriter, r *http.Request) {
+	a.serveScopedEvents(w, r, instancesTopic)
+}
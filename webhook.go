@@ -0,0 +1,324 @@
+package main
+
+import (
+	"cmp"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// webhookTopic is the event hub topic incoming notifications are published on.
+const webhookTopic = "webhooks"
+
+// maxWebhookBody caps the size of an incoming notification.
+const maxWebhookBody = 1 << 20
+
+// Notification is a GREEN-API webhook received on /webhook.
+type Notification struct {
+	ID          int64           `json:"id"`
+	ReceivedAt  time.Time       `json:"receivedAt"`
+	TypeWebhook string          `json:"typeWebhook"`
+	IDInstance  int64           `json:"idInstance,omitempty"`
+	ChatID      string          `json:"chatId,omitempty"`
+	IDMessage   string          `json:"idMessage,omitempty"`
+	TypeMessage string          `json:"typeMessage,omitempty"`
+	Text        string          `json:"text,omitempty"`
+	Reaction    *Reaction       `json:"reaction,omitempty"`
+	Poll        *PollResults    `json:"poll,omitempty"`
+	Location    *SharedLocation `json:"location,omitempty"`
+	Contacts    []SharedContact `json:"contacts,omitempty"`
+	File        *MessageFile    `json:"file,omitempty"`
+	Reply       *ButtonReply    `json:"reply,omitempty"`
+	Quoted      *QuotedMessage  `json:"quoted,omitempty"`
+	Link        *LinkPreview    `json:"link,omitempty"`
+	// Anomalies lists how the body breaks the schema of its typeWebhook.
+	Anomalies []string        `json:"anomalies,omitempty"`
+	Body      json.RawMessage `json:"body"`
+}
+
+// NotificationStore keeps the most recent notifications in memory,
+// partitioned by the instance that sent them, so a busy instance doesn't
+// push out the webhooks of the others. Each instance keeps up to
+// -webhook-history-size.
+type NotificationStore struct {
+	mu        sync.Mutex
+	instances map[int64][]Notification
+	nextID    int64
+	// size is how many notifications each instance keeps.
+	size int
+	// instanceWorkspace returns the workspace of an instance.
+	instanceWorkspace func(idInstance string) string
+}
+
+func newNotificationStore(size int) *NotificationStore {
+	return &NotificationStore{instances: make(map[int64][]Notification), nextID: 1, size: size}
+}
+
+func (s *NotificationStore) add(notification Notification) Notification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notification.ID = s.nextID
+	s.nextID++
+	partition := s.instances[notification.IDInstance]
+	if len(partition) >= max(s.size, 1) {
+		partition = partition[1:]
+	}
+	s.instances[notification.IDInstance] = append(partition, notification)
+	return notification
+}
+
+// prune drops notifications received before before, unless it is zero,
+// and all but the newest keep of each instance, unless it is 0.
+func (s *NotificationStore) prune(before time.Time, keep int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dropped := 0
+	for idInstance, partition := range s.instances {
+		drop := 0
+		for drop < len(partition) && ((keep > 0 && len(partition)-drop > keep) || partition[drop].ReceivedAt.Before(before)) {
+			drop++
+		}
+		dropped += drop
+		if drop == len(partition) {
+			delete(s.instances, idInstance)
+		} else {
+			s.instances[idInstance] = partition[drop:]
+		}
+	}
+	return dropped
+}
+
+// list returns the notifications workspace may see, newest first.
+func (s *NotificationStore) list(workspace string) []Notification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []Notification
+	for idInstance, partition := range s.instances {
+		if canSee(workspace, s.instanceWorkspace(strconv.FormatInt(idInstance, 10))) {
+			list = append(list, partition...)
+		}
+	}
+	slices.SortFunc(list, func(a, b Notification) int { return cmp.Compare(b.ID, a.ID) })
+	if list == nil {
+		list = []Notification{}
+	}
+	return list
+}
+
+// listInstance returns the notifications of one instance, newest first.
+func (s *NotificationStore) listInstance(idInstance int64) []Notification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := slices.Clone(s.instances[idInstance])
+	slices.Reverse(list)
+	if list == nil {
+		list = []Notification{}
+	}
+	return list
+}
+
+// instanceOfQuery reads ?idInstance=, which narrows the event APIs to the
+// webhooks of one instance. It writes an error when the instance isn't a
+// number or the caller may not see it; 0 means every instance.
+func (a *App) instanceOfQuery(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	value := r.URL.Query().Get("idInstance")
+	if value == "" {
+		return 0, true
+	}
+	idInstance, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "idInstance must be a number")
+		return 0, false
+	}
+	if !canSee(workspaceOf(r), a.instanceWorkspace(value)) {
+		writeErrorf(w, r, http.StatusNotFound, "not_found", "Instance %s not found", value)
+		return 0, false
+	}
+	return idInstance, true
+}
+
+// instanceTopic is the topic events of one instance are also published on.
+func instanceTopic(topic string, idInstance int64) string {
+	return topic + "#" + strconv.FormatInt(idInstance, 10)
+}
+
+// messageNotification is the part of a message webhook shared by incoming
+// and outgoing messages.
+type messageNotification struct {
+	IDMessage  string `json:"idMessage"`
+	SenderData struct {
+		ChatID string `json:"chatId"`
+		Sender string `json:"sender"`
+	} `json:"senderData"`
+	MessageData messageData `json:"messageData"`
+}
+
+// decodeMessage fills the typed fields of a message notification. Other
+// notification types and unparsable bodies are left as they are.
+func decodeMessage(notification *Notification) {
+	// A field of the wrong type is left empty rather than losing the rest
+	var message messageNotification
+	var typeErr *json.UnmarshalTypeError
+	if err := json.Unmarshal(notification.Body, &message); err != nil && !errors.As(err, &typeErr) {
+		return
+	}
+	notification.ChatID = message.SenderData.ChatID
+	notification.IDMessage = message.IDMessage
+	notification.TypeMessage = message.MessageData.TypeMessage
+
+	message.MessageData.normalize(notification)
+
+	if message.MessageData.TypeMessage == "reactionMessage" {
+		emoji := message.MessageData.ExtendedTextMessageData.Text
+		notification.Reaction = &Reaction{
+			ChatID:    message.SenderData.ChatID,
+			Sender:    message.SenderData.Sender,
+			IDMessage: message.IDMessage,
+			Target:    message.MessageData.ExtendedTextMessageData.StanzaID,
+			Emoji:     emoji,
+			// An empty reaction takes the previous one back
+			Removed: emoji == "",
+		}
+	}
+
+	// Votes arrive as updates referring to the poll's own idMessage
+	if poll := message.MessageData.PollMessageData; poll != nil {
+		idMessage := message.IDMessage
+		if message.MessageData.TypeMessage == "pollUpdateMessage" {
+			idMessage = poll.StanzaID
+		}
+		results := poll.results(idMessage, message.SenderData.ChatID, notification.ReceivedAt)
+		notification.Poll = &results
+	}
+}
+
+// verifyWebhookToken checks the Authorization header GREEN-API sends when
+// the instance has webhookUrlToken set. Without a configured token every
+// request is accepted.
+func (a *App) verifyWebhookToken(r *http.Request) bool {
+	if a.config.WebhookToken == "" {
+		return true
+	}
+	expected := "Bearer " + a.config.WebhookToken
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) == 1
+}
+
+func (a *App) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	if !a.verifyWebhookToken(r) {
+		writeError(w, r, http.StatusUnauthorized, "invalid_webhook_token", "Webhook token does not match")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	if err := a.verifyWebhookSignature(r, body); err != nil {
+		writeErrorf(w, r, http.StatusUnauthorized, "invalid_webhook_signature", "Webhook signature rejected: %v", err)
+		return
+	}
+
+	var envelope struct {
+		TypeWebhook  string `json:"typeWebhook"`
+		InstanceData struct {
+			IDInstance int64 `json:"idInstance"`
+		} `json:"instanceData"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.TypeWebhook == "" {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	// GREEN-API may deliver the same event more than once
+	if !a.seenNotifications.firstSeenFor(notificationKey(envelope.TypeWebhook, body), a.config.DedupTTL) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	notification := Notification{
+		ReceivedAt:  time.Now(),
+		TypeWebhook: envelope.TypeWebhook,
+		IDInstance:  envelope.InstanceData.IDInstance,
+		Body:        body,
+	}
+	decodeMessage(&notification)
+	a.anomalies.check(&notification)
+	notification = a.notifications.add(notification)
+	a.anomalies.add(notification)
+	a.indexMessage(notification)
+	if notification.Poll != nil {
+		a.polls.record(*notification.Poll)
+	}
+	a.batches.recordStatus(notification)
+	a.optOutOnStop(notification)
+	a.inbox.received(notification)
+	a.labeler.received(notification)
+	a.mediaDownloader.enqueue(notification)
+	a.publishScoped(webhookTopic, a.instanceWorkspace(strconv.FormatInt(notification.IDInstance, 10)), notification)
+	a.events.publish(instanceTopic(webhookTopic, notification.IDInstance), notification)
+	a.forwarder.forward(notification)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// notificationSpec sorts received webhooks by time (newest first by
+// default), type or chat, and searches their types, chats, text and bodies.
+var notificationSpec = listSpec[Notification]{
+	sorts: map[string]func(a, b Notification) int{
+		"time": byNumber(func(n Notification) int64 { return n.ID }),
+		"type": byString(func(n Notification) string { return n.TypeWebhook }),
+		"chat": byString(func(n Notification) string { return n.ChatID }),
+	},
+	text: func(n Notification) []string {
+		return []string{n.TypeWebhook, n.ChatID, n.Text, string(n.Body)}
+	},
+}
+
+// webhooksHandler lists the received webhooks, those of one instance with
+// ?idInstance=.
+func (a *App) webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseListQuery(w, r)
+	if !ok || !notificationSpec.check(w, r, &q, maxPageSize) {
+		return
+	}
+	idInstance, ok := a.instanceOfQuery(w, r)
+	if !ok {
+		return
+	}
+	list := a.notifications.list(workspaceOf(r))
+	if idInstance != 0 {
+		list = a.notifications.listInstance(idInstance)
+	}
+	page, total := notificationSpec.apply(list, q)
+	writeList(w, page, total)
+}
+
+// webhookStreamHandler streams webhooks as they come in, those of one
+// instance with ?idInstance=.
+func (a *App) webhookStreamHandler(w http.ResponseWriter, r *http.Request) {
+	idInstance, ok := a.instanceOfQuery(w, r)
+	if !ok {
+		return
+	}
+	if idInstance != 0 {
+		a.serveEvents(w, r, instanceTopic(webhookTopic, idInstance))
+		return
+	}
+	a.serveScopedEvents(w, r, webhookTopic)
+}
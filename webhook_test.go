@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDecodeMessage(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		want Notification
+	}{
+		{
+			name: "text",
+			body: `{"typeWebhook":"incomingMessageReceived","idMessage":"M1","senderData":{"chatId":"79001234567@c.us"},` +
+				`"messageData":{"typeMessage":"textMessage","textMessageData":{"textMessage":"hello"}}}`,
+			want: Notification{ChatID: "79001234567@c.us", IDMessage: "M1", TypeMessage: "textMessage", Text: "hello"},
+		},
+		{
+			// A field of the wrong type loses only itself
+			name: "wrong type kept partial",
+			body: `{"typeWebhook":"incomingMessageReceived","idMessage":"M2","senderData":{"chatId":79001234567},` +
+				`"messageData":{"typeMessage":"textMessage","textMessageData":{"textMessage":"still here"}}}`,
+			want: Notification{IDMessage: "M2", TypeMessage: "textMessage", Text: "still here"},
+		},
+		{
+			name: "wrong type of coordinates",
+			body: `{"idMessage":"M3","senderData":{"chatId":"79001234567@c.us"},` +
+				`"messageData":{"typeMessage":"locationMessage","locationMessageData":{"nameLocation":"Office","latitude":"55.75"}}}`,
+			want: Notification{ChatID: "79001234567@c.us", IDMessage: "M3", TypeMessage: "locationMessage", Text: "Office"},
+		},
+		{
+			name: "not JSON",
+			body: `{"idMessage":"M4",`,
+			want: Notification{},
+		},
+		{
+			name: "not a message",
+			body: `{"typeWebhook":"stateInstanceChanged","stateInstance":"authorized"}`,
+			want: Notification{},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			notification := Notification{Body: json.RawMessage(tc.body)}
+			decodeMessage(&notification)
+			if notification.ChatID != tc.want.ChatID || notification.IDMessage != tc.want.IDMessage ||
+				notification.TypeMessage != tc.want.TypeMessage || notification.Text != tc.want.Text {
+				t.Errorf("decoded chatId %q, idMessage %q, typeMessage %q, text %q, want %q, %q, %q, %q",
+					notification.ChatID, notification.IDMessage, notification.TypeMessage, notification.Text,
+					tc.want.ChatID, tc.want.IDMessage, tc.want.TypeMessage, tc.want.Text)
+			}
+		})
+	}
+}
+
+func FuzzDecodeMessage(f *testing.F) {
+	for _, seed := range []string{
+		`{"typeWebhook":"incomingMessageReceived","idMessage":"M1","senderData":{"chatId":"79001234567@c.us","sender":"79001234567@c.us"},"messageData":{"typeMessage":"textMessage","textMessageData":{"textMessage":"hello"}}}`,
+		`{"idMessage":"M2","senderData":{"chatId":79001234567},"messageData":{"typeMessage":"textMessage","textMessageData":{"textMessage":"hi"}}}`,
+		`{"idMessage":"M3","messageData":{"typeMessage":"reactionMessage","extendedTextMessageData":{"text":"👍","stanzaId":"M1"}}}`,
+		`{"idMessage":"M4","messageData":{"typeMessage":"quotedMessage","extendedTextMessageData":{"text":"yes"},"quotedMessage":{"stanzaId":"M1","typeMessage":"textMessage","textMessage":"ok?"}}}`,
+		`{"idMessage":"M5","messageData":{"typeMessage":"contactMessage","contactMessageData":{"displayName":"Ann","vcard":"BEGIN:VCARD\nTEL:+79001234567\nEND:VCARD"}}}`,
+		`{"idMessage":"M6","messageData":{"typeMessage":"contactsArrayMessage","messageData":{"contacts":[{"displayName":"A","vcard":"TEL:1"},{"displayName":"B"}]}}}`,
+		`{"idMessage":"M7","messageData":{"typeMessage":"imageMessage","fileMessageData":{"downloadUrl":"https://example.com/a.jpg","caption":"pic"}}}`,
+		`{"idMessage":"M8","messageData":{"typeMessage":"pollMessage","pollMessageData":{"name":"Lunch?","options":[{"optionName":"Yes"},{"optionName":"No"}]}}}`,
+		`{"idMessage":"M9","messageData":{"typeMessage":"pollUpdateMessage","pollMessageData":{"stanzaId":"M8","votes":[{"optionName":"Yes","optionVoters":["7900@c.us"]}]}}}`,
+		`{"idMessage":"M10","messageData":{"typeMessage":"locationMessage","locationMessageData":{"latitude":"x"}}}`,
+		`{"messageData":null}`,
+		`[]`,
+		`null`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, body []byte) {
+		notification := Notification{ReceivedAt: time.Unix(1700000000, 0), Body: body}
+		decodeMessage(&notification)
+
+		// Whatever decodes cleanly is copied as it is
+		var message messageNotification
+		if json.Unmarshal(body, &message) != nil {
+			return
+		}
+		if notification.IDMessage != message.IDMessage || notification.ChatID != message.SenderData.ChatID ||
+			notification.TypeMessage != message.MessageData.TypeMessage {
+			t.Errorf("decoded idMessage %q, chatId %q, typeMessage %q from %s", notification.IDMessage, notification.ChatID, notification.TypeMessage, body)
+		}
+		if notification.Reaction != nil && notification.Reaction.IDMessage != message.IDMessage {
+			t.Errorf("reaction idMessage %q, want %q", notification.Reaction.IDMessage, message.IDMessage)
+		}
+	})
+}
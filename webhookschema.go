@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxAnomalies is how many webhooks that broke their schema are kept.
+const maxAnomalies = 200
+
+// webhookSchemaFile holds a JSON Schema definition per typeWebhook, so a
+// change in what GREEN-API sends shows up as anomalies rather than as
+// fields quietly going missing.
+//
+//go:embed schemas/webhooks.json
+var webhookSchemaFile []byte
+
+// schemaDefs are the definitions of webhookSchemaFile by name.
+var schemaDefs = func() map[string]interface{} {
+	var document struct {
+		Defs map[string]interface{} `json:"$defs"`
+	}
+	if err := json.Unmarshal(webhookSchemaFile, &document); err != nil {
+		panic("invalid schemas/webhooks.json: " + err.Error())
+	}
+	return document.Defs
+}()
+
+// validateWebhook checks a webhook body against the schema of its
+// typeWebhook and lists what doesn't match, nil when it all does.
+// Validation covers what the bundled schemas use: $ref to their own
+// definitions, type, enum, const, required, properties, items, allOf and
+// if/then.
+func validateWebhook(typeWebhook string, body []byte) []string {
+	schema, ok := schemaDefs[typeWebhook]
+	if !ok {
+		return []string{fmt.Sprintf("no schema for typeWebhook %s", typeWebhook)}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return []string{err.Error()}
+	}
+	var problems []string
+	checkSchema(schema, value, "", &problems)
+	return problems
+}
+
+// checkSchema adds the ways value breaks schema to problems, each
+// prefixed with the path to the value.
+func checkSchema(schema, value interface{}, path string, problems *[]string) {
+	rules, ok := schema.(map[string]interface{})
+	if !ok {
+		return
+	}
+	report := func(format string, args ...interface{}) {
+		at := path
+		if at == "" {
+			at = "body"
+		}
+		*problems = append(*problems, at+": "+fmt.Sprintf(format, args...))
+	}
+
+	if ref, ok := rules["$ref"].(string); ok {
+		checkSchema(schemaDefs[strings.TrimPrefix(ref, "#/$defs/")], value, path, problems)
+	}
+	if want, ok := rules["type"].(string); ok && !hasJSONType(value, want) {
+		report("expected %s, got %s", want, jsonKind(value))
+		return
+	}
+	if allowed, ok := rules["enum"].([]interface{}); ok && !slices.ContainsFunc(allowed, func(a interface{}) bool { return sameJSON(a, value) }) {
+		report("unexpected value %s", compactJSON(value))
+	}
+	if want, ok := rules["const"]; ok && !sameJSON(want, value) {
+		report("expected %s, got %s", compactJSON(want), compactJSON(value))
+	}
+
+	if object, ok := value.(map[string]interface{}); ok {
+		if required, ok := rules["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := object[name.(string)]; !ok {
+					report("missing %s", name)
+				}
+			}
+		}
+		if properties, ok := rules["properties"].(map[string]interface{}); ok {
+			for _, name := range slices.Sorted(maps.Keys(properties)) {
+				if field, ok := object[name]; ok {
+					checkSchema(properties[name], field, joinPath(path, name), problems)
+				}
+			}
+		}
+	}
+	if array, ok := value.([]interface{}); ok {
+		if items, ok := rules["items"]; ok {
+			for i, item := range array {
+				checkSchema(items, item, path+"["+strconv.Itoa(i)+"]", problems)
+			}
+		}
+	}
+
+	if all, ok := rules["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			checkSchema(sub, value, path, problems)
+		}
+	}
+	if condition, ok := rules["if"]; ok {
+		var failed []string
+		checkSchema(condition, value, path, &failed)
+		if len(failed) == 0 {
+			checkSchema(rules["then"], value, path, problems)
+		}
+	}
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// hasJSONType reports whether a value decoded with UseNumber is of the
+// JSON Schema type want.
+func hasJSONType(value interface{}, want string) bool {
+	switch want {
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := n.Int64()
+		return err == nil
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	default:
+		return jsonKind(value) == want
+	}
+}
+
+func jsonKind(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// sameJSON compares a schema value with a decoded one; numbers are
+// json.Number on one side and float64 on the other.
+func sameJSON(a, b interface{}) bool {
+	return compactJSON(a) == compactJSON(b)
+}
+
+func compactJSON(value interface{}) string {
+	if n, ok := value.(json.Number); ok {
+		if f, err := n.Float64(); err == nil {
+			value = f
+		}
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// WebhookAnomaly is a received webhook that didn't match its schema.
+type WebhookAnomaly struct {
+	ID int64 `json:"id"`
+	// NotificationID is the webhook's ID in /api/webhooks.
+	NotificationID int64           `json:"notificationId"`
+	ReceivedAt     time.Time       `json:"receivedAt"`
+	TypeWebhook    string          `json:"typeWebhook"`
+	IDInstance     int64           `json:"idInstance,omitempty"`
+	Problems       []string        `json:"problems"`
+	Body           json.RawMessage `json:"body"`
+}
+
+// AnomalyStore keeps the latest webhook anomalies in memory, apart from
+// the webhooks themselves so they outlive -webhook-history-size, and
+// counts them by typeWebhook since the start.
+type AnomalyStore struct {
+	mu        sync.Mutex
+	anomalies []WebhookAnomaly
+	nextID    int64
+	counts    map[string]int
+	// instanceWorkspace returns the workspace of an instance.
+	instanceWorkspace func(idInstance string) string
+}
+
+// check validates a received notification, flagging it and keeping an
+// anomaly when it breaks its schema.
+func (s *AnomalyStore) check(notification *Notification) {
+	problems := validateWebhook(notification.TypeWebhook, notification.Body)
+	if len(problems) == 0 {
+		return
+	}
+	notification.Anomalies = problems
+	warnf("Webhook %s from instance %d doesn't match its schema: %s", notification.TypeWebhook, notification.IDInstance, strings.Join(problems, "; "))
+}
+
+// add keeps the anomaly of a notification flagged by check, once it has
+// its ID.
+func (s *AnomalyStore) add(notification Notification) {
+	if len(notification.Anomalies) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.anomalies) >= maxAnomalies {
+		s.anomalies = s.anomalies[1:]
+	}
+	s.anomalies = append(s.anomalies, WebhookAnomaly{
+		ID:             s.nextID,
+		NotificationID: notification.ID,
+		ReceivedAt:     notification.ReceivedAt,
+		TypeWebhook:    notification.TypeWebhook,
+		IDInstance:     notification.IDInstance,
+		Problems:       notification.Anomalies,
+		Body:           notification.Body,
+	})
+	s.nextID++
+	s.counts[notification.TypeWebhook]++
+}
+
+// list returns the anomalies workspace may see, newest first.
+func (s *AnomalyStore) list(workspace string) []WebhookAnomaly {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]WebhookAnomaly, 0, len(s.anomalies))
+	for i := len(s.anomalies) - 1; i >= 0; i-- {
+		anomaly := s.anomalies[i]
+		if canSee(workspace, s.instanceWorkspace(strconv.FormatInt(anomaly.IDInstance, 10))) {
+			list = append(list, anomaly)
+		}
+	}
+	return list
+}
+
+// totals returns the anomaly counts by typeWebhook.
+func (s *AnomalyStore) totals() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.counts)
+}
+
+// anomalySpec sorts anomalies by time (newest first by default) or type,
+// and searches their types and problems.
+var anomalySpec = listSpec[WebhookAnomaly]{
+	sorts: map[string]func(a, b WebhookAnomaly) int{
+		"time": byNumber(func(a WebhookAnomaly) int64 { return a.ID }),
+		"type": byString(func(a WebhookAnomaly) string { return a.TypeWebhook }),
+	},
+	text: func(a WebhookAnomaly) []string { return append([]string{a.TypeWebhook}, a.Problems...) },
+}
+
+func (a *App) webhookAnomaliesHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseListQuery(w, r)
+	if !ok || !anomalySpec.check(w, r, &q, maxPageSize) {
+		return
+	}
+	idInstance, ok := a.instanceOfQuery(w, r)
+	if !ok {
+		return
+	}
+	list := a.anomalies.list(workspaceOf(r))
+	if idInstance != 0 {
+		list = slices.DeleteFunc(list, func(a WebhookAnomaly) bool { return a.IDInstance != idInstance })
+	}
+	page, total := anomalySpec.apply(list, q)
+	writeList(w, page, total)
+}
+
+// webhookSchemaHandler serves the bundled webhook schemas, for checking
+// webhooks elsewhere the same way.
+func webhookSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(webhookSchemaFile)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// webhookTestTimeout bounds delivering a sample webhook.
+const webhookTestTimeout = 10 * time.Second
+
+// sampleWebhook describes the webhook to synthesize.
+type sampleWebhook struct {
+	IDInstance int64
+	ChatID     string
+	Text       string
+	// IDMessage is the message a quote, reaction or status refers to.
+	IDMessage string
+	Status    string
+	// id is the idMessage of the sample itself.
+	id string
+	at time.Time
+}
+
+// sampleWebhooks build GREEN-API webhook bodies by sample name. Message
+// samples are incomingMessageReceived webhooks of that typeMessage.
+var sampleWebhooks = map[string]func(s sampleWebhook) map[string]interface{}{
+	"textMessage": func(s sampleWebhook) map[string]interface{} {
+		return s.message("textMessage", "textMessageData", map[string]interface{}{"textMessage": s.Text})
+	},
+	"quotedMessage": func(s sampleWebhook) map[string]interface{} {
+		body := s.message("quotedMessage", "extendedTextMessageData", map[string]interface{}{"text": s.Text, "stanzaId": s.IDMessage})
+		body["messageData"].(map[string]interface{})["quotedMessage"] = map[string]interface{}{
+			"stanzaId":    s.IDMessage,
+			"participant": s.ChatID,
+			"typeMessage": "textMessage",
+			"textMessage": "Quoted message",
+		}
+		return body
+	},
+	"imageMessage": func(s sampleWebhook) map[string]interface{} {
+		return s.message("imageMessage", "fileMessageData", map[string]interface{}{
+			"downloadUrl": "https://example.com/sample.jpg",
+			"caption":     s.Text,
+			"fileName":    "sample.jpg",
+			"mimeType":    "image/jpeg",
+		})
+	},
+	"locationMessage": func(s sampleWebhook) map[string]interface{} {
+		return s.message("locationMessage", "locationMessageData", map[string]interface{}{
+			"nameLocation": s.Text,
+			"address":      "Red Square, Moscow",
+			"latitude":     55.7539,
+			"longitude":    37.6208,
+		})
+	},
+	"contactMessage": func(s sampleWebhook) map[string]interface{} {
+		phone := strings.TrimSuffix(s.ChatID, "@c.us")
+		return s.message("contactMessage", "contactMessageData", map[string]interface{}{
+			"displayName": s.Text,
+			"vcard":       fmt.Sprintf("BEGIN:VCARD\nVERSION:3.0\nFN:%s\nTEL;type=CELL;waid=%s:+%s\nEND:VCARD", s.Text, phone, phone),
+		})
+	},
+	"reactionMessage": func(s sampleWebhook) map[string]interface{} {
+		return s.message("reactionMessage", "extendedTextMessageData", map[string]interface{}{"text": "👍", "stanzaId": s.IDMessage})
+	},
+	"pollMessage": func(s sampleWebhook) map[string]interface{} {
+		return s.message("pollMessage", "pollMessageData", map[string]interface{}{
+			"name":            s.Text,
+			"multipleAnswers": false,
+			"options":         []map[string]string{{"optionName": "Yes"}, {"optionName": "No"}},
+			"votes":           []interface{}{},
+		})
+	},
+	"outgoingMessageStatus": func(s sampleWebhook) map[string]interface{} {
+		return map[string]interface{}{
+			"typeWebhook":  "outgoingMessageStatus",
+			"instanceData": s.instanceData(),
+			"timestamp":    s.at.Unix(),
+			"idMessage":    s.IDMessage,
+			"status":       s.Status,
+			"chatId":       s.ChatID,
+			"sendByApi":    true,
+		}
+	},
+	"stateInstanceChanged": func(s sampleWebhook) map[string]interface{} {
+		return map[string]interface{}{
+			"typeWebhook":   "stateInstanceChanged",
+			"instanceData":  s.instanceData(),
+			"timestamp":     s.at.Unix(),
+			"stateInstance": "authorized",
+		}
+	},
+	"incomingCall": func(s sampleWebhook) map[string]interface{} {
+		return map[string]interface{}{
+			"typeWebhook":  "incomingCall",
+			"instanceData": s.instanceData(),
+			"timestamp":    s.at.Unix(),
+			"idMessage":    s.id,
+			"from":         s.ChatID,
+			"status":       "offer",
+		}
+	},
+}
+
+func (s sampleWebhook) instanceData() map[string]interface{} {
+	return map[string]interface{}{
+		"idInstance":   s.IDInstance,
+		"wid":          "79000000000@c.us",
+		"typeInstance": "whatsapp",
+	}
+}
+
+func (s sampleWebhook) message(typeMessage, dataKey string, data map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"typeWebhook":  "incomingMessageReceived",
+		"instanceData": s.instanceData(),
+		"timestamp":    s.at.Unix(),
+		"idMessage":    s.id,
+		"senderData": map[string]interface{}{
+			"chatId":     s.ChatID,
+			"sender":     s.ChatID,
+			"senderName": "Test Sender",
+		},
+		"messageData": map[string]interface{}{
+			"typeMessage": typeMessage,
+			dataKey:       data,
+		},
+	}
+}
+
+// WebhookTestResult is what delivering a sample webhook gave.
+type WebhookTestResult struct {
+	Target     string          `json:"target"`
+	StatusCode int             `json:"statusCode,omitempty"`
+	Response   string          `json:"response,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// webhookTestHandler synthesizes a sample webhook and posts it to this
+// server's /webhook, or to url, so integrations can be tried without real
+// traffic. Posting elsewhere takes the admin role.
+func (a *App) webhookTestHandler(w http.ResponseWriter, r *http.Request) {
+	var requestBody struct {
+		Type        string `json:"type"`
+		IDInstance  string `json:"idInstance"`
+		PhoneNumber string `json:"phoneNumber"`
+		Text        string `json:"text"`
+		IDMessage   string `json:"idMessage"`
+		Status      string `json:"status"`
+		URL         string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	build, ok := sampleWebhooks[requestBody.Type]
+	if !ok {
+		writeErrorf(w, r, http.StatusBadRequest, "invalid_type", "Type must be one of: %s", strings.Join(slices.Sorted(maps.Keys(sampleWebhooks)), ", "))
+		return
+	}
+	sample := sampleWebhook{
+		ChatID:    "79001234567@c.us",
+		Text:      "Test message",
+		IDMessage: requestBody.IDMessage,
+		Status:    "delivered",
+		at:        time.Now(),
+		id:        strings.ToUpper(newMediaID()),
+	}
+	if requestBody.IDInstance != "" {
+		id, err := strconv.ParseInt(requestBody.IDInstance, 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_request", "idInstance must be a number")
+			return
+		}
+		sample.IDInstance = id
+	}
+	if requestBody.PhoneNumber != "" {
+		sample.ChatID = requestBody.PhoneNumber + "@c.us"
+	}
+	if requestBody.Text != "" {
+		sample.Text = requestBody.Text
+	}
+	if requestBody.Status != "" {
+		sample.Status = requestBody.Status
+	}
+	if sample.IDMessage == "" {
+		sample.IDMessage = sample.id
+	}
+
+	target := a.requestBaseURL(r) + "/webhook"
+	if requestBody.URL != "" {
+		if _, ok := a.authorize(w, r, roleAdmin); !ok {
+			return
+		}
+		if parsed, err := url.ParseRequestURI(requestBody.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			writeError(w, r, http.StatusBadRequest, "invalid_url", "Invalid URL")
+			return
+		}
+		target = requestBody.URL
+	}
+
+	body, _ := json.Marshal(build(sample))
+	result := WebhookTestResult{Target: target, Body: body}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_url", "Invalid URL")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Our own /webhook checks the token GREEN-API would send and the
+	// signature of forwarded webhooks; other receivers get the signature
+	// of forwarded webhooks
+	if requestBody.URL == "" {
+		if a.config.WebhookToken != "" {
+			req.Header.Set("Authorization", "Bearer "+a.config.WebhookToken)
+		}
+		signRequest(req, a.config.WebhookSecret, body)
+	} else {
+		signRequest(req, a.liveConfig().ForwardSecret, body)
+	}
+	client := &http.Client{Timeout: webhookTestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		response, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		resp.Body.Close()
+		result.StatusCode = resp.StatusCode
+		result.Response = string(response)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
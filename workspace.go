@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Workspaces let several teams share one deployment. API keys and
+// configured instances may be prefixed with a workspace, as in
+// team-a/alice:sender:key or team-a/1101:token. Callers in a workspace only
+// see history, webhooks and instance states of that workspace and can't
+// use instances configured for another one. Callers without a workspace,
+// and everyone while auth is disabled, see all of them.
+
+var errForeignInstance = errors.New("instance belongs to another workspace")
+
+// splitWorkspace separates an optional "workspace/" prefix.
+func splitWorkspace(value string) (workspace, rest string) {
+	if workspace, rest, ok := strings.Cut(value, "/"); ok {
+		return workspace, rest
+	}
+	return "", value
+}
+
+// workspaceOf returns the caller's workspace, "" for all of them.
+func workspaceOf(r *http.Request) string {
+	return workspaceFromContext(r.Context())
+}
+
+func workspaceFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(Principal)
+	return principal.Workspace
+}
+
+// ownerWorkspace is the workspace whose presets and canned replies a
+// request reads and writes: the caller's own, or for callers without a
+// workspace the one named by the workspace query parameter, "" by default.
+func ownerWorkspace(r *http.Request) string {
+	if workspace := workspaceOf(r); workspace != "" {
+		return workspace
+	}
+	return r.URL.Query().Get("workspace")
+}
+
+// workspaceKey keys what is named within a workspace. Workspace names
+// can't hold a /, so keys of different workspaces never collide.
+func workspaceKey(workspace, name string) string {
+	return workspace + "/" + name
+}
+
+// canSee reports whether a caller in workspace may see data owned by owner.
+func canSee(workspace, owner string) bool {
+	return workspace == "" || workspace == owner
+}
+
+// instanceWorkspace is the workspace an instance is configured for, ""
+// when it isn't configured or shared.
+func (a *App) instanceWorkspace(idInstance string) string {
+	instance, _ := a.lookupInstance(idInstance)
+	return instance.Workspace
+}
+
+// checkInstanceWorkspace stops callers from using instances configured for
+// another workspace.
+func (a *App) checkInstanceWorkspace(ctx context.Context, idInstance string) error {
+	owner := a.instanceWorkspace(idInstance)
+	if owner != "" && !canSee(workspaceFromContext(ctx), owner) {
+		return errForeignInstance
+	}
+	return nil
+}
+
+// workspaceTopic is the event topic carrying the events of one workspace.
+func workspaceTopic(topic, workspace string) string {
+	if workspace == "" {
+		return topic
+	}
+	return topic + "@" + workspace
+}
+
+// publishScoped publishes an event owned by workspace to everyone allowed
+// to see it.
+func (a *App) publishScoped(topic, workspace string, event interface{}) {
+	a.events.publish(topic, event)
+	if workspace != "" {
+		a.events.publish(workspaceTopic(topic, workspace), event)
+	}
+}
+
+// serveScopedEvents streams the events of topic the caller may see.
+func (a *App) serveScopedEvents(w http.ResponseWriter, r *http.Request, topic string) {
+	a.serveEvents(w, r, workspaceTopic(topic, workspaceOf(r)))
+}